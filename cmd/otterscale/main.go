@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/otterscale/otterscale/internal/config"
 	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/providers/chisel"
 )
 
 // version is injected at build time via -ldflags
@@ -77,6 +79,8 @@ func newCmd(conf *config.Config) (*cobra.Command, error) {
 
 	agentCmd, err := cmd.NewAgentCommand(conf, func() (*agent.Agent, func(), error) {
 		return wireAgent(v, conf)
+	}, func() (*agent.Uninstaller, func(), error) {
+		return wireUninstaller()
 	})
 	if err != nil {
 		return nil, err
@@ -87,9 +91,116 @@ func newCmd(conf *config.Config) (*cobra.Command, error) {
 	return c, nil
 }
 
-// provideCA is a Wire provider that generates a fresh ephemeral CA on
-// every server start. The CA is not persisted; agents re-register
-// automatically via the public Register API when the server restarts.
-func provideCA() (*pki.CA, error) {
-	return pki.NewCA()
+// provideCA is a Wire provider that loads the tunnel CA from the
+// configured store, generating and persisting it on first boot. Because
+// the same CA survives restarts, agent certificates stay valid across
+// hub restarts and rolling upgrades. HMAC-signed manifest URLs and
+// tokens are signed with keys derived from the secret persisted in
+// secrets, so they also survive CA rotations and are accepted by every
+// hub replica. A non-nil signer holds the CA key outside the process.
+func provideCA(store pki.CAStore, secrets pki.HMACSecretStore, signer pki.Signer) (*pki.CA, error) {
+	const caLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), caLoadTimeout)
+	defer cancel()
+
+	return pki.LoadOrCreateCA(ctx, store, pki.WithSigner(signer), pki.WithHMACSecretStore(secrets))
+}
+
+// provideCSRPolicy is a Wire provider that builds the policy every
+// agent CSR must satisfy before the tunnel CA signs it.
+func provideCSRPolicy(conf *config.Config) (*pki.CSRPolicy, error) {
+	return pki.NewCSRPolicy(conf.ServerCACSRSANPattern())
+}
+
+// provideRevocations is a Wire provider that loads the agent
+// certificate revocation list from the configured store, so that
+// revoked agents stay locked out across hub restarts.
+func provideRevocations(store pki.RevocationStore) (*pki.RevocationList, error) {
+	const revocationsLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), revocationsLoadTimeout)
+	defer cancel()
+
+	return pki.LoadRevocationList(ctx, store)
+}
+
+// provideTunnelService is a Wire provider that builds the chisel
+// tunnel service with its links restored from the configured store,
+// so that clusters stay listed, as disconnected, across hub restarts.
+// With a peer URL configured, the hub replicas sharing the store
+// record which of them holds each tunnel. The health check of the
+// tunnels follows the configured policy and per-cluster overrides.
+func provideTunnelService(ca *pki.CA, revocations *pki.RevocationList, policy *pki.CSRPolicy, store core.LinkStore, conf *config.Config) (*chisel.Service, error) {
+	const linksLoadTimeout = 30 * time.Second
+
+	health, err := chisel.NewHealthConfig(conf.ServerHealthInterval(), chisel.HealthPolicy{
+		ProbeTimeout:  conf.ServerHealthProbeTimeout(),
+		FailThreshold: conf.ServerHealthFailThreshold(),
+		GracePeriod:   conf.ServerHealthGracePeriod(),
+	}, conf.ServerHealthOverrides())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), linksLoadTimeout)
+	defer cancel()
+
+	replica := core.HubReplica{ID: conf.ServerReplicaID(), PeerURL: conf.ServerPeerURL()}
+	return chisel.LoadService(ctx, ca, revocations, policy, store, replica, health)
+}
+
+// provideEnrollmentTokens is a Wire provider that loads the agent
+// enrollment token registry from the configured store, so that issued
+// manifests keep working, and redeemed tokens stay spent, across hub
+// restarts.
+func provideEnrollmentTokens(store core.EnrollmentStore) (*core.EnrollmentTokens, error) {
+	const enrollmentLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), enrollmentLoadTimeout)
+	defer cancel()
+
+	return core.LoadEnrollmentTokens(ctx, store)
+}
+
+// provideClusterPins is a Wire provider that loads the cluster pins
+// from the configured store, so that clusters stay bound to the agent
+// that enrolled them across hub restarts.
+func provideClusterPins(store core.ClusterPinStore) (*core.ClusterPins, error) {
+	const pinsLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), pinsLoadTimeout)
+	defer cancel()
+
+	return core.LoadClusterPins(ctx, store)
+}
+
+// provideClusterApprovals is a Wire provider that loads the cluster
+// approvals from the configured store. Approval of first-time
+// registrations is required if server.links.require_approval is set.
+func provideClusterApprovals(store core.ClusterApprovalStore, conf *config.Config) (*core.ClusterApprovals, error) {
+	const approvalsLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), approvalsLoadTimeout)
+	defer cancel()
+
+	return core.LoadClusterApprovals(ctx, store, conf.ServerLinksRequireApproval())
+}
+
+// provideAgentUpgrades is a Wire provider that loads the agent upgrade
+// policy from the configured store. Without a policy, agents are moved
+// to the hub version v.
+func provideAgentUpgrades(store core.UpgradePolicyStore, v core.Version) (*core.AgentUpgrades, error) {
+	const upgradesLoadTimeout = 30 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), upgradesLoadTimeout)
+	defer cancel()
+
+	return core.LoadAgentUpgrades(ctx, store, v)
+}
+
+// provideSharedStateRefresher is a Wire provider that returns the
+// refresher of the state the hub replicas share through the CA store:
+// the CA, the revocations, the enrollment tokens, the cluster pins,
+// the cluster approvals and the upgrade policy. A hub without peer
+// forwarding runs as a single replica and has nothing to refresh.
+func provideSharedStateRefresher(conf *config.Config, ca *pki.CA, revocations *pki.RevocationList, tokens *core.EnrollmentTokens, pins *core.ClusterPins, approvals *core.ClusterApprovals, upgrades *core.AgentUpgrades) *core.SharedStateRefresher {
+	if conf.ServerPeerURL() == "" {
+		return core.NewSharedStateRefresher()
+	}
+	return core.NewSharedStateRefresher(ca, revocations, tokens, pins, approvals, upgrades)
 }
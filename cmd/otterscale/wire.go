@@ -26,7 +26,7 @@ func wireCmd() (*cobra.Command, func(), error) {
 // use-cases, and infrastructure providers. The version parameter is
 // provided by the caller and flows through Wire to LinkUseCase.
 func wireServer(v core.Version, conf *config.Config) (*server.Server, func(), error) {
-	panic(wire.Build(cmd.ProviderSet, handler.ProviderSet, core.ProviderSet, providers.ProviderSet, provideCA, manifest.ProvideAgentManifestConfig))
+	panic(wire.Build(cmd.ProviderSet, handler.ProviderSet, core.ProviderSet, providers.ProviderSet, provideCA, provideCSRPolicy, provideRevocations, provideTunnelService, provideEnrollmentTokens, provideClusterPins, provideClusterApprovals, provideAgentUpgrades, provideSharedStateRefresher, manifest.ProvideAgentManifestConfig))
 }
 
 // wireAgent assembles a fully wired Agent with its handler, link
@@ -35,3 +35,9 @@ func wireServer(v core.Version, conf *config.Config) (*server.Server, func(), er
 func wireAgent(v core.Version, conf *config.Config) (*agent.Agent, func(), error) {
 	panic(wire.Build(cmd.ProviderSet, providers.ProviderSet, bootstrap.ProviderSet, kubernetes.ProvideInClusterConfig))
 }
+
+// wireUninstaller assembles an Uninstaller with the bootstrapper and the
+// manifest renderer.
+func wireUninstaller() (*agent.Uninstaller, func(), error) {
+	panic(wire.Build(cmd.ProviderSet, providers.ProviderSet, bootstrap.ProviderSet, kubernetes.ProvideInClusterConfig))
+}
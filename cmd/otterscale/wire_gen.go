@@ -14,12 +14,16 @@ import (
 	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/handler"
 	"github.com/otterscale/otterscale/internal/providers"
-	"github.com/otterscale/otterscale/internal/providers/chisel"
+	"github.com/otterscale/otterscale/internal/providers/casigner"
+	"github.com/otterscale/otterscale/internal/providers/castore"
 	"github.com/otterscale/otterscale/internal/providers/harbor"
 	"github.com/otterscale/otterscale/internal/providers/helm"
+	"github.com/otterscale/otterscale/internal/providers/hubstore"
 	"github.com/otterscale/otterscale/internal/providers/kubernetes"
+	"github.com/otterscale/otterscale/internal/providers/linkstore"
 	"github.com/otterscale/otterscale/internal/providers/manifest"
 	"github.com/otterscale/otterscale/internal/providers/otterscale"
+	"github.com/otterscale/otterscale/internal/providers/peer"
 	"github.com/spf13/cobra"
 )
 
@@ -43,42 +47,118 @@ func wireCmd() (*cobra.Command, func(), error) {
 // use-cases, and infrastructure providers. The version parameter is
 // provided by the caller and flows through Wire to LinkUseCase.
 func wireServer(v core.Version, conf *config.Config) (*server.Server, func(), error) {
-	ca, err := provideCA()
+	store, err := castore.ProvideStore(conf)
 	if err != nil {
 		return nil, nil, err
 	}
-	service := chisel.NewService(ca)
+	caStore := castore.ProvideCAStore(store)
+	signer, cleanup, err := casigner.ProvideSigner(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	hmacSecretStore := castore.ProvideHMACSecretStore(store)
+	ca, err := provideCA(caStore, hmacSecretStore, signer)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	hubstoreStore, err := hubstore.ProvideStore(conf)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	revocationStore := hubstore.ProvideRevocationStore(hubstoreStore)
+	revocationList, err := provideRevocations(revocationStore)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	csrPolicy, err := provideCSRPolicy(conf)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	linkStore, err := linkstore.ProvideLinkStore(conf)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	service, err := provideTunnelService(ca, revocationList, csrPolicy, linkStore, conf)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	forwarder, err := peer.ProvideForwarder(conf, ca, service)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
 	agentManifestConfig, err := manifest.ProvideAgentManifestConfig(conf, ca)
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
 	renderer := manifest.NewRenderer()
 	harborClient := harbor.ProvideHarborClient(conf)
-	linkUseCase, err := core.NewLinkUseCase(service, v, agentManifestConfig, renderer, harborClient)
+	enrollmentStore := hubstore.ProvideEnrollmentStore(hubstoreStore)
+	enrollmentTokens, err := provideEnrollmentTokens(enrollmentStore)
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
-	linkService := handler.NewLinkService(linkUseCase)
-	kubernetesKubernetes := kubernetes.New(service)
+	clusterPinStore := hubstore.ProvideClusterPinStore(hubstoreStore)
+	clusterPins, err := provideClusterPins(clusterPinStore)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	clusterApprovalStore := hubstore.ProvideClusterApprovalStore(hubstoreStore)
+	clusterApprovals, err := provideClusterApprovals(clusterApprovalStore, conf)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	upgradePolicyStore := hubstore.ProvideUpgradePolicyStore(hubstoreStore)
+	agentUpgrades, err := provideAgentUpgrades(upgradePolicyStore, v)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	kubernetesKubernetes := kubernetes.New(service, forwarder)
 	discoveryClient := kubernetes.NewDiscoveryClient(kubernetesKubernetes)
-	resourceRepo := kubernetes.NewResourceRepo(kubernetesKubernetes)
 	discoveryCache := providers.ProvideDiscoveryCache(discoveryClient)
+	sessionStore := core.NewSessionStore()
+	v2 := providers.ProvideClusterEvictors(kubernetesKubernetes, discoveryCache, sessionStore)
+	linkUseCase, err := core.NewLinkUseCase(service, service, agentManifestConfig, renderer, harborClient, enrollmentTokens, clusterPins, clusterApprovals, agentUpgrades, v2)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	linkService := handler.NewLinkService(linkUseCase)
+	resourceRepo := kubernetes.NewResourceRepo(kubernetesKubernetes)
 	resourceUseCase := core.NewResourceUseCase(discoveryClient, resourceRepo, discoveryCache)
 	resourceService := handler.NewResourceService(resourceUseCase)
 	runtimeRepo := kubernetes.NewRuntimeRepo(kubernetesKubernetes)
 	helmRepo, err := helm.NewRepo()
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
-	sessionStore := core.NewSessionStore()
-	runtimeUseCase := core.NewRuntimeUseCase(discoveryClient, runtimeRepo, helmRepo, sessionStore)
+	runtimeUseCase := core.NewRuntimeUseCase(discoveryClient, runtimeRepo, helmRepo, sessionStore, forwarder)
 	runtimeService := handler.NewRuntimeService(runtimeUseCase)
 	manifestHandler := handler.NewManifestHandler(linkUseCase)
+	renewHandler := handler.NewRenewHandler(linkUseCase)
 	proxyHandler := handler.NewProxyHandler(service)
-	serverHandler := server.NewHandler(linkService, resourceService, runtimeService, manifestHandler, proxyHandler)
-	backgroundListeners := server.ProvideBackgroundListeners(runtimeUseCase, discoveryCache)
-	serverServer := server.NewServer(serverHandler, service, backgroundListeners)
+	caUseCase := core.NewCAUseCase(service, service, service)
+	linkWatchHandler := handler.NewLinkWatchHandler(linkUseCase)
+	adminHandler := handler.NewAdminHandler(caUseCase, linkUseCase)
+	peerSessionHandler := handler.NewPeerSessionHandler(runtimeUseCase)
+	serverHandler := server.NewHandler(linkService, resourceService, runtimeService, manifestHandler, renewHandler, proxyHandler, linkWatchHandler, adminHandler, peerSessionHandler)
+	sharedStateRefresher := provideSharedStateRefresher(conf, ca, revocationList, enrollmentTokens, clusterPins, clusterApprovals, agentUpgrades)
+	backgroundListeners := server.ProvideBackgroundListeners(runtimeUseCase, discoveryCache, sharedStateRefresher)
+	serverServer := server.NewServer(serverHandler, service, forwarder, backgroundListeners)
 	return serverServer, func() {
+		cleanup()
 	}, nil
 }
 
@@ -100,7 +180,23 @@ func wireAgent(v core.Version, conf *config.Config) (*agent.Agent, func(), error
 		return nil, nil, err
 	}
 	selfUpdater := agent.NewUpdater(restConfig)
-	agentAgent := agent.NewAgent(restConfig, agentHandler, tunnelConsumer, v, bootstrapper, selfUpdater)
+	credentialStore := agent.NewCredentialStore(restConfig)
+	agentAgent := agent.NewAgent(restConfig, agentHandler, tunnelConsumer, v, bootstrapper, selfUpdater, credentialStore)
 	return agentAgent, func() {
 	}, nil
 }
+
+func wireUninstaller() (*agent.Uninstaller, func(), error) {
+	restConfig, err := kubernetes.ProvideInClusterConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	bootstrapper, err := bootstrap.New(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	renderer := manifest.NewRenderer()
+	uninstaller := agent.NewUninstaller(restConfig, bootstrapper, renderer)
+	return uninstaller, func() {
+	}, nil
+}
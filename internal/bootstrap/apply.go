@@ -105,17 +105,20 @@ func (b *Bootstrapper) applyObject(
 		Force:        &force,
 	}
 
-	var client dynamic.ResourceInterface
-	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
-		client = b.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace())
-	} else {
-		client = b.dynamic.Resource(mapping.Resource)
-	}
-
+	client := b.resourceClient(mapping, obj.GetNamespace())
 	_, err = client.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, patchOpts)
 	return err
 }
 
+// resourceClient returns the dynamic client of the resource of
+// mapping, in namespace if the resource is namespaced.
+func (b *Bootstrapper) resourceClient(mapping *meta.RESTMapping, namespace string) dynamic.ResourceInterface {
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return b.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	}
+	return b.dynamic.Resource(mapping.Resource)
+}
+
 // crdPollInterval is how often to check CRD establishment status.
 const crdPollInterval = 2 * time.Second
 
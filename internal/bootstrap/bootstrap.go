@@ -154,9 +154,33 @@ spec:
 // processed in lexicographic order so that ordering can be controlled
 // via file-name prefixes if needed.
 func (b *Bootstrapper) applyStage(ctx context.Context, fsys embed.FS, dir string) error {
+	files, err := stageFiles(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		b.log.Info("applying manifest", "file", f.name)
+		if err := b.applyManifest(ctx, f.data); err != nil {
+			return fmt.Errorf("apply manifest %s: %w", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// stageFile is an embedded YAML manifest of a bootstrap stage.
+type stageFile struct {
+	name string
+	data []byte
+}
+
+// stageFiles reads every embedded YAML manifest from the given
+// embed.FS directory in lexicographic order.
+func stageFiles(fsys embed.FS, dir string) ([]stageFile, error) {
 	entries, err := fsys.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("read embedded manifests directory %s: %w", dir, err)
+		return nil, fmt.Errorf("read embedded manifests directory %s: %w", dir, err)
 	}
 
 	// Sort entries explicitly (embed.FS returns sorted results per
@@ -165,6 +189,7 @@ func (b *Bootstrapper) applyStage(ctx context.Context, fsys embed.FS, dir string
 		return entries[i].Name() < entries[j].Name()
 	})
 
+	var files []stageFile
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
@@ -173,14 +198,10 @@ func (b *Bootstrapper) applyStage(ctx context.Context, fsys embed.FS, dir string
 		name := entry.Name()
 		data, err := fs.ReadFile(fsys, dir+"/"+name)
 		if err != nil {
-			return fmt.Errorf("read manifest %s: %w", name, err)
-		}
-
-		b.log.Info("applying manifest", "file", name)
-		if err := b.applyManifest(ctx, data); err != nil {
-			return fmt.Errorf("apply manifest %s: %w", name, err)
+			return nil, fmt.Errorf("read manifest %s: %w", name, err)
 		}
+		files = append(files, stageFile{name: name, data: data})
 	}
 
-	return nil
+	return files, nil
 }
@@ -0,0 +1,130 @@
+package bootstrap
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/otterscale/otterscale/manifests"
+)
+
+// Teardown removes the bootstrap layer from the cluster: the objects
+// of the embedded manifests, in the reverse order of Run. Only objects
+// the agent owns are deleted, that is objects applied by the
+// fieldManager and by no other Server-Side Apply manager; objects
+// another tool also applies, such as a cert-manager managed by GitOps,
+// are kept. Deleting the CRDs removes every custom resource of theirs.
+// With dryRun, the deletions are only validated by the API server.
+func (b *Bootstrapper) Teardown(ctx context.Context, dryRun bool) error {
+	var objects []*unstructured.Unstructured
+	for _, stage := range []struct {
+		fsys embed.FS
+		dir  string
+	}{
+		{manifests.Base, "bootstrap/base"},
+		{manifests.Platform, "bootstrap/platform"},
+	} {
+		files, err := stageFiles(stage.fsys, stage.dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			objs, err := parseMultiDoc(f.data)
+			if err != nil {
+				return fmt.Errorf("parse manifest %s: %w", f.name, err)
+			}
+			objects = append(objects, objs...)
+		}
+	}
+	slices.Reverse(objects)
+
+	b.log.Info("removing Layer 0 bootstrap", "dry_run", dryRun)
+	return b.deleteObjects(ctx, objects, true, dryRun)
+}
+
+// DeleteManifest deletes every object of a multi-document YAML
+// manifest, in order, regardless of its field managers. Objects that
+// do not exist, or whose API is not served, are skipped. With dryRun,
+// the deletions are only validated by the API server.
+func (b *Bootstrapper) DeleteManifest(ctx context.Context, data []byte, dryRun bool) error {
+	objects, err := parseMultiDoc(data)
+	if err != nil {
+		return fmt.Errorf("parse multi-doc YAML: %w", err)
+	}
+	return b.deleteObjects(ctx, objects, false, dryRun)
+}
+
+// deleteObjects deletes objects in order. With ownedOnly, objects not
+// owned by the fieldManager are kept.
+func (b *Bootstrapper) deleteObjects(ctx context.Context, objects []*unstructured.Unstructured, ownedOnly, dryRun bool) error {
+	propagation := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	mapper := b.newMapper()
+	for _, obj := range objects {
+		log := b.log.With("kind", obj.GetKind(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			continue // the API is not served, so there is nothing to delete
+		}
+		if err != nil {
+			return fmt.Errorf("map GVK %s: %w", gvk, err)
+		}
+		client := b.resourceClient(mapping, obj.GetNamespace())
+
+		opts := opts
+		if ownedOnly {
+			current, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("get %s %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+			}
+			if !ownedByFieldManager(current.GetManagedFields()) {
+				log.Info("keeping resource applied by another manager")
+				continue
+			}
+			// Do not delete an object recreated since it was read.
+			opts.Preconditions = metav1.NewUIDPreconditions(string(current.GetUID()))
+		}
+
+		err = client.Delete(ctx, obj.GetName(), opts)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("delete %s %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		}
+		log.Info("deleted resource")
+	}
+	return nil
+}
+
+// ownedByFieldManager reports whether the fieldManager applied the
+// object and no other manager did. Updates by other managers, such as
+// controllers writing status or injecting CA bundles, do not count.
+func ownedByFieldManager(entries []metav1.ManagedFieldsEntry) bool {
+	owned := false
+	for _, e := range entries {
+		if e.Operation != metav1.ManagedFieldsOperationApply {
+			continue
+		}
+		if e.Manager != fieldManager {
+			return false
+		}
+		owned = true
+	}
+	return owned
+}
@@ -13,11 +13,16 @@ import (
 // wired Agent together with a cleanup function.
 type AgentInjector func() (*agent.Agent, func(), error)
 
+// UninstallerInjector is a Wire-generated factory that creates a fully
+// wired Uninstaller together with a cleanup function.
+type UninstallerInjector func() (*agent.Uninstaller, func(), error)
+
 // NewAgentCommand returns the "agent" Cobra subcommand. The injector
 // is called lazily inside RunE so that expensive initialisation
 // (loading kubeconfig, etc.) only happens when the command actually
-// executes.
-func NewAgentCommand(conf *config.Config, newAgent AgentInjector) (*cobra.Command, error) {
+// executes. The agent flags are persistent so that the "uninstall"
+// subcommand shares the cluster name.
+func NewAgentCommand(conf *config.Config, newAgent AgentInjector, newUninstaller UninstallerInjector) (*cobra.Command, error) {
 	cmd := &cobra.Command{
 		Use:     "agent",
 		Short:   "Start agent that connects to server and executes requests in-cluster",
@@ -36,15 +41,49 @@ func NewAgentCommand(conf *config.Config, newAgent AgentInjector) (*cobra.Comman
 				Bootstrap:          conf.AgentBootstrap(),
 				ProxyPrometheusURL: conf.AgentProxyPrometheusURL(),
 				HarborURL:          conf.AgentHarborURL(),
+				MetricsAddress:     conf.AgentMetricsAddress(),
 			}
 
 			return agt.Run(cmd.Context(), cfg)
 		},
 	}
 
-	if err := conf.BindFlags(cmd.Flags(), config.AgentOptions); err != nil {
+	if err := conf.BindFlags(cmd.PersistentFlags(), config.AgentOptions); err != nil {
 		return nil, err
 	}
 
+	cmd.AddCommand(newAgentUninstallCommand(conf, newUninstaller))
+
 	return cmd, nil
 }
+
+// newAgentUninstallCommand returns the "agent uninstall" Cobra
+// subcommand, which removes the agent from the cluster of the current
+// kubeconfig or service account.
+func newAgentUninstallCommand(conf *config.Config, newUninstaller UninstallerInjector) *cobra.Command {
+	opts := agent.UninstallOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the agent and, optionally, its bootstrap layer from the cluster",
+		Long: "Remove the resources of the agent manifest from the cluster. With --remove-bootstrap, " +
+			"the bootstrap layer is removed as well, except for the resources that other tools also apply.",
+		Example: "otterscale agent uninstall --cluster=default --remove-bootstrap --dry-run",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			u, cleanup, err := newUninstaller()
+			if err != nil {
+				return fmt.Errorf("failed to initialize uninstaller: %w", err)
+			}
+			defer cleanup()
+
+			opts.Cluster = conf.AgentCluster()
+			return u.Run(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.Bootstrap, "remove-bootstrap", false, "Also remove the bootstrap layer (CRDs, FluxCD, cert-manager, ...) applied by the agent")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Only validate the deletions with the API server")
+
+	return cmd
+}
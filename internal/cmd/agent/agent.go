@@ -26,12 +26,21 @@ type Config struct {
 	Bootstrap          bool
 	ProxyPrometheusURL string
 	HarborURL          string
+	MetricsAddress     string
 }
 
 // SelfUpdater abstracts the self-update mechanism so it can be
 // injected via DI and mocked in tests.
 type SelfUpdater interface {
-	Patch(ctx context.Context, version string) error
+	// Patch starts a self-update to version, pulling image with the
+	// given pull secrets.
+	Patch(ctx context.Context, version, image string, pullSecrets []string) error
+	// Confirm ends a pending self-update to version once an agent of
+	// that version has registered.
+	Confirm(ctx context.Context, version string) error
+	// Watch reverts self-updates that are not confirmed in time. It
+	// blocks until ctx is canceled.
+	Watch(ctx context.Context)
 }
 
 // Agent binds a local HTTP reverse-proxy to a dynamically allocated
@@ -43,20 +52,24 @@ type Agent struct {
 	version      core.Version
 	bootstrapper *bootstrap.Bootstrapper
 	updater      SelfUpdater
+	credentials  CredentialStore
 }
 
 // NewAgent returns an Agent wired to the given handler, tunnel
-// consumer, bootstrapper, and self-updater. version is injected via
-// DI and used for version-mismatch detection during registration.
-func NewAgent(cfg *rest.Config, handler *Handler, tunnel core.TunnelConsumer, version core.Version, bootstrapper *bootstrap.Bootstrapper, updater SelfUpdater) *Agent {
-	return &Agent{cfg: cfg, handler: handler, tunnel: tunnel, version: version, bootstrapper: bootstrapper, updater: updater}
+// consumer, bootstrapper, self-updater, and credential store. version
+// is injected via DI and used for version-mismatch detection during
+// registration.
+func NewAgent(cfg *rest.Config, handler *Handler, tunnel core.TunnelConsumer, version core.Version, bootstrapper *bootstrap.Bootstrapper, updater SelfUpdater, credentials CredentialStore) *Agent {
+	return &Agent{cfg: cfg, handler: handler, tunnel: tunnel, version: version, bootstrapper: bootstrapper, updater: updater, credentials: credentials}
 }
 
 // Run starts the agent. When bootstrap is enabled, it first applies
 // embedded infrastructure manifests (FluxCD) to the local
 // cluster. It then creates an in-memory pipe listener for the HTTP
 // server, a TCP bridge for chisel to forward to, and a tunnel client,
-// then blocks until ctx is canceled.
+// and, if a metrics address is configured, the Prometheus metrics
+// endpoint, along with the watch for self-updates that have to be
+// reverted. It blocks until ctx is canceled.
 func (a *Agent) Run(ctx context.Context, cfg *Config) error {
 	if cfg.Bootstrap {
 		if err := a.bootstrapper.Run(ctx, cfg.HarborURL); err != nil {
@@ -66,7 +79,7 @@ func (a *Agent) Run(ctx context.Context, cfg *Config) error {
 
 	pl := pipe.NewListener()
 
-	bridge, err := tunnel.NewBridge(ctx, pl)
+	bridge, err := tunnel.NewBridge(ctx, pl, tunnel.WithBridgeCluster(cfg.Cluster))
 	if err != nil {
 		return fmt.Errorf("failed to create tunnel bridge: %w", err)
 	}
@@ -86,47 +99,113 @@ func (a *Agent) Run(ctx context.Context, cfg *Config) error {
 		tunnel.WithCluster(cfg.Cluster),
 		tunnel.WithLocalPort(bridge.Port()),
 		tunnel.WithRegister(a.register()),
+		tunnel.WithRenew(a.renew()),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create tunnel client: %w", err)
 	}
-	return transport.Serve(ctx, httpSrv, bridge, tunnelClt)
+
+	listeners := []transport.Listener{httpSrv, bridge, tunnelClt, newUpdateWatcher(a.updater)}
+	if cfg.MetricsAddress != "" {
+		metricsSrv, err := http.NewServer(
+			ctx,
+			http.WithAddress(cfg.MetricsAddress),
+			http.WithMount(mountMetrics),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create metrics server: %w", err)
+		}
+		listeners = append(listeners, metricsSrv)
+	}
+
+	return transport.Serve(ctx, listeners...)
 }
 
 // register wraps the TunnelConsumer so that it returns a
 // RegisterResult containing mTLS credentials and derived auth.
-// After a successful registration it checks whether the server
-// version diverges from the agent version and, if so, triggers a
-// self-update by patching its own Deployment image.
+// The registration is authorized by the identity of the previous
+// registration if there is one, falling back to the enrollment token
+// if the server rejects it (e.g. because the certificate expired
+// while the agent was down). It reports the cluster facts along with
+// the registration, or none if they cannot be collected. After a
+// successful registration it
+// checks whether the server version diverges from the agent version
+// and, if so, triggers a self-update by patching its own Deployment
+// image.
 func (a *Agent) register() tunnel.RegisterFunc {
 	return func(ctx context.Context, serverURL, cluster string) (*tunnel.RegisterResult, error) {
-		reg, err := a.tunnel.Register(ctx, serverURL, cluster)
+		creds, err := a.credentials.Load(ctx)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("load credentials: %w", err)
 		}
 
-		// Check version and trigger self-update if needed.
-		a.checkVersion(ctx, &reg)
+		facts, err := collectFacts(ctx, a.cfg)
+		if err != nil {
+			slog.Warn("failed to collect cluster facts", "error", err)
+		}
+
+		reg, err := a.tunnel.Register(ctx, serverURL, cluster, creds, facts)
+		if err != nil && creds.HasIdentity() && creds.Token != "" {
+			slog.Warn("registration with previous identity failed, retrying with enrollment token", "error", err)
+			reg, err = a.tunnel.Register(ctx, serverURL, cluster, core.AgentCredentials{UID: creds.UID, Token: creds.Token}, facts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return a.toRegisterResult(ctx, &reg)
+	}
+}
 
-		// Derive the chisel auth string from the signed
-		// certificate. This must match the password the server
-		// computed when it signed the same certificate.
-		auth, err := pki.DeriveAuth(reg.AgentID, reg.Certificate)
+// renew wraps the TunnelConsumer so that the tunnel client can renew
+// its certificate before it expires. The renewed registration goes
+// through the same version check as a fresh one.
+func (a *Agent) renew() tunnel.RenewFunc {
+	return func(ctx context.Context, serverURL, cluster string, current *tunnel.RegisterResult) (*tunnel.RegisterResult, error) {
+		reg, err := a.tunnel.Renew(ctx, serverURL, cluster, core.Registration{
+			Endpoint:      current.Endpoint,
+			Certificate:   current.CertPEM,
+			CACertificate: current.CACertPEM,
+			PrivateKeyPEM: current.KeyPEM,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("derive auth: %w", err)
+			return nil, err
 		}
+		return a.toRegisterResult(ctx, &reg)
+	}
+}
 
-		return &tunnel.RegisterResult{
-			Endpoint:  reg.Endpoint,
-			Auth:      auth,
-			CACertPEM: reg.CACertificate,
-			CertPEM:   reg.Certificate,
-			KeyPEM:    reg.PrivateKeyPEM,
-		}, nil
+// toRegisterResult records the new identity, checks the server
+// version and converts a registration into the credentials used by
+// the tunnel client.
+func (a *Agent) toRegisterResult(ctx context.Context, reg *core.Registration) (*tunnel.RegisterResult, error) {
+	// Keep the identity so that the next registration, possibly from
+	// a restarted pod, does not need the spent enrollment token.
+	if err := a.credentials.SaveIdentity(ctx, reg.Certificate, reg.PrivateKeyPEM); err != nil {
+		slog.Warn("failed to persist agent identity", "error", err)
 	}
+
+	// Check version and trigger self-update if needed.
+	a.checkVersion(ctx, reg)
+
+	// Derive the chisel auth string from the signed certificate. This
+	// must match the password the server computed when it signed the
+	// same certificate.
+	auth, err := pki.DeriveAuth(reg.AgentID, reg.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("derive auth: %w", err)
+	}
+
+	return &tunnel.RegisterResult{
+		Endpoint:  reg.Endpoint,
+		Auth:      auth,
+		CACertPEM: reg.CACertificate,
+		CertPEM:   reg.Certificate,
+		KeyPEM:    reg.PrivateKeyPEM,
+	}, nil
 }
 
-// checkVersion compares the agent and server versions. When they
+// checkVersion compares the agent version with the version the hub
+// wants it to run, which the hub's upgrade policy decides. When they
 // differ and a self-updater is configured, the agent patches its own
 // Deployment image to trigger a rolling update. Errors are logged but
 // do not prevent the tunnel from connecting — the agent continues to
@@ -143,15 +222,18 @@ func (a *Agent) checkVersion(ctx context.Context, reg *core.Registration) {
 
 	if reg.ServerVersion == agentVersion {
 		log.Info("version match", "version", agentVersion)
+		if err := a.updater.Confirm(ctx, agentVersion); err != nil {
+			log.Warn("failed to confirm self-update", "error", err)
+		}
 		return
 	}
 
 	log.Warn("version mismatch detected",
 		"agent_version", agentVersion,
-		"server_version", reg.ServerVersion,
+		"desired_version", reg.ServerVersion,
 	)
 
-	if err := a.updater.Patch(ctx, reg.ServerVersion); err != nil {
+	if err := a.updater.Patch(ctx, reg.ServerVersion, reg.AgentImage, reg.ImagePullSecrets); err != nil {
 		log.Error("self-update failed", "error", err)
 	}
 }
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+const (
+	// enrollmentSecretName is the Secret rendered into the agent
+	// manifest. It carries the enrollment token and, once the agent
+	// has registered, the agent's latest certificate and key.
+	enrollmentSecretName = "otterscale-agent-enrollment"
+
+	// enrollmentTokenKey is the Secret data key holding the
+	// enrollment token.
+	enrollmentTokenKey = "token"
+
+	// uidNamespace is the namespace whose UID identifies the cluster.
+	// kube-system exists in every cluster and is never recreated, so
+	// its UID is stable across agent restarts and re-installations.
+	uidNamespace = "kube-system"
+)
+
+// CredentialStore abstracts where the agent keeps the credentials it
+// registers with, so that it can be injected via DI and mocked in
+// tests.
+type CredentialStore interface {
+	// Load returns the agent UID, the enrollment token and the
+	// latest identity.
+	Load(ctx context.Context) (core.AgentCredentials, error)
+	// SaveIdentity records the certificate and key of the latest
+	// registration or renewal.
+	SaveIdentity(ctx context.Context, certPEM, keyPEM []byte) error
+}
+
+// secretCredentials keeps the agent credentials in the enrollment
+// Secret in the agent's namespace, so that a restarted agent pod can
+// register again with the identity of its predecessor after the
+// single-use enrollment token has been spent. The latest identity is
+// also kept in memory and takes precedence, so that a failed write
+// does not lock a running agent out. It implements CredentialStore.
+type secretCredentials struct {
+	mu       sync.Mutex
+	client   kubernetes.Interface // cached clientset
+	cfg      *rest.Config
+	identity core.AgentCredentials // latest identity, token unset
+	uid      string                // cached agent UID
+	log      *slog.Logger
+}
+
+// Verify at compile time that *secretCredentials satisfies
+// CredentialStore.
+var _ CredentialStore = (*secretCredentials)(nil)
+
+// NewCredentialStore returns a CredentialStore backed by the agent's
+// enrollment Secret. It is exported for Wire injection.
+func NewCredentialStore(cfg *rest.Config) CredentialStore {
+	return &secretCredentials{
+		cfg: cfg,
+		log: slog.Default().With("component", "credentials"),
+	}
+}
+
+// Load reads the agent UID and the enrollment Secret. A missing Secret
+// yields no token or identity so that the server reports the
+// registration as unauthenticated.
+func (s *secretCredentials) Load(ctx context.Context) (core.AgentCredentials, error) {
+	uid, err := s.getUID(ctx)
+	if err != nil {
+		return core.AgentCredentials{}, err
+	}
+	creds := core.AgentCredentials{UID: uid}
+
+	secret, err := s.getSecret(ctx)
+	if err != nil {
+		return core.AgentCredentials{}, err
+	}
+	if secret != nil {
+		creds.Token = string(secret.Data[enrollmentTokenKey])
+		creds.Certificate = secret.Data[corev1.TLSCertKey]
+		creds.PrivateKeyPEM = secret.Data[corev1.TLSPrivateKeyKey]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.identity.HasIdentity() {
+		creds.Certificate = s.identity.Certificate
+		creds.PrivateKeyPEM = s.identity.PrivateKeyPEM
+	}
+	return creds, nil
+}
+
+// SaveIdentity merges the certificate and key into the enrollment
+// Secret. The token entry is left untouched.
+func (s *secretCredentials) SaveIdentity(ctx context.Context, certPEM, keyPEM []byte) error {
+	s.mu.Lock()
+	s.identity = core.AgentCredentials{Certificate: certPEM, PrivateKeyPEM: keyPEM}
+	s.mu.Unlock()
+
+	client, err := s.getOrCreateClient()
+	if err != nil {
+		return fmt.Errorf("create kube client: %w", err)
+	}
+	namespace, err := detectNamespace()
+	if err != nil {
+		return fmt.Errorf("save identity: %w", err)
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"data": map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal patch: %w", err)
+	}
+
+	_, err = client.CoreV1().Secrets(namespace).Patch(ctx, enrollmentSecretName, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("patch secret %s/%s: %w", namespace, enrollmentSecretName, err)
+	}
+	return nil
+}
+
+// getUID returns the UID of the uidNamespace namespace, caching it
+// after the first successful lookup.
+func (s *secretCredentials) getUID(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	uid := s.uid
+	s.mu.Unlock()
+	if uid != "" {
+		return uid, nil
+	}
+
+	client, err := s.getOrCreateClient()
+	if err != nil {
+		return "", fmt.Errorf("create kube client: %w", err)
+	}
+	ns, err := client.CoreV1().Namespaces().Get(ctx, uidNamespace, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("read namespace %s: %w", uidNamespace, err)
+	}
+
+	s.mu.Lock()
+	s.uid = string(ns.UID)
+	s.mu.Unlock()
+	return string(ns.UID), nil
+}
+
+// getSecret returns the enrollment Secret, or nil if it does not
+// exist.
+func (s *secretCredentials) getSecret(ctx context.Context) (*corev1.Secret, error) {
+	client, err := s.getOrCreateClient()
+	if err != nil {
+		return nil, fmt.Errorf("create kube client: %w", err)
+	}
+	namespace, err := detectNamespace()
+	if err != nil {
+		return nil, fmt.Errorf("load credentials: %w", err)
+	}
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, enrollmentSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.log.Warn("enrollment secret not found", "namespace", namespace, "name", enrollmentSecretName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secret %s/%s: %w", namespace, enrollmentSecretName, err)
+	}
+	return secret, nil
+}
+
+// getOrCreateClient returns the cached Kubernetes clientset, creating
+// it on first use.
+func (s *secretCredentials) getOrCreateClient() (kubernetes.Interface, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	client, err := kubernetes.NewForConfig(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
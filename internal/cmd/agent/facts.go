@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// collectFacts gathers the facts the agent reports to the hub on every
+// registration: the Kubernetes version, the node count and the
+// infrastructure provider of the local cluster.
+func collectFacts(ctx context.Context, cfg *rest.Config) (core.ClusterFacts, error) {
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return core.ClusterFacts{}, fmt.Errorf("create kube client: %w", err)
+	}
+
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return core.ClusterFacts{}, fmt.Errorf("read server version: %w", err)
+	}
+
+	// ResourceVersion "0" serves the list from the API server cache.
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return core.ClusterFacts{}, fmt.Errorf("list nodes: %w", err)
+	}
+
+	facts := core.ClusterFacts{
+		KubernetesVersion: info.GitVersion,
+		NodeCount:         len(nodes.Items),
+	}
+	for i := range nodes.Items {
+		if provider := providerName(nodes.Items[i].Spec.ProviderID); provider != "" {
+			facts.Provider = provider
+			break
+		}
+	}
+	return facts, nil
+}
+
+// providerName returns the scheme of a node provider ID such as
+// "aws:///eu-west-1a/i-0123", or "" if it has none.
+func providerName(providerID string) string {
+	name, _, ok := strings.Cut(providerID, "://")
+	if !ok {
+		return ""
+	}
+	return name
+}
@@ -1,18 +1,22 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
 	utilproxy "k8s.io/apimachinery/pkg/util/proxy"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
 
 	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/core"
 )
 
 // proxyPathPrefix is the URL prefix reserved for service proxies
@@ -20,6 +24,10 @@ import (
 // are routed to a dedicated reverse proxy instead of kube-apiserver.
 const proxyPathPrefix = "/__otterscale/proxy/"
 
+// readyzTimeout bounds the kube-apiserver readiness check of the
+// health endpoint, leaving the hub's probe time to receive the answer.
+const readyzTimeout = 3 * time.Second
+
 // Handler is a reverse proxy that forwards incoming HTTP requests to
 // the local kube-apiserver and, optionally, to configured in-cluster
 // services (e.g. Prometheus). It is the spoke-side component in the
@@ -69,7 +77,8 @@ func (h *Handler) mountPrometheusProxy(mux *http.ServeMux) error {
 }
 
 // mountKubeProxy registers the catch-all reverse proxy to
-// kube-apiserver with WebSocket/SPDY upgrade support.
+// kube-apiserver with WebSocket/SPDY upgrade support, and the health
+// endpoint the hub probes through the tunnel.
 func (h *Handler) mountKubeProxy(mux *http.ServeMux) error {
 	host := h.cfg.Host
 	if !strings.HasSuffix(host, "/") {
@@ -95,6 +104,47 @@ func (h *Handler) mountKubeProxy(mux *http.ServeMux) error {
 	proxy.UseRequestLocation = true
 	proxy.UseLocationHost = true
 	mux.Handle("/", proxy)
+	mux.Handle(core.AgentHealthPath, &healthHandler{readyz: targetURL.JoinPath("readyz"), client: &http.Client{Transport: rt}})
+	return nil
+}
+
+// healthHandler serves core.AgentHealthPath. That the request arrives
+// shows the tunnel and the agent work; the response tells whether
+// kube-apiserver is ready, as reported by its /readyz endpoint.
+type healthHandler struct {
+	readyz *url.URL
+	client *http.Client
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.checkReadyz(r.Context()); err != nil {
+		w.Header().Set(core.AgentHealthHeader, "not-ready")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set(core.AgentHealthHeader, "ready")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// checkReadyz returns an error unless kube-apiserver reports ready.
+func (h *healthHandler) checkReadyz(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.readyz.String(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req) // #nosec G704 -- the target is the configured kube-apiserver
+	if err != nil {
+		return fmt.Errorf("kube-apiserver readyz: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kube-apiserver readyz: %s", resp.Status)
+	}
 	return nil
 }
 
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ready := true
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			http.NotFound(w, r)
+			return
+		}
+		if !ready {
+			http.Error(w, "etcd not ready", http.StatusInternalServerError)
+		}
+	}))
+	defer apiserver.Close()
+
+	base, err := url.Parse(apiserver.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &healthHandler{readyz: base.JoinPath("readyz"), client: apiserver.Client()}
+
+	for _, tt := range []struct {
+		ready      bool
+		wantStatus int
+		wantHeader string
+	}{
+		{true, http.StatusOK, "ready"},
+		{false, http.StatusServiceUnavailable, "not-ready"},
+	} {
+		ready = tt.ready
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequestWithContext(t.Context(), http.MethodGet, core.AgentHealthPath, http.NoBody))
+
+		if rec.Code != tt.wantStatus || rec.Header().Get(core.AgentHealthHeader) != tt.wantHeader {
+			t.Errorf("ready=%v: got %d %q, want %d %q", tt.ready, rec.Code, rec.Header().Get(core.AgentHealthHeader), tt.wantStatus, tt.wantHeader)
+		}
+	}
+}
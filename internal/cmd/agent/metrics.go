@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+// mountMetrics registers the Prometheus metrics endpoint of the agent,
+// which exports the tunnel traffic recorded by the bridge.
+func mountMetrics(mux *http.ServeMux) error {
+	exporter, err := prometheus.New()
+	if err != nil {
+		return err
+	}
+	// NOTE: Like the hub, this sets the global OTel MeterProvider, which
+	// the tunnel metrics are recorded to.
+	otel.SetMeterProvider(metric.NewMeterProvider(metric.WithReader(exporter)))
+	mux.Handle("/metrics", promhttp.Handler())
+	return nil
+}
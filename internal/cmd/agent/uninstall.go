@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/bootstrap"
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+const (
+	// namespaceAdminBinding is the RoleBinding the namespace-scoped
+	// manifest profile renders in each allow-listed namespace.
+	namespaceAdminBinding = "otterscale-namespace-admin"
+
+	// managedBySelector selects the resources the agent manifest
+	// renders outside the agent namespace.
+	managedBySelector = "app.kubernetes.io/managed-by=otterscale"
+)
+
+// UninstallOptions configures an Uninstaller run.
+type UninstallOptions struct {
+	// Cluster is the name the agent registered under.
+	Cluster string
+	// Bootstrap additionally removes the bootstrap layer the agent
+	// applied, keeping the resources other tools also apply.
+	Bootstrap bool
+	// DryRun only has the API server validate the deletions.
+	DryRun bool
+}
+
+// Uninstaller removes the agent from the cluster it runs against: the
+// resources of the agent manifest and, on request, the bootstrap
+// layer. It is the command-line counterpart of the uninstall manifest
+// the hub serves.
+type Uninstaller struct {
+	cfg          *rest.Config
+	bootstrapper *bootstrap.Bootstrapper
+	renderer     core.ManifestRenderer
+	log          *slog.Logger
+}
+
+// NewUninstaller returns an Uninstaller. It is exported for Wire
+// injection.
+func NewUninstaller(cfg *rest.Config, bootstrapper *bootstrap.Bootstrapper, renderer core.ManifestRenderer) *Uninstaller {
+	return &Uninstaller{
+		cfg:          cfg,
+		bootstrapper: bootstrapper,
+		renderer:     renderer,
+		log:          slog.Default().With("component", "uninstaller"),
+	}
+}
+
+// Run removes the agent. The namespace admin bindings and the
+// bootstrap layer are removed first, and the resources of the agent
+// manifest last, so that a run with the agent's own service account
+// keeps its permissions until the end.
+func (u *Uninstaller) Run(ctx context.Context, opts UninstallOptions) error {
+	if err := core.ValidateClusterName(opts.Cluster); err != nil {
+		return err
+	}
+	manifest, err := u.renderer.RenderUninstallManifest(opts.Cluster)
+	if err != nil {
+		return err
+	}
+
+	client, err := kubernetes.NewForConfig(u.cfg)
+	if err != nil {
+		return fmt.Errorf("create kubernetes client: %w", err)
+	}
+	if err := u.deleteNamespaceBindings(ctx, client, opts.DryRun); err != nil {
+		return err
+	}
+
+	if opts.Bootstrap {
+		if err := u.bootstrapper.Teardown(ctx, opts.DryRun); err != nil {
+			return fmt.Errorf("remove bootstrap: %w", err)
+		}
+	}
+
+	u.log.Info("removing agent", "cluster", opts.Cluster, "dry_run", opts.DryRun)
+	if err := u.bootstrapper.DeleteManifest(ctx, []byte(manifest), opts.DryRun); err != nil {
+		return fmt.Errorf("remove agent: %w", err)
+	}
+	return nil
+}
+
+// deleteNamespaceBindings deletes the namespace admin bindings of the
+// namespace-scoped profile in every namespace. Other RoleBindings
+// carrying the managed-by label are left alone.
+func (u *Uninstaller) deleteNamespaceBindings(ctx context.Context, client kubernetes.Interface, dryRun bool) error {
+	bindings, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: managedBySelector})
+	if err != nil {
+		return fmt.Errorf("list namespace admin bindings: %w", err)
+	}
+
+	opts := metav1.DeleteOptions{}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	for _, b := range bindings.Items {
+		if b.Name != namespaceAdminBinding {
+			continue
+		}
+		err := client.RbacV1().RoleBindings(b.Namespace).Delete(ctx, b.Name, opts)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("delete namespace admin binding in %s: %w", b.Namespace, err)
+		}
+		u.log.Info("deleted namespace admin binding", "namespace", b.Namespace)
+	}
+	return nil
+}
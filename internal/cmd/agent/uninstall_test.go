@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func roleBinding(namespace, name string, labels map[string]string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func TestDeleteNamespaceBindings(t *testing.T) {
+	managed := map[string]string{"app.kubernetes.io/managed-by": "otterscale"}
+	client := fake.NewClientset(
+		roleBinding("team-a", namespaceAdminBinding, managed),
+		roleBinding("team-b", namespaceAdminBinding, managed),
+		roleBinding("team-a", "other", managed),
+		roleBinding("team-c", namespaceAdminBinding, nil),
+	)
+
+	u := NewUninstaller(&rest.Config{}, nil, nil)
+	if err := u.deleteNamespaceBindings(t.Context(), client, false); err != nil {
+		t.Fatalf("deleteNamespaceBindings: %v", err)
+	}
+
+	for _, tt := range []struct {
+		namespace, name string
+		deleted         bool
+	}{
+		{"team-a", namespaceAdminBinding, true},
+		{"team-b", namespaceAdminBinding, true},
+		{"team-a", "other", false},
+		{"team-c", namespaceAdminBinding, false},
+	} {
+		_, err := client.RbacV1().RoleBindings(tt.namespace).Get(t.Context(), tt.name, metav1.GetOptions{})
+		if deleted := apierrors.IsNotFound(err); deleted != tt.deleted {
+			t.Errorf("%s/%s deleted = %v, want %v (err %v)", tt.namespace, tt.name, deleted, tt.deleted, err)
+		}
+	}
+}
+
+func TestUninstall_InvalidCluster(t *testing.T) {
+	u := NewUninstaller(&rest.Config{}, nil, nil)
+	if err := u.Run(t.Context(), UninstallOptions{Cluster: "Not_Valid"}); err == nil {
+		t.Error("expected an error for an invalid cluster name")
+	}
+}
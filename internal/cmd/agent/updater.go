@@ -8,24 +8,60 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/Masterminds/semver/v3"
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/core"
 )
 
 const (
-	// imageRepo is the fixed container image repository shared by both
-	// the server and the agent. The tag is derived from the version.
-	imageRepo = "ghcr.io/otterscale/otterscale"
-
 	// containerName is the name of the container inside the Deployment to patch.
 	containerName = "otterscale"
 
 	// deploymentName is the default Kubernetes Deployment name used for the agent.
 	deploymentName = "otterscale-agent"
+
+	// previousImageAnnotation records the image the agent Deployment
+	// ran before its latest self-update.
+	previousImageAnnotation = "otterscale.io/previous-image"
+
+	// updateDeadlineAnnotation records the time by which the latest
+	// self-update has to be confirmed. It is removed on confirmation.
+	updateDeadlineAnnotation = "otterscale.io/update-deadline"
+
+	// updateImageAnnotation records the image the latest self-update
+	// patched the agent Deployment to. It is removed on confirmation.
+	updateImageAnnotation = "otterscale.io/update-image"
+
+	// updateVersionAnnotation records the agent version of the image
+	// the latest self-update patched the agent Deployment to. It is
+	// removed on confirmation.
+	updateVersionAnnotation = "otterscale.io/update-version"
+
+	// rejectedImageAnnotation records the image of the latest
+	// self-update that was reverted. It is refused by later updates
+	// until the annotation is removed, which happens once an update
+	// to another image is confirmed, or once the rejected image,
+	// set on the Deployment by an admin, registers.
+	rejectedImageAnnotation = "otterscale.io/rejected-image"
+
+	// updateConfirmTimeout is the time a new agent has to register
+	// after a self-update before the update is reverted.
+	updateConfirmTimeout = 10 * time.Minute
+
+	// updateWatchInterval is the interval at which pending
+	// self-updates are checked against their deadline.
+	updateWatchInterval = 30 * time.Second
+
+	// minReadySeconds keeps the old agent pod running until the new
+	// one has been up for this long, so that an image that crashes on
+	// start leaves the old pod in place to revert it.
+	minReadySeconds = 30
 )
 
 // inClusterNamespacePath is the standard Kubernetes path that exposes
@@ -54,28 +90,41 @@ func NewUpdater(cfg *rest.Config) SelfUpdater {
 	}
 }
 
-// imageRef constructs the full image reference from the fixed repo
-// and the given version tag.
+// imageRef constructs the full image reference from the default
+// repository and the given version tag. It is used when the hub does
+// not send an image reference.
 func imageRef(version string) string {
-	return imageRepo + ":" + version
+	return core.DefaultAgentImageRepository + ":" + version
+}
+
+// deploymentPatch is the minimal JSON structure for a strategic merge
+// patch of the agent Deployment. A nil annotation value removes the
+// annotation.
+type deploymentPatch struct {
+	Metadata metadataPatch `json:"metadata,omitzero"`
+	Spec     specPatch     `json:"spec,omitzero"`
 }
 
-// containerPatch is the minimal JSON structure for a strategic merge
-// patch that updates a single container's image.
-type containerPatch struct {
-	Spec specPatch `json:"spec"`
+type metadataPatch struct {
+	Annotations map[string]*string `json:"annotations,omitempty"`
 }
 
 type specPatch struct {
-	Template templatePatch `json:"template"`
+	MinReadySeconds int32         `json:"minReadySeconds,omitempty"`
+	Template        templatePatch `json:"template,omitzero"`
 }
 
 type templatePatch struct {
-	Spec podSpecPatch `json:"spec"`
+	Spec podSpecPatch `json:"spec,omitzero"`
 }
 
 type podSpecPatch struct {
-	Containers []containerImagePatch `json:"containers"`
+	ImagePullSecrets []localObjectReference `json:"imagePullSecrets,omitempty"`
+	Containers       []containerImagePatch  `json:"containers,omitempty"`
+}
+
+type localObjectReference struct {
+	Name string `json:"name"`
 }
 
 type containerImagePatch struct {
@@ -83,56 +132,273 @@ type containerImagePatch struct {
 	Image string `json:"image"`
 }
 
-// patch updates the agent Deployment's container image to match the
-// given version using a strategic merge patch. This preserves all
-// other Deployment configuration (resources, env, volumes, etc.).
-// The version string is validated as semver to prevent arbitrary
-// image tag injection from a compromised server.
-func (u *updater) Patch(ctx context.Context, version string) error {
-	// Validate the version is a legitimate semver tag to prevent
-	// arbitrary image injection (e.g. "latest@sha256:malicious...").
-	if _, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v")); err != nil {
-		return fmt.Errorf("invalid server version %q: %w", version, err)
+// imagePatch returns a patch that sets the agent container image and
+// the given annotations, and adds the given image pull secrets.
+func imagePatch(image string, pullSecrets []string, annotations map[string]*string) deploymentPatch {
+	var refs []localObjectReference
+	for _, name := range pullSecrets {
+		refs = append(refs, localObjectReference{Name: name})
 	}
-
-	client, err := u.getOrCreateClient()
-	if err != nil {
-		return fmt.Errorf("create kube client: %w", err)
-	}
-
-	image := imageRef(version)
-
-	p := containerPatch{
+	return deploymentPatch{
+		Metadata: metadataPatch{Annotations: annotations},
 		Spec: specPatch{
+			MinReadySeconds: minReadySeconds,
 			Template: templatePatch{
 				Spec: podSpecPatch{
-					Containers: []containerImagePatch{
-						{
-							Name:  containerName,
-							Image: image,
-						},
-					},
+					ImagePullSecrets: refs,
+					Containers:       []containerImagePatch{{Name: containerName, Image: image}},
 				},
 			},
 		},
 	}
+}
 
-	data, err := json.Marshal(p)
-	if err != nil {
-		return fmt.Errorf("marshal patch: %w", err)
+// Patch updates the agent Deployment's container image to image, the
+// reference of the given version, using a strategic merge patch. This
+// preserves all other Deployment configuration (resources, env,
+// volumes, etc.); the pull secrets are added to the existing ones. An
+// empty image stands for the version in the default repository. The
+// version string is validated as semver, and must be the tag of image,
+// to prevent arbitrary image tag injection from a compromised server.
+// Downgrades and major version jumps are refused, except for a return
+// to the image recorded before the previous update. The current image
+// is recorded along with a deadline by which the new agent has to
+// confirm the update; see Watch.
+func (u *updater) Patch(ctx context.Context, version, image string, pullSecrets []string) error {
+	// Validate the version is a legitimate semver tag to prevent
+	// arbitrary image injection (e.g. "latest@sha256:malicious...").
+	if _, err := core.ParseAgentVersion(version); err != nil {
+		return fmt.Errorf("invalid server version %q: %w", version, err)
+	}
+	if image == "" {
+		image = imageRef(version)
+	}
+	if _, tag, _, err := core.ParseAgentImage(image); err != nil {
+		return fmt.Errorf("invalid agent image: %w", err)
+	} else if tag != version {
+		return fmt.Errorf("invalid agent image %q: tag does not match version %q", image, version)
+	}
+	if err := (core.AgentImageConfig{PullSecrets: pullSecrets}).Validate(); err != nil {
+		return fmt.Errorf("invalid image pull secrets: %w", err)
 	}
 
-	namespace, err := detectNamespace()
+	client, namespace, err := u.target()
 	if err != nil {
 		return fmt.Errorf("self-update: %w", err)
 	}
+	return u.update(ctx, client, namespace, version, image, pullSecrets)
+}
+
+// update patches the agent Deployment in namespace to image, the
+// reference of version, as described by Patch.
+func (u *updater) update(ctx context.Context, client kubernetes.Interface, namespace, version, image string, pullSecrets []string) error {
+	deploy, err := client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get deployment: %w", err)
+	}
+
+	current := containerImage(deploy)
+	if current == image {
+		u.log.Debug("agent deployment already runs the image", "image", image)
+		return nil
+	}
+	if deploy.Annotations[rejectedImageAnnotation] == image {
+		return fmt.Errorf("refusing self-update to %s: the image was rolled back before", image)
+	}
+
+	var annotations map[string]*string
+	if deploy.Annotations[previousImageAnnotation] == image {
+		// Returning to the image the agent came from is allowed even
+		// if it is a downgrade; it is known to work.
+		annotations = pendingUpdateAnnotations(nil, nil, nil)
+		annotations[previousImageAnnotation] = nil
+	} else {
+		if err := core.CheckAgentUpgrade(imageTag(current), version); err != nil {
+			return fmt.Errorf("refusing self-update: %w", err)
+		}
+		deadline := time.Now().Add(updateConfirmTimeout).UTC().Format(time.RFC3339)
+		annotations = pendingUpdateAnnotations(&deadline, &image, &version)
+		annotations[previousImageAnnotation] = &current
+	}
 
 	u.log.Info("patching agent deployment",
 		"deployment", deploymentName,
 		"namespace", namespace,
 		"image", image,
+		"previous_image", current,
+	)
+	if err := u.patch(ctx, client, namespace, imagePatch(image, pullSecrets, annotations)); err != nil {
+		return err
+	}
+
+	u.log.Info("agent deployment patched, rolling update will restart the agent")
+	return nil
+}
+
+// Confirm ends the pending update of the agent Deployment to version,
+// so that Watch no longer reverts it, and lifts the rejection of an
+// earlier image. It is called once an agent of that version has
+// registered; updates to other versions are left pending.
+func (u *updater) Confirm(ctx context.Context, version string) error {
+	client, namespace, err := u.target()
+	if err != nil {
+		return fmt.Errorf("confirm self-update: %w", err)
+	}
+	return u.confirm(ctx, client, namespace, version)
+}
+
+// confirm ends the pending update of the agent Deployment in
+// namespace to version, as described by Confirm. The update is only
+// confirmed while the Deployment still runs the image it patched,
+// which may be pinned by digest alone; updates recorded without that
+// image are confirmed by the tag of the running image. Without a
+// pending update, the rejection is only lifted if the Deployment runs
+// the rejected image itself, which an admin set to retry it.
+func (u *updater) confirm(ctx context.Context, client kubernetes.Interface, namespace, version string) error {
+	deploy, err := client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get deployment: %w", err)
+	}
+	current := containerImage(deploy)
+	rejected, isRejected := deploy.Annotations[rejectedImageAnnotation]
+
+	if _, ok := deploy.Annotations[updateDeadlineAnnotation]; !ok {
+		if !isRejected || current != rejected || imageTag(current) != version {
+			return nil
+		}
+		p := deploymentPatch{Metadata: metadataPatch{Annotations: map[string]*string{rejectedImageAnnotation: nil}}}
+		if err := u.patch(ctx, client, namespace, p); err != nil {
+			return err
+		}
+		u.log.Info("rejected image registered, lifting its rejection", "version", version, "image", current)
+		return nil
+	}
+	if patched, ok := deploy.Annotations[updateImageAnnotation]; ok {
+		if current != patched || deploy.Annotations[updateVersionAnnotation] != version {
+			return nil
+		}
+	} else if imageTag(current) != version {
+		return nil
+	}
+
+	annotations := pendingUpdateAnnotations(nil, nil, nil)
+	if isRejected {
+		annotations[rejectedImageAnnotation] = nil
+	}
+	p := deploymentPatch{Metadata: metadataPatch{Annotations: annotations}}
+	if err := u.patch(ctx, client, namespace, p); err != nil {
+		return err
+	}
+	u.log.Info("self-update confirmed", "version", version, "image", current, "lifted_rejection", rejected)
+	return nil
+}
+
+// pendingUpdateAnnotations returns the annotations that record a
+// pending self-update; nil values remove them.
+func pendingUpdateAnnotations(deadline, image, version *string) map[string]*string {
+	return map[string]*string{
+		updateDeadlineAnnotation: deadline,
+		updateImageAnnotation:    image,
+		updateVersionAnnotation:  version,
+	}
+}
+
+// Watch reverts the agent Deployment to the previous image if its
+// pending update is not confirmed by the deadline, e.g. because the
+// new agent crash-loops or cannot register. The reverted image is
+// recorded and refused by later updates until Confirm lifts the
+// rejection. Every agent replica watches,
+// so the update is reverted by the old pod while the new one is not
+// available, and by the new pod once the old one is gone. Watch blocks
+// until ctx is canceled.
+func (u *updater) Watch(ctx context.Context) {
+	client, namespace, err := u.target()
+	if err != nil {
+		u.log.Debug("not watching self-updates", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(updateWatchInterval)
+	defer ticker.Stop()
+	for {
+		if err := u.revertExpired(ctx, client, namespace); err != nil {
+			u.log.Warn("failed to check pending self-update", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateWatcher wraps SelfUpdater.Watch as a transport.Listener so
+// that it participates in the same errgroup lifecycle as the HTTP and
+// tunnel servers.
+type updateWatcher struct {
+	updater SelfUpdater
+}
+
+// newUpdateWatcher returns a listener that reverts the self-updates of
+// updater that are not confirmed in time.
+func newUpdateWatcher(updater SelfUpdater) *updateWatcher {
+	return &updateWatcher{updater: updater}
+}
+
+// Start runs the watch, blocking until ctx is canceled.
+func (w *updateWatcher) Start(ctx context.Context) error {
+	w.updater.Watch(ctx)
+	return nil
+}
+
+// Stop is a no-op; the watch exits when its context is canceled.
+func (w *updateWatcher) Stop(_ context.Context) error {
+	return nil
+}
+
+// revertExpired reverts the agent Deployment to its previous image if
+// the deadline of its pending update has passed.
+func (u *updater) revertExpired(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	deploy, err := client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get deployment: %w", err)
+	}
+	raw, ok := deploy.Annotations[updateDeadlineAnnotation]
+	if !ok {
+		return nil
+	}
+	deadline, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return fmt.Errorf("parse annotation %s: %w", updateDeadlineAnnotation, err)
+	}
+	if time.Now().Before(deadline) {
+		return nil
+	}
+
+	failed := containerImage(deploy)
+	previous := deploy.Annotations[previousImageAnnotation]
+	if previous == "" {
+		p := deploymentPatch{Metadata: metadataPatch{Annotations: pendingUpdateAnnotations(nil, nil, nil)}}
+		return u.patch(ctx, client, namespace, p)
+	}
+
+	u.log.Warn("self-update was not confirmed in time, reverting",
+		"image", failed,
+		"previous_image", previous,
+		"deadline", deadline,
 	)
+	annotations := pendingUpdateAnnotations(nil, nil, nil)
+	annotations[previousImageAnnotation] = nil
+	annotations[rejectedImageAnnotation] = &failed
+	return u.patch(ctx, client, namespace, imagePatch(previous, nil, annotations))
+}
 
+// patch applies p to the agent Deployment as a strategic merge patch.
+func (u *updater) patch(ctx context.Context, client kubernetes.Interface, namespace string, p deploymentPatch) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal patch: %w", err)
+	}
 	_, err = client.AppsV1().Deployments(namespace).Patch(
 		ctx,
 		deploymentName,
@@ -143,11 +409,44 @@ func (u *updater) Patch(ctx context.Context, version string) error {
 	if err != nil {
 		return fmt.Errorf("patch deployment: %w", err)
 	}
-
-	u.log.Info("agent deployment patched, rolling update will restart the agent")
 	return nil
 }
 
+// target returns the Kubernetes client and the namespace of the agent
+// Deployment.
+func (u *updater) target() (kubernetes.Interface, string, error) {
+	client, err := u.getOrCreateClient()
+	if err != nil {
+		return nil, "", fmt.Errorf("create kube client: %w", err)
+	}
+	namespace, err := detectNamespace()
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
+
+// containerImage returns the image of the agent container of deploy,
+// or an empty string if it has none.
+func containerImage(deploy *appsv1.Deployment) string {
+	for _, c := range deploy.Spec.Template.Spec.Containers {
+		if c.Name == containerName {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// imageTag returns the tag of an image reference, or an empty string
+// if it has none.
+func imageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
 // getOrCreateClient returns the cached Kubernetes clientset, creating
 // it on first use. The clientset is reused across patch calls to avoid
 // redundant connection setup. Access is serialized by mu to prevent
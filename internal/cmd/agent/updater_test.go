@@ -3,8 +3,15 @@ package agent
 import (
 	"strings"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/core"
 )
 
 func TestPatch_InvalidVersion(t *testing.T) {
@@ -25,7 +32,7 @@ func TestPatch_InvalidVersion(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := u.Patch(t.Context(), tt.version)
+			err := u.Patch(t.Context(), tt.version, "", nil)
 			if err == nil {
 				t.Errorf("expected error for version %q, got nil", tt.version)
 			}
@@ -50,7 +57,7 @@ func TestPatch_ValidVersion(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := u.Patch(t.Context(), tt.version)
+			err := u.Patch(t.Context(), tt.version, "", nil)
 			if err == nil {
 				// If it succeeds we're probably in-cluster, which is fine.
 				return
@@ -64,6 +71,27 @@ func TestPatch_ValidVersion(t *testing.T) {
 	}
 }
 
+func TestPatch_InvalidImage(t *testing.T) {
+	u := NewUpdater(&rest.Config{})
+
+	for name, image := range map[string]string{
+		"tag mismatch": "ghcr.io/otterscale/otterscale:v1.2.4",
+		"no tag":       "ghcr.io/otterscale/otterscale",
+		"bad digest":   "ghcr.io/otterscale/otterscale:v1.2.3@sha256:abc123",
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := u.Patch(t.Context(), "v1.2.3", image, nil)
+			if err == nil || !strings.Contains(err.Error(), "invalid agent image") {
+				t.Errorf("Patch with image %q = %v, want an invalid image error", image, err)
+			}
+		})
+	}
+
+	if err := u.Patch(t.Context(), "v1.2.3", "", []string{"Bad_Name"}); err == nil || !strings.Contains(err.Error(), "invalid image pull secrets") {
+		t.Errorf("Patch with a bad pull secret = %v, want an invalid pull secrets error", err)
+	}
+}
+
 func TestImageRef(t *testing.T) {
 	got := imageRef("v1.2.3")
 	want := "ghcr.io/otterscale/otterscale:v1.2.3"
@@ -80,3 +108,227 @@ func TestDetectNamespace_ErrorOutsideCluster(t *testing.T) {
 		t.Error("expected error for detectNamespace outside cluster, got nil")
 	}
 }
+
+// newTestDeployment returns an agent Deployment running image with the
+// given annotations.
+func newTestDeployment(image string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: deploymentName, Namespace: "otterscale-system", Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: containerName, Image: image}}},
+			},
+		},
+	}
+}
+
+func getTestDeployment(t *testing.T, client *fake.Clientset) *appsv1.Deployment {
+	t.Helper()
+	deploy, err := client.AppsV1().Deployments("otterscale-system").Get(t.Context(), deploymentName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get deployment: %v", err)
+	}
+	return deploy
+}
+
+func TestUpdate_RecordsPreviousImage(t *testing.T) {
+	client := fake.NewClientset(newTestDeployment(imageRef("v1.0.0"), nil))
+	u := NewUpdater(&rest.Config{}).(*updater)
+
+	if err := u.update(t.Context(), client, "otterscale-system", "v1.1.0", imageRef("v1.1.0"), nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	deploy := getTestDeployment(t, client)
+	if got := containerImage(deploy); got != imageRef("v1.1.0") {
+		t.Errorf("image = %q, want %q", got, imageRef("v1.1.0"))
+	}
+	if got := deploy.Annotations[previousImageAnnotation]; got != imageRef("v1.0.0") {
+		t.Errorf("previous image = %q, want %q", got, imageRef("v1.0.0"))
+	}
+	if got := deploy.Annotations[updateImageAnnotation]; got != imageRef("v1.1.0") {
+		t.Errorf("update image = %q, want %q", got, imageRef("v1.1.0"))
+	}
+	if _, ok := deploy.Annotations[updateDeadlineAnnotation]; !ok {
+		t.Error("expected an update deadline")
+	}
+	if deploy.Spec.MinReadySeconds != minReadySeconds {
+		t.Errorf("minReadySeconds = %d, want %d", deploy.Spec.MinReadySeconds, minReadySeconds)
+	}
+
+	// The new agent confirms the update once it has registered.
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.1.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[updateDeadlineAnnotation]; ok {
+		t.Error("expected the update deadline to be removed")
+	}
+}
+
+func TestUpdate_ImageAndPullSecrets(t *testing.T) {
+	client := fake.NewClientset(newTestDeployment(imageRef("v1.0.0"), nil))
+	u := NewUpdater(&rest.Config{}).(*updater)
+
+	image := "harbor.example.com/otterscale/otterscale:v1.1.0@sha256:" + strings.Repeat("a", 64)
+	if err := u.update(t.Context(), client, "otterscale-system", "v1.1.0", image, []string{"harbor"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	deploy := getTestDeployment(t, client)
+	if got := containerImage(deploy); got != image {
+		t.Errorf("image = %q, want %q", got, image)
+	}
+	if secrets := deploy.Spec.Template.Spec.ImagePullSecrets; len(secrets) != 1 || secrets[0].Name != "harbor" {
+		t.Errorf("imagePullSecrets = %v, want [harbor]", secrets)
+	}
+
+	// The agent confirms by version, whatever the repository and digest.
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.1.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[updateDeadlineAnnotation]; ok {
+		t.Error("expected the update deadline to be removed")
+	}
+}
+
+// TestConfirm_PatchedImage verifies that an update is confirmed by the
+// image it patched, even if it is pinned by digest alone, and only
+// while the Deployment still runs that image.
+func TestConfirm_PatchedImage(t *testing.T) {
+	image := core.DefaultAgentImageRepository + "@sha256:" + strings.Repeat("b", 64)
+	pending := func() map[string]string {
+		return map[string]string{
+			updateDeadlineAnnotation: time.Now().Add(time.Minute).UTC().Format(time.RFC3339),
+			updateImageAnnotation:    image,
+			updateVersionAnnotation:  "v1.1.0",
+		}
+	}
+	u := NewUpdater(&rest.Config{}).(*updater)
+
+	// The Deployment was patched again since; the update is not ours.
+	client := fake.NewClientset(newTestDeployment(imageRef("v1.1.0"), pending()))
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.1.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[updateDeadlineAnnotation]; !ok {
+		t.Error("expected the update to stay pending while another image runs")
+	}
+
+	client = fake.NewClientset(newTestDeployment(image, pending()))
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.0.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[updateDeadlineAnnotation]; !ok {
+		t.Error("expected the update to stay pending for another version")
+	}
+
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.1.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	annotations := getTestDeployment(t, client).Annotations
+	for _, key := range []string{updateDeadlineAnnotation, updateImageAnnotation, updateVersionAnnotation} {
+		if _, ok := annotations[key]; ok {
+			t.Errorf("expected annotation %s to be removed", key)
+		}
+	}
+}
+
+func TestUpdate_SemverPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		version     string
+		wantErr     bool
+	}{
+		{"downgrade", nil, "v1.0.0", true},
+		{"major version jump", nil, "v2.0.0", true},
+		{"return to previous image", map[string]string{previousImageAnnotation: imageRef("v1.0.0")}, "v1.0.0", false},
+		{"rejected image", map[string]string{rejectedImageAnnotation: imageRef("v1.2.0")}, "v1.2.0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewClientset(newTestDeployment(imageRef("v1.1.0"), tt.annotations))
+			u := NewUpdater(&rest.Config{}).(*updater)
+
+			err := u.update(t.Context(), client, "otterscale-system", tt.version, imageRef(tt.version), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("update(%s) = %v, wantErr %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevertExpired(t *testing.T) {
+	deadline := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	client := fake.NewClientset(newTestDeployment(imageRef("v1.1.0"), map[string]string{
+		previousImageAnnotation:  imageRef("v1.0.0"),
+		updateDeadlineAnnotation: deadline,
+	}))
+	u := NewUpdater(&rest.Config{}).(*updater)
+
+	if err := u.revertExpired(t.Context(), client, "otterscale-system"); err != nil {
+		t.Fatalf("revertExpired: %v", err)
+	}
+	deploy := getTestDeployment(t, client)
+	if got := containerImage(deploy); got != imageRef("v1.0.0") {
+		t.Errorf("image = %q, want the previous image", got)
+	}
+	if got := deploy.Annotations[rejectedImageAnnotation]; got != imageRef("v1.1.0") {
+		t.Errorf("rejected image = %q, want %q", got, imageRef("v1.1.0"))
+	}
+	if _, ok := deploy.Annotations[updateDeadlineAnnotation]; ok {
+		t.Error("expected the update deadline to be removed")
+	}
+
+	// The reverted version is refused from now on.
+	if err := u.update(t.Context(), client, "otterscale-system", "v1.1.0", imageRef("v1.1.0"), nil); err == nil {
+		t.Error("expected the rejected version to be refused")
+	}
+}
+
+// TestConfirm_LiftsRejection verifies that a rejected image is
+// accepted again once an update to another image is confirmed, or once
+// an admin set the rejected image on the Deployment and it registered.
+func TestConfirm_LiftsRejection(t *testing.T) {
+	rejected := map[string]string{rejectedImageAnnotation: imageRef("v1.1.0")}
+	u := NewUpdater(&rest.Config{}).(*updater)
+
+	// The agent returned to v1.0.0; that is no reason to retry v1.1.0.
+	client := fake.NewClientset(newTestDeployment(imageRef("v1.0.0"), rejected))
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.0.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[rejectedImageAnnotation]; !ok {
+		t.Error("expected the rejection to stay without a confirmed update")
+	}
+
+	if err := u.update(t.Context(), client, "otterscale-system", "v1.2.0", imageRef("v1.2.0"), nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.2.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[rejectedImageAnnotation]; ok {
+		t.Error("expected a confirmed update to lift the rejection")
+	}
+
+	client = fake.NewClientset(newTestDeployment(imageRef("v1.1.0"), rejected))
+	if err := u.confirm(t.Context(), client, "otterscale-system", "v1.1.0"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, ok := getTestDeployment(t, client).Annotations[rejectedImageAnnotation]; ok {
+		t.Error("expected the registered rejected image to lift its rejection")
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	for image, want := range map[string]string{
+		"ghcr.io/otterscale/otterscale:v1.2.3":                                   "v1.2.3",
+		"localhost:5000/otterscale":                                              "",
+		"ghcr.io/otterscale/otterscale:v1.2.3@sha256:" + strings.Repeat("a", 64): "v1.2.3",
+		"otterscale": "",
+	} {
+		if got := imageTag(image); got != want {
+			t.Errorf("imageTag(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
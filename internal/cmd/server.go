@@ -34,6 +34,7 @@ func NewServerCommand(conf *config.Config, newServer ServerInjector) (*cobra.Com
 				AllowedOrigins:    conf.ServerAllowedOrigins(),
 				TunnelAddress:     conf.ServerTunnelAddress(),
 				ExternalTunnelURL: conf.ServerExternalTunnelURL(),
+				PeerAddress:       conf.ServerPeerAddress(),
 				KeycloakRealmURL:  conf.ServerKeycloakRealmURL(),
 				KeycloakClientID:  conf.ServerKeycloakClientID(),
 			}
@@ -15,15 +15,21 @@ const sessionReapInterval = 30 * time.Second
 // evictor removes expired schema and version entries.
 const cacheEvictionInterval = 5 * time.Minute
 
+// sharedStateRefreshInterval is the interval at which a hub replica
+// reloads the state it shares with the other replicas.
+const sharedStateRefreshInterval = 10 * time.Second
+
 // ProvideBackgroundListeners constructs the background transport
-// listeners (session reaper, cache evictor) that participate in the
-// server's managed lifecycle. The CacheEvictor interface decouples
-// this function from the concrete cache implementation, keeping the
-// application layer free of infrastructure dependencies.
-func ProvideBackgroundListeners(runtime *core.RuntimeUseCase, evictor core.CacheEvictor) BackgroundListeners {
+// listeners (session reaper, cache evictor, shared state refresher)
+// that participate in the server's managed lifecycle. The CacheEvictor
+// interface decouples this function from the concrete cache
+// implementation, keeping the application layer free of infrastructure
+// dependencies.
+func ProvideBackgroundListeners(runtime *core.RuntimeUseCase, evictor core.CacheEvictor, refresher *core.SharedStateRefresher) BackgroundListeners {
 	return BackgroundListeners{
 		&sessionReaperListener{runtime: runtime},
 		&cacheEvictorListener{cache: evictor},
+		&sharedStateListener{refresher: refresher},
 	}
 }
 
@@ -58,3 +64,19 @@ func (l *cacheEvictorListener) Start(ctx context.Context) error {
 func (l *cacheEvictorListener) Stop(_ context.Context) error {
 	return nil // evictor stops when its context is canceled
 }
+
+// sharedStateListener adapts a SharedStateRefresher to the
+// transport.Listener interface so it participates in the managed
+// lifecycle alongside other servers.
+type sharedStateListener struct {
+	refresher *core.SharedStateRefresher
+}
+
+func (l *sharedStateListener) Start(ctx context.Context) error {
+	l.refresher.StartRefreshLoop(ctx, sharedStateRefreshInterval)
+	return nil
+}
+
+func (l *sharedStateListener) Stop(_ context.Context) error {
+	return nil // refresher stops when its context is canceled
+}
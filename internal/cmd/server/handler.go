@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 
@@ -19,6 +20,7 @@ import (
 	resourcev1 "github.com/otterscale/api/resource/v1"
 	runtimev1 "github.com/otterscale/api/runtime/v1"
 
+	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/handler"
 )
 
@@ -30,18 +32,28 @@ type Handler struct {
 	resource *handler.ResourceService
 	runtime  *handler.RuntimeService
 	manifest *handler.ManifestHandler
+	renew    *handler.RenewHandler
 	proxy    *handler.ProxyHandler
+	watch    *handler.LinkWatchHandler
+	admin    *handler.AdminHandler
+	sessions *handler.PeerSessionHandler
 }
 
 // NewHandler returns a Handler for the given gRPC services, the raw
-// HTTP manifest handler, and the Prometheus reverse proxy handler.
-func NewHandler(link *handler.LinkService, resource *handler.ResourceService, runtime *handler.RuntimeService, manifest *handler.ManifestHandler, proxy *handler.ProxyHandler) *Handler {
+// HTTP manifest and renewal handlers, the Prometheus reverse proxy
+// handler, the link watch stream, the admin endpoints, and the handler
+// of the session operations other hub replicas forward.
+func NewHandler(link *handler.LinkService, resource *handler.ResourceService, runtime *handler.RuntimeService, manifest *handler.ManifestHandler, renew *handler.RenewHandler, proxy *handler.ProxyHandler, watch *handler.LinkWatchHandler, admin *handler.AdminHandler, sessions *handler.PeerSessionHandler) *Handler {
 	return &Handler{
 		link:     link,
 		resource: resource,
 		runtime:  runtime,
 		manifest: manifest,
+		renew:    renew,
 		proxy:    proxy,
+		watch:    watch,
+		admin:    admin,
+		sessions: sessions,
 	}
 }
 
@@ -81,6 +93,17 @@ func (h *Handler) Mount(mux *http.ServeMux) error {
 	// handled by the HMAC token embedded in the URL path, so this
 	// route is registered as a public path prefix in server.go.
 	mux.HandleFunc("GET /link/manifest/{token}", h.handleRawManifest)
+	mux.HandleFunc("GET /link/manifest/{token}/chart.tgz", h.handleManifestArchive(core.ManifestFormatHelm))
+	mux.HandleFunc("GET /link/manifest/{token}/kustomize.tgz", h.handleManifestArchive(core.ManifestFormatKustomize))
+
+	// Raw YAML endpoint for kubectl delete -f, authenticated like the
+	// manifest endpoint by an HMAC token of its own kind.
+	mux.HandleFunc("GET /link/uninstall/{token}", h.handleUninstallManifest)
+
+	// In-place certificate renewal. The request is authenticated by
+	// a proof signed with the agent's current certificate key, so
+	// this route is registered as a public path in server.go.
+	mux.Handle("POST "+core.RenewPath, h.renew)
 
 	// Prometheus reverse proxy. Requests arrive as
 	// /proxy/{cluster}/prometheus/api/v1/query?... and are
@@ -89,6 +112,37 @@ func (h *Handler) Mount(mux *http.ServeMux) error {
 	// path (it is not in the public paths list).
 	mux.Handle("/proxy/{cluster}/prometheus/{path...}", h.proxy)
 
+	// Link state change stream as newline-delimited JSON. The public
+	// API has no streaming RPC for it; OIDC middleware protects this
+	// path.
+	mux.Handle("GET /link/watch", h.watch)
+
+	// Admin endpoints for operations without an RPC in the public
+	// API. OIDC middleware authenticates the caller and each handler
+	// requires membership in the admin group.
+	mux.HandleFunc("GET /admin/ca/rotation", h.admin.GetCARotation)
+	mux.HandleFunc("POST /admin/ca/rotation", h.admin.StartCARotation)
+	mux.HandleFunc("DELETE /admin/ca/rotation", h.admin.FinishCARotation)
+	mux.HandleFunc("GET /admin/revocations", h.admin.ListRevocations)
+	mux.HandleFunc("DELETE /admin/revocations/agents/{agent}", h.admin.RestoreAgent)
+	mux.HandleFunc("GET /admin/links", h.admin.ListLinks)
+	mux.HandleFunc("DELETE /admin/clusters/{cluster}", h.admin.DeregisterCluster)
+	mux.HandleFunc("POST /admin/clusters/{cluster}/revoke", h.admin.RevokeCluster)
+	mux.HandleFunc("POST /admin/clusters/{cluster}/rehome", h.admin.RehomeCluster)
+	mux.HandleFunc("PUT /admin/clusters/{cluster}/labels", h.admin.SetClusterLabels)
+	mux.HandleFunc("POST /admin/clusters/{cluster}/approve", h.admin.ApproveCluster)
+	mux.HandleFunc("POST /admin/clusters/{cluster}/reject", h.admin.RejectCluster)
+	mux.HandleFunc("POST /admin/clusters/{cluster}/uninstall-url", h.admin.IssueUninstallURL)
+	mux.HandleFunc("GET /admin/cluster-pins", h.admin.ListClusterPins)
+	mux.HandleFunc("GET /admin/cluster-approvals", h.admin.ListClusterApprovals)
+	mux.HandleFunc("GET /admin/enrollment-tokens", h.admin.ListEnrollmentTokens)
+	mux.HandleFunc("DELETE /admin/enrollment-tokens/{id}", h.admin.RevokeEnrollmentToken)
+	mux.HandleFunc("GET /admin/upgrades", h.admin.GetUpgrades)
+	mux.HandleFunc("PUT /admin/upgrades/policy", h.admin.SetUpgradePolicy)
+	mux.HandleFunc("POST /admin/upgrades/pause", h.admin.PauseUpgrades)
+	mux.HandleFunc("POST /admin/upgrades/resume", h.admin.ResumeUpgrades)
+	mux.HandleFunc("POST /admin/upgrades/abort", h.admin.AbortUpgrades)
+
 	return nil
 }
 
@@ -117,6 +171,55 @@ func (h *Handler) handleRawManifest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleUninstallManifest verifies the HMAC token in the URL path and
+// returns the agent uninstall manifest as raw YAML, for
+// `kubectl delete --ignore-not-found -f <url>`.
+func (h *Handler) handleUninstallManifest(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.manifest.VerifyUninstallToken(r.Context(), r.PathValue("token"))
+	if err != nil {
+		slog.Debug("uninstall token verification failed", "error", err)
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	manifest, err := h.manifest.RenderUninstallManifest(r.Context(), claims)
+	if err != nil {
+		http.Error(w, "failed to render manifest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	if _, err := w.Write([]byte(manifest)); err != nil { // #nosec G705
+		slog.Warn("failed to write uninstall manifest response", "error", err)
+	}
+}
+
+// handleManifestArchive returns a handler that verifies the HMAC token
+// in the URL path and returns the agent installation manifest as a
+// gzipped tarball in format, for GitOps tools such as Flux or Argo CD.
+func (h *Handler) handleManifestArchive(format core.ManifestFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.manifest.VerifyManifestToken(r.Context(), r.PathValue("token"))
+		if err != nil {
+			slog.Debug("manifest token verification failed", "error", err)
+			http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		archive, err := h.manifest.RenderArchive(r.Context(), claims, format)
+		if err != nil {
+			http.Error(w, "failed to render manifest", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "otterscale-agent-"+claims.Cluster+".tgz"))
+		if _, err := w.Write(archive); err != nil {
+			slog.Warn("failed to write manifest archive response", "error", err)
+		}
+	}
+}
+
 // registerOpsHandlers sets up gRPC reflection, health checks, and
 // Prometheus metrics scraping.
 func (h *Handler) registerOpsHandlers(mux *http.ServeMux, serviceNames []string) error {
@@ -10,6 +10,7 @@ import (
 
 	linkv1 "github.com/otterscale/api/link/v1"
 
+	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/transport"
 	"github.com/otterscale/otterscale/internal/transport/http"
 )
@@ -20,6 +21,7 @@ type Config struct {
 	AllowedOrigins    []string
 	TunnelAddress     string
 	ExternalTunnelURL string
+	PeerAddress       string
 	KeycloakRealmURL  string
 	KeycloakClientID  string
 }
@@ -36,15 +38,17 @@ type BackgroundListeners []transport.Listener
 type Server struct {
 	handler    *Handler
 	tunnel     transport.TunnelService
+	peers      transport.PeerService
 	background BackgroundListeners
 }
 
 // NewServer returns a Server wired to the given handler, tunnel
-// service, and background listeners. The TunnelService interface
-// decouples the server from concrete tunnel implementations, keeping
-// infrastructure details behind the interface boundary.
-func NewServer(handler *Handler, tunnel transport.TunnelService, background BackgroundListeners) *Server {
-	return &Server{handler: handler, tunnel: tunnel, background: background}
+// service, peer service, and background listeners. The TunnelService
+// and PeerService interfaces decouple the server from concrete tunnel
+// implementations, keeping infrastructure details behind the
+// interface boundary.
+func NewServer(handler *Handler, tunnel transport.TunnelService, peers transport.PeerService, background BackgroundListeners) *Server {
+	return &Server{handler: handler, tunnel: tunnel, peers: peers, background: background}
 }
 
 // Run starts both the HTTP and tunnel servers. It blocks until ctx
@@ -88,9 +92,11 @@ func (s *Server) Run(ctx context.Context, cfg *Config) error {
 			"/grpc.health.v1.Health/Watch",
 			"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
 			linkv1.LinkServiceRegisterProcedure,
+			core.RenewPath,
 		}),
 		http.WithPublicPathPrefixes([]string{
 			"/link/manifest/",
+			"/link/uninstall/",
 		}),
 		http.WithMount(s.handler.Mount),
 	)
@@ -111,6 +117,16 @@ func (s *Server) Run(ctx context.Context, cfg *Config) error {
 	healthChecker := s.tunnel.BuildHealthListener()
 
 	listeners := []transport.Listener{httpSrv, tunnelSrv, healthChecker}
+
+	// Serve the channel through which other hub replicas reach the
+	// tunnels terminating on this one, if peer forwarding is enabled.
+	peerSrv, err := s.peers.BuildPeerListener(cfg.PeerAddress, s.handler.sessions)
+	if err != nil {
+		return fmt.Errorf("failed to create peer server: %w", err)
+	}
+	if peerSrv != nil {
+		listeners = append(listeners, peerSrv)
+	}
 	listeners = append(listeners, s.background...)
 
 	return transport.Serve(ctx, listeners...)
@@ -16,6 +16,8 @@ var ProviderSet = wire.NewSet(
 	agent.NewAgent,
 	agent.NewHandler,
 	agent.NewUpdater,
+	agent.NewCredentialStore,
+	agent.NewUninstaller,
 	server.NewServer,
 	server.NewHandler,
 	server.ProvideBackgroundListeners,
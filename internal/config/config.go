@@ -132,6 +132,190 @@ func (c *Config) ServerHarborURL() string {
 	return c.v.GetString(keyServerHarborURL)
 }
 
+// ServerCAStore returns the backend used to persist the tunnel CA
+// ("file" or "secret").
+func (c *Config) ServerCAStore() string {
+	return c.v.GetString(keyServerCAStore)
+}
+
+// ServerCADir returns the directory holding the CA file pair when the
+// file store is selected.
+func (c *Config) ServerCADir() string {
+	return c.v.GetString(keyServerCADir)
+}
+
+// ServerCASecretNamespace returns the namespace of the CA Secret when
+// the secret store is selected.
+func (c *Config) ServerCASecretNamespace() string {
+	return c.v.GetString(keyServerCASecretNamespace)
+}
+
+// ServerCASecretName returns the name of the CA Secret when the
+// secret store is selected.
+func (c *Config) ServerCASecretName() string {
+	return c.v.GetString(keyServerCASecretName)
+}
+
+// ServerCAStateSecretName returns the name of the Secret, in the
+// namespace of the CA Secret, holding the hub registries when the
+// secret store is selected.
+func (c *Config) ServerCAStateSecretName() string {
+	return c.v.GetString(keyServerCAStateSecretName)
+}
+
+// ServerCACSRSANPattern returns the regular expression that every
+// Subject Alternative Name in an agent CSR must match. Empty rejects
+// CSRs that carry SANs.
+func (c *Config) ServerCACSRSANPattern() string {
+	return c.v.GetString(keyServerCACSRSANPattern)
+}
+
+// ServerCASigner returns the backend holding the tunnel CA key
+// ("memory", "pkcs11" or "http").
+func (c *Config) ServerCASigner() string {
+	return c.v.GetString(keyServerCASigner)
+}
+
+// ServerCAPKCS11Module returns the path of the PKCS#11 module when
+// the pkcs11 signer is selected.
+func (c *Config) ServerCAPKCS11Module() string {
+	return c.v.GetString(keyServerCAPKCS11Module)
+}
+
+// ServerCAPKCS11Token returns the label of the PKCS#11 token holding
+// the CA key.
+func (c *Config) ServerCAPKCS11Token() string {
+	return c.v.GetString(keyServerCAPKCS11Token)
+}
+
+// ServerCAPKCS11PIN returns the user PIN of the PKCS#11 token.
+func (c *Config) ServerCAPKCS11PIN() string {
+	return c.v.GetString(keyServerCAPKCS11PIN)
+}
+
+// ServerCAPKCS11KeyLabel returns the label of the CA key pair on the
+// PKCS#11 token.
+func (c *Config) ServerCAPKCS11KeyLabel() string {
+	return c.v.GetString(keyServerCAPKCS11KeyLabel)
+}
+
+// ServerCAPKCS11HMACLabel returns the label of the secret key used to
+// derive HMAC keys on the PKCS#11 token.
+func (c *Config) ServerCAPKCS11HMACLabel() string {
+	return c.v.GetString(keyServerCAPKCS11HMACLabel)
+}
+
+// ServerCAHTTPURL returns the base URL of the external signing
+// service when the http signer is selected.
+func (c *Config) ServerCAHTTPURL() string {
+	return c.v.GetString(keyServerCAHTTPURL)
+}
+
+// ServerCAHTTPTokenFile returns the file holding the bearer token for
+// the external signing service. Empty disables authentication.
+func (c *Config) ServerCAHTTPTokenFile() string {
+	return c.v.GetString(keyServerCAHTTPTokenFile)
+}
+
+// ServerCAHTTPCAFile returns the PEM bundle used to verify the
+// external signing service. Empty uses the system roots.
+func (c *Config) ServerCAHTTPCAFile() string {
+	return c.v.GetString(keyServerCAHTTPCAFile)
+}
+
+// ServerLinksStore returns the backend used to persist the link
+// registry ("file" or "crd").
+func (c *Config) ServerLinksStore() string {
+	return c.v.GetString(keyServerLinksStore)
+}
+
+// ServerLinksDir returns the directory holding the link registry when
+// the file store is selected.
+func (c *Config) ServerLinksDir() string {
+	return c.v.GetString(keyServerLinksDir)
+}
+
+// ServerLinksNamespace returns the namespace of the TunnelLink
+// resources when the crd store is selected.
+func (c *Config) ServerLinksNamespace() string {
+	return c.v.GetString(keyServerLinksNamespace)
+}
+
+// ServerLinksRequireApproval returns whether the first registration of
+// a cluster waits for an admin to approve it.
+func (c *Config) ServerLinksRequireApproval() bool {
+	return c.v.GetBool(keyServerLinksRequireApproval)
+}
+
+// ServerAgentImageRepository returns the repository agents pull their
+// image from.
+func (c *Config) ServerAgentImageRepository() string {
+	return c.v.GetString(keyServerAgentImageRepository)
+}
+
+// ServerAgentImageDigests returns the pinned agent image digests as
+// version=digest pairs.
+func (c *Config) ServerAgentImageDigests() []string {
+	return c.v.GetStringSlice(keyServerAgentImageDigests)
+}
+
+// ServerAgentImagePullSecrets returns the names of the Secrets the
+// agent image is pulled with.
+func (c *Config) ServerAgentImagePullSecrets() []string {
+	return c.v.GetStringSlice(keyServerAgentPullSecrets)
+}
+
+// ServerReplicaID returns the identifier of this hub replica in the
+// link store, falling back to the host name.
+func (c *Config) ServerReplicaID() string {
+	if id := c.v.GetString(keyServerReplicaID); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// ServerPeerAddress returns the listen address of the peer channel.
+// Empty disables peer forwarding.
+func (c *Config) ServerPeerAddress() string {
+	return c.v.GetString(keyServerPeerAddress)
+}
+
+// ServerPeerURL returns the URL other hub replicas reach the peer
+// channel of this replica at.
+func (c *Config) ServerPeerURL() string {
+	return c.v.GetString(keyServerPeerURL)
+}
+
+// ServerHealthInterval returns how often the agent replicas are
+// probed.
+func (c *Config) ServerHealthInterval() time.Duration {
+	return c.v.GetDuration(keyServerHealthInterval)
+}
+
+// ServerHealthProbeTimeout returns the timeout of a health probe.
+func (c *Config) ServerHealthProbeTimeout() time.Duration {
+	return c.v.GetDuration(keyServerHealthProbeTimeout)
+}
+
+// ServerHealthFailThreshold returns the number of consecutive failed
+// probes after which an agent replica is marked as disconnected.
+func (c *Config) ServerHealthFailThreshold() int {
+	return c.v.GetInt(keyServerHealthFailThreshold)
+}
+
+// ServerHealthGracePeriod returns how long a disconnected agent
+// replica keeps its tunnel allocation before it is evicted.
+func (c *Config) ServerHealthGracePeriod() time.Duration {
+	return c.v.GetDuration(keyServerHealthGracePeriod)
+}
+
+// ServerHealthOverrides returns the per-cluster health check settings
+// as cluster.setting=value entries.
+func (c *Config) ServerHealthOverrides() []string {
+	return c.v.GetStringSlice(keyServerHealthOverrides)
+}
+
 // ---------------------------------------------------------------------------
 // Agent-mode accessors
 // ---------------------------------------------------------------------------
@@ -170,3 +354,9 @@ func (c *Config) AgentProxyPrometheusURL() string {
 func (c *Config) AgentHarborURL() string {
 	return c.v.GetString(keyAgentHarborURL)
 }
+
+// AgentMetricsAddress returns the listen address of the agent's
+// Prometheus metrics endpoint. Empty disables the endpoint.
+func (c *Config) AgentMetricsAddress() string {
+	return c.v.GetString(keyAgentMetricsAddress)
+}
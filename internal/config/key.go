@@ -10,14 +10,44 @@ package config
 
 // Viper keys for server-mode configuration.
 const (
-	keyServerAddress           = "server.address"
-	keyServerAllowedOrigins    = "server.allowed_origins"
-	keyServerTunnelAddress     = "server.tunnel.address"
-	keyServerKeycloakRealmURL  = "server.keycloak.realm_url"
-	keyServerKeycloakClientID  = "server.keycloak.client_id"
-	keyServerExternalURL       = "server.external_url"
-	keyServerExternalTunnelURL = "server.external_tunnel_url"
-	keyServerHarborURL         = "server.harbor_url"
+	keyServerAddress              = "server.address"
+	keyServerAllowedOrigins       = "server.allowed_origins"
+	keyServerTunnelAddress        = "server.tunnel.address"
+	keyServerKeycloakRealmURL     = "server.keycloak.realm_url"
+	keyServerKeycloakClientID     = "server.keycloak.client_id"
+	keyServerExternalURL          = "server.external_url"
+	keyServerExternalTunnelURL    = "server.external_tunnel_url"
+	keyServerHarborURL            = "server.harbor_url"
+	keyServerCAStore              = "server.ca.store"
+	keyServerCADir                = "server.ca.dir"
+	keyServerCASecretNamespace    = "server.ca.secret_namespace"
+	keyServerCASecretName         = "server.ca.secret_name"
+	keyServerCAStateSecretName    = "server.ca.state_secret_name"
+	keyServerCASigner             = "server.ca.signer"
+	keyServerCACSRSANPattern      = "server.ca.csr_san_pattern"
+	keyServerCAPKCS11Module       = "server.ca.pkcs11.module"
+	keyServerCAPKCS11Token        = "server.ca.pkcs11.token_label"
+	keyServerCAPKCS11PIN          = "server.ca.pkcs11.pin"
+	keyServerCAPKCS11KeyLabel     = "server.ca.pkcs11.key_label"
+	keyServerCAPKCS11HMACLabel    = "server.ca.pkcs11.hmac_key_label"
+	keyServerCAHTTPURL            = "server.ca.http.url"
+	keyServerCAHTTPTokenFile      = "server.ca.http.token_file"
+	keyServerCAHTTPCAFile         = "server.ca.http.ca_file"
+	keyServerLinksStore           = "server.links.store"
+	keyServerLinksDir             = "server.links.dir"
+	keyServerLinksNamespace       = "server.links.namespace"
+	keyServerLinksRequireApproval = "server.links.require_approval"
+	keyServerAgentImageRepository = "server.agent.image_repository"
+	keyServerAgentImageDigests    = "server.agent.image_digests"
+	keyServerAgentPullSecrets     = "server.agent.image_pull_secrets"
+	keyServerReplicaID            = "server.replica.id"
+	keyServerPeerAddress          = "server.peer.address"
+	keyServerPeerURL              = "server.peer.url"
+	keyServerHealthInterval       = "server.health.interval"
+	keyServerHealthProbeTimeout   = "server.health.probe_timeout"
+	keyServerHealthFailThreshold  = "server.health.fail_threshold"
+	keyServerHealthGracePeriod    = "server.health.grace_period"
+	keyServerHealthOverrides      = "server.health.cluster_overrides"
 )
 
 // Viper keys for agent-mode configuration.
@@ -28,4 +58,5 @@ const (
 	keyAgentBootstrap          = "agent.bootstrap"
 	keyAgentProxyPrometheusURL = "agent.proxy.prometheus_url"
 	keyAgentHarborURL          = "agent.harbor_url"
+	keyAgentMetricsAddress     = "agent.metrics_address"
 )
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 )
 
 // Option describes a single configuration entry: its viper key, the
@@ -25,6 +26,36 @@ var ServerOptions = []Option{
 	{Key: keyServerExternalURL, Flag: toFlag(keyServerExternalURL), Default: "", Description: "Externally reachable server URL for agent connections (required for manifest generation)"},
 	{Key: keyServerExternalTunnelURL, Flag: toFlag(keyServerExternalTunnelURL), Default: "", Description: "Externally reachable tunnel URL for agent tunnel connections (required for manifest generation)"},
 	{Key: keyServerHarborURL, Flag: toFlag(keyServerHarborURL), Default: "", Description: "Harbor registry URL for robot account creation (optional)"},
+	{Key: keyServerCAStore, Flag: toFlag(keyServerCAStore), Default: "file", Description: "Tunnel CA persistence backend (file or secret); peer forwarding requires secret"},
+	{Key: keyServerCADir, Flag: toFlag(keyServerCADir), Default: "/var/lib/otterscale/ca", Description: "Directory holding the CA certificate and key when the CA store is file"},
+	{Key: keyServerCASecretNamespace, Flag: toFlag(keyServerCASecretNamespace), Default: "otterscale-system", Description: "Namespace of the CA Secret when the CA store is secret"},
+	{Key: keyServerCASecretName, Flag: toFlag(keyServerCASecretName), Default: "otterscale-ca", Description: "Name of the CA Secret when the CA store is secret"},
+	{Key: keyServerCAStateSecretName, Flag: toFlag(keyServerCAStateSecretName), Default: "otterscale-hub-state", Description: "Name of the Secret holding the revocations, enrollment tokens, cluster pins, cluster approvals and upgrade policy when the CA store is secret"},
+	{Key: keyServerCACSRSANPattern, Flag: toFlag(keyServerCACSRSANPattern), Default: "", Description: "Regular expression every SAN in an agent CSR must match (empty rejects CSRs with SANs)"},
+	{Key: keyServerCASigner, Flag: toFlag(keyServerCASigner), Default: "memory", Description: "Tunnel CA signing backend (memory, pkcs11 or http)"},
+	{Key: keyServerCAPKCS11Module, Flag: toFlag(keyServerCAPKCS11Module), Default: "", Description: "Path of the PKCS#11 module when the CA signer is pkcs11"},
+	{Key: keyServerCAPKCS11Token, Flag: toFlag(keyServerCAPKCS11Token), Default: "", Description: "Label of the PKCS#11 token holding the CA key"},
+	{Key: keyServerCAPKCS11PIN, Flag: toFlag(keyServerCAPKCS11PIN), Default: "", Description: "User PIN of the PKCS#11 token (prefer the OTTERSCALE_SERVER_CA_PKCS11_PIN environment variable)"},
+	{Key: keyServerCAPKCS11KeyLabel, Flag: toFlag(keyServerCAPKCS11KeyLabel), Default: "otterscale-ca", Description: "Label of the CA key pair on the PKCS#11 token"},
+	{Key: keyServerCAPKCS11HMACLabel, Flag: toFlag(keyServerCAPKCS11HMACLabel), Default: "otterscale-ca-hmac", Description: "Label of the generic secret key on the PKCS#11 token used to derive HMAC keys"},
+	{Key: keyServerCAHTTPURL, Flag: toFlag(keyServerCAHTTPURL), Default: "", Description: "Base URL of the external signing service when the CA signer is http"},
+	{Key: keyServerCAHTTPTokenFile, Flag: toFlag(keyServerCAHTTPTokenFile), Default: "", Description: "File holding the bearer token for the external signing service (optional)"},
+	{Key: keyServerCAHTTPCAFile, Flag: toFlag(keyServerCAHTTPCAFile), Default: "", Description: "PEM bundle used to verify the external signing service (optional)"},
+	{Key: keyServerLinksStore, Flag: toFlag(keyServerLinksStore), Default: "file", Description: "Link registry persistence backend (file or crd)"},
+	{Key: keyServerLinksDir, Flag: toFlag(keyServerLinksDir), Default: "/var/lib/otterscale/links", Description: "Directory holding links.json when the link store is file"},
+	{Key: keyServerLinksNamespace, Flag: toFlag(keyServerLinksNamespace), Default: "otterscale-system", Description: "Namespace of the TunnelLink resources when the link store is crd"},
+	{Key: keyServerLinksRequireApproval, Flag: toFlag(keyServerLinksRequireApproval), Default: false, Description: "Require admin approval before a cluster registers for the first time"},
+	{Key: keyServerAgentImageRepository, Flag: toFlag(keyServerAgentImageRepository), Default: "ghcr.io/otterscale/otterscale", Description: "Repository of the agent image in generated manifests and self-updates"},
+	{Key: keyServerAgentImageDigests, Flag: toFlag(keyServerAgentImageDigests), Default: []string{}, Description: "Agent image digests as version=sha256:... pairs; when set, images are pinned by digest and other versions are not rolled out"},
+	{Key: keyServerAgentPullSecrets, Flag: toFlag(keyServerAgentPullSecrets), Default: []string{}, Description: "Names of the Secrets in the agent namespace the agent image is pulled with"},
+	{Key: keyServerReplicaID, Flag: toFlag(keyServerReplicaID), Default: "", Description: "Identifier of this hub replica (defaults to the host name)"},
+	{Key: keyServerPeerAddress, Flag: toFlag(keyServerPeerAddress), Default: "", Description: "Listen address of the channel other hub replicas forward cluster requests through; empty runs a single replica"},
+	{Key: keyServerPeerURL, Flag: toFlag(keyServerPeerURL), Default: "", Description: "URL at which other hub replicas reach the peer address of this replica (e.g. https://10.0.0.5:8301)"},
+	{Key: keyServerHealthInterval, Flag: toFlag(keyServerHealthInterval), Default: 15 * time.Second, Description: "How often the agent replicas of every cluster are probed through their tunnel"},
+	{Key: keyServerHealthProbeTimeout, Flag: toFlag(keyServerHealthProbeTimeout), Default: 5 * time.Second, Description: "Timeout of a health probe, including the agent's kube-apiserver readiness check; must be shorter than the interval"},
+	{Key: keyServerHealthFailThreshold, Flag: toFlag(keyServerHealthFailThreshold), Default: 3, Description: "Consecutive failed health probes after which an agent replica is marked as disconnected"},
+	{Key: keyServerHealthGracePeriod, Flag: toFlag(keyServerHealthGracePeriod), Default: 5 * time.Minute, Description: "How long a disconnected agent replica keeps its tunnel allocation, and is still probed, before it is evicted"},
+	{Key: keyServerHealthOverrides, Flag: toFlag(keyServerHealthOverrides), Default: []string{}, Description: "Per-cluster health check settings as cluster.setting=value entries, where setting is probe-timeout, fail-threshold or grace-period (e.g. edge-1.probe-timeout=10s); probe timeouts must be shorter than the interval"},
 }
 
 // AgentOptions defines the configuration entries available in agent
@@ -36,6 +67,7 @@ var AgentOptions = []Option{
 	{Key: keyAgentBootstrap, Flag: toFlag(keyAgentBootstrap), Default: true, Description: "Run Layer 0 bootstrap on startup (install FluxCD)"},
 	{Key: keyAgentProxyPrometheusURL, Flag: toFlag(keyAgentProxyPrometheusURL), Default: "http://otterscale-prometheus-kube-prometheus.monitoring.svc:9090", Description: "In-cluster Prometheus URL for the metrics proxy"},
 	{Key: keyAgentHarborURL, Flag: toFlag(keyAgentHarborURL), Default: "", Description: "Harbor registry host for the OCI modules HelmRepository (optional)"},
+	{Key: keyAgentMetricsAddress, Flag: toFlag(keyAgentMetricsAddress), Default: "", Description: "Listen address of the Prometheus metrics endpoint of the agent (e.g. :9464); empty disables it"},
 }
 
 // toFlag converts a viper key like "server.tunnel.key_seed" into a
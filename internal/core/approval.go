@@ -0,0 +1,299 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ClusterApprovalStore persists the JSON-encoded cluster approvals so
+// that pending, approved and rejected clusters keep their state across
+// server restarts.
+type ClusterApprovalStore interface {
+	// LoadClusterApprovals returns the persisted approvals, or nil if
+	// nothing has been stored yet.
+	LoadClusterApprovals(ctx context.Context) ([]byte, error)
+	// UpdateClusterApprovals replaces the persisted approvals with the
+	// approvals fn returns for the currently persisted ones, which are
+	// nil if nothing has been stored yet. If another writer changes
+	// the approvals before they are replaced, fn is called again with
+	// the new approvals. An error returned by fn aborts the update and
+	// is returned as is.
+	UpdateClusterApprovals(ctx context.Context, fn func(data []byte) ([]byte, error)) error
+}
+
+// ApprovalState is the state of a cluster's admission to the hub.
+type ApprovalState string
+
+const (
+	// ApprovalPending is a cluster whose first registration awaits an
+	// admin decision.
+	ApprovalPending ApprovalState = "Pending"
+	// ApprovalApproved is a cluster that may register.
+	ApprovalApproved ApprovalState = "Approved"
+	// ApprovalRejected is a cluster whose registrations are refused
+	// until it is deregistered or approved after all.
+	ApprovalRejected ApprovalState = "Rejected"
+)
+
+// ClusterApproval records the admission of a cluster: the agent that
+// requested it and the admin decision, if any.
+type ClusterApproval struct {
+	Cluster string        `json:"cluster"`
+	State   ApprovalState `json:"state"`
+	// AgentUID and AgentID identify the agent whose registration
+	// created the request. They are empty for clusters approved
+	// before their agent registered.
+	AgentUID     string    `json:"agentUid,omitempty"`
+	AgentID      string    `json:"agentId,omitempty"`
+	AgentVersion string    `json:"agentVersion,omitempty"`
+	RequestedAt  time.Time `json:"requestedAt,omitzero"`
+	DecidedBy    string    `json:"decidedBy,omitempty"`
+	DecidedAt    time.Time `json:"decidedAt,omitzero"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// ClusterApprovals holds the admin decisions on clusters that register
+// for the first time. When approval is required, the first
+// registration of a cluster is refused and recorded as pending until
+// an admin approves or rejects it; the agent retries in the meantime.
+// Clusters that registered before approval was required, and are
+// therefore pinned already, are admitted without a decision. It is
+// safe for concurrent use.
+type ClusterApprovals struct {
+	mu        sync.Mutex
+	required  bool
+	approvals map[string]ClusterApproval
+	store     ClusterApprovalStore // nil for in-memory approvals
+	now       func() time.Time
+}
+
+// NewClusterApprovals returns an empty in-memory approval registry.
+// If required is false every registration is admitted.
+func NewClusterApprovals(required bool) *ClusterApprovals {
+	return &ClusterApprovals{required: required, approvals: make(map[string]ClusterApproval), now: time.Now}
+}
+
+// LoadClusterApprovals loads the approvals from store. Subsequent
+// changes are written back to the same store.
+func LoadClusterApprovals(ctx context.Context, store ClusterApprovalStore, required bool) (*ClusterApprovals, error) {
+	a := NewClusterApprovals(required)
+
+	data, err := store.LoadClusterApprovals(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load cluster approvals: %w", err)
+	}
+	if err := a.decodeLocked(data); err != nil {
+		return nil, err
+	}
+
+	a.store = store
+	return a, nil
+}
+
+// Required reports whether first-time registrations need approval.
+func (a *ClusterApprovals) Required() bool {
+	return a.required
+}
+
+// Admit decides whether the agent may register cluster. known reports
+// whether the cluster was pinned before this registration. A cluster
+// without a decision is recorded as pending and refused with an
+// ErrorCodeFailedPrecondition domain error; a rejected one is refused
+// with an ErrorCodePermissionDenied domain error.
+func (a *ClusterApprovals) Admit(ctx context.Context, cluster, agentUID, agentID, agentVersion string, known bool) error {
+	if !a.required {
+		return nil
+	}
+	return a.update(ctx, func() error {
+		approval, ok := a.approvals[cluster]
+		switch {
+		case !ok && known:
+			return nil
+		case !ok:
+			a.approvals[cluster] = ClusterApproval{
+				Cluster:      cluster,
+				State:        ApprovalPending,
+				AgentUID:     agentUID,
+				AgentID:      agentID,
+				AgentVersion: agentVersion,
+				RequestedAt:  a.now(),
+			}
+			return errPendingApproval(cluster)
+		case approval.State == ApprovalApproved:
+			return nil
+		case approval.State == ApprovalRejected:
+			return &DomainError{
+				Code:    ErrorCodePermissionDenied,
+				Message: fmt.Sprintf("registration of cluster %s was rejected by %s", cluster, approval.DecidedBy),
+			}
+		default:
+			return errPendingApproval(cluster)
+		}
+	})
+}
+
+// Approve admits cluster. A cluster without a pending request is
+// approved ahead of its first registration. A rejection may be
+// overturned.
+func (a *ClusterApprovals) Approve(ctx context.Context, cluster, decidedBy string) error {
+	if err := a.checkRequired(); err != nil {
+		return err
+	}
+	return a.update(ctx, func() error {
+		approval, ok := a.approvals[cluster]
+		if !ok {
+			approval = ClusterApproval{Cluster: cluster}
+		}
+		approval.State = ApprovalApproved
+		approval.DecidedBy = decidedBy
+		approval.DecidedAt = a.now()
+		approval.Reason = ""
+		a.approvals[cluster] = approval
+		return nil
+	})
+}
+
+// Reject refuses the pending registration of cluster. It returns an
+// ErrorCodeNotFound domain error if the cluster has no request, and an
+// ErrorCodeFailedPrecondition one if it was approved already; such a
+// cluster is removed by deregistering it.
+func (a *ClusterApprovals) Reject(ctx context.Context, cluster, decidedBy, reason string) error {
+	if err := a.checkRequired(); err != nil {
+		return err
+	}
+	return a.update(ctx, func() error {
+		approval, ok := a.approvals[cluster]
+		if !ok {
+			return &DomainError{Code: ErrorCodeNotFound, Message: fmt.Sprintf("cluster %s has no pending approval", cluster)}
+		}
+		if approval.State == ApprovalApproved {
+			return &DomainError{
+				Code:    ErrorCodeFailedPrecondition,
+				Message: fmt.Sprintf("cluster %s is approved already; deregister it instead", cluster),
+			}
+		}
+		approval.State = ApprovalRejected
+		approval.DecidedBy = decidedBy
+		approval.DecidedAt = a.now()
+		approval.Reason = reason
+		a.approvals[cluster] = approval
+		return nil
+	})
+}
+
+// Remove forgets the decision on cluster, so that its next first-time
+// registration needs approval again.
+func (a *ClusterApprovals) Remove(ctx context.Context, cluster string) error {
+	return a.update(ctx, func() error {
+		delete(a.approvals, cluster)
+		return nil
+	})
+}
+
+// List returns every approval, sorted by cluster.
+func (a *ClusterApprovals) List() []ClusterApproval {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.entriesLocked()
+}
+
+// checkRequired returns an ErrorCodeFailedPrecondition domain error if
+// approval is not required, since a decision would have no effect.
+func (a *ClusterApprovals) checkRequired() error {
+	if a.required {
+		return nil
+	}
+	return &DomainError{Code: ErrorCodeFailedPrecondition, Message: "cluster approval is not enabled"}
+}
+
+// Refresh reloads the approvals from the store, so that the requests
+// and decisions recorded on other hub replicas take effect on this
+// one. In-memory approvals are left unchanged.
+func (a *ClusterApprovals) Refresh(ctx context.Context) error {
+	if a.store == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := a.store.LoadClusterApprovals(ctx)
+	if err != nil {
+		return fmt.Errorf("load cluster approvals: %w", err)
+	}
+	return a.decodeLocked(data)
+}
+
+// update applies fn under the lock to the persisted approvals and
+// persists the result, unless it is unchanged. fn decides on the
+// persisted approvals, so that a decision made on another hub replica
+// is not overridden. The change is rolled back if persisting fails.
+// fn may return an error after changing the approvals; the change is
+// kept and persisted, and the error returned afterwards.
+func (a *ClusterApprovals) update(ctx context.Context, fn func() error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.store == nil {
+		return fn()
+	}
+
+	prev := a.approvals
+	var fnErr error
+	err := a.store.UpdateClusterApprovals(ctx, func(data []byte) ([]byte, error) {
+		if err := a.decodeLocked(data); err != nil {
+			return nil, err
+		}
+		current := maps.Clone(a.approvals)
+		fnErr = fn()
+		if maps.Equal(current, a.approvals) {
+			return data, nil
+		}
+		return json.Marshal(a.entriesLocked())
+	})
+	if err != nil {
+		a.approvals = prev
+		return fmt.Errorf("persist cluster approvals: %w", err)
+	}
+	return fnErr
+}
+
+// decodeLocked replaces the approvals with the JSON-encoded approvals
+// data. a.mu must be held, unless a is not shared yet.
+func (a *ClusterApprovals) decodeLocked(data []byte) error {
+	var approvals []ClusterApproval
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &approvals); err != nil {
+			return fmt.Errorf("decode cluster approvals: %w", err)
+		}
+	}
+	a.approvals = make(map[string]ClusterApproval, len(approvals))
+	for _, approval := range approvals {
+		a.approvals[approval.Cluster] = approval
+	}
+	return nil
+}
+
+// entriesLocked returns every approval sorted by cluster. a.mu must be
+// held.
+func (a *ClusterApprovals) entriesLocked() []ClusterApproval {
+	ret := make([]ClusterApproval, 0, len(a.approvals))
+	for _, cluster := range slices.Sorted(maps.Keys(a.approvals)) {
+		ret = append(ret, a.approvals[cluster])
+	}
+	return ret
+}
+
+// errPendingApproval is returned to agents whose cluster awaits an
+// admin decision. They retry the registration with backoff.
+func errPendingApproval(cluster string) error {
+	return &DomainError{
+		Code:    ErrorCodeFailedPrecondition,
+		Message: fmt.Sprintf("cluster %s is pending admin approval", cluster),
+	}
+}
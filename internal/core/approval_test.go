@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// memClusterApprovalStore implements ClusterApprovalStore in memory.
+type memClusterApprovalStore struct {
+	data  []byte
+	saves int
+}
+
+func (s *memClusterApprovalStore) LoadClusterApprovals(context.Context) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memClusterApprovalStore) UpdateClusterApprovals(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	data, err := fn(s.data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, s.data) {
+		s.saves++
+		s.data = data
+	}
+	return nil
+}
+
+func TestClusterApprovals_Admit(t *testing.T) {
+	a := NewClusterApprovals(true)
+
+	err := a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", false)
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("error = %v, want code FailedPrecondition", err)
+	}
+	if got := a.List(); len(got) != 1 || got[0].State != ApprovalPending || got[0].AgentUID != "uid-a" {
+		t.Fatalf("approvals = %+v, want prod pending for uid-a", got)
+	}
+
+	// Clusters pinned before approval was required are admitted.
+	if err := a.Admit(t.Context(), "legacy", "uid-b", "agent-2", "v1", true); err != nil {
+		t.Fatalf("Admit of a known cluster: %v", err)
+	}
+
+	if err := a.Approve(t.Context(), "prod", "alice"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if err := a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", true); err != nil {
+		t.Fatalf("Admit after approval: %v", err)
+	}
+	if err := a.Reject(t.Context(), "prod", "alice", ""); err == nil {
+		t.Fatal("expected an approved cluster not to be rejected")
+	}
+}
+
+func TestClusterApprovals_Reject(t *testing.T) {
+	a := NewClusterApprovals(true)
+	_ = a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", false)
+
+	if err := a.Reject(t.Context(), "prod", "alice", "unknown cluster"); err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	err := a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", true)
+	if code, _ := DomainErrorCode(err); code != ErrorCodePermissionDenied {
+		t.Fatalf("error = %v, want code PermissionDenied", err)
+	}
+	if got := a.List(); got[0].DecidedBy != "alice" || got[0].Reason != "unknown cluster" {
+		t.Fatalf("approval = %+v, want the rejection recorded", got[0])
+	}
+
+	// Removing the decision makes the cluster pending again.
+	if err := a.Remove(t.Context(), "prod"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	err = a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", false)
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("error = %v, want code FailedPrecondition", err)
+	}
+}
+
+func TestClusterApprovals_NotRequired(t *testing.T) {
+	a := NewClusterApprovals(false)
+
+	if err := a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", false); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+	if err := a.Approve(t.Context(), "prod", "alice"); err == nil {
+		t.Fatal("expected approval to fail while it is not required")
+	}
+	if got := a.List(); len(got) != 0 {
+		t.Fatalf("approvals = %+v, want none", got)
+	}
+}
+
+func TestClusterApprovals_Persisted(t *testing.T) {
+	store := &memClusterApprovalStore{}
+	a, err := LoadClusterApprovals(t.Context(), store, true)
+	if err != nil {
+		t.Fatalf("LoadClusterApprovals: %v", err)
+	}
+	_ = a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", false)
+	// Retries of a pending cluster do not write to the store.
+	_ = a.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", true)
+	if store.saves != 1 {
+		t.Fatalf("saves = %d, want 1", store.saves)
+	}
+	if err := a.Approve(t.Context(), "prod", "alice"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+
+	reloaded, err := LoadClusterApprovals(t.Context(), store, true)
+	if err != nil {
+		t.Fatalf("LoadClusterApprovals: %v", err)
+	}
+	if err := reloaded.Admit(t.Context(), "prod", "uid-a", "agent-1", "v1", true); err != nil {
+		t.Fatalf("Admit after reload: %v", err)
+	}
+}
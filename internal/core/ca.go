@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CACertificate summarizes a tunnel CA certificate.
+type CACertificate struct {
+	// Fingerprint is the hex-encoded SHA-256 digest of the DER
+	// certificate.
+	Fingerprint string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// CARotation describes the state of a tunnel CA rotation.
+type CARotation struct {
+	// Active is the CA that signs new agent certificates.
+	Active CACertificate
+	// Retiring is the previous CA, still trusted until the rotation
+	// is finished. Nil when no rotation is in progress.
+	Retiring *CACertificate
+	// PendingClusters lists the clusters whose agent certificate is
+	// still signed by the retiring CA, sorted by name.
+	PendingClusters []string
+}
+
+// InProgress reports whether a rotation has been started but not yet
+// finished.
+func (r CARotation) InProgress() bool {
+	return r.Retiring != nil
+}
+
+// CARotator manages the tunnel CA. During a rotation both the active
+// and the retiring CA are trusted for mTLS and returned to agents in
+// Registration.CACertificate. Implementations live in the providers
+// layer.
+type CARotator interface {
+	// CARotation returns the active and, during a rotation, the
+	// retiring CA. PendingClusters is left empty.
+	CARotation(ctx context.Context) (CARotation, error)
+	// StartCARotation generates a new CA that signs all subsequent
+	// agent certificates while the current one keeps being trusted.
+	StartCARotation(ctx context.Context) (CARotation, error)
+	// FinishCARotation stops trusting the retiring CA.
+	FinishCARotation(ctx context.Context) (CARotation, error)
+}
+
+// Revocation is a deny-list entry for agent certificates. Exactly one
+// of Serial and AgentID is set.
+type Revocation struct {
+	// Serial is the hex-encoded serial number of a single revoked
+	// certificate.
+	Serial string
+	// AgentID rejects every certificate of the agent and blocks it
+	// from registering again.
+	AgentID   string
+	Reason    string
+	RevokedAt time.Time
+	// ExpiresAt is when a serial entry lapses because the
+	// certificate has expired on its own. Zero for agent entries.
+	ExpiresAt time.Time
+}
+
+// CertificateRevoker revokes agent certificates. Revoked certificates
+// are rejected on every tunnel handshake and revoked agents cannot
+// register. Implementations live in the providers layer.
+type CertificateRevoker interface {
+	// RevokeCluster revokes the certificate and agent currently
+	// registered for cluster, removes its tunnel user and closes its
+	// tunnel session immediately. A tunnel held by another hub
+	// replica is closed by that replica once it sees the revocation.
+	RevokeCluster(ctx context.Context, cluster, reason string) error
+	// Revocations returns every current deny-list entry.
+	Revocations(ctx context.Context) ([]Revocation, error)
+	// RestoreAgent lifts an agent revocation so that the agent may
+	// register again.
+	RestoreAgent(ctx context.Context, agentID string) error
+}
+
+// CAUseCase orchestrates tunnel CA rotations and certificate
+// revocation. It refuses to retire the old CA while registered agents
+// still depend on it, unless forced.
+type CAUseCase struct {
+	rotator CARotator
+	revoker CertificateRevoker
+	tunnel  TunnelProvider
+}
+
+// NewCAUseCase returns a CAUseCase backed by the given CARotator,
+// CertificateRevoker and TunnelProvider.
+func NewCAUseCase(rotator CARotator, revoker CertificateRevoker, tunnel TunnelProvider) *CAUseCase {
+	return &CAUseCase{rotator: rotator, revoker: revoker, tunnel: tunnel}
+}
+
+// Rotation returns the current rotation state, including the clusters
+// that have not re-enrolled under the active CA yet.
+func (uc *CAUseCase) Rotation(ctx context.Context) (CARotation, error) {
+	rotation, err := uc.rotator.CARotation(ctx)
+	if err != nil {
+		return CARotation{}, err
+	}
+	return uc.withPending(rotation), nil
+}
+
+// StartRotation begins a CA rotation. Agents pick up the new trust
+// bundle and a certificate signed by the new CA the next time they
+// register.
+func (uc *CAUseCase) StartRotation(ctx context.Context) (CARotation, error) {
+	rotation, err := uc.rotator.StartCARotation(ctx)
+	if err != nil {
+		return CARotation{}, err
+	}
+	return uc.withPending(rotation), nil
+}
+
+// FinishRotation retires the old CA. Unless force is set, it fails
+// with ErrorCodeFailedPrecondition while any registered cluster still
+// holds a certificate signed by the retiring CA, because those agents
+// would be locked out until they register again.
+func (uc *CAUseCase) FinishRotation(ctx context.Context, force bool) (CARotation, error) {
+	current, err := uc.Rotation(ctx)
+	if err != nil {
+		return CARotation{}, err
+	}
+	if !current.InProgress() {
+		return CARotation{}, &DomainError{Code: ErrorCodeFailedPrecondition, Message: "no CA rotation in progress"}
+	}
+	if len(current.PendingClusters) > 0 && !force {
+		return CARotation{}, &DomainError{
+			Code:    ErrorCodeFailedPrecondition,
+			Message: "clusters still use the retiring CA: " + joinClusters(current.PendingClusters),
+		}
+	}
+	return uc.rotator.FinishCARotation(ctx)
+}
+
+// RevokeCluster revokes the cluster's current agent certificate and
+// kicks its tunnel session. The agent cannot register again until
+// RestoreAgent is called for it.
+func (uc *CAUseCase) RevokeCluster(ctx context.Context, cluster, reason string) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	return uc.revoker.RevokeCluster(ctx, cluster, reason)
+}
+
+// ListRevocations returns every current deny-list entry.
+func (uc *CAUseCase) ListRevocations(ctx context.Context) ([]Revocation, error) {
+	return uc.revoker.Revocations(ctx)
+}
+
+// RestoreAgent lifts the revocation of agentID so that it may register
+// again.
+func (uc *CAUseCase) RestoreAgent(ctx context.Context, agentID string) error {
+	if agentID == "" {
+		return &ErrInvalidInput{Field: "agent_id", Message: "must not be empty"}
+	}
+	return uc.revoker.RestoreAgent(ctx, agentID)
+}
+
+// withPending fills PendingClusters from the registered links.
+func (uc *CAUseCase) withPending(rotation CARotation) CARotation {
+	if !rotation.InProgress() {
+		return rotation
+	}
+	retiring := func(fingerprint string) bool {
+		return fingerprint == rotation.Retiring.Fingerprint
+	}
+	for cluster, link := range uc.tunnel.ListLinks() {
+		// Every replica of the cluster has to re-enroll.
+		if retiring(link.CAFingerprint) || slices.ContainsFunc(link.Replicas, func(r LinkReplica) bool { return retiring(r.CAFingerprint) }) {
+			rotation.PendingClusters = append(rotation.PendingClusters, cluster)
+		}
+	}
+	slices.Sort(rotation.PendingClusters)
+	return rotation
+}
+
+// joinClusters formats a list of cluster names for error messages.
+func joinClusters(clusters []string) string {
+	const maxListed = 10
+	if len(clusters) <= maxListed {
+		return strings.Join(clusters, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(clusters[:maxListed], ", "), len(clusters)-maxListed)
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// mockCARotator implements CARotator for testing.
+type mockCARotator struct {
+	rotation CARotation
+	finished bool
+}
+
+func (m *mockCARotator) CARotation(_ context.Context) (CARotation, error) {
+	return m.rotation, nil
+}
+
+func (m *mockCARotator) StartCARotation(_ context.Context) (CARotation, error) {
+	m.rotation = CARotation{Active: CACertificate{Fingerprint: "new"}, Retiring: &CACertificate{Fingerprint: "old"}}
+	return m.rotation, nil
+}
+
+func (m *mockCARotator) FinishCARotation(_ context.Context) (CARotation, error) {
+	m.finished = true
+	m.rotation.Retiring = nil
+	return m.rotation, nil
+}
+
+func TestCAUseCase_PendingClusters(t *testing.T) {
+	tp := &mockTunnelProvider{links: map[string]Link{
+		"b-cluster": {CAFingerprint: "old"},
+		"a-cluster": {CAFingerprint: "old"},
+		"c-cluster": {CAFingerprint: "new"},
+	}}
+	uc := NewCAUseCase(&mockCARotator{}, nil, tp)
+
+	rotation, err := uc.StartRotation(t.Context())
+	if err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	if want := []string{"a-cluster", "b-cluster"}; !slices.Equal(rotation.PendingClusters, want) {
+		t.Errorf("PendingClusters = %v, want %v", rotation.PendingClusters, want)
+	}
+}
+
+func TestCAUseCase_FinishRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		links    map[string]Link
+		start    bool
+		force    bool
+		wantCode ErrorCode
+		wantErr  bool
+	}{
+		{name: "no rotation", wantErr: true, wantCode: ErrorCodeFailedPrecondition},
+		{name: "pending clusters", start: true, links: map[string]Link{"a": {CAFingerprint: "old"}}, wantErr: true, wantCode: ErrorCodeFailedPrecondition},
+		{name: "pending clusters forced", start: true, force: true, links: map[string]Link{"a": {CAFingerprint: "old"}}},
+		{name: "all re-enrolled", start: true, links: map[string]Link{"a": {CAFingerprint: "new"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotator := &mockCARotator{rotation: CARotation{Active: CACertificate{Fingerprint: "old"}}}
+			uc := NewCAUseCase(rotator, nil, &mockTunnelProvider{links: tt.links})
+			if tt.start {
+				if _, err := uc.StartRotation(t.Context()); err != nil {
+					t.Fatalf("StartRotation: %v", err)
+				}
+			}
+
+			_, err := uc.FinishRotation(t.Context(), tt.force)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("FinishRotation: %v", err)
+				}
+				if !rotator.finished {
+					t.Error("expected the rotator to finish the rotation")
+				}
+				return
+			}
+			if code, ok := DomainErrorCode(err); !ok || code != tt.wantCode {
+				t.Errorf("expected domain error code %v, got %v", tt.wantCode, err)
+			}
+			if rotator.finished {
+				t.Error("expected the rotation to remain in progress")
+			}
+		})
+	}
+}
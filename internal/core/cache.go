@@ -12,3 +12,11 @@ import (
 type CacheEvictor interface {
 	StartEvictionLoop(ctx context.Context, interval time.Duration)
 }
+
+// ClusterEvictor drops the state an adapter keeps for a single
+// cluster, such as cached transports, discovery data or live sessions.
+// It is called once a cluster has been deregistered so that nothing
+// keeps serving the removed cluster.
+type ClusterEvictor interface {
+	EvictCluster(cluster string)
+}
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request headers carrying the agent's credentials on the Register
+// RPC. An agent that has never enrolled sends EnrollmentTokenHeader;
+// afterwards it proves its previous identity with AgentCertificateHeader
+// and AgentProofHeader. Binary values are base64-encoded.
+const (
+	EnrollmentTokenHeader  = "Otterscale-Enrollment-Token"
+	AgentCertificateHeader = "Otterscale-Agent-Certificate"
+	AgentProofHeader       = "Otterscale-Agent-Proof"
+)
+
+// enrollmentTokenTTL is the validity period of an enrollment token.
+// A token that has not been redeemed within this period expires and
+// a new manifest has to be generated.
+const enrollmentTokenTTL = 24 * time.Hour
+
+// usedTokenRetention is how long redeemed, revoked and expired tokens
+// stay listed before they are dropped from the registry.
+const usedTokenRetention = 7 * 24 * time.Hour
+
+// EnrollmentStore persists the JSON-encoded enrollment token registry
+// so that issued tokens stay valid, and redeemed ones stay spent,
+// across server restarts.
+type EnrollmentStore interface {
+	// LoadEnrollmentTokens returns the persisted registry, or nil if
+	// nothing has been stored yet.
+	LoadEnrollmentTokens(ctx context.Context) ([]byte, error)
+	// UpdateEnrollmentTokens replaces the persisted registry with the
+	// registry fn returns for the currently persisted one, which is
+	// nil if nothing has been stored yet. If another writer changes
+	// the registry before it is replaced, fn is called again with the
+	// new registry. An error returned by fn aborts the update and is
+	// returned as is.
+	UpdateEnrollmentTokens(ctx context.Context, fn func(data []byte) ([]byte, error)) error
+}
+
+// EnrollmentToken describes an issued enrollment token. The secret
+// part of the token is never stored, only its SHA-256 digest.
+type EnrollmentToken struct {
+	ID        string    `json:"id"`
+	Cluster   string    `json:"cluster"`
+	Digest    string    `json:"digest"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// UsedAt is set once an agent has enrolled with the token.
+	UsedAt time.Time `json:"usedAt,omitzero"`
+	// UsedBy is the agent ID that redeemed the token.
+	UsedBy    string    `json:"usedBy,omitempty"`
+	RevokedAt time.Time `json:"revokedAt,omitzero"`
+}
+
+// Valid reports whether the token can still be redeemed at now.
+func (t *EnrollmentToken) Valid(now time.Time) bool {
+	return t.UsedAt.IsZero() && t.RevokedAt.IsZero() && now.Before(t.ExpiresAt)
+}
+
+// AgentCredentials is what an agent presents when it registers: an
+// enrollment token for its first registration, and the certificate
+// and key of its previous registration afterwards. Either may be
+// empty.
+type AgentCredentials struct {
+	// UID identifies the Kubernetes cluster the agent runs in. It is
+	// stable across agent restarts and re-installations, and the hub
+	// pins each cluster name to it (see ClusterPins).
+	UID string
+	// Token is the enrollment token embedded in the agent manifest.
+	Token string
+	// Certificate is the PEM-encoded certificate of the agent's
+	// previous registration.
+	Certificate []byte
+	// PrivateKeyPEM is the PEM-encoded key of Certificate.
+	PrivateKeyPEM []byte
+}
+
+// HasIdentity reports whether c carries a previous identity.
+func (c AgentCredentials) HasIdentity() bool {
+	return len(c.Certificate) > 0 && len(c.PrivateKeyPEM) > 0
+}
+
+// EnrollmentProof is the server-side view of AgentCredentials: the
+// agent's UID and the enrollment token, or the previous certificate
+// together with a proof made with its key over the cluster name and
+// the new CSR.
+type EnrollmentProof struct {
+	// AgentUID is the UID reported by the agent. For registrations
+	// with a previous certificate it must match the UID the
+	// certificate was issued for.
+	AgentUID    string
+	Token       string
+	Certificate []byte
+	Proof       []byte
+}
+
+// EnrollmentTokens is the registry of issued enrollment tokens. Each
+// token is bound to one cluster, expires after enrollmentTokenTTL, is
+// consumed by the first successful registration and can be revoked
+// before that. It is safe for concurrent use.
+//
+// The now function is injected to decouple from wall-clock time,
+// enabling deterministic tests.
+type EnrollmentTokens struct {
+	mu     sync.Mutex
+	tokens map[string]EnrollmentToken
+	store  EnrollmentStore // nil for in-memory registries
+	now    func() time.Time
+}
+
+// NewEnrollmentTokens returns an empty in-memory registry.
+func NewEnrollmentTokens() *EnrollmentTokens {
+	return &EnrollmentTokens{tokens: make(map[string]EnrollmentToken), now: time.Now}
+}
+
+// LoadEnrollmentTokens loads the registry from store. Subsequent
+// changes are written back to the same store.
+func LoadEnrollmentTokens(ctx context.Context, store EnrollmentStore) (*EnrollmentTokens, error) {
+	r := NewEnrollmentTokens()
+
+	data, err := store.LoadEnrollmentTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load enrollment tokens: %w", err)
+	}
+	if err := r.decodeLocked(data); err != nil {
+		return nil, err
+	}
+
+	r.store = store
+	return r, nil
+}
+
+// Issue creates a new token for cluster and returns it in its
+// "<id>.<secret>" wire form together with its registry entry.
+func (r *EnrollmentTokens) Issue(ctx context.Context, cluster string) (string, EnrollmentToken, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", EnrollmentToken{}, fmt.Errorf("generate token id: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", EnrollmentToken{}, fmt.Errorf("generate token secret: %w", err)
+	}
+
+	now := r.now()
+	t := EnrollmentToken{
+		ID:        hex.EncodeToString(id),
+		Cluster:   cluster,
+		Digest:    tokenDigest(secret),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(enrollmentTokenTTL),
+	}
+	if err := r.update(ctx, func() error {
+		r.tokens[t.ID] = t
+		return nil
+	}); err != nil {
+		return "", EnrollmentToken{}, err
+	}
+	return t.ID + "." + base64.RawURLEncoding.EncodeToString(secret), t, nil
+}
+
+// Redeem marks token as used by agentID for cluster. All failures
+// return the same generic error so that callers cannot tell an
+// unknown token from a spent, revoked, expired or mismatched one; the
+// reason is logged at debug level. The returned release function
+// makes the token redeemable again and is meant for registrations
+// that fail after the token was consumed.
+func (r *EnrollmentTokens) Redeem(ctx context.Context, token, cluster, agentID string) (release func(), err error) {
+	id, secret, ok := parseEnrollmentToken(token)
+	if !ok {
+		return nil, errInvalidEnrollmentToken
+	}
+
+	err = r.update(ctx, func() error {
+		t, ok := r.tokens[id]
+		var reason string
+		switch {
+		case !ok:
+			reason = "unknown token"
+		case subtle.ConstantTimeCompare([]byte(t.Digest), []byte(tokenDigest(secret))) != 1:
+			reason = "secret mismatch"
+		case t.Cluster != cluster:
+			reason = fmt.Sprintf("token is bound to cluster %q", t.Cluster)
+		case !t.Valid(r.now()):
+			reason = "token used, revoked or expired"
+		}
+		if reason != "" {
+			slog.Debug("enrollment token rejected", "id", id, "cluster", cluster, "reason", reason)
+			return errInvalidEnrollmentToken
+		}
+		t.UsedAt = r.now()
+		t.UsedBy = agentID
+		r.tokens[id] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	release = func() {
+		err := r.update(context.WithoutCancel(ctx), func() error {
+			if t, ok := r.tokens[id]; ok && t.UsedBy == agentID {
+				t.UsedAt, t.UsedBy = time.Time{}, ""
+				r.tokens[id] = t
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("failed to release enrollment token", "id", id, "error", err)
+		}
+	}
+	return release, nil
+}
+
+// Revoke invalidates the token with the given ID. It returns an
+// ErrorCodeNotFound domain error if no such token exists.
+func (r *EnrollmentTokens) Revoke(ctx context.Context, id string) error {
+	return r.update(ctx, func() error {
+		t, ok := r.tokens[id]
+		if !ok {
+			return &DomainError{Code: ErrorCodeNotFound, Message: fmt.Sprintf("enrollment token %q not found", id)}
+		}
+		if t.RevokedAt.IsZero() {
+			t.RevokedAt = r.now()
+			r.tokens[id] = t
+		}
+		return nil
+	})
+}
+
+// List returns every token in the registry, newest first.
+func (r *EnrollmentTokens) List() []EnrollmentToken {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entriesLocked()
+}
+
+// Refresh reloads the registry from the store, so that the tokens
+// issued, redeemed and revoked by other hub replicas are listed on
+// this one. In-memory registries are left unchanged.
+func (r *EnrollmentTokens) Refresh(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := r.store.LoadEnrollmentTokens(ctx)
+	if err != nil {
+		return fmt.Errorf("load enrollment tokens: %w", err)
+	}
+	return r.decodeLocked(data)
+}
+
+// update applies fn under the lock to the persisted registry, drops
+// stale tokens and persists the result. fn decides on the persisted
+// tokens, so that a token redeemed on another hub replica is not
+// redeemed again. The change is rolled back if fn or persisting
+// fails, so the in-memory registry never diverges from the store.
+func (r *EnrollmentTokens) update(ctx context.Context, fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := maps.Clone(r.tokens)
+	if r.store == nil {
+		if err := fn(); err != nil {
+			r.tokens = prev
+			return err
+		}
+		r.pruneLocked(r.now())
+		return nil
+	}
+
+	var fnErr error
+	err := r.store.UpdateEnrollmentTokens(ctx, func(data []byte) ([]byte, error) {
+		if err := r.decodeLocked(data); err != nil {
+			return nil, err
+		}
+		if fnErr = fn(); fnErr != nil {
+			return nil, fnErr
+		}
+		r.pruneLocked(r.now())
+		return json.Marshal(r.entriesLocked())
+	})
+	if err != nil {
+		r.tokens = prev
+		if fnErr != nil {
+			return fnErr
+		}
+		return fmt.Errorf("persist enrollment tokens: %w", err)
+	}
+	return nil
+}
+
+// decodeLocked replaces the tokens with the JSON-encoded registry
+// data. r.mu must be held, unless r is not shared yet.
+func (r *EnrollmentTokens) decodeLocked(data []byte) error {
+	var tokens []EnrollmentToken
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tokens); err != nil {
+			return fmt.Errorf("decode enrollment tokens: %w", err)
+		}
+	}
+	r.tokens = make(map[string]EnrollmentToken, len(tokens))
+	for _, t := range tokens {
+		r.tokens[t.ID] = t
+	}
+	return nil
+}
+
+// pruneLocked drops tokens that can no longer be redeemed and have
+// been kept for usedTokenRetention. r.mu must be held.
+func (r *EnrollmentTokens) pruneLocked(now time.Time) {
+	for id, t := range r.tokens {
+		end := t.ExpiresAt
+		if !t.UsedAt.IsZero() {
+			end = t.UsedAt
+		}
+		if !t.RevokedAt.IsZero() {
+			end = t.RevokedAt
+		}
+		if now.After(end.Add(usedTokenRetention)) {
+			delete(r.tokens, id)
+		}
+	}
+}
+
+// entriesLocked returns every token, newest first. r.mu must be held.
+func (r *EnrollmentTokens) entriesLocked() []EnrollmentToken {
+	ret := slices.Collect(maps.Values(r.tokens))
+	slices.SortFunc(ret, func(a, b EnrollmentToken) int {
+		if c := b.IssuedAt.Compare(a.IssuedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return ret
+}
+
+// errInvalidEnrollmentToken is the generic error returned for every
+// rejected enrollment token.
+var errInvalidEnrollmentToken = &DomainError{
+	Code:    ErrorCodeUnauthenticated,
+	Message: "invalid, used or expired enrollment token",
+}
+
+// parseEnrollmentToken splits a token into its ID and decoded secret.
+func parseEnrollmentToken(token string) (id string, secret []byte, ok bool) {
+	id, enc, ok := strings.Cut(token, ".")
+	if !ok || id == "" {
+		return "", nil, false
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || len(secret) == 0 {
+		return "", nil, false
+	}
+	return id, secret, true
+}
+
+// tokenDigest returns the hex-encoded SHA-256 digest of a token
+// secret.
+func tokenDigest(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memEnrollmentStore implements EnrollmentStore in memory.
+type memEnrollmentStore struct {
+	data    []byte
+	saveErr error
+}
+
+func (s *memEnrollmentStore) LoadEnrollmentTokens(context.Context) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memEnrollmentStore) UpdateEnrollmentTokens(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	data, err := fn(s.data)
+	if err != nil {
+		return err
+	}
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.data = data
+	return nil
+}
+
+func TestEnrollmentTokens_Expiry(t *testing.T) {
+	r := NewEnrollmentTokens()
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	token, _, err := r.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	now = now.Add(enrollmentTokenTTL + time.Second)
+	if _, err := r.Redeem(t.Context(), token, "prod", "agent-1"); err == nil {
+		t.Fatal("expired token was accepted")
+	}
+}
+
+func TestEnrollmentTokens_Malformed(t *testing.T) {
+	r := NewEnrollmentTokens()
+	token, _, err := r.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no dot", "abcdef"},
+		{"bad base64", "abcdef.!!!"},
+		{"wrong secret", token[:len(token)-4] + "AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Redeem(t.Context(), tt.token, "prod", "agent-1")
+			if code, _ := DomainErrorCode(err); code != ErrorCodeUnauthenticated {
+				t.Fatalf("error = %v, want code Unauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestEnrollmentTokens_Persisted(t *testing.T) {
+	store := &memEnrollmentStore{}
+	r, err := LoadEnrollmentTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadEnrollmentTokens: %v", err)
+	}
+	used, _, err := r.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	unused, _, err := r.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := r.Redeem(t.Context(), used, "prod", "agent-1"); err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+
+	reloaded, err := LoadEnrollmentTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadEnrollmentTokens: %v", err)
+	}
+	if _, err := reloaded.Redeem(t.Context(), used, "prod", "agent-2"); err == nil {
+		t.Fatal("spent token was accepted after reload")
+	}
+	if _, err := reloaded.Redeem(t.Context(), unused, "prod", "agent-2"); err != nil {
+		t.Fatalf("unused token rejected after reload: %v", err)
+	}
+}
+
+// TestEnrollmentTokens_SharedStore verifies that hub replicas sharing a
+// store decide on the stored tokens: a token issued on one replica is
+// redeemed on another, and cannot be redeemed again on the first.
+func TestEnrollmentTokens_SharedStore(t *testing.T) {
+	store := &memEnrollmentStore{}
+	a, err := LoadEnrollmentTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadEnrollmentTokens: %v", err)
+	}
+	b, err := LoadEnrollmentTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadEnrollmentTokens: %v", err)
+	}
+
+	token, _, err := a.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := b.Redeem(t.Context(), token, "prod", "agent-1"); err != nil {
+		t.Fatalf("Redeem on the other replica: %v", err)
+	}
+	if _, err := a.Redeem(t.Context(), token, "prod", "agent-2"); err == nil {
+		t.Fatal("token redeemed on the other replica was accepted again")
+	}
+
+	if _, _, err := b.Issue(t.Context(), "staging"); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := a.Refresh(t.Context()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := a.List(); len(got) != 2 {
+		t.Errorf("List after Refresh = %d tokens, want 2", len(got))
+	}
+}
+
+func TestEnrollmentTokens_RollbackOnPersistFailure(t *testing.T) {
+	store := &memEnrollmentStore{}
+	r, err := LoadEnrollmentTokens(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadEnrollmentTokens: %v", err)
+	}
+	token, _, err := r.Issue(t.Context(), "prod")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	store.saveErr = errors.New("disk full")
+	if _, err := r.Redeem(t.Context(), token, "prod", "agent-1"); err == nil {
+		t.Fatal("expected persist error")
+	}
+	store.saveErr = nil
+
+	if _, err := r.Redeem(t.Context(), token, "prod", "agent-1"); err != nil {
+		t.Fatalf("token spent although redeeming it failed: %v", err)
+	}
+}
+
+func TestEnrollmentTokens_Prune(t *testing.T) {
+	r := NewEnrollmentTokens()
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if _, _, err := r.Issue(t.Context(), "old"); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	now = now.Add(enrollmentTokenTTL + usedTokenRetention + time.Second)
+	if _, _, err := r.Issue(t.Context(), "new"); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tokens := r.List()
+	if len(tokens) != 1 || tokens[0].Cluster != "new" {
+		t.Fatalf("tokens = %+v, want only the new token", tokens)
+	}
+}
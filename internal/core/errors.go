@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // ErrorCode represents a domain-level error category that abstracts
@@ -64,6 +65,28 @@ func (e *ErrClusterNotFound) Error() string {
 	return fmt.Sprintf("cluster %s not registered", e.Cluster)
 }
 
+// ErrClusterDisconnected indicates that the requested cluster is
+// known but its agent is currently not connected.
+type ErrClusterDisconnected struct {
+	Cluster  string
+	LastSeen time.Time
+}
+
+func (e *ErrClusterDisconnected) Error() string {
+	return fmt.Sprintf("cluster %s disconnected, last seen %s", e.Cluster, e.LastSeen.Format(time.RFC3339))
+}
+
+// ErrClusterOnPeer indicates that the tunnel of the requested cluster
+// terminates on another hub replica, to which requests are forwarded.
+type ErrClusterOnPeer struct {
+	Cluster string
+	Replica HubReplica
+}
+
+func (e *ErrClusterOnPeer) Error() string {
+	return fmt.Sprintf("cluster %s is served by hub replica %s", e.Cluster, e.Replica.ID)
+}
+
 // ErrNotReady indicates that a required subsystem (e.g. the tunnel
 // server) has not been initialized yet.
 type ErrNotReady struct {
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// PeerTokenHeader is the request header of the peer channel carrying
+// the token that authenticates a hub replica to another.
+const PeerTokenHeader = "Otterscale-Peer-Token"
+
+// PeerClusterPath is the path prefix of the peer channel under which
+// a hub replica relays requests to the cluster tunnels it terminates:
+// PeerClusterPath + cluster + the request path on the agent.
+const PeerClusterPath = "/clusters/"
+
+// HubReplica identifies a hub process. Several hub replicas may serve
+// behind one load balancer; each agent tunnel terminates on exactly
+// one of them, and the others forward the requests of its cluster to
+// that replica over the peer channel.
+type HubReplica struct {
+	// ID identifies the replica in the link store.
+	ID string
+	// PeerURL is the base URL of the replica's peer channel. Empty
+	// when peer forwarding is disabled.
+	PeerURL string
+}
+
+// PeerSessionPath is the path prefix of the peer channel under which
+// a hub replica applies the session operations other replicas forward
+// to it: PeerSessionPath + session ID.
+const PeerSessionPath = "/sessions/"
+
+// HubReplicaHeader is the response header of the streaming runtime
+// RPCs naming the hub replica that owns the session. Clients behind a
+// load balancer that supports it may pin the session's writes to that
+// replica; writes reaching another replica are forwarded.
+const HubReplicaHeader = "Otterscale-Hub-Replica"
+
+// SessionOpKind names an operation on an exec, port-forward or VNC
+// session.
+type SessionOpKind string
+
+const (
+	SessionOpWriteExec        SessionOpKind = "write-exec"
+	SessionOpResizeExec       SessionOpKind = "resize-exec"
+	SessionOpWritePortForward SessionOpKind = "write-portforward"
+	SessionOpWriteVNC         SessionOpKind = "write-vnc"
+)
+
+// SessionOp is a unary operation on a session, as forwarded between
+// hub replicas. The session ID travels in the request path.
+type SessionOp struct {
+	Kind      SessionOpKind `json:"kind"`
+	SessionID string        `json:"-"`
+	Data      []byte        `json:"data,omitempty"`
+	Rows      uint16        `json:"rows,omitempty"`
+	Cols      uint16        `json:"cols,omitempty"`
+}
+
+// SessionRouter issues session IDs that encode the hub replica owning
+// the session, and forwards operations on sessions owned by another
+// replica to it. Implementations live in the providers layer.
+type SessionRouter interface {
+	// ReplicaID returns the ID of this hub replica, or "" if peer
+	// forwarding is disabled.
+	ReplicaID() string
+	// NewSessionID returns a new session ID owned by this replica.
+	NewSessionID() string
+	// SessionOwner returns the replica owning sessionID and true if
+	// that is another replica. IDs that were not issued by a replica
+	// of this hub are reported as local, and so are not found.
+	SessionOwner(sessionID string) (HubReplica, bool)
+	// ForwardSessionOp applies op on replica.
+	ForwardSessionOp(ctx context.Context, replica HubReplica, op SessionOp) error
+}
+
+// SharedState is hub state that every replica keeps in memory and
+// persists to a store shared by all replicas, such as the enrollment
+// tokens or the cluster pins. Changes are decided on the stored state;
+// Refresh brings the in-memory copy, which serves the reads, up to
+// date with the changes the other replicas made.
+type SharedState interface {
+	Refresh(ctx context.Context) error
+}
+
+// SharedStateRefresher keeps the shared state of a hub replica up to
+// date with the other replicas.
+type SharedStateRefresher struct {
+	states []SharedState
+}
+
+// NewSharedStateRefresher returns a refresher of states. Without
+// states, as for a hub running as a single replica, its refresh loop
+// returns at once.
+func NewSharedStateRefresher(states ...SharedState) *SharedStateRefresher {
+	return &SharedStateRefresher{states: states}
+}
+
+// StartRefreshLoop refreshes every state each interval until ctx is
+// canceled. A failed refresh is logged and retried at the next
+// interval.
+func (r *SharedStateRefresher) StartRefreshLoop(ctx context.Context, interval time.Duration) {
+	if len(r.states) == 0 {
+		return
+	}
+	log := slog.Default().With("component", "shared-state-refresher")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, interval)
+			for _, state := range r.states {
+				if err := state.Refresh(roundCtx); err != nil && ctx.Err() == nil {
+					log.Warn("failed to refresh shared state", "error", err)
+				}
+			}
+			cancel()
+		}
+	}
+}
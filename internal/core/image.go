@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultAgentImageRepository is the repository the agent image is
+// pulled from unless the hub is configured with another one.
+const DefaultAgentImageRepository = "ghcr.io/otterscale/otterscale"
+
+// AgentImageHeader is the response header of a registration carrying
+// the image reference the agent should run, as AgentImageConfig.Ref
+// renders it for the version in the response. The Register RPC
+// response has no field for it.
+const AgentImageHeader = "Otterscale-Agent-Image"
+
+// ImagePullSecretsHeader is the response header of a registration
+// carrying the comma-separated names of the Secrets the agent image is
+// pulled with.
+const ImagePullSecretsHeader = "Otterscale-Image-Pull-Secrets"
+
+var (
+	// reImageRepository matches an image repository: an optional
+	// registry host and port followed by lower-case path components.
+	reImageRepository = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+	// reImageDigest matches the sha256 content digest of an image.
+	reImageDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	// reSecretName matches a Kubernetes Secret name, a DNS-1123
+	// subdomain.
+	reSecretName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+)
+
+// AgentImageConfig describes where the agent image is pulled from, so
+// that air-gapped sites can run agents from a mirror.
+type AgentImageConfig struct {
+	// Repository is the image repository, e.g.
+	// "harbor.example.com/otterscale/otterscale". Empty means
+	// DefaultAgentImageRepository.
+	Repository string
+	// Digests pins agent versions to image digests ("sha256:..."). If
+	// it is not empty, images are referenced by digest and versions
+	// without a digest are not rolled out.
+	Digests map[string]string
+	// PullSecrets are the names of the Secrets in the agent namespace
+	// the image is pulled with.
+	PullSecrets []string
+}
+
+// Validate checks the configuration and returns an *ErrInvalidInput on
+// failure.
+func (c AgentImageConfig) Validate() error {
+	if c.Repository != "" && !reImageRepository.MatchString(c.Repository) {
+		return &ErrInvalidInput{Field: "image_repository", Message: fmt.Sprintf("must be an image repository, got %q", c.Repository)}
+	}
+	for version, digest := range c.Digests {
+		if _, err := ParseAgentVersion(version); err != nil {
+			return &ErrInvalidInput{Field: "image_digests", Message: fmt.Sprintf("must map semantic versions to digests, got version %q", version)}
+		}
+		if !reImageDigest.MatchString(digest) {
+			return &ErrInvalidInput{Field: "image_digests", Message: fmt.Sprintf("must map versions to sha256 digests, got %q", digest)}
+		}
+	}
+	for _, name := range c.PullSecrets {
+		if !reSecretName.MatchString(name) {
+			return &ErrInvalidInput{Field: "image_pull_secrets", Message: fmt.Sprintf("must be Secret names, got %q", name)}
+		}
+	}
+	return nil
+}
+
+// Ref returns the reference of the agent image of version. When
+// digests are pinned, the reference carries the digest as well as the
+// version tag, and versions without a digest are refused with an
+// ErrorCodeFailedPrecondition domain error.
+func (c AgentImageConfig) Ref(version string) (string, error) {
+	repository := c.Repository
+	if repository == "" {
+		repository = DefaultAgentImageRepository
+	}
+	ref := repository + ":" + version
+	if len(c.Digests) == 0 {
+		return ref, nil
+	}
+	digest, ok := c.Digests[version]
+	if !ok {
+		return "", &DomainError{
+			Code:    ErrorCodeFailedPrecondition,
+			Message: fmt.Sprintf("no image digest is pinned for agent version %s", version),
+		}
+	}
+	return ref + "@" + digest, nil
+}
+
+// ParseAgentImage splits an agent image reference, as rendered by
+// AgentImageConfig.Ref, into its repository, version tag and optional
+// digest. The tag must be a semantic version.
+func ParseAgentImage(ref string) (repository, version, digest string, err error) {
+	rest := ref
+	if i := strings.Index(rest, "@"); i >= 0 {
+		rest, digest = rest[:i], rest[i+1:]
+		if !reImageDigest.MatchString(digest) {
+			return "", "", "", &ErrInvalidInput{Field: "image", Message: fmt.Sprintf("must have a sha256 digest, got %q", ref)}
+		}
+	}
+	i := strings.LastIndex(rest, ":")
+	if i < 0 || i < strings.LastIndex(rest, "/") {
+		return "", "", "", &ErrInvalidInput{Field: "image", Message: fmt.Sprintf("must have a version tag, got %q", ref)}
+	}
+	repository, version = rest[:i], rest[i+1:]
+	if !reImageRepository.MatchString(repository) {
+		return "", "", "", &ErrInvalidInput{Field: "image", Message: fmt.Sprintf("must have a valid repository, got %q", ref)}
+	}
+	if _, err := ParseAgentVersion(version); err != nil {
+		return "", "", "", &ErrInvalidInput{Field: "image", Message: fmt.Sprintf("must be tagged with a semantic version, got %q", ref)}
+	}
+	return repository, version, digest, nil
+}
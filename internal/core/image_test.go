@@ -0,0 +1,63 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAgentImageConfig_Ref(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	if got, err := (AgentImageConfig{}).Ref("v1.2.3"); err != nil || got != DefaultAgentImageRepository+":v1.2.3" {
+		t.Errorf("Ref with the default repository = %q, %v", got, err)
+	}
+
+	c := AgentImageConfig{
+		Repository: "harbor.example.com:8443/otterscale/otterscale",
+		Digests:    map[string]string{"v1.2.3": digest},
+	}
+	if got, err := c.Ref("v1.2.3"); err != nil || got != c.Repository+":v1.2.3@"+digest {
+		t.Errorf("Ref with a pinned digest = %q, %v", got, err)
+	}
+
+	_, err := c.Ref("v1.2.4")
+	var de *DomainError
+	if !errors.As(err, &de) || de.Code != ErrorCodeFailedPrecondition {
+		t.Errorf("Ref without a pinned digest = %v, want a failed precondition", err)
+	}
+}
+
+func TestAgentImageConfig_Validate(t *testing.T) {
+	for name, c := range map[string]AgentImageConfig{
+		"bad repository":  {Repository: "Harbor/OtterScale"},
+		"tag repository":  {Repository: "ghcr.io/otterscale/otterscale:latest"},
+		"bad version":     {Digests: map[string]string{"latest": "sha256:" + strings.Repeat("a", 64)}},
+		"bad digest":      {Digests: map[string]string{"v1.2.3": "sha256:abc"}},
+		"bad pull secret": {PullSecrets: []string{"Harbor_Creds"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseAgentImage(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("b", 64)
+
+	repository, version, got, err := ParseAgentImage("localhost:5000/otterscale:v1.2.3@" + digest)
+	if err != nil || repository != "localhost:5000/otterscale" || version != "v1.2.3" || got != digest {
+		t.Errorf("ParseAgentImage = %q, %q, %q, %v", repository, version, got, err)
+	}
+
+	for _, ref := range []string{
+		"localhost:5000/otterscale",
+		"ghcr.io/otterscale/otterscale:latest",
+		"ghcr.io/otterscale/otterscale:v1.2.3@sha256:abc",
+		"GHCR.io/otterscale:v1.2.3",
+	} {
+		if _, _, _, err := ParseAgentImage(ref); err == nil {
+			t.Errorf("ParseAgentImage(%q): expected an error", ref)
+		}
+	}
+}
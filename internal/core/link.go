@@ -5,12 +5,59 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// RenewPath is the HTTP path, relative to the link server URL, at
+// which agents renew their tunnel certificate in place.
+const RenewPath = "/link/renew"
+
+// AgentHealthPath is the HTTP path, on the agent's tunnel endpoint, of
+// the health endpoint the hub probes. It answers 200 if kube-apiserver
+// is ready and 503 otherwise, and sets AgentHealthHeader either way.
+const AgentHealthPath = "/__otterscale/healthz"
+
+// AgentHealthHeader is set by the agent's health endpoint to the
+// result of its kube-apiserver readiness check. It tells the answer of
+// the agent apart from the kube-apiserver answer older agents, without
+// the endpoint, proxy.
+const AgentHealthHeader = "Otterscale-Agent-Health"
+
+// LinkHealth is the state of a tunnel as seen by the hub's health
+// probes.
+type LinkHealth string
+
+const (
+	// LinkHealthy means the agent answers through the tunnel and
+	// kube-apiserver is ready.
+	LinkHealthy LinkHealth = "healthy"
+	// LinkDegraded means the agent answers through the tunnel but
+	// kube-apiserver is not ready.
+	LinkDegraded LinkHealth = "degraded"
+	// LinkUnreachable means the agent does not answer through the
+	// tunnel.
+	LinkUnreachable LinkHealth = "unreachable"
+)
+
+// ClusterFactsHeader is the request header in which an agent reports
+// the JSON-encoded ClusterFacts of its cluster on the Register RPC.
+const ClusterFactsHeader = "Otterscale-Cluster-Facts"
+
+// LabelSelectorHeader is the request header carrying the optional
+// label selector of the ListLinks RPC, in the Kubernetes label
+// selector syntax (e.g. "env=prod,region in (eu-west)").
+const LabelSelectorHeader = "Otterscale-Label-Selector"
+
 // maxClusterNameLength is the maximum allowed length for a cluster
 // name. This matches the Kubernetes label value length limit.
 const maxClusterNameLength = 63
@@ -21,6 +68,35 @@ const maxClusterNameLength = 63
 // names that contain quotes, newlines, or other special characters.
 var reClusterName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
 
+// maxAgentUIDLength is the maximum allowed length for an agent UID.
+const maxAgentUIDLength = 128
+
+// reAgentUID matches a valid agent UID, such as a Kubernetes object
+// UID.
+var reAgentUID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateAgentUID checks that the given agent UID is non-empty,
+// within maxAgentUIDLength, and matches the allowed character
+// pattern. It returns an *ErrInvalidInput on failure.
+func ValidateAgentUID(uid string) error {
+	if uid == "" {
+		return &ErrInvalidInput{Field: "agent_uid", Message: "must not be empty"}
+	}
+	if len(uid) > maxAgentUIDLength {
+		return &ErrInvalidInput{
+			Field:   "agent_uid",
+			Message: fmt.Sprintf("must not exceed %d characters", maxAgentUIDLength),
+		}
+	}
+	if !reAgentUID.MatchString(uid) {
+		return &ErrInvalidInput{
+			Field:   "agent_uid",
+			Message: fmt.Sprintf("must match [A-Za-z0-9][A-Za-z0-9._-]*, got %q", uid),
+		}
+	}
+	return nil
+}
+
 // ValidateClusterName checks that the given cluster name is non-empty,
 // within the Kubernetes label value length limit, and matches the
 // allowed character pattern. It returns an *ErrInvalidInput on failure.
@@ -47,17 +123,45 @@ func ValidateClusterName(cluster string) error {
 // tunnels. It allocates unique endpoints per cluster, signs agent
 // CSRs, and provisions tunnel users for each connecting agent.
 type TunnelProvider interface {
-	// CACertPEM returns the PEM-encoded CA certificate so that
+	// CACertPEM returns the PEM-encoded CA trust bundle so that
 	// agents can verify the tunnel server and the server can
-	// configure mTLS.
+	// configure mTLS. During a CA rotation it contains both the
+	// active and the retiring CA certificate.
 	CACertPEM() []byte
-	// ListLinks returns the names of all registered clusters.
+	// ListLinks returns all known clusters, including those whose
+	// agent is currently disconnected.
 	ListLinks() map[string]Link
+	// SetLinkLabels replaces the labels of the cluster's link. It
+	// returns an *ErrClusterNotFound if the cluster is unknown.
+	SetLinkLabels(ctx context.Context, cluster string, set map[string]string) error
+	// SetLinkFacts records the facts the cluster's agent reported.
+	// It returns an *ErrClusterNotFound if the cluster is unknown.
+	SetLinkFacts(ctx context.Context, cluster string, facts ClusterFacts) error
+	// WatchLinks returns a channel of the link events that occur
+	// after the call. The channel is closed when ctx is canceled or
+	// the caller falls behind.
+	WatchLinks(ctx context.Context) <-chan LinkEvent
 	// RegisterLink validates and signs the agent's CSR, creates
 	// a tunnel user, and returns the allocated endpoint together
-	// with the PEM-encoded signed certificate.
-	RegisterLink(ctx context.Context, cluster, agentID, agentVersion string, csrPEM []byte) (endpoint string, certPEM []byte, err error)
-	// ResolveAddress returns the HTTP base URL for the given cluster.
+	// with the PEM-encoded signed certificate. The certificate is
+	// bound to cluster and agentUID. An agent ID the cluster does
+	// not know yet adds a replica to the cluster; a known one
+	// replaces its replica.
+	RegisterLink(ctx context.Context, cluster, agentID, agentUID, agentVersion string, csrPEM []byte) (endpoint string, certPEM []byte, err error)
+	// RenewLink signs a successor to the cluster's current agent
+	// certificate, authenticated by a proof made with the current
+	// certificate's key. The successor is bound to the same agent
+	// UID. The cluster keeps its endpoint and tunnel user, so the
+	// running tunnel session is not interrupted.
+	RenewLink(ctx context.Context, cluster, agentID string, currentCertPEM, csrPEM, proof []byte) (endpoint string, certPEM []byte, err error)
+	// VerifyIdentity checks that certPEM is a valid, unrevoked
+	// agent certificate issued for cluster and that proof was made
+	// with its key over cluster and csrPEM, and returns the agent
+	// UID the certificate was issued for. It authorizes an agent to
+	// register again without an enrollment token.
+	VerifyIdentity(ctx context.Context, cluster string, certPEM, csrPEM, proof []byte) (agentUID string, err error)
+	// ResolveAddress returns the HTTP base URL for the given
+	// cluster, preferring a replica whose health probes succeed.
 	ResolveAddress(ctx context.Context, cluster string) (string, error)
 }
 
@@ -69,7 +173,15 @@ type TunnelConsumer interface {
 	// private key that corresponds to the CSR. Returning the key
 	// alongside the certificate eliminates the TOCTOU race that
 	// would occur if callers had to fetch the key separately.
-	Register(ctx context.Context, serverURL, cluster string) (Registration, error)
+	// creds authorizes the registration: the previous identity is
+	// presented if set, the enrollment token otherwise. facts are
+	// reported to the hub along with the registration.
+	Register(ctx context.Context, serverURL, cluster string, creds AgentCredentials, facts ClusterFacts) (Registration, error)
+	// Renew obtains a successor to the certificate in current,
+	// proving possession of current.PrivateKeyPEM instead of
+	// registering from scratch. The returned Registration keeps
+	// current's endpoint and carries a fresh private key.
+	Renew(ctx context.Context, serverURL, cluster string, current Registration) (Registration, error)
 }
 
 // Registration holds the credentials and connection details returned
@@ -80,8 +192,9 @@ type Registration struct {
 	// Certificate is the PEM-encoded X.509 certificate signed by
 	// the server's CA, used for mTLS client authentication.
 	Certificate []byte
-	// CACertificate is the PEM-encoded CA certificate used to
-	// verify the tunnel server's identity.
+	// CACertificate is the PEM-encoded CA trust bundle used to
+	// verify the tunnel server's identity. During a CA rotation it
+	// holds both the active and the retiring CA certificate.
 	CACertificate []byte
 	// PrivateKeyPEM is the PEM-encoded ECDSA private key that
 	// corresponds to the CSR sent during this registration.
@@ -92,18 +205,156 @@ type Registration struct {
 	// set by the TunnelConsumer so that callers can derive auth
 	// credentials without re-querying the hostname.
 	AgentID string
-	// ServerVersion is the version of the server binary. Agents
-	// compare this against their own version to decide whether a
-	// self-update is needed.
+	// ServerVersion is the agent version the hub wants the agent to
+	// run, as decided by the upgrade policy. Agents compare this
+	// against their own version to decide whether a self-update is
+	// needed.
 	ServerVersion string
+	// AgentImage is the image reference of ServerVersion. Agents of
+	// hubs that do not send it derive the reference from
+	// DefaultAgentImageRepository.
+	AgentImage string
+	// ImagePullSecrets are the names of the Secrets the agent image
+	// is pulled with.
+	ImagePullSecrets []string
+}
+
+// ClusterFacts describes a cluster as observed by its agent when it
+// registers.
+type ClusterFacts struct {
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	NodeCount         int    `json:"nodeCount,omitempty"`
+	// Provider is the infrastructure provider, taken from the scheme
+	// of the nodes' provider ID (e.g. "aws", "gce", "azure").
+	Provider string `json:"provider,omitempty"`
+}
+
+// maxFactLength bounds the length of the string facts an agent
+// reports.
+const maxFactLength = 64
+
+// ValidateClusterFacts checks that the facts reported by an agent are
+// within bounds. It returns an *ErrInvalidInput on failure.
+func ValidateClusterFacts(facts ClusterFacts) error {
+	if len(facts.KubernetesVersion) > maxFactLength {
+		return &ErrInvalidInput{Field: "kubernetes_version", Message: fmt.Sprintf("must not exceed %d characters", maxFactLength)}
+	}
+	if len(facts.Provider) > maxFactLength {
+		return &ErrInvalidInput{Field: "provider", Message: fmt.Sprintf("must not exceed %d characters", maxFactLength)}
+	}
+	if facts.NodeCount < 0 {
+		return &ErrInvalidInput{Field: "node_count", Message: "must not be negative"}
+	}
+	return nil
 }
 
-// Cluster holds the per-cluster tunnel state: the allocated
-// loopback host and the chisel user name.
+// ValidateLinkLabels checks that every key and value of set is a
+// valid Kubernetes label key and value. It returns an
+// *ErrInvalidInput on failure.
+func ValidateLinkLabels(set map[string]string) error {
+	for k, v := range set {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return &ErrInvalidInput{Field: "labels", Message: fmt.Sprintf("invalid key %q: %s", k, strings.Join(errs, "; "))}
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return &ErrInvalidInput{Field: "labels", Message: fmt.Sprintf("invalid value %q for key %q: %s", v, k, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// Link holds the per-cluster tunnel state. A cluster may be served by
+// several agent replicas, each with its own loopback host and chisel
+// user; the endpoint fields describe the active replica, the one
+// requests to the cluster are routed to.
 type Link struct {
-	Host         string // unique 127.x.x.x loopback address
-	User         string // chisel user name
-	AgentVersion string // agent binary version
+	Host          string    // loopback address of the active replica
+	User          string    // chisel user name of the active replica
+	AgentVersion  string    // agent binary version of the active replica
+	CAFingerprint string    // fingerprint of the CA that signed the active replica's certificate
+	Serial        string    // hex-encoded serial number of the active replica's certificate
+	Connected     bool      // false once every tunnel is lost or the hub restarted
+	FirstSeen     time.Time // first registration of the cluster
+	LastSeen      time.Time // last registration, renewal or successful probe of any replica
+	// Labels are set by admins and kept across re-registrations. The
+	// map is replaced, never modified, so it may be shared.
+	Labels map[string]string
+	Facts  ClusterFacts // as reported on the last registration
+	// Replicas lists the agent replicas serving the cluster, most
+	// recently registered first. The slice is replaced, never
+	// modified, so it may be shared.
+	Replicas []LinkReplica
+	// Health and Latency are those of the active replica.
+	Health  LinkHealth
+	Latency time.Duration
+	// HubReplica is the ID of the hub replica the cluster's tunnel
+	// terminates on, if that is another replica than this one. The
+	// endpoint fields and Replicas are not known for such links.
+	HubReplica string
+}
+
+// LinkReplica is the tunnel state of one agent replica of a cluster.
+type LinkReplica struct {
+	Host          string // unique 127.x.x.x loopback address
+	User          string // chisel user name, the agent ID
+	AgentUID      string // UID the replica's certificate is bound to
+	AgentVersion  string
+	CAFingerprint string
+	Serial        string
+	// Healthy is false while health probes of the replica's tunnel
+	// endpoint fail, that is while Health is LinkUnreachable.
+	// Unhealthy replicas are only routed to if no healthy replica is
+	// left.
+	Healthy bool
+	// Health is the result of the last health probe.
+	Health LinkHealth
+	// Latency is the round-trip time of the last health probe the
+	// agent answered.
+	Latency      time.Duration
+	RegisteredAt time.Time
+	LastSeen     time.Time
+	// DisconnectedAt is when the replica was marked as disconnected
+	// after failing the health check's threshold of probes, and zero
+	// otherwise. A disconnected replica keeps its loopback host and
+	// chisel user, and is still probed, until its grace period is
+	// over and it is evicted.
+	DisconnectedAt time.Time
+}
+
+// LinkRecord is the persisted form of a Link. It carries what is
+// needed to list a cluster, and to keep its loopback host reserved,
+// while its agent is disconnected.
+type LinkRecord struct {
+	Cluster      string            `json:"cluster"`
+	AgentID      string            `json:"agentId"`
+	AgentVersion string            `json:"agentVersion"`
+	Host         string            `json:"host"`
+	FirstSeen    time.Time         `json:"firstSeen"`
+	LastSeen     time.Time         `json:"lastSeen"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Facts        ClusterFacts      `json:"facts,omitzero"`
+	// Connected reports whether the tunnel was up when the record
+	// was written.
+	Connected bool `json:"connected,omitempty"`
+	// HubReplica and PeerURL identify the hub replica the tunnel
+	// terminates on, so that the other replicas of a shared store
+	// forward the cluster's requests to it. Host is only reserved on
+	// that replica.
+	HubReplica string `json:"hubReplica,omitempty"`
+	PeerURL    string `json:"peerURL,omitempty"`
+}
+
+// LinkStore persists the link registry so that clusters stay listed,
+// as disconnected, across hub restarts until their agents register
+// again.
+type LinkStore interface {
+	// LoadLinks returns every persisted link.
+	LoadLinks(ctx context.Context) ([]LinkRecord, error)
+	// SaveLink creates or replaces the record of rec.Cluster.
+	SaveLink(ctx context.Context, rec LinkRecord) error
+	// DeleteLink removes the record of cluster. Deleting a missing
+	// record is not an error.
+	DeleteLink(ctx context.Context, cluster string) error
 }
 
 // HarborRobotCredentials holds the name and secret for a Harbor
@@ -115,7 +366,8 @@ type HarborRobotCredentials struct {
 	Secret string
 }
 
-// HarborClient creates per-cluster robot accounts in Harbor.
+// HarborClient creates and deletes per-cluster robot accounts in
+// Harbor.
 // Implementations live in the providers layer.
 type HarborClient interface {
 	// EnsureRobotAccount creates (or re-creates) a system-level
@@ -123,6 +375,9 @@ type HarborClient interface {
 	// credentials. On conflict, the existing robot is deleted and
 	// re-created to obtain a fresh secret.
 	EnsureRobotAccount(ctx context.Context, clusterName string) (*HarborRobotCredentials, error)
+	// DeleteRobotAccount deletes the robot account of the given
+	// cluster name. Deleting a missing robot is not an error.
+	DeleteRobotAccount(ctx context.Context, clusterName string) error
 }
 
 // AgentManifestConfig holds the external URLs and HMAC key needed to
@@ -134,33 +389,57 @@ type AgentManifestConfig struct {
 	// TunnelURL is the externally reachable URL of the tunnel server
 	// (e.g. "https://tunnel.example.com:8300").
 	TunnelURL string
-	// HMACKey is a 32-byte key derived from the CA seed via HKDF.
+	// HMACKey is a 32-byte key derived from the CA's HMAC secret via
+	// HKDF.
 	// It is used to sign and verify stateless manifest tokens.
 	HMACKey []byte
 	// HarborURL is the externally reachable Harbor registry URL.
 	// Empty when Harbor integration is disabled.
 	HarborURL string
+	// Image describes where agents pull their image from, both in
+	// generated manifests and on self-update.
+	Image AgentImageConfig
 }
 
 // ManifestParams holds the parameters needed to render an agent
 // installation manifest. It is defined in the core layer as a
 // pure value object; the rendering logic lives in the providers layer.
 type ManifestParams struct {
-	Cluster   string
-	UserName  string
+	Cluster  string
+	UserName string
+	// Version is the agent version, the tag of Image.
+	Version   string
 	Image     string
 	ServerURL string
 	TunnelURL string
-	// ExtraUsers are additional user identities bound to cluster-admin
-	// via the otterscale-cluster-admin ClusterRoleBinding, in addition
-	// to UserName.
+	// Values are the settings of the agent Deployment.
+	Values AgentDeploymentValues
+	// ImagePullSecrets are the names of the Secrets the agent image
+	// is pulled with.
+	ImagePullSecrets []string
+	// ExtraUsers are additional user identities granted the same
+	// access as UserName under Profile.
 	ExtraUsers []string
+	// Profile selects the RBAC of the manifest.
+	Profile ManifestProfile
+	// Bootstrap reports whether the agent bootstraps its cluster, and
+	// therefore needs the bootstrap permissions.
+	Bootstrap bool
+	// ImpersonateGroups, if not empty, are the only groups the agent
+	// may impersonate.
+	ImpersonateGroups []string
+	// Namespaces are the namespaces in which the users are admins
+	// under ManifestProfileNamespaceScoped.
+	Namespaces []string
 	// HarborURL is the Harbor registry URL. Empty when Harbor
 	// integration is disabled.
 	HarborURL string
 	// HarborCreds holds the per-cluster robot account credentials.
 	// Nil when Harbor integration is disabled.
 	HarborCreds *HarborRobotCredentials
+	// EnrollmentToken is the single-use token the agent presents on
+	// its first registration.
+	EnrollmentToken string
 }
 
 // ManifestRenderer renders agent installation manifests from the given
@@ -168,84 +447,360 @@ type ManifestParams struct {
 // template and formatting details.
 type ManifestRenderer interface {
 	RenderAgentManifest(params *ManifestParams) (string, error)
+	// RenderAgentArchive renders the agent manifest as a gzipped
+	// tarball in format, which is ManifestFormatHelm or
+	// ManifestFormatKustomize.
+	RenderAgentArchive(params *ManifestParams, format ManifestFormat) ([]byte, error)
+	// RenderUninstallManifest renders a manifest that identifies
+	// every resource installed by the agent manifest, for use with
+	// "kubectl delete -f".
+	RenderUninstallManifest(cluster string) (string, error)
 }
 
 // LinkUseCase orchestrates cluster registration on the server side.
 // It delegates CSR signing and tunnel setup to the TunnelProvider,
-// and token management to the ManifestTokenIssuer.
+// manifest token management to the ManifestTokenIssuer and
+// enrollment token management to EnrollmentTokens.
 type LinkUseCase struct {
 	tunnel      TunnelProvider
-	version     Version
+	revoker     CertificateRevoker
 	manifestCfg AgentManifestConfig
 	renderer    ManifestRenderer
 	tokenIssuer *ManifestTokenIssuer
 	harbor      HarborClient // nil when Harbor integration is disabled
+	enrollment  *EnrollmentTokens
+	pins        *ClusterPins
+	approvals   *ClusterApprovals
+	upgrades    *AgentUpgrades
+	evictors    []ClusterEvictor
 }
 
 // NewLinkUseCase returns a LinkUseCase backed by the given
-// TunnelProvider. version is the server binary version, included in
-// registration responses so agents can detect mismatches.
-// manifestCfg provides the external URLs embedded in generated agent
-// installation manifests. It returns an error if any required
-// manifest configuration field is missing.
-func NewLinkUseCase(tunnel TunnelProvider, version Version, manifestCfg AgentManifestConfig, renderer ManifestRenderer, harbor HarborClient) (*LinkUseCase, error) {
+// TunnelProvider. revoker removes deregistered clusters from the
+// tunnel. manifestCfg provides the external URLs embedded in generated
+// agent installation manifests. enrollment holds
+// the tokens agents present on their first registration and pins binds
+// each cluster to the agent that enrolled it. approvals holds the
+// admin decisions on clusters that register for the first time.
+// upgrades decides the agent version reported in registration
+// responses. evictors drop the per-cluster state of deregistered
+// clusters. It returns an error if any required manifest configuration
+// field is missing.
+func NewLinkUseCase(tunnel TunnelProvider, revoker CertificateRevoker, manifestCfg AgentManifestConfig, renderer ManifestRenderer, harbor HarborClient, enrollment *EnrollmentTokens, pins *ClusterPins, approvals *ClusterApprovals, upgrades *AgentUpgrades, evictors []ClusterEvictor) (*LinkUseCase, error) {
 	if manifestCfg.ServerURL == "" {
 		return nil, fmt.Errorf("manifest config: server URL is required")
 	}
 	if manifestCfg.TunnelURL == "" {
 		return nil, fmt.Errorf("manifest config: tunnel URL is required")
 	}
+	if err := manifestCfg.Image.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest config: %w", err)
+	}
+	if revoker == nil {
+		return nil, fmt.Errorf("certificate revoker is required")
+	}
+	if enrollment == nil {
+		return nil, fmt.Errorf("enrollment token registry is required")
+	}
+	if pins == nil {
+		return nil, fmt.Errorf("cluster pins are required")
+	}
+	if approvals == nil {
+		return nil, fmt.Errorf("cluster approvals are required")
+	}
+	if upgrades == nil {
+		return nil, fmt.Errorf("agent upgrades are required")
+	}
 	tokenIssuer, err := NewManifestTokenIssuer(manifestCfg.HMACKey)
 	if err != nil {
 		return nil, err
 	}
 	return &LinkUseCase{
 		tunnel:      tunnel,
-		version:     version,
+		revoker:     revoker,
 		manifestCfg: manifestCfg,
 		renderer:    renderer,
 		tokenIssuer: tokenIssuer,
 		harbor:      harbor,
+		enrollment:  enrollment,
+		pins:        pins,
+		approvals:   approvals,
+		upgrades:    upgrades,
+		evictors:    evictors,
 	}, nil
 }
 
-// ListLinks returns the names of all currently registered clusters.
-func (uc *LinkUseCase) ListLinks(_ context.Context) map[string]Link {
-	return uc.tunnel.ListLinks()
+// ListLinks returns all known clusters whose labels match selector,
+// in the Kubernetes label selector syntax; an empty selector matches
+// every cluster. Clusters whose agent is disconnected are included
+// with Connected unset.
+func (uc *LinkUseCase) ListLinks(_ context.Context, selector string) (map[string]Link, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, &ErrInvalidInput{Field: "selector", Message: err.Error()}
+	}
+	ret := make(map[string]Link)
+	for cluster, link := range uc.tunnel.ListLinks() {
+		if sel.Matches(labels.Set(link.Labels)) {
+			ret[cluster] = link
+		}
+	}
+	return ret, nil
+}
+
+// WatchLinks streams the link events of the clusters whose labels
+// match selector. The stream starts with a LinkEventSnapshot for every
+// matching link, and is closed when ctx is canceled or the caller
+// falls behind, after which it should watch again.
+func (uc *LinkUseCase) WatchLinks(ctx context.Context, selector string) (<-chan LinkEvent, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, &ErrInvalidInput{Field: "selector", Message: err.Error()}
+	}
+
+	// Subscribe before listing so that no change between the two is
+	// lost; a change may be reported twice instead.
+	events := uc.tunnel.WatchLinks(ctx)
+	links := uc.tunnel.ListLinks()
+
+	out := make(chan LinkEvent)
+	go func() {
+		defer close(out)
+
+		send := func(ev LinkEvent) bool {
+			if !sel.Matches(labels.Set(ev.Link.Labels)) {
+				return true
+			}
+			select {
+			case out <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		now := time.Now()
+		for _, cluster := range slices.Sorted(maps.Keys(links)) {
+			if !send(LinkEvent{Type: LinkEventSnapshot, Cluster: cluster, Time: now, Link: links[cluster]}) {
+				return
+			}
+		}
+		for ev := range events {
+			if !send(ev) {
+				return
+			}
+		}
+	}()
+	return out, nil
 }
 
-// RegisterCluster validates the inputs, forwards the agent's CSR to
-// the tunnel provider for signing, and returns the signed certificate,
-// CA certificate, tunnel endpoint, and the server's version.
-func (uc *LinkUseCase) RegisterCluster(ctx context.Context, cluster, agentID, agentVersion string, csrPEM []byte) (Registration, error) {
+// SetClusterLabels replaces the labels of cluster with set.
+func (uc *LinkUseCase) SetClusterLabels(ctx context.Context, cluster string, set map[string]string) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	if err := ValidateLinkLabels(set); err != nil {
+		return err
+	}
+	return uc.tunnel.SetLinkLabels(ctx, cluster, set)
+}
+
+// ReportClusterFacts records the facts an agent reported on its
+// registration of cluster.
+func (uc *LinkUseCase) ReportClusterFacts(ctx context.Context, cluster string, facts ClusterFacts) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	if err := ValidateClusterFacts(facts); err != nil {
+		return err
+	}
+	return uc.tunnel.SetLinkFacts(ctx, cluster, facts)
+}
+
+// RegisterCluster validates the inputs, authorizes the registration,
+// forwards the agent's CSR to the tunnel provider for signing, and
+// returns the signed certificate, CA certificate, tunnel endpoint, and
+// the server's version.
+//
+// An agent that presents a previous certificate must prove possession
+// of its key, and the certificate must have been issued for the same
+// cluster and agent UID. Otherwise the registration consumes an
+// enrollment token bound to the cluster; the token is released again
+// if the registration fails. A token is refused for a cluster whose
+// pinned agent registered already: that agent registers with its
+// previous certificate, and any other one needs an admin to re-home
+// the cluster first. Either way the cluster must not be pinned to
+// another agent UID; an unpinned cluster is pinned to the registering
+// agent. When cluster approval is required, the first
+// registration of a cluster is refused as pending until an admin
+// approves it; the enrollment token stays redeemable meanwhile.
+func (uc *LinkUseCase) RegisterCluster(ctx context.Context, cluster, agentID, agentVersion string, csrPEM []byte, proof EnrollmentProof) (Registration, error) {
 	if err := ValidateClusterName(cluster); err != nil {
 		return Registration{}, err
 	}
 	if agentID == "" {
 		return Registration{}, &ErrInvalidInput{Field: "agent_id", Message: "must not be empty"}
 	}
+	if err := ValidateAgentUID(proof.AgentUID); err != nil {
+		return Registration{}, err
+	}
 	if len(csrPEM) == 0 {
 		return Registration{}, &ErrInvalidInput{Field: "csr", Message: "must not be empty"}
 	}
 
-	endpoint, certPEM, err := uc.tunnel.RegisterLink(ctx, cluster, agentID, agentVersion, csrPEM)
+	pin, known := uc.pins.Get(cluster)
+	switch {
+	case len(proof.Certificate) > 0:
+		if len(proof.Proof) == 0 {
+			return Registration{}, &ErrInvalidInput{Field: "proof", Message: "must not be empty"}
+		}
+		uid, err := uc.tunnel.VerifyIdentity(ctx, cluster, proof.Certificate, csrPEM, proof.Proof)
+		if err != nil {
+			return Registration{}, err
+		}
+		if uid != proof.AgentUID {
+			return Registration{}, &DomainError{
+				Code:    ErrorCodePermissionDenied,
+				Message: fmt.Sprintf("previous certificate was issued for agent UID %q, not %q", uid, proof.AgentUID),
+			}
+		}
+		if err := uc.pins.Claim(ctx, cluster, uid, agentID); err != nil {
+			return Registration{}, err
+		}
+		if err := uc.approvals.Admit(ctx, cluster, uid, agentID, agentVersion, known); err != nil {
+			return Registration{}, err
+		}
+	case proof.Token != "":
+		if _, linked := uc.tunnel.ListLinks()[cluster]; known && pin.AgentID != "" && linked {
+			return Registration{}, &DomainError{
+				Code: ErrorCodeFailedPrecondition,
+				Message: fmt.Sprintf("cluster %s is enrolled already; register with the previous agent certificate, or have an admin re-home the cluster first",
+					cluster),
+			}
+		}
+		release, err := uc.enrollment.Redeem(ctx, proof.Token, cluster, agentID)
+		if err != nil {
+			return Registration{}, err
+		}
+		if err := uc.pins.Claim(ctx, cluster, proof.AgentUID, agentID); err != nil {
+			release()
+			return Registration{}, err
+		}
+		if err := uc.approvals.Admit(ctx, cluster, proof.AgentUID, agentID, agentVersion, known); err != nil {
+			release()
+			return Registration{}, err
+		}
+		reg, err := uc.registerLink(ctx, cluster, agentID, proof.AgentUID, agentVersion, csrPEM)
+		if err != nil {
+			release()
+			return Registration{}, err
+		}
+		return reg, nil
+	default:
+		return Registration{}, &DomainError{
+			Code:    ErrorCodeUnauthenticated,
+			Message: "an enrollment token or the previous agent certificate is required",
+		}
+	}
+
+	return uc.registerLink(ctx, cluster, agentID, proof.AgentUID, agentVersion, csrPEM)
+}
+
+// registerLink asks the tunnel provider to sign the CSR and allocate
+// the cluster's endpoint.
+func (uc *LinkUseCase) registerLink(ctx context.Context, cluster, agentID, agentUID, agentVersion string, csrPEM []byte) (Registration, error) {
+	endpoint, certPEM, err := uc.tunnel.RegisterLink(ctx, cluster, agentID, agentUID, agentVersion, csrPEM)
 	if err != nil {
 		return Registration{}, err
 	}
+	return uc.registration(ctx, cluster, agentVersion, endpoint, certPEM, uc.tunnel.ListLinks()), nil
+}
+
+// registration returns the Registration of an agent of cluster running
+// version current, telling it the version and image to run. A version
+// whose image cannot be referenced, because no digest is pinned for
+// it, is not rolled out: the agent is told to keep its version.
+func (uc *LinkUseCase) registration(ctx context.Context, cluster, current, endpoint string, certPEM []byte, links map[string]Link) Registration {
+	version := uc.upgrades.Desired(ctx, cluster, current, links)
+	image, err := uc.manifestCfg.Image.Ref(version)
+	if err != nil && version != current {
+		slog.Warn("not rolling out agent version", "cluster", cluster, "version", version, "error", err)
+		version = current
+		image, err = uc.manifestCfg.Image.Ref(current)
+	}
+	if err != nil {
+		image = ""
+	}
 	return Registration{
-		Endpoint:      endpoint,
-		Certificate:   certPEM,
-		CACertificate: uc.tunnel.CACertPEM(),
-		ServerVersion: string(uc.version),
-	}, nil
+		Endpoint:         endpoint,
+		Certificate:      certPEM,
+		CACertificate:    uc.tunnel.CACertPEM(),
+		ServerVersion:    version,
+		AgentImage:       image,
+		ImagePullSecrets: uc.manifestCfg.Image.PullSecrets,
+	}
+}
+
+// RenewCluster validates the inputs and asks the tunnel provider to
+// renew the agent's current certificate in place. The certificate
+// must belong to the agent UID the cluster is pinned to, so an agent
+// whose cluster was re-homed cannot extend its access. The returned
+// Registration carries the current CA trust bundle, so renewal also
+// moves an agent onto a new CA during a rotation.
+func (uc *LinkUseCase) RenewCluster(ctx context.Context, cluster, agentID string, currentCertPEM, csrPEM, proof []byte) (Registration, error) {
+	if err := ValidateClusterName(cluster); err != nil {
+		return Registration{}, err
+	}
+	if agentID == "" {
+		return Registration{}, &ErrInvalidInput{Field: "agent_id", Message: "must not be empty"}
+	}
+	if len(currentCertPEM) == 0 {
+		return Registration{}, &ErrInvalidInput{Field: "certificate", Message: "must not be empty"}
+	}
+	if len(csrPEM) == 0 {
+		return Registration{}, &ErrInvalidInput{Field: "csr", Message: "must not be empty"}
+	}
+	if len(proof) == 0 {
+		return Registration{}, &ErrInvalidInput{Field: "proof", Message: "must not be empty"}
+	}
+
+	uid, err := uc.tunnel.VerifyIdentity(ctx, cluster, currentCertPEM, csrPEM, proof)
+	if err != nil {
+		return Registration{}, err
+	}
+	if err := uc.pins.Claim(ctx, cluster, uid, agentID); err != nil {
+		return Registration{}, err
+	}
+
+	endpoint, certPEM, err := uc.tunnel.RenewLink(ctx, cluster, agentID, currentCertPEM, csrPEM, proof)
+	if err != nil {
+		return Registration{}, err
+	}
+	links := uc.tunnel.ListLinks()
+	return uc.registration(ctx, cluster, replicaVersion(links[cluster], agentID), endpoint, certPEM, links), nil
+}
+
+// replicaVersion returns the agent version of the replica of link
+// served by agentID, falling back to the version of the active
+// replica.
+func replicaVersion(link Link, agentID string) string {
+	for _, r := range link.Replicas {
+		if r.User == agentID {
+			return r.AgentVersion
+		}
+	}
+	return link.AgentVersion
 }
 
 // IssueManifestURL generates an HMAC-signed token that encodes the
-// cluster name, user identity, and extra users bound to cluster-admin,
-// and returns a full URL that serves the agent manifest as raw YAML.
-// The token is valid for manifestTokenTTL.
-func (uc *LinkUseCase) IssueManifestURL(_ context.Context, cluster, userName string, extraUsers []string) (string, error) {
-	token, err := uc.tokenIssuer.Issue(cluster, userName, extraUsers)
+// cluster name, user identity, extra users and manifest options, and
+// returns a full URL that serves the agent manifest as raw YAML. The
+// token is valid for manifestTokenTTL.
+func (uc *LinkUseCase) IssueManifestURL(_ context.Context, cluster, userName string, extraUsers []string, opts ManifestOptions) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	token, err := uc.tokenIssuer.Issue(cluster, userName, extraUsers, opts.normalized())
 	if err != nil {
 		return "", fmt.Errorf("issue manifest token: %w", err)
 	}
@@ -265,36 +820,341 @@ func (uc *LinkUseCase) VerifyManifestToken(_ context.Context, token string) (Man
 	return claims, nil
 }
 
+// IssueUninstallURL generates an HMAC-signed token for the uninstall
+// manifest of cluster on behalf of the calling admin, and returns a
+// full URL that serves the manifest as raw YAML. The token is valid
+// for manifestTokenTTL.
+func (uc *LinkUseCase) IssueUninstallURL(ctx context.Context, cluster string) (string, error) {
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateClusterName(cluster); err != nil {
+		return "", err
+	}
+	token, err := uc.tokenIssuer.IssueUninstall(cluster, admin)
+	if err != nil {
+		return "", fmt.Errorf("issue uninstall token: %w", err)
+	}
+	return strings.TrimRight(uc.manifestCfg.ServerURL, "/") + "/link/uninstall/" + token, nil
+}
+
+// VerifyUninstallToken is VerifyManifestToken for the tokens of
+// IssueUninstallURL.
+func (uc *LinkUseCase) VerifyUninstallToken(_ context.Context, token string) (ManifestTokenClaims, error) {
+	claims, err := uc.tokenIssuer.VerifyUninstall(token)
+	if err != nil {
+		slog.Debug("uninstall token verification failed", "error", err)
+		return ManifestTokenClaims{}, err
+	}
+	return claims, nil
+}
+
+// GenerateUninstallManifest produces a multi-document YAML manifest
+// that removes the agent of cluster with "kubectl delete -f". The
+// bootstrap layer is left in place; "otterscale agent uninstall
+// --remove-bootstrap" removes it as well.
+func (uc *LinkUseCase) GenerateUninstallManifest(_ context.Context, cluster string) (string, error) {
+	if err := ValidateClusterName(cluster); err != nil {
+		return "", err
+	}
+	return uc.renderer.RenderUninstallManifest(cluster)
+}
+
 // GenerateAgentManifest produces a multi-document YAML manifest for
 // installing the otterscale agent on a target Kubernetes cluster.
-// The manifest includes a Namespace, ServiceAccount,
-// ClusterRoleBinding (binding userName to cluster-admin), and a
+// The manifest includes a Namespace, ServiceAccount, the RBAC of the
+// profile in opts (by default binding userName to cluster-admin), a
+// Secret holding a fresh enrollment token for the cluster, and a
 // Deployment that runs the agent with the correct server/tunnel URLs.
-func (uc *LinkUseCase) GenerateAgentManifest(ctx context.Context, cluster, userName string, extraUsers []string) (string, error) {
-	if err := ValidateClusterName(cluster); err != nil {
+// The agent image is the cluster's target version under the upgrade
+// policy, pulled from the configured repository.
+func (uc *LinkUseCase) GenerateAgentManifest(ctx context.Context, cluster, userName string, extraUsers []string, opts ManifestOptions) (string, error) {
+	params, err := uc.manifestParams(ctx, cluster, userName, extraUsers, opts)
+	if err != nil {
 		return "", err
 	}
+	return uc.renderer.RenderAgentManifest(params)
+}
+
+// GenerateAgentArchive produces the agent manifest of
+// GenerateAgentManifest packaged in format, a Helm chart or a
+// Kustomize base, as a gzipped tarball.
+func (uc *LinkUseCase) GenerateAgentArchive(ctx context.Context, cluster, userName string, extraUsers []string, opts ManifestOptions, format ManifestFormat) ([]byte, error) {
+	if format != ManifestFormatHelm && format != ManifestFormatKustomize {
+		return nil, &ErrInvalidInput{Field: "format", Message: fmt.Sprintf("must be helm or kustomize, got %q", format)}
+	}
+	params, err := uc.manifestParams(ctx, cluster, userName, extraUsers, opts)
+	if err != nil {
+		return nil, err
+	}
+	return uc.renderer.RenderAgentArchive(params, format)
+}
+
+// manifestParams validates the inputs and returns the parameters of
+// the agent manifest of cluster, issuing a fresh enrollment token.
+func (uc *LinkUseCase) manifestParams(ctx context.Context, cluster, userName string, extraUsers []string, opts ManifestOptions) (*ManifestParams, error) {
+	if err := ValidateClusterName(cluster); err != nil {
+		return nil, err
+	}
 	if userName == "" {
-		return "", &ErrInvalidInput{Field: "user_name", Message: "must not be empty"}
+		return nil, &ErrInvalidInput{Field: "user_name", Message: "must not be empty"}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.normalized()
+
+	version := uc.upgrades.Target(cluster, uc.tunnel.ListLinks()[cluster].Labels)
+	image, err := uc.manifestCfg.Image.Ref(version)
+	if err != nil {
+		return nil, err
 	}
 
 	params := &ManifestParams{
-		Cluster:    cluster,
-		UserName:   userName,
-		ExtraUsers: extraUsers,
-		Image:      fmt.Sprintf("ghcr.io/otterscale/otterscale:%s", uc.version),
-		ServerURL:  uc.manifestCfg.ServerURL,
-		TunnelURL:  uc.manifestCfg.TunnelURL,
+		Cluster:           cluster,
+		UserName:          userName,
+		ExtraUsers:        extraUsers,
+		Profile:           opts.Profile,
+		Bootstrap:         opts.Bootstrap(),
+		ImpersonateGroups: opts.ImpersonateGroups,
+		Namespaces:        opts.Namespaces,
+		Values:            opts.Values,
+		Version:           version,
+		Image:             image,
+		ImagePullSecrets:  uc.manifestCfg.Image.PullSecrets,
+		ServerURL:         uc.manifestCfg.ServerURL,
+		TunnelURL:         uc.manifestCfg.TunnelURL,
 	}
 
 	if uc.harbor != nil {
 		creds, err := uc.harbor.EnsureRobotAccount(ctx, cluster)
 		if err != nil {
-			return "", fmt.Errorf("create harbor robot account: %w", err)
+			return nil, fmt.Errorf("create harbor robot account: %w", err)
 		}
 		params.HarborURL = uc.manifestCfg.HarborURL
 		params.HarborCreds = creds
 	}
 
-	return uc.renderer.RenderAgentManifest(params)
+	token, _, err := uc.enrollment.Issue(ctx, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("issue enrollment token: %w", err)
+	}
+	params.EnrollmentToken = token
+
+	return params, nil
+}
+
+// ListEnrollmentTokens returns every enrollment token that is still
+// redeemable or was recently used, revoked or expired, newest first.
+func (uc *LinkUseCase) ListEnrollmentTokens(_ context.Context) []EnrollmentToken {
+	return uc.enrollment.List()
+}
+
+// RevokeEnrollmentToken invalidates an unused enrollment token so that
+// the manifest it was embedded in can no longer enroll an agent.
+func (uc *LinkUseCase) RevokeEnrollmentToken(ctx context.Context, id string) error {
+	if id == "" {
+		return &ErrInvalidInput{Field: "id", Message: "must not be empty"}
+	}
+	return uc.enrollment.Revoke(ctx, id)
+}
+
+// ListClusterPins returns the agent UID every cluster is pinned to,
+// sorted by cluster.
+func (uc *LinkUseCase) ListClusterPins(_ context.Context) []ClusterPin {
+	return uc.pins.List()
+}
+
+// RehomeCluster re-pins cluster to agentUID so that another agent can
+// take it over; an empty agentUID unpins the cluster and lets the next
+// agent that enrolls with a valid token claim it. The agent currently
+// serving the cluster keeps its session but is refused on its next
+// registration or renewal.
+func (uc *LinkUseCase) RehomeCluster(ctx context.Context, cluster, agentUID string) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	if agentUID != "" {
+		if err := ValidateAgentUID(agentUID); err != nil {
+			return err
+		}
+	}
+	if err := uc.pins.Rehome(ctx, cluster, agentUID); err != nil {
+		return err
+	}
+	slog.Info("cluster re-homed", "cluster", cluster, "agent_uid", agentUID)
+	return nil
+}
+
+// ListClusterApprovals returns the approval of every cluster that
+// requested or received an admin decision, sorted by cluster.
+func (uc *LinkUseCase) ListClusterApprovals(_ context.Context) []ClusterApproval {
+	return uc.approvals.List()
+}
+
+// ApproveCluster admits cluster on behalf of the calling admin. The
+// agent of a pending cluster registers on its next retry.
+func (uc *LinkUseCase) ApproveCluster(ctx context.Context, cluster string) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return err
+	}
+	if err := uc.approvals.Approve(ctx, cluster, admin); err != nil {
+		return err
+	}
+	slog.Info("cluster approved", "cluster", cluster, "admin", admin)
+	return nil
+}
+
+// RejectCluster refuses the pending registration of cluster on behalf
+// of the calling admin.
+func (uc *LinkUseCase) RejectCluster(ctx context.Context, cluster, reason string) error {
+	if err := ValidateClusterName(cluster); err != nil {
+		return err
+	}
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return err
+	}
+	if err := uc.approvals.Reject(ctx, cluster, admin, reason); err != nil {
+		return err
+	}
+	slog.Info("cluster rejected", "cluster", cluster, "admin", admin, "reason", reason)
+	return nil
+}
+
+// UpgradePolicy returns the agent upgrade policy.
+func (uc *LinkUseCase) UpgradePolicy(_ context.Context) UpgradePolicy {
+	return uc.upgrades.Policy()
+}
+
+// SetUpgradePolicy replaces the agent upgrade policy on behalf of the
+// calling admin. Every version the policy targets needs a pinned image
+// digest if digests are pinned.
+func (uc *LinkUseCase) SetUpgradePolicy(ctx context.Context, policy UpgradePolicy) error {
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return err
+	}
+	versions := []string{policy.Version}
+	for _, t := range policy.Targets {
+		versions = append(versions, t.Version)
+	}
+	for _, v := range versions {
+		if v == "" {
+			continue
+		}
+		if _, err := uc.manifestCfg.Image.Ref(v); err != nil {
+			return err
+		}
+	}
+	if err := uc.upgrades.SetPolicy(ctx, policy); err != nil {
+		return err
+	}
+	slog.Info("upgrade policy updated", "admin", admin, "version", policy.Version, "paused", policy.Paused)
+	return nil
+}
+
+// PauseUpgrades stops new agent upgrades from starting, or lets them
+// start again if paused is false, on behalf of the calling admin.
+func (uc *LinkUseCase) PauseUpgrades(ctx context.Context, paused bool) error {
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return err
+	}
+	if err := uc.upgrades.SetPaused(ctx, paused); err != nil {
+		return err
+	}
+	slog.Info("upgrade rollout paused", "admin", admin, "paused", paused)
+	return nil
+}
+
+// AbortUpgrades pauses the rollout and rolls the agent upgrades in
+// flight back on behalf of the calling admin. It returns the clusters
+// being rolled back.
+func (uc *LinkUseCase) AbortUpgrades(ctx context.Context) ([]string, error) {
+	admin, err := adminSubject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := uc.upgrades.Abort(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("upgrade rollout aborted", "admin", admin, "clusters", clusters)
+	return clusters, nil
+}
+
+// ListAgentUpgrades returns the upgrade state of every known cluster,
+// sorted by cluster.
+func (uc *LinkUseCase) ListAgentUpgrades(ctx context.Context) []AgentUpgrade {
+	return uc.upgrades.Status(ctx, uc.tunnel.ListLinks())
+}
+
+// adminSubject returns the subject of the calling user, who must be a
+// member of the admin group.
+func adminSubject(ctx context.Context) (string, error) {
+	userInfo, ok := UserInfoFromContext(ctx)
+	if !ok {
+		return "", &DomainError{Code: ErrorCodeUnauthenticated, Message: "user info not found in context"}
+	}
+	if !IsAdmin(userInfo.Groups) {
+		return "", &DomainError{Code: ErrorCodePermissionDenied, Message: "caller is not a member of the admin group"}
+	}
+	return userInfo.Subject, nil
+}
+
+// deregisterReason is the revocation reason recorded for deregistered
+// clusters.
+const deregisterReason = "cluster deregistered"
+
+// DeregisterCluster removes cluster from the hub: it revokes the
+// agent's certificate, deletes its tunnel user, releases its address,
+// unpins the cluster, forgets its approval and upgrade state, drops
+// the cached transports, discovery data and sessions of the cluster
+// and, when Harbor integration is enabled, deletes its robot account.
+// A cluster that is only pinned, because its link was already revoked,
+// is cleaned up as well. If uninstall is set, the returned manifest
+// lists the agent resources to delete on the cluster.
+func (uc *LinkUseCase) DeregisterCluster(ctx context.Context, cluster string, uninstall bool) (string, error) {
+	if err := ValidateClusterName(cluster); err != nil {
+		return "", err
+	}
+
+	err := uc.revoker.RevokeCluster(ctx, cluster, deregisterReason)
+	var notFound *ErrClusterNotFound
+	if errors.As(err, &notFound) {
+		if _, pinned := uc.pins.Get(cluster); !pinned {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	if err := uc.pins.Rehome(ctx, cluster, ""); err != nil {
+		return "", fmt.Errorf("unpin cluster: %w", err)
+	}
+	if err := uc.approvals.Remove(ctx, cluster); err != nil {
+		return "", fmt.Errorf("remove cluster approval: %w", err)
+	}
+	if err := uc.upgrades.Forget(ctx, cluster); err != nil {
+		return "", fmt.Errorf("forget agent upgrade: %w", err)
+	}
+	for _, e := range uc.evictors {
+		e.EvictCluster(cluster)
+	}
+	if uc.harbor != nil {
+		if err := uc.harbor.DeleteRobotAccount(ctx, cluster); err != nil {
+			return "", fmt.Errorf("delete harbor robot account: %w", err)
+		}
+	}
+	slog.Info("cluster deregistered", "cluster", cluster)
+
+	if !uninstall {
+		return "", nil
+	}
+	return uc.renderer.RenderUninstallManifest(cluster)
 }
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LinkEventType is the kind of change a LinkEvent reports.
+type LinkEventType string
+
+const (
+	// LinkEventSnapshot reports a link that existed when the watch
+	// started. Every watch begins with one snapshot event per link.
+	LinkEventSnapshot LinkEventType = "SNAPSHOT"
+	// LinkEventRegistered reports a registration of the cluster's
+	// agent.
+	LinkEventRegistered LinkEventType = "REGISTERED"
+	// LinkEventVersionChanged reports a registration with a different
+	// agent version than the previous one.
+	LinkEventVersionChanged LinkEventType = "VERSION_CHANGED"
+	// LinkEventUnhealthy reports the first failed health probe of a
+	// connected cluster.
+	LinkEventUnhealthy LinkEventType = "UNHEALTHY"
+	// LinkEventDegraded reports a health probe of a connected cluster
+	// that the agent answered with kube-apiserver not ready.
+	LinkEventDegraded LinkEventType = "DEGRADED"
+	// LinkEventRecovered reports a healthy probe after failed or
+	// degraded ones, including one that reconnects a disconnected
+	// cluster within its grace period.
+	LinkEventRecovered LinkEventType = "RECOVERED"
+	// LinkEventDisconnected reports a cluster whose tunnel was lost:
+	// every agent replica failed the health check's threshold of
+	// probes. The cluster keeps its allocation for a grace period.
+	LinkEventDisconnected LinkEventType = "DISCONNECTED"
+	// LinkEventUpdated reports a change of the labels or facts of a
+	// link.
+	LinkEventUpdated LinkEventType = "UPDATED"
+	// LinkEventDeregistered reports a cluster that was revoked or
+	// deregistered.
+	LinkEventDeregistered LinkEventType = "DEREGISTERED"
+)
+
+// LinkEvent is a change of the link state of a cluster.
+type LinkEvent struct {
+	Type    LinkEventType
+	Cluster string
+	Time    time.Time
+	// Link is the state of the link after the change, or its last
+	// state for LinkEventDeregistered.
+	Link Link
+	// PreviousAgentVersion is set for LinkEventVersionChanged.
+	PreviousAgentVersion string
+}
+
+// linkEventBuffer is the number of events buffered per subscriber. A
+// subscriber that falls further behind is dropped.
+const linkEventBuffer = 64
+
+// LinkEventBroadcaster fans link events out to every subscriber.
+// Publishing never blocks: a subscriber whose buffer is full is
+// dropped and its channel closed, so that it can list the links again
+// and resubscribe. It is safe for concurrent use.
+type LinkEventBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan LinkEvent]struct{}
+}
+
+// NewLinkEventBroadcaster returns a broadcaster without subscribers.
+func NewLinkEventBroadcaster() *LinkEventBroadcaster {
+	return &LinkEventBroadcaster{subs: make(map[chan LinkEvent]struct{})}
+}
+
+// Publish sends ev to every subscriber.
+func (b *LinkEventBroadcaster) Publish(ev LinkEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every event published
+// after the call. The channel is closed when ctx is canceled or the
+// subscriber falls behind.
+func (b *LinkEventBroadcaster) Subscribe(ctx context.Context) <-chan LinkEvent {
+	ch := make(chan LinkEvent, linkEventBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}()
+	return ch
+}
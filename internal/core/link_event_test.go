@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLinkEventBroadcaster(t *testing.T) {
+	b := NewLinkEventBroadcaster()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	fast := b.Subscribe(ctx)
+	slow := b.Subscribe(t.Context())
+
+	for range linkEventBuffer {
+		b.Publish(LinkEvent{Type: LinkEventRegistered, Cluster: "a"})
+	}
+	for range linkEventBuffer {
+		if ev := <-fast; ev.Cluster != "a" {
+			t.Fatalf("got event for %q, want a", ev.Cluster)
+		}
+	}
+
+	// The slow subscriber's buffer is full, so it is dropped.
+	b.Publish(LinkEvent{Type: LinkEventDisconnected, Cluster: "a"})
+	if ev := <-fast; ev.Type != LinkEventDisconnected {
+		t.Errorf("got %s, want DISCONNECTED", ev.Type)
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != linkEventBuffer {
+		t.Errorf("slow subscriber got %d events, want %d", n, linkEventBuffer)
+	}
+
+	cancel()
+	if _, ok := <-fast; ok {
+		t.Error("channel still open after cancel")
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"errors"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -14,6 +15,10 @@ type mockTunnelProvider struct {
 	regEndpoint string
 	regCertPEM  []byte
 	regErr      error
+	identityUID string
+	identityErr error
+	identities  int // number of VerifyIdentity calls
+	events      *LinkEventBroadcaster
 }
 
 func (m *mockTunnelProvider) CACertPEM() []byte { return m.caCertPEM }
@@ -24,10 +29,46 @@ func (m *mockTunnelProvider) ListLinks() map[string]Link {
 	return m.links
 }
 
-func (m *mockTunnelProvider) RegisterLink(_ context.Context, _, _, _ string, _ []byte) (endpoint string, certPEM []byte, err error) {
+func (m *mockTunnelProvider) WatchLinks(ctx context.Context) <-chan LinkEvent {
+	if m.events == nil {
+		m.events = NewLinkEventBroadcaster()
+	}
+	return m.events.Subscribe(ctx)
+}
+
+func (m *mockTunnelProvider) SetLinkLabels(_ context.Context, cluster string, set map[string]string) error {
+	link, ok := m.links[cluster]
+	if !ok {
+		return &ErrClusterNotFound{Cluster: cluster}
+	}
+	link.Labels = set
+	m.links[cluster] = link
+	return nil
+}
+
+func (m *mockTunnelProvider) SetLinkFacts(_ context.Context, cluster string, facts ClusterFacts) error {
+	link, ok := m.links[cluster]
+	if !ok {
+		return &ErrClusterNotFound{Cluster: cluster}
+	}
+	link.Facts = facts
+	m.links[cluster] = link
+	return nil
+}
+
+func (m *mockTunnelProvider) RegisterLink(_ context.Context, _, _, _, _ string, _ []byte) (endpoint string, certPEM []byte, err error) {
 	return m.regEndpoint, m.regCertPEM, m.regErr
 }
 
+func (m *mockTunnelProvider) RenewLink(_ context.Context, _, _ string, _, _, _ []byte) (endpoint string, certPEM []byte, err error) {
+	return m.regEndpoint, m.regCertPEM, m.regErr
+}
+
+func (m *mockTunnelProvider) VerifyIdentity(_ context.Context, _ string, _, _, _ []byte) (string, error) {
+	m.identities++
+	return m.identityUID, m.identityErr
+}
+
 func (m *mockTunnelProvider) ResolveAddress(_ context.Context, _ string) (string, error) {
 	return "", nil
 }
@@ -36,12 +77,62 @@ func (m *mockTunnelProvider) ResolveAddress(_ context.Context, _ string) (string
 type mockManifestRenderer struct {
 	result string
 	err    error
+	params *ManifestParams // last rendered parameters
 }
 
-func (m *mockManifestRenderer) RenderAgentManifest(_ *ManifestParams) (string, error) {
+func (m *mockManifestRenderer) RenderAgentManifest(params *ManifestParams) (string, error) {
+	m.params = params
 	return m.result, m.err
 }
 
+func (m *mockManifestRenderer) RenderAgentArchive(params *ManifestParams, format ManifestFormat) ([]byte, error) {
+	m.params = params
+	return []byte(string(format) + " " + m.result), m.err
+}
+
+func (m *mockManifestRenderer) RenderUninstallManifest(cluster string) (string, error) {
+	return "uninstall " + cluster, m.err
+}
+
+// mockRevoker implements CertificateRevoker for testing.
+type mockRevoker struct {
+	revoked []string
+	err     error
+}
+
+func (m *mockRevoker) RevokeCluster(_ context.Context, cluster, _ string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.revoked = append(m.revoked, cluster)
+	return nil
+}
+
+func (m *mockRevoker) Revocations(context.Context) ([]Revocation, error) { return nil, nil }
+func (m *mockRevoker) RestoreAgent(context.Context, string) error        { return nil }
+
+// mockHarborClient implements HarborClient for testing.
+type mockHarborClient struct {
+	deleted []string
+}
+
+func (m *mockHarborClient) EnsureRobotAccount(_ context.Context, cluster string) (*HarborRobotCredentials, error) {
+	return &HarborRobotCredentials{Name: "robot$" + cluster}, nil
+}
+
+func (m *mockHarborClient) DeleteRobotAccount(_ context.Context, cluster string) error {
+	m.deleted = append(m.deleted, cluster)
+	return nil
+}
+
+// clusterEvictorFunc adapts a function to ClusterEvictor.
+type clusterEvictorFunc func(cluster string)
+
+func (f clusterEvictorFunc) EvictCluster(cluster string) { f(cluster) }
+
+// testAgentUID is the agent UID reported by test registrations.
+const testAgentUID = "0b6a4f52-7d1e-4c3a-9f8e-2a5b6c7d8e9f"
+
 func testLinkConfig() AgentManifestConfig {
 	return AgentManifestConfig{
 		ServerURL: "https://server.example.com",
@@ -52,13 +143,26 @@ func testLinkConfig() AgentManifestConfig {
 
 func newTestLinkUseCase(t *testing.T, tp TunnelProvider, renderer ManifestRenderer) *LinkUseCase {
 	t.Helper()
-	uc, err := NewLinkUseCase(tp, "v1.0.0", testLinkConfig(), renderer, nil)
+	uc, err := NewLinkUseCase(tp, &mockRevoker{}, testLinkConfig(), renderer, nil, NewEnrollmentTokens(), NewClusterPins(), NewClusterApprovals(false), NewAgentUpgrades("v1.0.0"), nil)
 	if err != nil {
 		t.Fatalf("NewLinkUseCase: %v", err)
 	}
 	return uc
 }
 
+// issueEnrollmentToken generates a manifest for cluster and returns
+// the enrollment token embedded in it.
+func issueEnrollmentToken(t *testing.T, uc *LinkUseCase, renderer *mockManifestRenderer, cluster string) string {
+	t.Helper()
+	if _, err := uc.GenerateAgentManifest(t.Context(), cluster, "admin@example.com", nil, ManifestOptions{}); err != nil {
+		t.Fatalf("GenerateAgentManifest: %v", err)
+	}
+	if renderer.params == nil || renderer.params.EnrollmentToken == "" {
+		t.Fatal("manifest was rendered without an enrollment token")
+	}
+	return renderer.params.EnrollmentToken
+}
+
 func TestNewLinkUseCase_ValidationErrors(t *testing.T) {
 	tp := &mockTunnelProvider{}
 	renderer := &mockManifestRenderer{}
@@ -87,7 +191,7 @@ func TestNewLinkUseCase_ValidationErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := NewLinkUseCase(tp, "v1.0.0", tt.cfg, renderer, nil)
+			_, err := NewLinkUseCase(tp, &mockRevoker{}, tt.cfg, renderer, nil, NewEnrollmentTokens(), NewClusterPins(), NewClusterApprovals(false), NewAgentUpgrades("v1.0.0"), nil)
 			if err == nil {
 				t.Fatal("expected error, got nil")
 			}
@@ -106,12 +210,112 @@ func TestLinkUseCase_ListLinks(t *testing.T) {
 	tp := &mockTunnelProvider{links: links}
 	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
 
-	got := uc.ListLinks(t.Context())
+	got, err := uc.ListLinks(t.Context(), "")
+	if err != nil {
+		t.Fatalf("ListLinks: %v", err)
+	}
 	if len(got) != 2 {
 		t.Fatalf("expected 2 clusters, got %d", len(got))
 	}
 }
 
+func TestLinkUseCase_ListLinks_Selector(t *testing.T) {
+	tp := &mockTunnelProvider{links: map[string]Link{
+		"prod-eu": {Host: "127.0.0.1"},
+		"prod-us": {Host: "127.0.0.2"},
+		"dev":     {Host: "127.0.0.3"},
+	}}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	for cluster, set := range map[string]map[string]string{
+		"prod-eu": {"env": "prod", "region": "eu-west"},
+		"prod-us": {"env": "prod", "region": "us-east"},
+		"dev":     {"env": "dev", "region": "eu-west"},
+	} {
+		if err := uc.SetClusterLabels(t.Context(), cluster, set); err != nil {
+			t.Fatalf("SetClusterLabels(%s): %v", cluster, err)
+		}
+	}
+
+	got, err := uc.ListLinks(t.Context(), "env=prod,region=eu-west")
+	if err != nil {
+		t.Fatalf("ListLinks: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d clusters, want only prod-eu", len(got))
+	}
+	if _, ok := got["prod-eu"]; !ok {
+		t.Errorf("got %v, want prod-eu", got)
+	}
+
+	if _, err := uc.ListLinks(t.Context(), "env in (prod"); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestLinkUseCase_WatchLinks(t *testing.T) {
+	tp := &mockTunnelProvider{links: map[string]Link{
+		"prod-b": {Host: "127.0.0.1", Labels: map[string]string{"env": "prod"}},
+		"prod-a": {Host: "127.0.0.2", Labels: map[string]string{"env": "prod"}},
+		"dev":    {Host: "127.0.0.3", Labels: map[string]string{"env": "dev"}},
+	}}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	events, err := uc.WatchLinks(ctx, "env=prod")
+	if err != nil {
+		t.Fatalf("WatchLinks: %v", err)
+	}
+
+	for _, want := range []string{"prod-a", "prod-b"} {
+		ev := <-events
+		if ev.Type != LinkEventSnapshot || ev.Cluster != want {
+			t.Errorf("got %s %s, want SNAPSHOT %s", ev.Type, ev.Cluster, want)
+		}
+	}
+
+	tp.events.Publish(LinkEvent{Type: LinkEventDisconnected, Cluster: "dev", Link: tp.links["dev"]})
+	tp.events.Publish(LinkEvent{Type: LinkEventDisconnected, Cluster: "prod-a", Link: tp.links["prod-a"]})
+	if ev := <-events; ev.Type != LinkEventDisconnected || ev.Cluster != "prod-a" {
+		t.Errorf("got %s %s, want DISCONNECTED prod-a", ev.Type, ev.Cluster)
+	}
+
+	cancel()
+	for range events {
+	}
+
+	if _, err := uc.WatchLinks(t.Context(), "env in (prod"); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestLinkUseCase_SetClusterLabels_Validation(t *testing.T) {
+	tp := &mockTunnelProvider{links: map[string]Link{"prod": {}}}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	tests := []struct {
+		name string
+		set  map[string]string
+	}{
+		{"invalid key", map[string]string{"bad key": "x"}},
+		{"invalid value", map[string]string{"env": "not valid!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := uc.SetClusterLabels(t.Context(), "prod", tt.set)
+			var invalid *ErrInvalidInput
+			if !errors.As(err, &invalid) {
+				t.Fatalf("error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+
+	var notFound *ErrClusterNotFound
+	if err := uc.SetClusterLabels(t.Context(), "missing", map[string]string{"env": "prod"}); !errors.As(err, &notFound) {
+		t.Fatalf("error = %v, want ErrClusterNotFound", err)
+	}
+}
+
 func TestLinkUseCase_RegisterCluster_Validation(t *testing.T) {
 	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
 	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
@@ -132,7 +336,7 @@ func TestLinkUseCase_RegisterCluster_Validation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := uc.RegisterCluster(t.Context(), tt.cluster, tt.agentID, "v1", tt.csr)
+			_, err := uc.RegisterCluster(t.Context(), tt.cluster, tt.agentID, "v1", tt.csr, EnrollmentProof{AgentUID: testAgentUID, Token: "id.secret"})
 			if err == nil {
 				t.Fatal("expected error, got nil")
 			}
@@ -153,9 +357,11 @@ func TestLinkUseCase_RegisterCluster_Success(t *testing.T) {
 		regCertPEM:  []byte("signed-cert"),
 		caCertPEM:   []byte("ca-cert"),
 	}
-	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
 
-	reg, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr-data"))
+	reg, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr-data"), EnrollmentProof{AgentUID: testAgentUID, Token: token})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -173,11 +379,265 @@ func TestLinkUseCase_RegisterCluster_Success(t *testing.T) {
 	}
 }
 
+func TestLinkUseCase_RegisterCluster_Approval(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	renderer := &mockManifestRenderer{}
+	uc, err := NewLinkUseCase(tp, &mockRevoker{}, testLinkConfig(), renderer, nil, NewEnrollmentTokens(), NewClusterPins(), NewClusterApprovals(true), NewAgentUpgrades("v1.0.0"), nil)
+	if err != nil {
+		t.Fatalf("NewLinkUseCase: %v", err)
+	}
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	register := func() error {
+		_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: testAgentUID, Token: token})
+		return err
+	}
+
+	// The first registration waits for approval without spending
+	// the token.
+	for range 2 {
+		err := register()
+		if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+			t.Fatalf("error = %v, want code FailedPrecondition while pending", err)
+		}
+	}
+
+	if err := uc.ApproveCluster(t.Context(), "my-cluster"); err == nil {
+		t.Fatal("expected approval by a non-admin caller to fail")
+	}
+	admin := WithUserInfo(t.Context(), UserInfo{Subject: "alice", Groups: []string{adminGroup}})
+	if err := uc.ApproveCluster(admin, "my-cluster"); err != nil {
+		t.Fatalf("ApproveCluster: %v", err)
+	}
+	if err := register(); err != nil {
+		t.Fatalf("RegisterCluster after approval: %v", err)
+	}
+	if got := uc.ListClusterApprovals(t.Context()); len(got) != 1 || got[0].DecidedBy != "alice" {
+		t.Fatalf("approvals = %+v, want my-cluster approved by alice", got)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_RequiresCredentials(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: testAgentUID})
+	if code, _ := DomainErrorCode(err); code != ErrorCodeUnauthenticated {
+		t.Fatalf("error = %v, want code Unauthenticated", err)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_EnrollmentToken(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	csr := []byte("csr")
+
+	if _, err := uc.RegisterCluster(t.Context(), "other-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err == nil {
+		t.Fatal("token was accepted for another cluster")
+	}
+
+	// A failed registration does not spend the token.
+	tp.regErr = errors.New("tunnel unavailable")
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err == nil {
+		t.Fatal("expected the tunnel error")
+	}
+	tp.regErr = nil
+
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err != nil {
+		t.Fatalf("RegisterCluster: %v", err)
+	}
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-2", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token})
+	if code, _ := DomainErrorCode(err); code != ErrorCodeUnauthenticated {
+		t.Fatalf("reused token: error = %v, want code Unauthenticated", err)
+	}
+
+	tokens := uc.ListEnrollmentTokens(t.Context())
+	if len(tokens) != 1 || tokens[0].UsedBy != "agent-1" {
+		t.Fatalf("tokens = %+v, want one token used by agent-1", tokens)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_RevokedToken(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
+
+	id := uc.ListEnrollmentTokens(t.Context())[0].ID
+	if err := uc.RevokeEnrollmentToken(t.Context(), id); err != nil {
+		t.Fatalf("RevokeEnrollmentToken: %v", err)
+	}
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: testAgentUID, Token: token}); err == nil {
+		t.Fatal("revoked token was accepted")
+	}
+
+	err := uc.RevokeEnrollmentToken(t.Context(), "missing")
+	if code, _ := DomainErrorCode(err); code != ErrorCodeNotFound {
+		t.Fatalf("revoke unknown token: error = %v, want code NotFound", err)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_PreviousIdentity(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert"), identityUID: testAgentUID}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+	proof := EnrollmentProof{AgentUID: testAgentUID, Certificate: []byte("prev-cert"), Proof: []byte("proof")}
+
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), proof); err != nil {
+		t.Fatalf("RegisterCluster: %v", err)
+	}
+	if tp.identities != 1 {
+		t.Fatalf("VerifyIdentity calls = %d, want 1", tp.identities)
+	}
+
+	tp.identityErr = &DomainError{Code: ErrorCodePermissionDenied, Message: "denied"}
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), proof); err == nil {
+		t.Fatal("rejected identity was accepted")
+	}
+
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: testAgentUID, Certificate: []byte("prev-cert")})
+	var invalidInput *ErrInvalidInput
+	if !isErrInvalidInput(err, &invalidInput) {
+		t.Fatalf("missing proof: expected ErrInvalidInput, got %T: %v", err, err)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_InvalidAgentUID(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	for _, uid := range []string{"", "-leading-dash", "with/slash", strings.Repeat("a", 129)} {
+		_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: uid, Token: "id.secret"})
+		var invalidInput *ErrInvalidInput
+		if !isErrInvalidInput(err, &invalidInput) || invalidInput.Field != "agent_uid" {
+			t.Fatalf("uid %q: expected ErrInvalidInput for agent_uid, got %T: %v", uid, err, err)
+		}
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_PinnedCluster(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+	csr := []byte("csr")
+
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err != nil {
+		t.Fatalf("RegisterCluster: %v", err)
+	}
+
+	// Another agent with a valid token cannot take the cluster over,
+	// and the refused registration does not spend its token.
+	token = issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-2", "v1", csr, EnrollmentProof{AgentUID: "other-uid", Token: token})
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("conflicting UID: error = %v, want code FailedPrecondition", err)
+	}
+
+	if err := uc.RehomeCluster(t.Context(), "my-cluster", "other-uid"); err != nil {
+		t.Fatalf("RehomeCluster: %v", err)
+	}
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-2", "v1", csr, EnrollmentProof{AgentUID: "other-uid", Token: token}); err != nil {
+		t.Fatalf("RegisterCluster after re-home: %v", err)
+	}
+
+	// The previous agent is now refused, also when renewing.
+	tp.identityUID = testAgentUID
+	_, err = uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Certificate: []byte("prev-cert"), Proof: []byte("proof")})
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("previous agent: error = %v, want code FailedPrecondition", err)
+	}
+	_, err = uc.RenewCluster(t.Context(), "my-cluster", "agent-1", []byte("cert"), csr, []byte("proof"))
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("previous agent renewal: error = %v, want code FailedPrecondition", err)
+	}
+
+	pins := uc.ListClusterPins(t.Context())
+	if len(pins) != 1 || pins[0].AgentUID != "other-uid" {
+		t.Fatalf("pins = %+v, want my-cluster pinned to other-uid", pins)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_TokenForEnrolledCluster(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+	csr := []byte("csr")
+
+	token := issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err != nil {
+		t.Fatalf("RegisterCluster: %v", err)
+	}
+	tp.links = map[string]Link{"my-cluster": {User: "agent-1", Connected: true}}
+
+	// A fresh token does not re-enroll the cluster, even for the
+	// pinned agent UID, and the refused registration does not spend
+	// it.
+	token = issueEnrollmentToken(t, uc, renderer, "my-cluster")
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-2", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token})
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("token for an enrolled cluster: error = %v, want code FailedPrecondition", err)
+	}
+
+	if err := uc.RehomeCluster(t.Context(), "my-cluster", testAgentUID); err != nil {
+		t.Fatalf("RehomeCluster: %v", err)
+	}
+	if _, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-2", "v1", csr, EnrollmentProof{AgentUID: testAgentUID, Token: token}); err != nil {
+		t.Fatalf("RegisterCluster after re-home: %v", err)
+	}
+	if pins := uc.ListClusterPins(t.Context()); len(pins) != 1 || pins[0].AgentID != "agent-2" {
+		t.Fatalf("pins = %+v, want my-cluster claimed by agent-2", pins)
+	}
+}
+
+func TestLinkUseCase_RegisterCluster_IdentityUIDMismatch(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert"), identityUID: "cert-uid"}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	_, err := uc.RegisterCluster(t.Context(), "my-cluster", "agent-1", "v1", []byte("csr"), EnrollmentProof{AgentUID: testAgentUID, Certificate: []byte("prev-cert"), Proof: []byte("proof")})
+	if code, _ := DomainErrorCode(err); code != ErrorCodePermissionDenied {
+		t.Fatalf("error = %v, want code PermissionDenied", err)
+	}
+}
+
+func TestLinkUseCase_RenewCluster_Validation(t *testing.T) {
+	tp := &mockTunnelProvider{regEndpoint: "127.0.0.1:8080", regCertPEM: []byte("cert")}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	tests := []struct {
+		name    string
+		agentID string
+		cert    []byte
+		csr     []byte
+		proof   []byte
+		wantErr string
+	}{
+		{"empty agent_id", "", []byte("cert"), []byte("csr"), []byte("proof"), "agent_id"},
+		{"empty certificate", "agent", nil, []byte("csr"), []byte("proof"), "certificate"},
+		{"empty csr", "agent", []byte("cert"), nil, []byte("proof"), "csr"},
+		{"empty proof", "agent", []byte("cert"), []byte("csr"), nil, "proof"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := uc.RenewCluster(t.Context(), "valid-cluster", tt.agentID, tt.cert, tt.csr, tt.proof)
+			var invalidInput *ErrInvalidInput
+			if !isErrInvalidInput(err, &invalidInput) {
+				t.Fatalf("expected ErrInvalidInput, got %T: %v", err, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
 func TestLinkUseCase_ManifestToken_IssueAndVerify(t *testing.T) {
 	tp := &mockTunnelProvider{}
 	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
 
-	url, err := uc.IssueManifestURL(t.Context(), "test-cluster", "user@example.com", []string{"bob@example.com"})
+	opts := ManifestOptions{Profile: ManifestProfileProxyOnly, ImpersonateGroups: []string{"devs"}}
+	url, err := uc.IssueManifestURL(t.Context(), "test-cluster", "user@example.com", []string{"bob@example.com"}, opts)
 	if err != nil {
 		t.Fatalf("IssueManifestURL: %v", err)
 	}
@@ -202,6 +662,54 @@ func TestLinkUseCase_ManifestToken_IssueAndVerify(t *testing.T) {
 	if len(claims.ExtraUsers) != 1 || claims.ExtraUsers[0] != "bob@example.com" {
 		t.Errorf("extraUsers = %v, want [bob@example.com]", claims.ExtraUsers)
 	}
+	if claims.Options.Profile != ManifestProfileProxyOnly || !slices.Equal(claims.Options.ImpersonateGroups, []string{"devs"}) {
+		t.Errorf("options = %+v, want %+v", claims.Options, opts)
+	}
+}
+
+func TestLinkUseCase_UninstallToken(t *testing.T) {
+	tp := &mockTunnelProvider{}
+	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
+
+	if _, err := uc.IssueUninstallURL(t.Context(), "test-cluster"); err == nil {
+		t.Fatal("expected an error without user info")
+	}
+	user := WithUserInfo(t.Context(), UserInfo{Subject: "bob"})
+	if _, err := uc.IssueUninstallURL(user, "test-cluster"); err == nil {
+		t.Fatal("expected an error for a non-admin caller")
+	}
+
+	admin := WithUserInfo(t.Context(), UserInfo{Subject: "alice", Groups: []string{adminGroup}})
+	url, err := uc.IssueUninstallURL(admin, "test-cluster")
+	if err != nil {
+		t.Fatalf("IssueUninstallURL: %v", err)
+	}
+	parts := strings.SplitN(url, "/link/uninstall/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		t.Fatalf("unexpected URL format: %q", url)
+	}
+	uninstallToken := parts[1]
+
+	claims, err := uc.VerifyUninstallToken(t.Context(), uninstallToken)
+	if err != nil {
+		t.Fatalf("VerifyUninstallToken: %v", err)
+	}
+	if claims.Cluster != "test-cluster" || claims.Sub != "alice" {
+		t.Errorf("claims = %+v, want test-cluster issued by alice", claims)
+	}
+
+	// Neither kind of token is accepted in place of the other.
+	if _, err := uc.VerifyManifestToken(t.Context(), uninstallToken); err == nil {
+		t.Error("expected an uninstall token to be refused as a manifest token")
+	}
+	url, err = uc.IssueManifestURL(t.Context(), "test-cluster", "user@example.com", nil, ManifestOptions{})
+	if err != nil {
+		t.Fatalf("IssueManifestURL: %v", err)
+	}
+	installToken := strings.SplitN(url, "/link/manifest/", 2)[1]
+	if _, err := uc.VerifyUninstallToken(t.Context(), installToken); err == nil {
+		t.Error("expected a manifest token to be refused as an uninstall token")
+	}
 }
 
 func TestLinkUseCase_VerifyManifestToken_MalformedToken(t *testing.T) {
@@ -232,7 +740,7 @@ func TestLinkUseCase_VerifyManifestToken_TamperedSignature(t *testing.T) {
 	tp := &mockTunnelProvider{}
 	uc := newTestLinkUseCase(t, tp, &mockManifestRenderer{})
 
-	url, err := uc.IssueManifestURL(t.Context(), "test-cluster", "user@example.com", nil)
+	url, err := uc.IssueManifestURL(t.Context(), "test-cluster", "user@example.com", nil, ManifestOptions{})
 	if err != nil {
 		t.Fatalf("IssueManifestURL: %v", err)
 	}
@@ -272,7 +780,7 @@ func TestLinkUseCase_GenerateAgentManifest_Validation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := uc.GenerateAgentManifest(ctx, tt.cluster, tt.userName, nil)
+			_, err := uc.GenerateAgentManifest(ctx, tt.cluster, tt.userName, nil, ManifestOptions{})
 			if err == nil {
 				t.Fatal("expected error, got nil")
 			}
@@ -285,7 +793,7 @@ func TestLinkUseCase_GenerateAgentManifest_Success(t *testing.T) {
 	renderer := &mockManifestRenderer{result: "---\napiVersion: v1\nkind: Namespace"}
 	uc := newTestLinkUseCase(t, tp, renderer)
 
-	manifest, err := uc.GenerateAgentManifest(t.Context(), "my-cluster", "admin@example.com", nil)
+	manifest, err := uc.GenerateAgentManifest(t.Context(), "my-cluster", "admin@example.com", nil, ManifestOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -294,8 +802,146 @@ func TestLinkUseCase_GenerateAgentManifest_Success(t *testing.T) {
 	}
 }
 
+func TestLinkUseCase_GenerateAgentManifest_Profiles(t *testing.T) {
+	tp := &mockTunnelProvider{}
+	renderer := &mockManifestRenderer{}
+	uc := newTestLinkUseCase(t, tp, renderer)
+
+	tests := []struct {
+		name          string
+		opts          ManifestOptions
+		wantProfile   ManifestProfile
+		wantBootstrap bool
+		wantErr       bool
+	}{
+		{"default", ManifestOptions{}, ManifestProfileFull, true, false},
+		{"full without bootstrap", ManifestOptions{NoBootstrap: true}, ManifestProfileFull, false, false},
+		{"proxy-only", ManifestOptions{Profile: ManifestProfileProxyOnly}, ManifestProfileProxyOnly, false, false},
+		{"namespace-scoped", ManifestOptions{Profile: ManifestProfileNamespaceScoped, Namespaces: []string{"team-b", "team-a", "team-b"}}, ManifestProfileNamespaceScoped, false, false},
+		{"namespace-scoped without namespaces", ManifestOptions{Profile: ManifestProfileNamespaceScoped}, "", false, true},
+		{"namespaces without scope", ManifestOptions{Namespaces: []string{"team-a"}}, "", false, true},
+		{"unknown profile", ManifestOptions{Profile: "minimal"}, "", false, true},
+		{"bad namespace", ManifestOptions{Profile: ManifestProfileNamespaceScoped, Namespaces: []string{"Team_A"}}, "", false, true},
+		{"too many replicas", ManifestOptions{Values: AgentDeploymentValues{Replicas: 9}}, "", false, true},
+		{"bad quantity", ManifestOptions{Values: AgentDeploymentValues{Resources: AgentResources{Limits: map[string]string{"memory": "lots"}}}}, "", false, true},
+		{"bad toleration", ManifestOptions{Values: AgentDeploymentValues{Tolerations: []AgentToleration{{Operator: "Equal", Value: "x"}}}}, "", false, true},
+		{"bad proxy", ManifestOptions{Values: AgentDeploymentValues{Proxy: AgentProxy{HTTP: "socks5://proxy:1080"}}}, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := uc.GenerateAgentManifest(t.Context(), "my-cluster", "admin@example.com", nil, tt.opts)
+			if tt.wantErr {
+				var target *ErrInvalidInput
+				if !isErrInvalidInput(err, &target) {
+					t.Fatalf("expected *ErrInvalidInput, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateAgentManifest: %v", err)
+			}
+			if renderer.params.Profile != tt.wantProfile || renderer.params.Bootstrap != tt.wantBootstrap {
+				t.Errorf("profile = %q, bootstrap = %v, want %q, %v", renderer.params.Profile, renderer.params.Bootstrap, tt.wantProfile, tt.wantBootstrap)
+			}
+		})
+	}
+	if !slices.Equal(renderer.params.Namespaces, []string{"team-a", "team-b"}) {
+		t.Errorf("namespaces = %v, want [team-a team-b]", renderer.params.Namespaces)
+	}
+}
+
+func TestLinkUseCase_GenerateAgentArchive(t *testing.T) {
+	tp := &mockTunnelProvider{}
+	renderer := &mockManifestRenderer{result: "chart"}
+	uc := newTestLinkUseCase(t, tp, renderer)
+
+	archive, err := uc.GenerateAgentArchive(t.Context(), "my-cluster", "admin@example.com", nil, ManifestOptions{}, ManifestFormatHelm)
+	if err != nil {
+		t.Fatalf("GenerateAgentArchive: %v", err)
+	}
+	if string(archive) != "helm chart" {
+		t.Errorf("archive = %q, want the rendered helm chart", archive)
+	}
+	if renderer.params.Version != "v1.0.0" || renderer.params.EnrollmentToken == "" {
+		t.Errorf("params = %+v, want the target version and an enrollment token", renderer.params)
+	}
+
+	_, err = uc.GenerateAgentArchive(t.Context(), "my-cluster", "admin@example.com", nil, ManifestOptions{}, ManifestFormatYAML)
+	var target *ErrInvalidInput
+	if !isErrInvalidInput(err, &target) {
+		t.Errorf("GenerateAgentArchive(yaml) = %v, want *ErrInvalidInput", err)
+	}
+}
+
 // isErrInvalidInput checks if err is *ErrInvalidInput using the
 // standard errors.As mechanism.
 func isErrInvalidInput(err error, target **ErrInvalidInput) bool {
 	return errors.As(err, target)
 }
+
+func TestLinkUseCase_DeregisterCluster(t *testing.T) {
+	revoker := &mockRevoker{}
+	harbor := &mockHarborClient{}
+	pins := NewClusterPins()
+	var evicted []string
+	evictor := clusterEvictorFunc(func(cluster string) { evicted = append(evicted, cluster) })
+
+	uc, err := NewLinkUseCase(&mockTunnelProvider{}, revoker, testLinkConfig(), &mockManifestRenderer{}, harbor, NewEnrollmentTokens(), pins, NewClusterApprovals(false), NewAgentUpgrades("v1.0.0"), []ClusterEvictor{evictor, evictor})
+	if err != nil {
+		t.Fatalf("NewLinkUseCase: %v", err)
+	}
+	if err := pins.Claim(t.Context(), "prod", testAgentUID, "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	manifest, err := uc.DeregisterCluster(t.Context(), "prod", true)
+	if err != nil {
+		t.Fatalf("DeregisterCluster: %v", err)
+	}
+	if manifest != "uninstall prod" {
+		t.Errorf("manifest = %q, want the uninstall manifest", manifest)
+	}
+	if len(revoker.revoked) != 1 || revoker.revoked[0] != "prod" {
+		t.Errorf("revoked = %v, want [prod]", revoker.revoked)
+	}
+	if _, ok := pins.Get("prod"); ok {
+		t.Error("cluster is still pinned")
+	}
+	if len(evicted) != 2 {
+		t.Errorf("evicted = %v, want every evictor called", evicted)
+	}
+	if len(harbor.deleted) != 1 || harbor.deleted[0] != "prod" {
+		t.Errorf("harbor robots deleted = %v, want [prod]", harbor.deleted)
+	}
+}
+
+func TestLinkUseCase_DeregisterCluster_NotFound(t *testing.T) {
+	revoker := &mockRevoker{err: &ErrClusterNotFound{Cluster: "prod"}}
+	pins := NewClusterPins()
+	uc, err := NewLinkUseCase(&mockTunnelProvider{}, revoker, testLinkConfig(), &mockManifestRenderer{}, nil, NewEnrollmentTokens(), pins, NewClusterApprovals(false), NewAgentUpgrades("v1.0.0"), nil)
+	if err != nil {
+		t.Fatalf("NewLinkUseCase: %v", err)
+	}
+
+	_, err = uc.DeregisterCluster(t.Context(), "prod", false)
+	var notFound *ErrClusterNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("error = %v, want ErrClusterNotFound", err)
+	}
+
+	// A cluster that is still pinned is cleaned up even though its
+	// link is gone.
+	if err := pins.Claim(t.Context(), "prod", testAgentUID, "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	manifest, err := uc.DeregisterCluster(t.Context(), "prod", false)
+	if err != nil {
+		t.Fatalf("DeregisterCluster: %v", err)
+	}
+	if manifest != "" {
+		t.Errorf("manifest = %q, want none", manifest)
+	}
+	if _, ok := pins.Get("prod"); ok {
+		t.Error("cluster is still pinned")
+	}
+}
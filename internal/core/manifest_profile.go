@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Request headers of the GetAgentManifest RPC selecting the
+// ManifestOptions of the rendered manifest. List values are
+// comma-separated. See also ManifestValuesHeader.
+const (
+	ManifestProfileHeader           = "Otterscale-Manifest-Profile"
+	ManifestBootstrapHeader         = "Otterscale-Manifest-Bootstrap"
+	ManifestImpersonateGroupsHeader = "Otterscale-Manifest-Impersonate-Groups"
+	ManifestNamespacesHeader        = "Otterscale-Manifest-Namespaces"
+)
+
+// ManifestProfile selects the RBAC an agent manifest grants on the
+// target cluster.
+type ManifestProfile string
+
+const (
+	// ManifestProfileFull binds the requesting and extra users to
+	// cluster-admin, lets every authenticated user read nodes and
+	// storage classes, and grants the agent the permissions the
+	// bootstrap needs.
+	ManifestProfileFull ManifestProfile = "full"
+	// ManifestProfileProxyOnly only lets the agent proxy requests by
+	// impersonation. The cluster owner grants users their permissions,
+	// and the agent does not bootstrap.
+	ManifestProfileProxyOnly ManifestProfile = "proxy-only"
+	// ManifestProfileNamespaceScoped is ManifestProfileProxyOnly that
+	// additionally binds the users to the admin ClusterRole in an
+	// allow-listed set of existing namespaces.
+	ManifestProfileNamespaceScoped ManifestProfile = "namespace-scoped"
+)
+
+// ManifestOptions customizes the RBAC of an agent manifest. The zero
+// value is the full profile with bootstrap.
+type ManifestOptions struct {
+	// Profile is the RBAC profile. Empty means ManifestProfileFull.
+	Profile ManifestProfile `json:"profile,omitempty"`
+	// NoBootstrap disables the bootstrap of a full profile agent and
+	// leaves out the permissions it needs. The other profiles never
+	// bootstrap.
+	NoBootstrap bool `json:"no_bootstrap,omitempty"`
+	// ImpersonateGroups, if not empty, restricts the groups the agent
+	// may impersonate. Requests of users in other groups are refused
+	// by the target cluster.
+	ImpersonateGroups []string `json:"impersonate_groups,omitempty"`
+	// Namespaces are the namespaces in which the users are admins
+	// under ManifestProfileNamespaceScoped.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Values are the settings of the agent Deployment.
+	Values AgentDeploymentValues `json:"values,omitzero"`
+}
+
+// Validate checks the options and returns an *ErrInvalidInput on
+// failure.
+func (o ManifestOptions) Validate() error {
+	switch o.Profile {
+	case "", ManifestProfileFull, ManifestProfileProxyOnly:
+		if len(o.Namespaces) > 0 {
+			return &ErrInvalidInput{Field: "namespaces", Message: "only apply to the namespace-scoped profile"}
+		}
+	case ManifestProfileNamespaceScoped:
+		if len(o.Namespaces) == 0 {
+			return &ErrInvalidInput{Field: "namespaces", Message: "must not be empty for the namespace-scoped profile"}
+		}
+	default:
+		return &ErrInvalidInput{Field: "profile", Message: fmt.Sprintf("must be one of full, proxy-only or namespace-scoped, got %q", o.Profile)}
+	}
+	for _, ns := range o.Namespaces {
+		if err := ValidateClusterName(ns); err != nil {
+			return &ErrInvalidInput{Field: "namespaces", Message: fmt.Sprintf("must be namespace names, got %q", ns)}
+		}
+	}
+	for _, group := range o.ImpersonateGroups {
+		if group == "" {
+			return &ErrInvalidInput{Field: "impersonate_groups", Message: "must not contain empty group names"}
+		}
+	}
+	return o.Values.Validate()
+}
+
+// Bootstrap reports whether the agent bootstraps its cluster.
+func (o ManifestOptions) Bootstrap() bool {
+	return (o.Profile == "" || o.Profile == ManifestProfileFull) && !o.NoBootstrap
+}
+
+// normalized returns the options with the default profile spelled out
+// and the lists sorted and deduplicated.
+func (o ManifestOptions) normalized() ManifestOptions {
+	if o.Profile == "" {
+		o.Profile = ManifestProfileFull
+	}
+	o.ImpersonateGroups = slices.Compact(slices.Sorted(slices.Values(o.ImpersonateGroups)))
+	o.Namespaces = slices.Compact(slices.Sorted(slices.Values(o.Namespaces)))
+	return o
+}
@@ -32,6 +32,13 @@ type ManifestTokenClaims struct {
 	ExtraUsers []string `json:"extra_users,omitempty"`
 	Iat        int64    `json:"iat"`
 	Exp        int64    `json:"exp"`
+	// Options are the options of the manifest. Tokens issued before
+	// options existed decode to the zero value, the full profile.
+	Options ManifestOptions `json:"options,omitzero"`
+	// Uninstall marks tokens that serve the uninstall manifest of the
+	// cluster rather than the agent manifest. The two kinds are not
+	// interchangeable.
+	Uninstall bool `json:"uninstall,omitempty"`
 }
 
 // ManifestTokenIssuer signs and verifies HMAC-based manifest tokens.
@@ -57,17 +64,29 @@ func NewManifestTokenIssuer(hmacKey []byte) (*ManifestTokenIssuer, error) {
 }
 
 // Issue creates a signed token containing the user identity, cluster
-// name, extra users bound to cluster-admin, issued-at, and expiry
+// name, extra users, manifest options, issued-at, and expiry
 // timestamps.
-func (i *ManifestTokenIssuer) Issue(cluster, userName string, extraUsers []string) (string, error) {
-	now := i.now()
-	claims := ManifestTokenClaims{
+func (i *ManifestTokenIssuer) Issue(cluster, userName string, extraUsers []string, opts ManifestOptions) (string, error) {
+	return i.sign(ManifestTokenClaims{
 		Sub:        userName,
 		Cluster:    cluster,
 		ExtraUsers: extraUsers,
-		Iat:        now.Unix(),
-		Exp:        now.Add(manifestTokenTTL).Unix(),
-	}
+		Options:    opts,
+	})
+}
+
+// IssueUninstall creates a signed token for the uninstall manifest of
+// cluster, issued by userName.
+func (i *ManifestTokenIssuer) IssueUninstall(cluster, userName string) (string, error) {
+	return i.sign(ManifestTokenClaims{Sub: userName, Cluster: cluster, Uninstall: true})
+}
+
+// sign sets the issued-at and expiry timestamps of claims and returns
+// them as a signed token.
+func (i *ManifestTokenIssuer) sign(claims ManifestTokenClaims) (string, error) {
+	now := i.now()
+	claims.Iat = now.Unix()
+	claims.Exp = now.Add(manifestTokenTTL).Unix()
 
 	payload, err := json.Marshal(claims)
 	if err != nil {
@@ -88,7 +107,16 @@ func (i *ManifestTokenIssuer) Issue(cluster, userName string, extraUsers []strin
 // reasons are available via verifyDetailed.
 func (i *ManifestTokenIssuer) Verify(token string) (ManifestTokenClaims, error) {
 	claims, err := i.verifyDetailed(token)
-	if err != nil {
+	if err != nil || claims.Uninstall {
+		return ManifestTokenClaims{}, errInvalidToken
+	}
+	return claims, nil
+}
+
+// VerifyUninstall is Verify for tokens issued by IssueUninstall.
+func (i *ManifestTokenIssuer) VerifyUninstall(token string) (ManifestTokenClaims, error) {
+	claims, err := i.verifyDetailed(token)
+	if err != nil || !claims.Uninstall {
 		return ManifestTokenClaims{}, errInvalidToken
 	}
 	return claims, nil
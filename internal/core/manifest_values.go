@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ManifestValuesHeader is the request header of the GetAgentManifest
+// RPC carrying the JSON-encoded AgentDeploymentValues of the manifest.
+const ManifestValuesHeader = "Otterscale-Manifest-Values"
+
+// maxAgentReplicas bounds the replicas of an agent Deployment. The hub
+// serves a cluster from a bounded number of replicas.
+const maxAgentReplicas = 8
+
+// ManifestFormat is the packaging of an agent manifest.
+type ManifestFormat string
+
+const (
+	// ManifestFormatYAML is a multi-document YAML manifest for
+	// "kubectl apply -f".
+	ManifestFormatYAML ManifestFormat = "yaml"
+	// ManifestFormatHelm is a gzipped tarball of a Helm chart whose
+	// values default to the AgentDeploymentValues of the manifest.
+	ManifestFormatHelm ManifestFormat = "helm"
+	// ManifestFormatKustomize is a gzipped tarball of a Kustomize base
+	// for overlays to patch.
+	ManifestFormatKustomize ManifestFormat = "kustomize"
+)
+
+// AgentDeploymentValues are the scheduling and environment settings of
+// the agent Deployment. The zero value is a single replica with no
+// resource requirements, constraints or proxy.
+type AgentDeploymentValues struct {
+	// Replicas is the number of agent pods. Zero means one.
+	Replicas int `json:"replicas,omitempty"`
+	// Resources are the compute resources of the agent container.
+	Resources AgentResources `json:"resources,omitzero"`
+	// NodeSelector constrains the nodes the agent runs on.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations let the agent run on tainted nodes.
+	Tolerations []AgentToleration `json:"tolerations,omitempty"`
+	// PriorityClassName is the priority class of the agent pods.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Proxy is the HTTP proxy the agent reaches the hub through.
+	Proxy AgentProxy `json:"proxy,omitzero"`
+}
+
+// AgentResources are the resource requests and limits of the agent
+// container, as Kubernetes quantities keyed by resource name.
+type AgentResources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// AgentToleration is a Kubernetes toleration of the agent pods.
+type AgentToleration struct {
+	Key               string `json:"key,omitempty"`
+	Operator          string `json:"operator,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Effect            string `json:"effect,omitempty"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+// AgentProxy holds the proxy environment variables of the agent.
+type AgentProxy struct {
+	HTTP    string `json:"http,omitempty"`
+	HTTPS   string `json:"https,omitempty"`
+	NoProxy string `json:"noProxy,omitempty"`
+}
+
+// Validate checks the values and returns an *ErrInvalidInput on
+// failure.
+func (v AgentDeploymentValues) Validate() error {
+	if v.Replicas < 0 || v.Replicas > maxAgentReplicas {
+		return &ErrInvalidInput{Field: "replicas", Message: fmt.Sprintf("must be between 0 and %d", maxAgentReplicas)}
+	}
+	for _, quantities := range []map[string]string{v.Resources.Requests, v.Resources.Limits} {
+		for name, q := range quantities {
+			if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+				return &ErrInvalidInput{Field: "resources", Message: fmt.Sprintf("invalid resource name %q", name)}
+			}
+			if _, err := resource.ParseQuantity(q); err != nil {
+				return &ErrInvalidInput{Field: "resources", Message: fmt.Sprintf("invalid quantity %q of %s", q, name)}
+			}
+		}
+	}
+	for key, value := range v.NodeSelector {
+		if len(validation.IsQualifiedName(key)) > 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			return &ErrInvalidInput{Field: "nodeSelector", Message: fmt.Sprintf("invalid node label %s=%s", key, value)}
+		}
+	}
+	for _, t := range v.Tolerations {
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	if v.PriorityClassName != "" && len(validation.IsDNS1123Subdomain(v.PriorityClassName)) > 0 {
+		return &ErrInvalidInput{Field: "priorityClassName", Message: fmt.Sprintf("invalid priority class %q", v.PriorityClassName)}
+	}
+	for _, proxy := range []string{v.Proxy.HTTP, v.Proxy.HTTPS} {
+		if proxy == "" {
+			continue
+		}
+		if u, err := url.Parse(proxy); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ErrInvalidInput{Field: "proxy", Message: fmt.Sprintf("must be an http or https URL, got %q", proxy)}
+		}
+	}
+	return nil
+}
+
+// validate checks the toleration against the Kubernetes rules.
+func (t AgentToleration) validate() error {
+	if t.Key != "" && len(validation.IsQualifiedName(t.Key)) > 0 {
+		return &ErrInvalidInput{Field: "tolerations", Message: fmt.Sprintf("invalid key %q", t.Key)}
+	}
+	if len(validation.IsValidLabelValue(t.Value)) > 0 {
+		return &ErrInvalidInput{Field: "tolerations", Message: fmt.Sprintf("invalid value %q", t.Value)}
+	}
+	switch t.Operator {
+	case "", "Equal":
+		if t.Key == "" {
+			return &ErrInvalidInput{Field: "tolerations", Message: "an Equal toleration needs a key"}
+		}
+	case "Exists":
+		if t.Value != "" {
+			return &ErrInvalidInput{Field: "tolerations", Message: "an Exists toleration must not have a value"}
+		}
+	default:
+		return &ErrInvalidInput{Field: "tolerations", Message: fmt.Sprintf("operator must be Equal or Exists, got %q", t.Operator)}
+	}
+	switch t.Effect {
+	case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return &ErrInvalidInput{Field: "tolerations", Message: fmt.Sprintf("invalid effect %q", t.Effect)}
+	}
+	if t.TolerationSeconds != nil && t.Effect != "NoExecute" {
+		return &ErrInvalidInput{Field: "tolerations", Message: "tolerationSeconds only applies to NoExecute tolerations"}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AgentUIDHeader is the request header in which an agent reports its
+// UID on the Register RPC (see AgentCredentials.UID).
+const AgentUIDHeader = "Otterscale-Agent-UID"
+
+// ClusterPinStore persists the JSON-encoded cluster pins so that a
+// cluster stays bound to its agent across server restarts.
+type ClusterPinStore interface {
+	// LoadClusterPins returns the persisted pins, or nil if nothing
+	// has been stored yet.
+	LoadClusterPins(ctx context.Context) ([]byte, error)
+	// UpdateClusterPins replaces the persisted pins with the pins fn
+	// returns for the currently persisted ones, which are nil if
+	// nothing has been stored yet. If another writer changes the pins
+	// before they are replaced, fn is called again with the new pins.
+	// An error returned by fn aborts the update and is returned as is.
+	UpdateClusterPins(ctx context.Context, fn func(data []byte) ([]byte, error)) error
+}
+
+// ClusterPin binds a cluster name to the agent UID that first
+// enrolled it.
+type ClusterPin struct {
+	Cluster  string `json:"cluster"`
+	AgentUID string `json:"agentUid"`
+	// AgentID is the agent that claimed the pin, for auditing. It is
+	// empty while a pin set by a re-home is unclaimed.
+	AgentID  string    `json:"agentId,omitempty"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// ClusterPins pins each cluster to the agent UID that first enrolled
+// it, so that a second agent cannot take over a cluster's traffic by
+// registering under the same name. A pin only changes through an
+// explicit re-home. It is safe for concurrent use.
+type ClusterPins struct {
+	mu    sync.Mutex
+	pins  map[string]ClusterPin
+	store ClusterPinStore // nil for in-memory pins
+	now   func() time.Time
+}
+
+// NewClusterPins returns an empty in-memory pin set.
+func NewClusterPins() *ClusterPins {
+	return &ClusterPins{pins: make(map[string]ClusterPin), now: time.Now}
+}
+
+// LoadClusterPins loads the pins from store. Subsequent changes are
+// written back to the same store.
+func LoadClusterPins(ctx context.Context, store ClusterPinStore) (*ClusterPins, error) {
+	p := NewClusterPins()
+
+	data, err := store.LoadClusterPins(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load cluster pins: %w", err)
+	}
+	if err := p.decodeLocked(data); err != nil {
+		return nil, err
+	}
+
+	p.store = store
+	return p, nil
+}
+
+// Claim pins cluster to agentUID if it is not pinned yet, and records
+// agentID on a pin that a re-home left unclaimed. It returns an
+// ErrorCodeFailedPrecondition domain error if the cluster is pinned to
+// another agent UID.
+func (p *ClusterPins) Claim(ctx context.Context, cluster, agentUID, agentID string) error {
+	return p.update(ctx, func() error {
+		pin, ok := p.pins[cluster]
+		if !ok {
+			p.pins[cluster] = ClusterPin{Cluster: cluster, AgentUID: agentUID, AgentID: agentID, PinnedAt: p.now()}
+			return nil
+		}
+		if pin.AgentUID != agentUID {
+			return &DomainError{
+				Code: ErrorCodeFailedPrecondition,
+				Message: fmt.Sprintf("cluster %s is pinned to agent UID %s, refusing agent UID %s; an admin must re-home the cluster first",
+					cluster, pin.AgentUID, agentUID),
+			}
+		}
+		if pin.AgentID == "" {
+			pin.AgentID = agentID
+			p.pins[cluster] = pin
+		}
+		return nil
+	})
+}
+
+// Rehome pins cluster to agentUID, replacing any previous pin. The
+// new pin is unclaimed until an agent of agentUID registers, so that
+// the agent may enroll with a token. An empty agentUID removes the
+// pin, so that the next agent to enroll claims the cluster.
+func (p *ClusterPins) Rehome(ctx context.Context, cluster, agentUID string) error {
+	return p.update(ctx, func() error {
+		if agentUID == "" {
+			delete(p.pins, cluster)
+			return nil
+		}
+		p.pins[cluster] = ClusterPin{Cluster: cluster, AgentUID: agentUID, PinnedAt: p.now()}
+		return nil
+	})
+}
+
+// Get returns the pin of cluster, if any.
+func (p *ClusterPins) Get(cluster string) (ClusterPin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.pins[cluster]
+	return pin, ok
+}
+
+// List returns every pin, sorted by cluster.
+func (p *ClusterPins) List() []ClusterPin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.entriesLocked()
+}
+
+// Refresh reloads the pins from the store, so that the pins claimed
+// and re-homed on other hub replicas are listed on this one.
+// In-memory pins are left unchanged.
+func (p *ClusterPins) Refresh(ctx context.Context) error {
+	if p.store == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := p.store.LoadClusterPins(ctx)
+	if err != nil {
+		return fmt.Errorf("load cluster pins: %w", err)
+	}
+	return p.decodeLocked(data)
+}
+
+// update applies fn under the lock to the persisted pins and persists
+// the result, unless it is unchanged. fn decides on the persisted
+// pins, so that two hub replicas cannot pin a cluster to different
+// agents. The change is rolled back if fn or persisting fails.
+func (p *ClusterPins) update(ctx context.Context, fn func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev := maps.Clone(p.pins)
+	if p.store == nil {
+		if err := fn(); err != nil {
+			p.pins = prev
+			return err
+		}
+		return nil
+	}
+
+	var fnErr error
+	err := p.store.UpdateClusterPins(ctx, func(data []byte) ([]byte, error) {
+		if err := p.decodeLocked(data); err != nil {
+			return nil, err
+		}
+		current := maps.Clone(p.pins)
+		if fnErr = fn(); fnErr != nil {
+			return nil, fnErr
+		}
+		if maps.Equal(current, p.pins) {
+			return data, nil
+		}
+		return json.Marshal(p.entriesLocked())
+	})
+	if err != nil {
+		p.pins = prev
+		if fnErr != nil {
+			return fnErr
+		}
+		return fmt.Errorf("persist cluster pins: %w", err)
+	}
+	return nil
+}
+
+// decodeLocked replaces the pins with the JSON-encoded pins data. p.mu
+// must be held, unless p is not shared yet.
+func (p *ClusterPins) decodeLocked(data []byte) error {
+	var pins []ClusterPin
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &pins); err != nil {
+			return fmt.Errorf("decode cluster pins: %w", err)
+		}
+	}
+	p.pins = make(map[string]ClusterPin, len(pins))
+	for _, pin := range pins {
+		p.pins[pin.Cluster] = pin
+	}
+	return nil
+}
+
+// entriesLocked returns every pin sorted by cluster. p.mu must be
+// held.
+func (p *ClusterPins) entriesLocked() []ClusterPin {
+	ret := make([]ClusterPin, 0, len(p.pins))
+	for _, cluster := range slices.Sorted(maps.Keys(p.pins)) {
+		ret = append(ret, p.pins[cluster])
+	}
+	return ret
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// memClusterPinStore implements ClusterPinStore in memory.
+type memClusterPinStore struct {
+	data    []byte
+	saves   int
+	saveErr error
+}
+
+func (s *memClusterPinStore) LoadClusterPins(context.Context) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memClusterPinStore) UpdateClusterPins(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	data, err := fn(s.data)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, s.data) {
+		return nil
+	}
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.saves++
+	s.data = data
+	return nil
+}
+
+func TestClusterPins_Claim(t *testing.T) {
+	p := NewClusterPins()
+
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-2"); err != nil {
+		t.Fatalf("Claim by the same UID: %v", err)
+	}
+	err := p.Claim(t.Context(), "prod", "uid-b", "agent-3")
+	if code, _ := DomainErrorCode(err); code != ErrorCodeFailedPrecondition {
+		t.Fatalf("error = %v, want code FailedPrecondition", err)
+	}
+
+	pin, ok := p.Get("prod")
+	if !ok || pin.AgentUID != "uid-a" || pin.AgentID != "agent-1" {
+		t.Fatalf("pin = %+v, want uid-a claimed by agent-1", pin)
+	}
+}
+
+func TestClusterPins_Rehome(t *testing.T) {
+	p := NewClusterPins()
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	if err := p.Rehome(t.Context(), "prod", "uid-b"); err != nil {
+		t.Fatalf("Rehome: %v", err)
+	}
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-1"); err == nil {
+		t.Fatal("previous UID was accepted after re-home")
+	}
+	if err := p.Claim(t.Context(), "prod", "uid-b", "agent-2"); err != nil {
+		t.Fatalf("Claim by the new UID: %v", err)
+	}
+
+	// Unpinning lets the next agent claim the cluster.
+	if err := p.Rehome(t.Context(), "prod", ""); err != nil {
+		t.Fatalf("Rehome: %v", err)
+	}
+	if err := p.Claim(t.Context(), "prod", "uid-c", "agent-3"); err != nil {
+		t.Fatalf("Claim after unpin: %v", err)
+	}
+}
+
+func TestClusterPins_Persisted(t *testing.T) {
+	store := &memClusterPinStore{}
+	p, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	// Re-claiming an existing pin does not write to the store.
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-2"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if store.saves != 1 {
+		t.Fatalf("saves = %d, want 1", store.saves)
+	}
+
+	reloaded, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+	if err := reloaded.Claim(t.Context(), "prod", "uid-b", "agent-3"); err == nil {
+		t.Fatal("conflicting UID was accepted after reload")
+	}
+}
+
+// TestClusterPins_SharedStore verifies that hub replicas sharing a
+// store cannot pin a cluster to different agents.
+func TestClusterPins_SharedStore(t *testing.T) {
+	store := &memClusterPinStore{}
+	a, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+	b, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+
+	if err := a.Claim(t.Context(), "prod", "uid-a", "agent-1"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if err := b.Claim(t.Context(), "prod", "uid-b", "agent-2"); err == nil {
+		t.Fatal("cluster pinned on the other replica was claimed by another UID")
+	}
+	if err := b.Claim(t.Context(), "edge", "uid-c", "agent-3"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	reloaded, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+	if got := reloaded.List(); len(got) != 2 {
+		t.Errorf("stored pins = %+v, want the pins of both replicas", got)
+	}
+}
+
+func TestClusterPins_RollbackOnPersistFailure(t *testing.T) {
+	store := &memClusterPinStore{saveErr: errors.New("disk full")}
+	p, err := LoadClusterPins(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadClusterPins: %v", err)
+	}
+
+	if err := p.Claim(t.Context(), "prod", "uid-a", "agent-1"); err == nil {
+		t.Fatal("expected persist error")
+	}
+	if _, ok := p.Get("prod"); ok {
+		t.Fatal("cluster pinned although persisting the pin failed")
+	}
+}
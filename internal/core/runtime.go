@@ -2,11 +2,11 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"time"
 
-	"github.com/google/uuid"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -120,21 +120,32 @@ type RuntimeUseCase struct {
 	runtime   RuntimeRepo
 	helm      HelmRepo
 	sessions  *SessionStore
+	router    SessionRouter
 }
 
 // NewRuntimeUseCase returns a RuntimeUseCase wired to the given
 // discovery, runtime, and session store backends. The SessionStore is
 // injected rather than created internally so that callers can supply
-// alternative implementations for testing or monitoring.
-func NewRuntimeUseCase(discovery DiscoveryClient, runtime RuntimeRepo, helm HelmRepo, sessions *SessionStore) *RuntimeUseCase {
+// alternative implementations for testing or monitoring. The
+// SessionRouter routes operations on sessions owned by other hub
+// replicas.
+func NewRuntimeUseCase(discovery DiscoveryClient, runtime RuntimeRepo, helm HelmRepo, sessions *SessionStore, router SessionRouter) *RuntimeUseCase {
 	return &RuntimeUseCase{
 		discovery: discovery,
 		runtime:   runtime,
 		helm:      helm,
 		sessions:  sessions,
+		router:    router,
 	}
 }
 
+// HubReplicaID returns the ID of the hub replica that owns the
+// sessions this RuntimeUseCase starts, or "" if peer forwarding is
+// disabled.
+func (uc *RuntimeUseCase) HubReplicaID() string {
+	return uc.router.ReplicaID()
+}
+
 // StartPodLogs validates the request and opens a streaming log reader.
 func (uc *RuntimeUseCase) StartPodLogs(ctx context.Context, cluster, namespace, name string, opts PodLogOptions) (io.ReadCloser, error) {
 	if name == "" {
@@ -168,7 +179,8 @@ func (uc *RuntimeUseCase) StartExec(ctx context.Context, params *StartExecParams
 	errCh := make(chan error, 1)
 
 	session = &ExecSession{
-		ID:        uuid.New().String(),
+		ID:        uc.router.NewSessionID(),
+		Cluster:   params.Cluster,
 		Stdin:     stdinW,
 		SizeQueue: sizeQueue,
 		Cancel:    cancel,
@@ -212,11 +224,17 @@ func (uc *RuntimeUseCase) StartExec(ctx context.Context, params *StartExecParams
 	return session, stdoutR, stderrR, nil
 }
 
-// WriteExec writes stdin data to an active exec session. The write is
-// performed in a background goroutine so that the caller's context can
-// cancel a blocking pipe write during graceful shutdown or if the exec
-// session has already finished.
+// WriteExec writes stdin data to an active exec session, on the hub
+// replica that owns it.
 func (uc *RuntimeUseCase) WriteExec(ctx context.Context, sessionID string, data []byte) error {
+	return uc.routeSessionOp(ctx, SessionOp{Kind: SessionOpWriteExec, SessionID: sessionID, Data: data})
+}
+
+// writeExec writes stdin data to an exec session of this replica. The
+// write is performed in a background goroutine so that the caller's
+// context can cancel a blocking pipe write during graceful shutdown or
+// if the exec session has already finished.
+func (uc *RuntimeUseCase) writeExec(ctx context.Context, sessionID string, data []byte) error {
 	sess, ok := uc.sessions.GetExec(sessionID)
 	if !ok {
 		return &ErrSessionNotFound{Resource: "exec-session", ID: sessionID}
@@ -245,8 +263,15 @@ func (uc *RuntimeUseCase) WriteExec(ctx context.Context, sessionID string, data
 	}
 }
 
-// ResizeExec sends a terminal resize event to an active exec session.
-func (uc *RuntimeUseCase) ResizeExec(_ context.Context, sessionID string, rows, cols uint16) error {
+// ResizeExec sends a terminal resize event to an active exec session,
+// on the hub replica that owns it.
+func (uc *RuntimeUseCase) ResizeExec(ctx context.Context, sessionID string, rows, cols uint16) error {
+	return uc.routeSessionOp(ctx, SessionOp{Kind: SessionOpResizeExec, SessionID: sessionID, Rows: rows, Cols: cols})
+}
+
+// resizeExec sends a terminal resize event to an exec session of this
+// replica.
+func (uc *RuntimeUseCase) resizeExec(sessionID string, rows, cols uint16) error {
 	sess, ok := uc.sessions.GetExec(sessionID)
 	if !ok {
 		return &ErrSessionNotFound{Resource: "exec-session", ID: sessionID}
@@ -286,10 +311,11 @@ func (uc *RuntimeUseCase) StartPortForward(ctx context.Context, cluster, namespa
 	errCh := make(chan error, 1)
 
 	sess := &PortForwardSession{
-		ID:     uuid.New().String(),
-		Writer: dataInW,
-		Cancel: cancel,
-		Done:   errCh,
+		ID:      uc.router.NewSessionID(),
+		Cluster: cluster,
+		Writer:  dataInW,
+		Cancel:  cancel,
+		Done:    errCh,
 	}
 
 	// Register the session BEFORE launching the goroutine to avoid
@@ -315,10 +341,17 @@ func (uc *RuntimeUseCase) StartPortForward(ctx context.Context, cluster, namespa
 	return sess, dataOutR, nil
 }
 
-// WritePortForward writes data to an active port-forward session. The
-// write is performed in a background goroutine so that the caller's
-// context can cancel a blocking pipe write during graceful shutdown.
+// WritePortForward writes data to an active port-forward session, on
+// the hub replica that owns it.
 func (uc *RuntimeUseCase) WritePortForward(ctx context.Context, sessionID string, data []byte) error {
+	return uc.routeSessionOp(ctx, SessionOp{Kind: SessionOpWritePortForward, SessionID: sessionID, Data: data})
+}
+
+// writePortForward writes data to a port-forward session of this
+// replica. The write is performed in a background goroutine so that
+// the caller's context can cancel a blocking pipe write during
+// graceful shutdown.
+func (uc *RuntimeUseCase) writePortForward(ctx context.Context, sessionID string, data []byte) error {
 	sess, ok := uc.sessions.GetPortForward(sessionID)
 	if !ok {
 		return &ErrSessionNotFound{Resource: "portforward-session", ID: sessionID}
@@ -375,10 +408,11 @@ func (uc *RuntimeUseCase) StartVNC(ctx context.Context, cluster, namespace, name
 	done := make(chan struct{})
 
 	sess := &VNCSession{
-		ID:     uuid.New().String(),
-		Writer: dataInW,
-		Cancel: cancel,
-		Done:   done,
+		ID:      uc.router.NewSessionID(),
+		Cluster: cluster,
+		Writer:  dataInW,
+		Cancel:  cancel,
+		Done:    done,
 	}
 
 	if err := uc.sessions.PutVNC(sess); err != nil {
@@ -403,8 +437,14 @@ func (uc *RuntimeUseCase) StartVNC(ctx context.Context, cluster, namespace, name
 	return sess, dataOutR, nil
 }
 
-// WriteVNC writes data to an active VNC session.
+// WriteVNC writes data to an active VNC session, on the hub replica
+// that owns it.
 func (uc *RuntimeUseCase) WriteVNC(ctx context.Context, sessionID string, data []byte) error {
+	return uc.routeSessionOp(ctx, SessionOp{Kind: SessionOpWriteVNC, SessionID: sessionID, Data: data})
+}
+
+// writeVNC writes data to a VNC session of this replica.
+func (uc *RuntimeUseCase) writeVNC(ctx context.Context, sessionID string, data []byte) error {
 	sess, ok := uc.sessions.GetVNC(sessionID)
 	if !ok {
 		return &ErrSessionNotFound{Resource: "vnc-session", ID: sessionID}
@@ -542,3 +582,29 @@ func (uc *RuntimeUseCase) ShowChart(ctx context.Context, repoURL, chartName, ver
 	}
 	return uc.helm.ShowChart(ctx, repoURL, chartName, version)
 }
+
+// routeSessionOp applies op on the hub replica that owns its session.
+func (uc *RuntimeUseCase) routeSessionOp(ctx context.Context, op SessionOp) error {
+	if replica, remote := uc.router.SessionOwner(op.SessionID); remote {
+		return uc.router.ForwardSessionOp(ctx, replica, op)
+	}
+	return uc.ApplySessionOp(ctx, op)
+}
+
+// ApplySessionOp applies op on a session of this hub replica. It is
+// called for the operations other replicas forward, and never forwards
+// them again.
+func (uc *RuntimeUseCase) ApplySessionOp(ctx context.Context, op SessionOp) error {
+	switch op.Kind {
+	case SessionOpWriteExec:
+		return uc.writeExec(ctx, op.SessionID, op.Data)
+	case SessionOpResizeExec:
+		return uc.resizeExec(op.SessionID, op.Rows, op.Cols)
+	case SessionOpWritePortForward:
+		return uc.writePortForward(ctx, op.SessionID, op.Data)
+	case SessionOpWriteVNC:
+		return uc.writeVNC(ctx, op.SessionID, op.Data)
+	default:
+		return &ErrInvalidInput{Field: "kind", Message: fmt.Sprintf("unknown session operation %q", op.Kind)}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"testing"
 
+	"github.com/google/uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
@@ -87,8 +88,19 @@ func (m *mockHelmRepoForRuntime) ShowChart(context.Context, string, string, stri
 	return nil, nil, nil
 }
 
+// localSessionRouter implements SessionRouter for a single hub
+// replica.
+type localSessionRouter struct{}
+
+func (localSessionRouter) ReplicaID() string                      { return "" }
+func (localSessionRouter) NewSessionID() string                   { return uuid.NewString() }
+func (localSessionRouter) SessionOwner(string) (HubReplica, bool) { return HubReplica{}, false }
+func (localSessionRouter) ForwardSessionOp(context.Context, HubReplica, SessionOp) error {
+	return errors.New("unexpected forward")
+}
+
 func newTestRuntimeUseCase(discovery DiscoveryClient, runtime RuntimeRepo) *RuntimeUseCase {
-	return NewRuntimeUseCase(discovery, runtime, &mockHelmRepoForRuntime{}, NewSessionStore())
+	return NewRuntimeUseCase(discovery, runtime, &mockHelmRepoForRuntime{}, NewSessionStore(), localSessionRouter{})
 }
 
 func TestRuntimeUseCase_SubResourceAction_Validation(t *testing.T) {
@@ -239,3 +251,67 @@ func TestRuntimeUseCase_SubResourceAction_LookupError(t *testing.T) {
 		t.Fatal("expected error, got nil")
 	}
 }
+
+// remoteSessionRouter implements SessionRouter, reporting every
+// session as owned by replica and recording the forwarded operations.
+type remoteSessionRouter struct {
+	localSessionRouter
+	replica   HubReplica
+	forwarded []SessionOp
+}
+
+func (r *remoteSessionRouter) SessionOwner(string) (HubReplica, bool) { return r.replica, true }
+
+func (r *remoteSessionRouter) ForwardSessionOp(_ context.Context, replica HubReplica, op SessionOp) error {
+	if replica != r.replica {
+		return errors.New("forwarded to the wrong replica")
+	}
+	r.forwarded = append(r.forwarded, op)
+	return nil
+}
+
+func TestRuntimeUseCase_ForwardsRemoteSessionOps(t *testing.T) {
+	router := &remoteSessionRouter{replica: HubReplica{ID: "hub-b", PeerURL: "https://hub-b:8301"}}
+	uc := NewRuntimeUseCase(&mockDiscoveryForRuntime{}, &mockRuntimeRepo{}, &mockHelmRepoForRuntime{}, NewSessionStore(), router)
+
+	if err := uc.WriteExec(t.Context(), "s1", []byte("ls\n")); err != nil {
+		t.Fatalf("WriteExec: %v", err)
+	}
+	if err := uc.ResizeExec(t.Context(), "s1", 24, 80); err != nil {
+		t.Fatalf("ResizeExec: %v", err)
+	}
+	if err := uc.WritePortForward(t.Context(), "s2", []byte("GET")); err != nil {
+		t.Fatalf("WritePortForward: %v", err)
+	}
+	if err := uc.WriteVNC(t.Context(), "s3", []byte{1}); err != nil {
+		t.Fatalf("WriteVNC: %v", err)
+	}
+
+	want := []SessionOp{
+		{Kind: SessionOpWriteExec, SessionID: "s1", Data: []byte("ls\n")},
+		{Kind: SessionOpResizeExec, SessionID: "s1", Rows: 24, Cols: 80},
+		{Kind: SessionOpWritePortForward, SessionID: "s2", Data: []byte("GET")},
+		{Kind: SessionOpWriteVNC, SessionID: "s3", Data: []byte{1}},
+	}
+	if len(router.forwarded) != len(want) {
+		t.Fatalf("forwarded %d operations, want %d", len(router.forwarded), len(want))
+	}
+	for i, op := range router.forwarded {
+		if op.Kind != want[i].Kind || op.SessionID != want[i].SessionID || string(op.Data) != string(want[i].Data) || op.Rows != want[i].Rows || op.Cols != want[i].Cols {
+			t.Errorf("forwarded[%d] = %+v, want %+v", i, op, want[i])
+		}
+	}
+}
+
+func TestRuntimeUseCase_ApplySessionOp_Local(t *testing.T) {
+	uc := newTestRuntimeUseCase(&mockDiscoveryForRuntime{}, &mockRuntimeRepo{})
+
+	var notFound *ErrSessionNotFound
+	if err := uc.ApplySessionOp(t.Context(), SessionOp{Kind: SessionOpWriteExec, SessionID: "missing"}); !errors.As(err, &notFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+	var invalid *ErrInvalidInput
+	if err := uc.ApplySessionOp(t.Context(), SessionOp{Kind: "bogus", SessionID: "missing"}); !errors.As(err, &invalid) {
+		t.Errorf("expected ErrInvalidInput, got %v", err)
+	}
+}
@@ -97,6 +97,8 @@ func (q *TerminalSizeQueue) Close() {
 type ExecSession struct {
 	// ID is the unique session identifier.
 	ID string
+	// Cluster is the cluster the session runs against.
+	Cluster string
 	// Stdin is the writer side of the stdin pipe. WriteTTY writes here.
 	Stdin io.WriteCloser
 	// SizeQueue receives terminal resize events from ResizeTTY.
@@ -111,6 +113,8 @@ type ExecSession struct {
 type PortForwardSession struct {
 	// ID is the unique session identifier.
 	ID string
+	// Cluster is the cluster the session runs against.
+	Cluster string
 	// Writer is the writer side of the data pipe. WritePortForward writes here.
 	Writer io.WriteCloser
 	// Cancel stops the port-forward session.
@@ -123,6 +127,8 @@ type PortForwardSession struct {
 type VNCSession struct {
 	// ID is the unique session identifier.
 	ID string
+	// Cluster is the cluster the session runs against.
+	Cluster string
 	// Writer is the writer side of the data pipe. WriteVNC writes here.
 	Writer io.WriteCloser
 	// Cancel stops the VNC session.
@@ -152,6 +158,7 @@ const maxPortForwardSessions = 100
 const maxVNCSessions = 100
 
 // SessionStore manages active exec, port-forward, and VNC sessions.
+// It implements ClusterEvictor.
 type SessionStore struct {
 	mu       sync.RWMutex
 	execSess map[string]*ExecSession
@@ -159,6 +166,9 @@ type SessionStore struct {
 	vncSess  map[string]*VNCSession
 }
 
+// Verify at compile time that *SessionStore satisfies ClusterEvictor.
+var _ ClusterEvictor = (*SessionStore)(nil)
+
 // NewSessionStore returns an initialized SessionStore.
 func NewSessionStore() *SessionStore {
 	return &SessionStore{
@@ -327,24 +337,69 @@ func (s *SessionStore) ReapStaleSessions() int {
 	s.mu.Unlock()
 
 	// Phase 2: cancel and close resources outside the lock.
-	for _, sess := range staleExec {
+	closeSessions(staleExec, stalePF, staleVNC)
+
+	return len(staleExec) + len(stalePF) + len(staleVNC)
+}
+
+// EvictCluster removes and terminates every session of a deregistered
+// cluster. Like ReapStaleSessions, it cancels and closes the sessions
+// only after releasing the lock.
+func (s *SessionStore) EvictCluster(cluster string) {
+	s.mu.Lock()
+
+	var execs []*ExecSession
+	for id, sess := range s.execSess {
+		if sess.Cluster == cluster {
+			execs = append(execs, sess)
+			delete(s.execSess, id)
+		}
+	}
+
+	var pfs []*PortForwardSession
+	for id, sess := range s.pfSess {
+		if sess.Cluster == cluster {
+			pfs = append(pfs, sess)
+			delete(s.pfSess, id)
+		}
+	}
+
+	var vncs []*VNCSession
+	for id, sess := range s.vncSess {
+		if sess.Cluster == cluster {
+			vncs = append(vncs, sess)
+			delete(s.vncSess, id)
+		}
+	}
+
+	s.mu.Unlock()
+
+	closeSessions(execs, pfs, vncs)
+
+	if n := len(execs) + len(pfs) + len(vncs); n > 0 {
+		slog.Info("terminated sessions of deregistered cluster", "cluster", cluster, "count", n)
+	}
+}
+
+// closeSessions cancels the given sessions and closes their input
+// pipes. It must be called without holding the store lock.
+func closeSessions(execs []*ExecSession, pfs []*PortForwardSession, vncs []*VNCSession) {
+	for _, sess := range execs {
 		sess.Cancel()
 		if err := sess.Stdin.Close(); err != nil {
 			slog.Warn("failed to close exec stdin", "session", sess.ID, "error", err)
 		}
 	}
-	for _, sess := range stalePF {
+	for _, sess := range pfs {
 		sess.Cancel()
 		if err := sess.Writer.Close(); err != nil {
 			slog.Warn("failed to close port-forward writer", "session", sess.ID, "error", err)
 		}
 	}
-	for _, sess := range staleVNC {
+	for _, sess := range vncs {
 		sess.Cancel()
 		if err := sess.Writer.Close(); err != nil {
 			slog.Warn("failed to close VNC writer", "session", sess.ID, "error", err)
 		}
 	}
-
-	return len(staleExec) + len(stalePF) + len(staleVNC)
 }
@@ -206,3 +206,69 @@ type nopCloser struct{}
 
 func (n *nopCloser) Write(p []byte) (int, error) { return len(p), nil }
 func (n *nopCloser) Close() error                { return nil }
+
+func TestSessionStore_EvictCluster(t *testing.T) {
+	store := NewSessionStore()
+
+	canceled := map[string]bool{}
+	cancel := func(id string) func() {
+		return func() { canceled[id] = true }
+	}
+
+	if err := store.PutExec(&ExecSession{
+		ID:      "prod-exec",
+		Cluster: "prod",
+		Done:    make(chan error, 1),
+		Cancel:  cancel("prod-exec"),
+		Stdin:   &nopCloser{},
+	}); err != nil {
+		t.Fatalf("PutExec: %v", err)
+	}
+	if err := store.PutPortForward(&PortForwardSession{
+		ID:      "prod-pf",
+		Cluster: "prod",
+		Done:    make(chan error, 1),
+		Cancel:  cancel("prod-pf"),
+		Writer:  &nopCloser{},
+	}); err != nil {
+		t.Fatalf("PutPortForward: %v", err)
+	}
+	if err := store.PutVNC(&VNCSession{
+		ID:      "prod-vnc",
+		Cluster: "prod",
+		Done:    make(chan struct{}),
+		Cancel:  cancel("prod-vnc"),
+		Writer:  &nopCloser{},
+	}); err != nil {
+		t.Fatalf("PutVNC: %v", err)
+	}
+	if err := store.PutExec(&ExecSession{
+		ID:      "dev-exec",
+		Cluster: "dev",
+		Done:    make(chan error, 1),
+		Cancel:  cancel("dev-exec"),
+		Stdin:   &nopCloser{},
+	}); err != nil {
+		t.Fatalf("PutExec: %v", err)
+	}
+
+	store.EvictCluster("prod")
+
+	for _, id := range []string{"prod-exec", "prod-pf", "prod-vnc"} {
+		if !canceled[id] {
+			t.Errorf("session %s was not canceled", id)
+		}
+	}
+	if _, ok := store.GetExec("prod-exec"); ok {
+		t.Error("prod exec session should have been evicted")
+	}
+	if _, ok := store.GetPortForward("prod-pf"); ok {
+		t.Error("prod port-forward session should have been evicted")
+	}
+	if _, ok := store.GetVNC("prod-vnc"); ok {
+		t.Error("prod VNC session should have been evicted")
+	}
+	if _, ok := store.GetExec("dev-exec"); !ok || canceled["dev-exec"] {
+		t.Error("dev exec session should be untouched")
+	}
+}
@@ -0,0 +1,574 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// UpgradePolicyStore persists the JSON-encoded agent upgrade policy,
+// together with the upgrades in flight, so that version targets, waves,
+// a paused rollout and the concurrency limit survive server restarts
+// and apply across hub replicas sharing the store.
+type UpgradePolicyStore interface {
+	// LoadUpgradePolicy returns the persisted policy, or nil if
+	// nothing has been stored yet.
+	LoadUpgradePolicy(ctx context.Context) ([]byte, error)
+	// UpdateUpgradePolicy replaces the persisted policy with the policy
+	// fn returns for the currently persisted one, which is nil if
+	// nothing has been stored yet. If another writer changes the
+	// policy before it is replaced, fn is called again with the new
+	// policy. An error returned by fn aborts the update and is returned
+	// as is.
+	UpdateUpgradePolicy(ctx context.Context, fn func(data []byte) ([]byte, error)) error
+}
+
+// upgradeTimeout bounds the time an agent has to register with its
+// target version after it was told to upgrade. An upgrade that takes
+// longer is considered failed and pauses the rollout.
+const upgradeTimeout = 15 * time.Minute
+
+// UpgradePolicy decides which agent version each cluster should run
+// and how fast the fleet moves to it.
+type UpgradePolicy struct {
+	// Version is the target of clusters no entry of Targets matches.
+	// Empty means the hub's own version.
+	Version string `json:"version,omitempty"`
+	// Targets pin clusters to other versions. A target naming the
+	// cluster takes precedence over selector targets, of which the
+	// first matching one applies.
+	Targets []UpgradeTarget `json:"targets,omitempty"`
+	// Waves order the rollout. A cluster belongs to the first wave
+	// whose selector matches its labels, or to an implicit last wave
+	// if none does, and only upgrades once every cluster of the
+	// earlier waves runs its target version.
+	Waves []UpgradeWave `json:"waves,omitempty"`
+	// MaxConcurrent bounds the number of upgrades in flight across
+	// the fleet. Zero means no bound.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// Paused stops new upgrades from starting. Upgrades in flight
+	// complete.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// UpgradeTarget pins the clusters named by Cluster, or matched by the
+// label selector Selector, to Version. Exactly one of Cluster and
+// Selector is set.
+type UpgradeTarget struct {
+	Cluster  string `json:"cluster,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	Version  string `json:"version"`
+}
+
+// UpgradeWave is a stage of the rollout, such as a set of canary
+// clusters, selected by a label selector.
+type UpgradeWave struct {
+	Name     string `json:"name"`
+	Selector string `json:"selector"`
+}
+
+// Validate checks the policy and returns an *ErrInvalidInput on
+// failure.
+func (p UpgradePolicy) Validate() error {
+	if p.Version != "" {
+		if _, err := ParseAgentVersion(p.Version); err != nil {
+			return err
+		}
+	}
+	for i, t := range p.Targets {
+		field := fmt.Sprintf("targets[%d]", i)
+		if (t.Cluster == "") == (t.Selector == "") {
+			return &ErrInvalidInput{Field: field, Message: "must set exactly one of cluster and selector"}
+		}
+		if t.Cluster != "" {
+			if err := ValidateClusterName(t.Cluster); err != nil {
+				return err
+			}
+		}
+		if t.Selector != "" {
+			if _, err := labels.Parse(t.Selector); err != nil {
+				return &ErrInvalidInput{Field: field + ".selector", Message: err.Error()}
+			}
+		}
+		if _, err := ParseAgentVersion(t.Version); err != nil {
+			return &ErrInvalidInput{Field: field + ".version", Message: fmt.Sprintf("must be a semantic version, got %q", t.Version)}
+		}
+	}
+	names := make(map[string]bool, len(p.Waves))
+	for i, w := range p.Waves {
+		field := fmt.Sprintf("waves[%d]", i)
+		if w.Name == "" {
+			return &ErrInvalidInput{Field: field + ".name", Message: "must not be empty"}
+		}
+		if names[w.Name] {
+			return &ErrInvalidInput{Field: field + ".name", Message: fmt.Sprintf("duplicate wave %q", w.Name)}
+		}
+		names[w.Name] = true
+		if w.Selector == "" {
+			return &ErrInvalidInput{Field: field + ".selector", Message: "must not be empty"}
+		}
+		if _, err := labels.Parse(w.Selector); err != nil {
+			return &ErrInvalidInput{Field: field + ".selector", Message: err.Error()}
+		}
+	}
+	if p.MaxConcurrent < 0 {
+		return &ErrInvalidInput{Field: "maxConcurrent", Message: "must not be negative"}
+	}
+	return nil
+}
+
+// AgentUpgradeState is the upgrade state of a cluster's agent.
+type AgentUpgradeState string
+
+const (
+	// AgentUpgradeUpToDate is an agent running its target version.
+	AgentUpgradeUpToDate AgentUpgradeState = "UpToDate"
+	// AgentUpgradeWaiting is an agent whose upgrade has not started
+	// yet, because the rollout is paused, an earlier wave is not
+	// done or too many upgrades are in flight.
+	AgentUpgradeWaiting AgentUpgradeState = "Waiting"
+	// AgentUpgradeBlocked is an agent whose target version is a
+	// downgrade or a major version jump, which agents refuse.
+	AgentUpgradeBlocked AgentUpgradeState = "Blocked"
+	// AgentUpgradeInProgress is an agent that was told to upgrade and
+	// has not registered with its target version yet.
+	AgentUpgradeInProgress AgentUpgradeState = "InProgress"
+	// AgentUpgradeRollingBack is an agent whose upgrade was aborted
+	// and that is told to return to its previous version.
+	AgentUpgradeRollingBack AgentUpgradeState = "RollingBack"
+)
+
+// AgentUpgrade is the upgrade state of a cluster's agent.
+type AgentUpgrade struct {
+	Cluster string
+	// Wave is the name of the cluster's wave, or empty for the
+	// implicit last wave.
+	Wave           string
+	State          AgentUpgradeState
+	CurrentVersion string
+	TargetVersion  string
+	// StartedAt is set for upgrades in progress and roll-backs.
+	StartedAt time.Time
+}
+
+// agentUpgrade is an upgrade handed out to an agent, from the version
+// it ran to the version it was told to run.
+type agentUpgrade struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// upgradeState is the persisted form of AgentUpgrades. The policy is
+// embedded, so that a policy stored on its own decodes as a state
+// without upgrades in flight.
+type upgradeState struct {
+	UpgradePolicy
+	InFlight map[string]agentUpgrade `json:"inFlight,omitempty"`
+	Rollback map[string]agentUpgrade `json:"rollback,omitempty"`
+}
+
+// AgentUpgrades orchestrates agent self-updates across the fleet. It
+// tells each registering agent the version it should run: its target
+// version once the policy lets its upgrade start, and its current
+// version until then. Upgrades in flight and roll-backs are persisted
+// with the policy, so that MaxConcurrent and Abort apply across the
+// hub replicas sharing the store; an in-memory orchestrator tracks
+// them for itself only. Agents held back pick up their target at their
+// next registration or certificate renewal. It is safe for concurrent
+// use.
+type AgentUpgrades struct {
+	mu       sync.Mutex
+	hub      Version
+	policy   UpgradePolicy
+	inFlight map[string]agentUpgrade // by cluster
+	rollback map[string]agentUpgrade // aborted upgrades, by cluster
+	store    UpgradePolicyStore      // nil for an in-memory policy
+	now      func() time.Time
+}
+
+// NewAgentUpgrades returns an in-memory orchestrator with an empty
+// policy, which moves every agent to the hub version hub at once.
+func NewAgentUpgrades(hub Version) *AgentUpgrades {
+	return &AgentUpgrades{
+		hub:      hub,
+		inFlight: make(map[string]agentUpgrade),
+		rollback: make(map[string]agentUpgrade),
+		now:      time.Now,
+	}
+}
+
+// LoadAgentUpgrades loads the policy from store. Subsequent changes are
+// written back to the same store.
+func LoadAgentUpgrades(ctx context.Context, store UpgradePolicyStore, hub Version) (*AgentUpgrades, error) {
+	u := NewAgentUpgrades(hub)
+
+	data, err := store.LoadUpgradePolicy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load upgrade policy: %w", err)
+	}
+	if err := u.decodeLocked(data); err != nil {
+		return nil, err
+	}
+
+	u.store = store
+	return u, nil
+}
+
+// Policy returns the current policy.
+func (u *AgentUpgrades) Policy() UpgradePolicy {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return clonePolicy(u.policy)
+}
+
+// SetPolicy validates and replaces the policy.
+func (u *AgentUpgrades) SetPolicy(ctx context.Context, policy UpgradePolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	return u.update(ctx, func() {
+		u.policy = clonePolicy(policy)
+	})
+}
+
+// SetPaused pauses or resumes the rollout.
+func (u *AgentUpgrades) SetPaused(ctx context.Context, paused bool) error {
+	return u.update(ctx, func() {
+		u.policy.Paused = paused
+	})
+}
+
+// Abort pauses the rollout and rolls the upgrades in flight back: the
+// affected agents are told to return to the version they ran. It
+// returns the affected clusters, sorted.
+func (u *AgentUpgrades) Abort(ctx context.Context) ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var clusters []string
+	err := u.updateLocked(ctx, func() {
+		u.policy.Paused = true
+		clusters = slices.Sorted(maps.Keys(u.inFlight))
+		for _, cluster := range clusters {
+			u.rollback[cluster] = u.inFlight[cluster]
+			delete(u.inFlight, cluster)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// Desired returns the agent version cluster should run, given the
+// version current its agent reports and the links of the fleet. It
+// starts the cluster's upgrade if the policy allows it. A change of
+// the upgrades in flight is decided again on the persisted state, so
+// that concurrent registrations on other hub replicas are accounted
+// for; if it cannot be persisted, the agent is told to keep current.
+func (u *AgentUpgrades) Desired(ctx context.Context, cluster, current string, links map[string]Link) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := u.now()
+	u.expireLocked(ctx, now)
+
+	inFlight, rollback := maps.Clone(u.inFlight), maps.Clone(u.rollback)
+	version, changed := u.desiredLocked(cluster, current, links, now)
+	if changed && u.store != nil {
+		u.inFlight, u.rollback = inFlight, rollback
+		err := u.updateLocked(ctx, func() {
+			version, _ = u.desiredLocked(cluster, current, links, now)
+		})
+		if err != nil {
+			slog.Warn("failed to persist agent upgrade", "cluster", cluster, "error", err)
+			return current
+		}
+	}
+	if f, ok := u.inFlight[cluster]; ok && changed && f.StartedAt.Equal(now) {
+		slog.Info("agent upgrade started", "cluster", cluster, "from", f.From, "to", f.To)
+	}
+	return version
+}
+
+// desiredLocked implements Desired on the state at hand. It reports
+// whether it changed the upgrades in flight or the roll-backs. u.mu
+// must be held.
+func (u *AgentUpgrades) desiredLocked(cluster, current string, links map[string]Link, now time.Time) (string, bool) {
+	if r, ok := u.rollback[cluster]; ok {
+		if current == r.From {
+			delete(u.rollback, cluster)
+			return r.From, true
+		}
+		return r.From, false
+	}
+
+	target := u.targetLocked(cluster, links[cluster].Labels)
+	f, inFlight := u.inFlight[cluster]
+	if current == "" || current == target {
+		delete(u.inFlight, cluster)
+		return target, inFlight
+	}
+	if inFlight && f.To == target {
+		return target, false
+	}
+	delete(u.inFlight, cluster)
+
+	if CheckAgentUpgrade(current, target) != nil {
+		return current, inFlight
+	}
+	if u.policy.Paused || !u.waveDoneLocked(cluster, links) {
+		return current, inFlight
+	}
+	if u.policy.MaxConcurrent > 0 && len(u.inFlight) >= u.policy.MaxConcurrent {
+		return current, inFlight
+	}
+
+	u.inFlight[cluster] = agentUpgrade{From: current, To: target, StartedAt: now}
+	return target, true
+}
+
+// Status returns the upgrade state of every cluster in links, sorted
+// by cluster.
+func (u *AgentUpgrades) Status(ctx context.Context, links map[string]Link) []AgentUpgrade {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.expireLocked(ctx, u.now())
+
+	ret := make([]AgentUpgrade, 0, len(links))
+	for _, cluster := range slices.Sorted(maps.Keys(links)) {
+		link := links[cluster]
+		status := AgentUpgrade{
+			Cluster:        cluster,
+			CurrentVersion: link.AgentVersion,
+			TargetVersion:  u.targetLocked(cluster, link.Labels),
+		}
+		if i := u.waveLocked(link.Labels); i < len(u.policy.Waves) {
+			status.Wave = u.policy.Waves[i].Name
+		}
+		if r, ok := u.rollback[cluster]; ok {
+			status.State = AgentUpgradeRollingBack
+			status.TargetVersion = r.From
+			status.StartedAt = r.StartedAt
+		} else if f, ok := u.inFlight[cluster]; ok && f.To == status.TargetVersion {
+			status.State = AgentUpgradeInProgress
+			status.StartedAt = f.StartedAt
+		} else if status.CurrentVersion == status.TargetVersion {
+			status.State = AgentUpgradeUpToDate
+		} else if status.CurrentVersion != "" && CheckAgentUpgrade(status.CurrentVersion, status.TargetVersion) != nil {
+			status.State = AgentUpgradeBlocked
+		} else {
+			status.State = AgentUpgradeWaiting
+		}
+		ret = append(ret, status)
+	}
+	return ret
+}
+
+// Target returns the version the policy targets for a cluster with
+// the given labels.
+func (u *AgentUpgrades) Target(cluster string, set map[string]string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.targetLocked(cluster, set)
+}
+
+// Forget drops the upgrade state of cluster, e.g. after it was
+// deregistered, so that it no longer counts against MaxConcurrent.
+func (u *AgentUpgrades) Forget(ctx context.Context, cluster string) error {
+	return u.update(ctx, func() {
+		delete(u.inFlight, cluster)
+		delete(u.rollback, cluster)
+	})
+}
+
+// targetLocked returns the target version of cluster. u.mu must be
+// held.
+func (u *AgentUpgrades) targetLocked(cluster string, set map[string]string) string {
+	for _, t := range u.policy.Targets {
+		if t.Cluster == cluster {
+			return t.Version
+		}
+	}
+	for _, t := range u.policy.Targets {
+		if t.Selector != "" && matchesSelector(t.Selector, set) {
+			return t.Version
+		}
+	}
+	if u.policy.Version != "" {
+		return u.policy.Version
+	}
+	return string(u.hub)
+}
+
+// waveLocked returns the index of the wave of a cluster with the given
+// labels, or len(u.policy.Waves) for the implicit last wave. u.mu must
+// be held.
+func (u *AgentUpgrades) waveLocked(set map[string]string) int {
+	for i, w := range u.policy.Waves {
+		if matchesSelector(w.Selector, set) {
+			return i
+		}
+	}
+	return len(u.policy.Waves)
+}
+
+// waveDoneLocked reports whether every cluster of the waves before the
+// wave of cluster is done: no upgrade is in flight or rolling back,
+// and every connected agent runs its target version. u.mu must be
+// held.
+func (u *AgentUpgrades) waveDoneLocked(cluster string, links map[string]Link) bool {
+	wave := u.waveLocked(links[cluster].Labels)
+	if wave == 0 {
+		return true
+	}
+	for name, link := range links {
+		if name == cluster || u.waveLocked(link.Labels) >= wave {
+			continue
+		}
+		if _, ok := u.inFlight[name]; ok {
+			return false
+		}
+		if _, ok := u.rollback[name]; ok {
+			return false
+		}
+		if link.Connected && link.AgentVersion != u.targetLocked(name, link.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// expireLocked drops the upgrades in flight that did not complete
+// within upgradeTimeout and pauses the rollout, so that a broken
+// version does not spread further. u.mu must be held.
+func (u *AgentUpgrades) expireLocked(ctx context.Context, now time.Time) {
+	expired := func(f agentUpgrade) bool { return now.Sub(f.StartedAt) >= upgradeTimeout }
+	if !slices.ContainsFunc(slices.Collect(maps.Values(u.inFlight)), expired) &&
+		!slices.ContainsFunc(slices.Collect(maps.Values(u.rollback)), expired) {
+		return
+	}
+
+	err := u.updateLocked(ctx, func() {
+		for cluster, f := range u.inFlight {
+			if !expired(f) {
+				continue
+			}
+			slog.Warn("agent upgrade did not complete, pausing rollout",
+				"cluster", cluster, "from", f.From, "to", f.To, "started_at", f.StartedAt)
+			delete(u.inFlight, cluster)
+			u.policy.Paused = true
+		}
+		for cluster, r := range u.rollback {
+			if expired(r) {
+				slog.Warn("agent roll-back did not complete", "cluster", cluster, "to", r.From)
+				delete(u.rollback, cluster)
+			}
+		}
+	})
+	if err != nil {
+		slog.Warn("failed to persist expired agent upgrades", "error", err)
+	}
+}
+
+// Refresh reloads the policy from the store, so that the changes made
+// on other hub replicas, such as a paused rollout or the upgrades they
+// started, take effect on this one. An in-memory policy is left
+// unchanged.
+func (u *AgentUpgrades) Refresh(ctx context.Context) error {
+	if u.store == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	data, err := u.store.LoadUpgradePolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("load upgrade policy: %w", err)
+	}
+	return u.decodeLocked(data)
+}
+
+// update applies fn to the policy and the upgrades in flight under the
+// lock and persists the result. The change is rolled back if persisting fails.
+func (u *AgentUpgrades) update(ctx context.Context, fn func()) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.updateLocked(ctx, fn)
+}
+
+// updateLocked applies fn to the persisted state and persists the
+// result, so that the changes other hub replicas made concurrently,
+// such as a pause or an upgrade started, are kept. The change is
+// rolled back if persisting fails. u.mu must be held.
+func (u *AgentUpgrades) updateLocked(ctx context.Context, fn func()) error {
+	if u.store == nil {
+		fn()
+		return nil
+	}
+
+	prev := upgradeState{
+		UpgradePolicy: clonePolicy(u.policy),
+		InFlight:      maps.Clone(u.inFlight),
+		Rollback:      maps.Clone(u.rollback),
+	}
+	err := u.store.UpdateUpgradePolicy(ctx, func(data []byte) ([]byte, error) {
+		if err := u.decodeLocked(data); err != nil {
+			return nil, err
+		}
+		fn()
+		return json.Marshal(upgradeState{UpgradePolicy: u.policy, InFlight: u.inFlight, Rollback: u.rollback})
+	})
+	if err != nil {
+		u.policy, u.inFlight, u.rollback = prev.UpgradePolicy, prev.InFlight, prev.Rollback
+		return fmt.Errorf("persist upgrade policy: %w", err)
+	}
+	return nil
+}
+
+// decodeLocked replaces the policy and the upgrades in flight with the
+// JSON-encoded state data. u.mu must be held, unless u is not shared
+// yet.
+func (u *AgentUpgrades) decodeLocked(data []byte) error {
+	var state upgradeState
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("decode upgrade policy: %w", err)
+		}
+	}
+	if state.InFlight == nil {
+		state.InFlight = make(map[string]agentUpgrade)
+	}
+	if state.Rollback == nil {
+		state.Rollback = make(map[string]agentUpgrade)
+	}
+	u.policy, u.inFlight, u.rollback = state.UpgradePolicy, state.InFlight, state.Rollback
+	return nil
+}
+
+// clonePolicy returns a deep copy of p.
+func clonePolicy(p UpgradePolicy) UpgradePolicy {
+	p.Targets = slices.Clone(p.Targets)
+	p.Waves = slices.Clone(p.Waves)
+	return p
+}
+
+// matchesSelector reports whether set matches the label selector. The
+// selector has been validated, so a parse error means no match.
+func matchesSelector(selector string, set map[string]string) bool {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return false
+	}
+	return sel.Matches(labels.Set(set))
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memUpgradePolicyStore implements UpgradePolicyStore in memory.
+type memUpgradePolicyStore struct {
+	data []byte
+}
+
+func (s *memUpgradePolicyStore) LoadUpgradePolicy(context.Context) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memUpgradePolicyStore) UpdateUpgradePolicy(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	data, err := fn(s.data)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// upgradeLinks returns connected links running version, labeled with
+// the given labels by cluster.
+func upgradeLinks(version string, labeled map[string]map[string]string) map[string]Link {
+	links := make(map[string]Link, len(labeled))
+	for cluster, set := range labeled {
+		links[cluster] = Link{Connected: true, AgentVersion: version, Labels: set}
+	}
+	return links
+}
+
+func TestAgentUpgrades_Targets(t *testing.T) {
+	u := NewAgentUpgrades("v1.1.0")
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{
+		"a": nil,
+		"b": {"env": "prod"},
+		"c": {"env": "prod"},
+	})
+
+	if got := u.Desired(t.Context(), "a", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired without policy = %q, want the hub version", got)
+	}
+
+	err := u.SetPolicy(t.Context(), UpgradePolicy{
+		Version: "v1.2.0",
+		Targets: []UpgradeTarget{
+			{Selector: "env=prod", Version: "v1.0.0"},
+			{Cluster: "c", Version: "v1.3.0"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	for cluster, want := range map[string]string{"a": "v1.2.0", "b": "v1.0.0", "c": "v1.3.0"} {
+		if got := u.Target(cluster, links[cluster].Labels); got != want {
+			t.Errorf("Target(%s) = %q, want %q", cluster, got, want)
+		}
+	}
+}
+
+func TestAgentUpgrades_Validate(t *testing.T) {
+	for name, p := range map[string]UpgradePolicy{
+		"bad version":      {Version: "v1:latest"},
+		"no target match":  {Targets: []UpgradeTarget{{Version: "v1.0.0"}}},
+		"both target keys": {Targets: []UpgradeTarget{{Cluster: "a", Selector: "env=prod", Version: "v1.0.0"}}},
+		"bad selector":     {Waves: []UpgradeWave{{Name: "canary", Selector: "env in ("}}},
+		"duplicate wave":   {Waves: []UpgradeWave{{Name: "w", Selector: "a=b"}, {Name: "w", Selector: "c=d"}}},
+		"negative max":     {MaxConcurrent: -1},
+	} {
+		if err := NewAgentUpgrades("v1.1.0").SetPolicy(t.Context(), p); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAgentUpgrades_MaxConcurrent(t *testing.T) {
+	u := NewAgentUpgrades("v1.1.0")
+	if err := u.SetPolicy(t.Context(), UpgradePolicy{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{"a": nil, "b": nil})
+
+	if got := u.Desired(t.Context(), "a", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(a) = %q, want v1.1.0", got)
+	}
+	if got := u.Desired(t.Context(), "b", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(b) = %q, want v1.0.0 while a upgrades", got)
+	}
+
+	// a registers with its target, which frees the slot.
+	if got := u.Desired(t.Context(), "a", "v1.1.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(a) after upgrade = %q, want v1.1.0", got)
+	}
+	if got := u.Desired(t.Context(), "b", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(b) = %q, want v1.1.0 once a is done", got)
+	}
+}
+
+func TestAgentUpgrades_Waves(t *testing.T) {
+	u := NewAgentUpgrades("v1.1.0")
+	err := u.SetPolicy(t.Context(), UpgradePolicy{
+		Waves: []UpgradeWave{{Name: "canary", Selector: "canary=true"}},
+	})
+	if err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{
+		"canary": {"canary": "true"},
+		"prod":   nil,
+	})
+
+	if got := u.Desired(t.Context(), "prod", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(prod) = %q, want v1.0.0 before the canary", got)
+	}
+	if got := u.Desired(t.Context(), "canary", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(canary) = %q, want v1.1.0", got)
+	}
+
+	u.Desired(t.Context(), "canary", "v1.1.0", links)
+	links["canary"] = Link{Connected: true, AgentVersion: "v1.1.0", Labels: map[string]string{"canary": "true"}}
+	if got := u.Desired(t.Context(), "prod", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(prod) = %q, want v1.1.0 after the canary", got)
+	}
+
+	status := u.Status(t.Context(), links)
+	if status[0].Wave != "canary" || status[0].State != AgentUpgradeUpToDate {
+		t.Fatalf("status[0] = %+v, want canary up to date", status[0])
+	}
+	if status[1].State != AgentUpgradeInProgress {
+		t.Fatalf("status[1] = %+v, want prod in progress", status[1])
+	}
+}
+
+func TestAgentUpgrades_PauseAndAbort(t *testing.T) {
+	store := &memUpgradePolicyStore{}
+	u, err := LoadAgentUpgrades(t.Context(), store, "v1.1.0")
+	if err != nil {
+		t.Fatalf("LoadAgentUpgrades: %v", err)
+	}
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{"a": nil, "b": nil})
+
+	u.Desired(t.Context(), "a", "v1.0.0", links)
+	if err := u.SetPaused(t.Context(), true); err != nil {
+		t.Fatalf("SetPaused: %v", err)
+	}
+	if got := u.Desired(t.Context(), "b", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(b) = %q, want v1.0.0 while paused", got)
+	}
+	if got := u.Desired(t.Context(), "a", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(a) = %q, want the upgrade in flight to continue", got)
+	}
+
+	clusters, err := u.Abort(t.Context())
+	if err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if !slices.Equal(clusters, []string{"a"}) {
+		t.Fatalf("aborted = %v, want [a]", clusters)
+	}
+	if got := u.Desired(t.Context(), "a", "v1.1.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(a) = %q, want the roll-back to v1.0.0", got)
+	}
+
+	reloaded, err := LoadAgentUpgrades(t.Context(), store, "v1.1.0")
+	if err != nil {
+		t.Fatalf("LoadAgentUpgrades: %v", err)
+	}
+	if !reloaded.Policy().Paused {
+		t.Fatal("expected the paused policy to be persisted")
+	}
+}
+
+func TestAgentUpgrades_SharedStore(t *testing.T) {
+	store := &memUpgradePolicyStore{}
+	first, err := LoadAgentUpgrades(t.Context(), store, "v1.1.0")
+	if err != nil {
+		t.Fatalf("LoadAgentUpgrades: %v", err)
+	}
+	second, err := LoadAgentUpgrades(t.Context(), store, "v1.1.0")
+	if err != nil {
+		t.Fatalf("LoadAgentUpgrades: %v", err)
+	}
+	if err := first.SetPolicy(t.Context(), UpgradePolicy{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{"a": nil, "b": nil})
+
+	if got := first.Desired(t.Context(), "a", "v1.0.0", links); got != "v1.1.0" {
+		t.Fatalf("Desired(a) = %q, want v1.1.0", got)
+	}
+	// second has not refreshed, yet sees the upgrade of a in the store.
+	if got := second.Desired(t.Context(), "b", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(b) = %q, want v1.0.0 while a upgrades on the other replica", got)
+	}
+
+	clusters, err := second.Abort(t.Context())
+	if err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if !slices.Equal(clusters, []string{"a"}) {
+		t.Fatalf("aborted = %v, want [a]", clusters)
+	}
+	if err := first.Refresh(t.Context()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := first.Desired(t.Context(), "a", "v1.1.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(a) = %q, want the roll-back to v1.0.0", got)
+	}
+
+	if err := first.Forget(t.Context(), "a"); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if err := second.Refresh(t.Context()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if status := second.Status(t.Context(), links); status[0].State == AgentUpgradeRollingBack {
+		t.Fatalf("status[0] = %+v, want the roll-back forgotten", status[0])
+	}
+}
+
+func TestAgentUpgrades_Timeout(t *testing.T) {
+	u := NewAgentUpgrades("v1.1.0")
+	now := time.Now()
+	u.now = func() time.Time { return now }
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{"a": nil, "b": nil})
+
+	u.Desired(t.Context(), "a", "v1.0.0", links)
+	now = now.Add(upgradeTimeout)
+
+	if got := u.Desired(t.Context(), "b", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(b) = %q, want v1.0.0 after a failed upgrade", got)
+	}
+	if !u.Policy().Paused {
+		t.Fatal("expected a failed upgrade to pause the rollout")
+	}
+}
+
+func TestAgentUpgrades_Blocked(t *testing.T) {
+	u := NewAgentUpgrades("v2.0.0")
+	links := upgradeLinks("v1.0.0", map[string]map[string]string{"a": nil})
+
+	if got := u.Desired(t.Context(), "a", "v1.0.0", links); got != "v1.0.0" {
+		t.Fatalf("Desired(a) = %q, want v1.0.0 for a major version jump", got)
+	}
+	if status := u.Status(t.Context(), links); status[0].State != AgentUpgradeBlocked {
+		t.Fatalf("status = %+v, want blocked", status[0])
+	}
+}
+
+func TestCheckAgentUpgrade(t *testing.T) {
+	tests := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{"v1.0.0", "v1.1.0", false},
+		{"v1.1.0", "v1.1.0", false},
+		{"v1.1.0", "v1.2.0-rc.1", false},
+		{"devel", "v1.2.0", false},
+		{"v1.1.0", "v1.0.9", true},
+		{"v1.1.0", "v2.0.0", true},
+		{"v1.1.0", "latest", true},
+	}
+	for _, tt := range tests {
+		err := CheckAgentUpgrade(tt.from, tt.to)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckAgentUpgrade(%s, %s) = %v, wantErr %v", tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
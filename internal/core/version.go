@@ -1,6 +1,54 @@
 package core
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
 // Version is the build-time binary version (e.g. "v1.2.3").
 // It is a distinct type so that Wire can distinguish it from plain
 // strings when injecting dependencies.
 type Version string
+
+// ParseAgentVersion parses an agent version, a strict semantic version
+// with an optional "v" prefix. Agent versions double as image tags, so
+// anything else is refused to prevent arbitrary image references.
+func ParseAgentVersion(version string) (*semver.Version, error) {
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, &ErrInvalidInput{Field: "version", Message: fmt.Sprintf("must be a semantic version, got %q", version)}
+	}
+	return v, nil
+}
+
+// CheckAgentUpgrade reports whether an agent running version from may
+// self-update to version to. Downgrades and jumps to a later major
+// version are refused with an ErrorCodeFailedPrecondition domain
+// error, since neither is guaranteed to work with the state the
+// running agent left behind. If from is not a semantic version, as for
+// development builds, only to is checked.
+func CheckAgentUpgrade(from, to string) error {
+	target, err := ParseAgentVersion(to)
+	if err != nil {
+		return err
+	}
+	current, err := ParseAgentVersion(from)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case target.LessThan(current):
+		return &DomainError{
+			Code:    ErrorCodeFailedPrecondition,
+			Message: fmt.Sprintf("downgrade from %s to %s is not allowed", from, to),
+		}
+	case target.Major() > current.Major():
+		return &DomainError{
+			Code:    ErrorCodeFailedPrecondition,
+			Message: fmt.Sprintf("major version upgrade from %s to %s is not allowed", from, to),
+		}
+	}
+	return nil
+}
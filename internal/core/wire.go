@@ -6,6 +6,7 @@ import (
 
 // ProviderSet is the Wire provider set for all domain use-cases.
 var ProviderSet = wire.NewSet(
+	NewCAUseCase,
 	NewLinkUseCase,
 	NewResourceUseCase,
 	NewRuntimeUseCase,
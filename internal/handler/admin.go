@@ -0,0 +1,678 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// AdminHandler serves privileged operator endpoints that have no
+// ConnectRPC counterpart in the public API, such as tunnel CA
+// rotation. Every endpoint requires an authenticated caller in the
+// admin group. Errors are written in the Connect JSON error format so
+// that clients can handle them like RPC errors.
+type AdminHandler struct {
+	ca   *core.CAUseCase
+	link *core.LinkUseCase
+}
+
+// NewAdminHandler returns an AdminHandler backed by the given
+// use-cases.
+func NewAdminHandler(ca *core.CAUseCase, link *core.LinkUseCase) *AdminHandler {
+	return &AdminHandler{ca: ca, link: link}
+}
+
+// caCertificate is the JSON representation of core.CACertificate.
+type caCertificate struct {
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// caRotation is the JSON representation of core.CARotation.
+type caRotation struct {
+	InProgress      bool           `json:"inProgress"`
+	Active          caCertificate  `json:"active"`
+	Retiring        *caCertificate `json:"retiring,omitempty"`
+	PendingClusters []string       `json:"pendingClusters"`
+}
+
+// GetCARotation handles GET /admin/ca/rotation and reports the active
+// CA, the retiring CA and the clusters that still have to re-enroll.
+func (h *AdminHandler) GetCARotation(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rotation, err := h.ca.Rotation(r.Context())
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, toCARotation(rotation))
+}
+
+// StartCARotation handles POST /admin/ca/rotation and starts a CA
+// rotation.
+func (h *AdminHandler) StartCARotation(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rotation, err := h.ca.StartRotation(r.Context())
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, toCARotation(rotation))
+}
+
+// FinishCARotation handles DELETE /admin/ca/rotation and retires the
+// old CA. The optional force=true query parameter finishes the
+// rotation even if some clusters have not re-enrolled yet.
+func (h *AdminHandler) FinishCARotation(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	force, err := parseBoolQuery(r, "force")
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	rotation, err := h.ca.FinishRotation(r.Context(), force)
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, toCARotation(rotation))
+}
+
+// revocation is the JSON representation of core.Revocation.
+type revocation struct {
+	Serial    string    `json:"serial,omitempty"`
+	AgentID   string    `json:"agentId,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// revokeRequest is the optional JSON body of a revoke request.
+type revokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// maxAdminBodyBytes bounds the size of admin request bodies.
+const maxAdminBodyBytes = 64 << 10
+
+// ListRevocations handles GET /admin/revocations and returns every
+// entry of the agent certificate deny-list.
+func (h *AdminHandler) ListRevocations(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	entries, err := h.ca.ListRevocations(r.Context())
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	ret := make([]revocation, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, revocation(e))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// RevokeCluster handles POST /admin/clusters/{cluster}/revoke. It
+// revokes the cluster's current agent certificate and agent, and
+// closes its tunnel session immediately. The body may carry a JSON
+// object with a "reason" field.
+func (h *AdminHandler) RevokeCluster(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req revokeRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if err := h.ca.RevokeCluster(r.Context(), r.PathValue("cluster"), req.Reason); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreAgent handles DELETE /admin/revocations/agents/{agent} and
+// allows a revoked agent to register again.
+func (h *AdminHandler) RestoreAgent(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.ca.RestoreAgent(r.Context(), r.PathValue("agent")); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkStatus is the JSON representation of a core.Link.
+type linkStatus struct {
+	Cluster      string            `json:"cluster"`
+	AgentID      string            `json:"agentId"`
+	AgentVersion string            `json:"agentVersion"`
+	Connected    bool              `json:"connected"`
+	Health       core.LinkHealth   `json:"health,omitempty"`
+	LatencyMs    float64           `json:"latencyMs,omitempty"`
+	FirstSeen    time.Time         `json:"firstSeen,omitzero"`
+	LastSeen     time.Time         `json:"lastSeen,omitzero"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Facts        core.ClusterFacts `json:"facts,omitzero"`
+	Replicas     []replicaStatus   `json:"replicas,omitempty"`
+}
+
+// replicaStatus is the JSON representation of a core.LinkReplica.
+type replicaStatus struct {
+	AgentID        string          `json:"agentId"`
+	AgentVersion   string          `json:"agentVersion"`
+	Active         bool            `json:"active"`
+	Healthy        bool            `json:"healthy"`
+	Health         core.LinkHealth `json:"health,omitempty"`
+	LatencyMs      float64         `json:"latencyMs,omitempty"`
+	RegisteredAt   time.Time       `json:"registeredAt,omitzero"`
+	LastSeen       time.Time       `json:"lastSeen,omitzero"`
+	DisconnectedAt time.Time       `json:"disconnectedAt,omitzero"`
+}
+
+// ListLinks handles GET /admin/links and returns every known cluster,
+// sorted by name, including those whose agent is disconnected. The
+// optional selector query parameter restricts the result to clusters
+// whose labels match the label selector.
+func (h *AdminHandler) ListLinks(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	links, err := h.link.ListLinks(r.Context(), r.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	ret := make([]linkStatus, 0, len(links))
+	for _, cluster := range slices.Sorted(maps.Keys(links)) {
+		ret = append(ret, toLinkStatus(cluster, links[cluster]))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// toLinkStatus converts the link of cluster to its JSON
+// representation.
+func toLinkStatus(cluster string, l core.Link) linkStatus {
+	return linkStatus{
+		Cluster:      cluster,
+		AgentID:      l.User,
+		AgentVersion: l.AgentVersion,
+		Connected:    l.Connected,
+		Health:       l.Health,
+		LatencyMs:    latencyMillis(l.Latency),
+		FirstSeen:    l.FirstSeen,
+		LastSeen:     l.LastSeen,
+		Labels:       l.Labels,
+		Facts:        l.Facts,
+		Replicas:     toReplicaStatuses(l),
+	}
+}
+
+// toReplicaStatuses converts the replicas of l to their JSON
+// representation.
+func toReplicaStatuses(l core.Link) []replicaStatus {
+	ret := make([]replicaStatus, 0, len(l.Replicas))
+	for _, r := range l.Replicas {
+		ret = append(ret, replicaStatus{
+			AgentID:        r.User,
+			AgentVersion:   r.AgentVersion,
+			Active:         r.Host == l.Host,
+			Healthy:        r.Healthy,
+			Health:         r.Health,
+			LatencyMs:      latencyMillis(r.Latency),
+			RegisteredAt:   r.RegisteredAt,
+			LastSeen:       r.LastSeen,
+			DisconnectedAt: r.DisconnectedAt,
+		})
+	}
+	return ret
+}
+
+// latencyMillis returns d in milliseconds.
+func latencyMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// labelsRequest is the JSON body of a set-labels request.
+type labelsRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// SetClusterLabels handles PUT /admin/clusters/{cluster}/labels and
+// replaces the cluster's labels with the body's "labels" object.
+// Without a body the labels are cleared.
+func (h *AdminHandler) SetClusterLabels(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req labelsRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if err := h.link.SetClusterLabels(r.Context(), r.PathValue("cluster"), req.Labels); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enrollmentToken is the JSON representation of core.EnrollmentToken.
+// The token digest is deliberately omitted.
+type enrollmentToken struct {
+	ID        string    `json:"id"`
+	Cluster   string    `json:"cluster"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	UsedAt    time.Time `json:"usedAt,omitzero"`
+	UsedBy    string    `json:"usedBy,omitempty"`
+	RevokedAt time.Time `json:"revokedAt,omitzero"`
+	Valid     bool      `json:"valid"`
+}
+
+// ListEnrollmentTokens handles GET /admin/enrollment-tokens and
+// returns the issued agent enrollment tokens, newest first.
+func (h *AdminHandler) ListEnrollmentTokens(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	now := time.Now()
+	tokens := h.link.ListEnrollmentTokens(r.Context())
+	ret := make([]enrollmentToken, 0, len(tokens))
+	for _, t := range tokens {
+		ret = append(ret, enrollmentToken{
+			ID:        t.ID,
+			Cluster:   t.Cluster,
+			IssuedAt:  t.IssuedAt,
+			ExpiresAt: t.ExpiresAt,
+			UsedAt:    t.UsedAt,
+			UsedBy:    t.UsedBy,
+			RevokedAt: t.RevokedAt,
+			Valid:     t.Valid(now),
+		})
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// RevokeEnrollmentToken handles DELETE /admin/enrollment-tokens/{id}
+// and invalidates the token so that it can no longer enroll an agent.
+func (h *AdminHandler) RevokeEnrollmentToken(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.link.RevokeEnrollmentToken(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clusterPin is the JSON representation of core.ClusterPin.
+type clusterPin struct {
+	Cluster  string    `json:"cluster"`
+	AgentUID string    `json:"agentUid"`
+	AgentID  string    `json:"agentId,omitempty"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// rehomeRequest is the optional JSON body of a re-home request.
+type rehomeRequest struct {
+	AgentUID string `json:"agentUid"`
+}
+
+// ListClusterPins handles GET /admin/cluster-pins and returns the
+// agent UID every cluster is pinned to.
+func (h *AdminHandler) ListClusterPins(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	pins := h.link.ListClusterPins(r.Context())
+	ret := make([]clusterPin, 0, len(pins))
+	for _, p := range pins {
+		ret = append(ret, clusterPin(p))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// RehomeCluster handles POST /admin/clusters/{cluster}/rehome and
+// re-pins the cluster to the agent UID in the body's "agentUid" field.
+// Without a body the cluster is unpinned, and the next agent that
+// enrolls with a valid token claims it.
+func (h *AdminHandler) RehomeCluster(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req rehomeRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if err := h.link.RehomeCluster(r.Context(), r.PathValue("cluster"), req.AgentUID); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clusterApproval is the JSON representation of core.ClusterApproval.
+type clusterApproval struct {
+	Cluster      string             `json:"cluster"`
+	State        core.ApprovalState `json:"state"`
+	AgentUID     string             `json:"agentUid,omitempty"`
+	AgentID      string             `json:"agentId,omitempty"`
+	AgentVersion string             `json:"agentVersion,omitempty"`
+	RequestedAt  time.Time          `json:"requestedAt,omitzero"`
+	DecidedBy    string             `json:"decidedBy,omitempty"`
+	DecidedAt    time.Time          `json:"decidedAt,omitzero"`
+	Reason       string             `json:"reason,omitempty"`
+}
+
+// rejectRequest is the optional JSON body of a reject request.
+type rejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ListClusterApprovals handles GET /admin/cluster-approvals and
+// returns the pending, approved and rejected clusters.
+func (h *AdminHandler) ListClusterApprovals(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	approvals := h.link.ListClusterApprovals(r.Context())
+	ret := make([]clusterApproval, 0, len(approvals))
+	for _, a := range approvals {
+		ret = append(ret, clusterApproval(a))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// ApproveCluster handles POST /admin/clusters/{cluster}/approve and
+// admits the cluster, either pending or ahead of its first
+// registration.
+func (h *AdminHandler) ApproveCluster(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.link.ApproveCluster(r.Context(), r.PathValue("cluster")); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RejectCluster handles POST /admin/clusters/{cluster}/reject and
+// refuses the cluster's pending registration, recording the body's
+// optional "reason".
+func (h *AdminHandler) RejectCluster(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req rejectRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if err := h.link.RejectCluster(r.Context(), r.PathValue("cluster"), req.Reason); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// agentUpgrade is the JSON representation of core.AgentUpgrade.
+type agentUpgrade struct {
+	Cluster        string                 `json:"cluster"`
+	Wave           string                 `json:"wave,omitempty"`
+	State          core.AgentUpgradeState `json:"state"`
+	CurrentVersion string                 `json:"currentVersion"`
+	TargetVersion  string                 `json:"targetVersion"`
+	StartedAt      time.Time              `json:"startedAt,omitzero"`
+}
+
+// upgradeStatus is the JSON response of an upgrade status request.
+type upgradeStatus struct {
+	Policy   core.UpgradePolicy `json:"policy"`
+	Clusters []agentUpgrade     `json:"clusters"`
+}
+
+// abortedUpgrades is the JSON response of an abort request.
+type abortedUpgrades struct {
+	Clusters []string `json:"clusters"`
+}
+
+// GetUpgrades handles GET /admin/upgrades and returns the agent
+// upgrade policy and the upgrade state of every cluster.
+func (h *AdminHandler) GetUpgrades(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	upgrades := h.link.ListAgentUpgrades(r.Context())
+	ret := upgradeStatus{
+		Policy:   h.link.UpgradePolicy(r.Context()),
+		Clusters: make([]agentUpgrade, 0, len(upgrades)),
+	}
+	for _, u := range upgrades {
+		ret.Clusters = append(ret.Clusters, agentUpgrade(u))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// SetUpgradePolicy handles PUT /admin/upgrades/policy and replaces the
+// agent upgrade policy with the body. Without a body the policy is
+// reset, which moves every agent to the hub version.
+func (h *AdminHandler) SetUpgradePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req core.UpgradePolicy
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if err := h.link.SetUpgradePolicy(r.Context(), req); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PauseUpgrades handles POST /admin/upgrades/pause and stops new agent
+// upgrades from starting. Upgrades in flight complete.
+func (h *AdminHandler) PauseUpgrades(w http.ResponseWriter, r *http.Request) {
+	h.setUpgradesPaused(w, r, true)
+}
+
+// ResumeUpgrades handles POST /admin/upgrades/resume and lets agent
+// upgrades start again.
+func (h *AdminHandler) ResumeUpgrades(w http.ResponseWriter, r *http.Request) {
+	h.setUpgradesPaused(w, r, false)
+}
+
+// setUpgradesPaused pauses or resumes the agent upgrade rollout.
+func (h *AdminHandler) setUpgradesPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.link.PauseUpgrades(r.Context(), paused); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AbortUpgrades handles POST /admin/upgrades/abort. It pauses the
+// rollout, tells the agents whose upgrade is in flight to return to
+// their previous version, and returns those clusters.
+func (h *AdminHandler) AbortUpgrades(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	clusters, err := h.link.AbortUpgrades(r.Context())
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, abortedUpgrades{Clusters: clusters})
+}
+
+// uninstallURL is the JSON response of an uninstall URL request.
+type uninstallURL struct {
+	URL string `json:"url"`
+}
+
+// IssueUninstallURL handles POST /admin/clusters/{cluster}/uninstall-url.
+// It returns a signed URL that serves the uninstall manifest of the
+// cluster to "kubectl delete -f" without further authentication. The
+// cluster does not have to be registered.
+func (h *AdminHandler) IssueUninstallURL(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	url, err := h.link.IssueUninstallURL(r.Context(), r.PathValue("cluster"))
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, uninstallURL{URL: url})
+}
+
+// deregistration is the JSON response of a deregister request that
+// asked for the uninstall manifest.
+type deregistration struct {
+	UninstallManifest string `json:"uninstallManifest"`
+}
+
+// DeregisterCluster handles DELETE /admin/clusters/{cluster}. It
+// revokes the cluster's agent, releases its tunnel and drops every
+// cached transport, discovery entry, session and Harbor robot account
+// of the cluster. With the optional uninstall=true query parameter the
+// response carries a manifest that removes the agent from the cluster
+// with "kubectl delete -f".
+func (h *AdminHandler) DeregisterCluster(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	uninstall, err := parseBoolQuery(r, "uninstall")
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	manifest, err := h.link.DeregisterCluster(r.Context(), r.PathValue("cluster"), uninstall)
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	if !uninstall {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, deregistration{UninstallManifest: manifest})
+}
+
+// requireAdmin writes an error response and returns false unless the
+// request carries an authenticated admin identity.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userInfo, ok := core.UserInfoFromContext(r.Context())
+	if !ok {
+		writeError(w, r, connect.NewError(connect.CodeUnauthenticated, errors.New("user info not found in context")))
+		return false
+	}
+	if !core.IsAdmin(userInfo.Groups) {
+		writeError(w, r, connect.NewError(connect.CodePermissionDenied, errors.New("caller is not a member of the admin group")))
+		return false
+	}
+	return true
+}
+
+// parseBoolQuery parses an optional boolean query parameter. A missing
+// parameter is false.
+func parseBoolQuery(r *http.Request, name string) (bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, &core.ErrInvalidInput{Field: name, Message: "must be a boolean"}
+	}
+	return v, nil
+}
+
+// decodeOptionalJSON decodes the request body into v. An empty body
+// leaves v untouched.
+func decodeOptionalJSON(r *http.Request, v any) error {
+	if r.Body == nil {
+		return nil
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return &core.ErrInvalidInput{Field: "body", Message: err.Error()}
+	}
+	return nil
+}
+
+// writeError writes err in the Connect error format.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if werr := connect.NewErrorWriter().Write(w, r, err); werr != nil {
+		slog.Warn("failed to write error response", "error", werr)
+	}
+}
+
+// writeJSON writes v as a JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write JSON response", "error", err)
+	}
+}
+
+// toCARotation converts a core.CARotation into its JSON
+// representation.
+func toCARotation(rotation core.CARotation) caRotation {
+	ret := caRotation{
+		InProgress:      rotation.InProgress(),
+		Active:          toCACertificate(rotation.Active),
+		PendingClusters: rotation.PendingClusters,
+	}
+	if ret.PendingClusters == nil {
+		ret.PendingClusters = []string{}
+	}
+	if rotation.Retiring != nil {
+		retiring := toCACertificate(*rotation.Retiring)
+		ret.Retiring = &retiring
+	}
+	return ret
+}
+
+// toCACertificate converts a core.CACertificate into its JSON
+// representation.
+func toCACertificate(cert core.CACertificate) caCertificate {
+	return caCertificate{
+		Fingerprint: cert.Fingerprint,
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+}
@@ -43,6 +43,14 @@ func domainErrorToConnectError(err error) error {
 	if errors.As(err, &clusterNotFound) {
 		return connect.NewError(connect.CodeNotFound, err)
 	}
+	var clusterDisconnected *core.ErrClusterDisconnected
+	if errors.As(err, &clusterDisconnected) {
+		return connect.NewError(connect.CodeUnavailable, err)
+	}
+	var clusterOnPeer *core.ErrClusterOnPeer
+	if errors.As(err, &clusterOnPeer) {
+		return connect.NewError(connect.CodeUnavailable, err)
+	}
 	var notReady *core.ErrNotReady
 	if errors.As(err, &notReady) {
 		return connect.NewError(connect.CodeUnavailable, err)
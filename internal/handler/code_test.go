@@ -30,6 +30,16 @@ func TestDomainErrorToConnectError_ConcreteTypes(t *testing.T) {
 			err:      &core.ErrClusterNotFound{Cluster: "test"},
 			wantCode: connect.CodeNotFound,
 		},
+		{
+			name:     "ErrClusterDisconnected",
+			err:      &core.ErrClusterDisconnected{Cluster: "test"},
+			wantCode: connect.CodeUnavailable,
+		},
+		{
+			name:     "ErrClusterOnPeer",
+			err:      &core.ErrClusterOnPeer{Cluster: "test", Replica: core.HubReplica{ID: "hub-1"}},
+			wantCode: connect.CodeUnavailable,
+		},
 		{
 			name:     "ErrNotReady",
 			err:      &core.ErrNotReady{Subsystem: "chisel"},
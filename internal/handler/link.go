@@ -6,8 +6,14 @@ package handler
 import (
 	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
 	"slices"
+	"strconv"
+	"strings"
 
 	"connectrpc.com/connect"
 
@@ -34,9 +40,17 @@ func NewLinkService(link *core.LinkUseCase) *LinkService {
 var _ pb.LinkServiceHandler = (*LinkService)(nil)
 
 // ListLinks returns the names of all clusters that have a
-// registered agent.
+// registered agent. The optional label selector is read from the
+// core.LabelSelectorHeader request header.
 func (s *LinkService) ListLinks(ctx context.Context, _ *pb.ListLinksRequest) (*pb.ListLinksResponse, error) {
-	links := s.link.ListLinks(ctx)
+	var selector string
+	if info, ok := connect.CallInfoForHandlerContext(ctx); ok {
+		selector = info.RequestHeader().Get(core.LabelSelectorHeader)
+	}
+	links, err := s.link.ListLinks(ctx, selector)
+	if err != nil {
+		return nil, domainErrorToConnectError(err)
+	}
 
 	resp := &pb.ListLinksResponse{}
 	resp.SetLinks(toProtoLinks(links))
@@ -45,13 +59,42 @@ func (s *LinkService) ListLinks(ctx context.Context, _ *pb.ListLinksRequest) (*p
 
 // Register validates and signs the agent's CSR, allocates a tunnel
 // endpoint, and returns the signed certificate together with the CA
-// certificate for mTLS. The response includes the server version so
-// agents can detect mismatches and self-update.
+// certificate for mTLS. The response includes the version the agent
+// should run so that it can self-update; the image reference and pull
+// secrets of that version are returned in the core.AgentImageHeader
+// and core.ImagePullSecretsHeader response headers. The agent authorizes
+// the registration with an enrollment token or its previous identity,
+// carried in request headers (see core.EnrollmentTokenHeader). The
+// cluster facts the agent reports in the core.ClusterFactsHeader
+// header are recorded once the registration succeeded.
 func (s *LinkService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	reg, err := s.link.RegisterCluster(ctx, req.GetCluster(), req.GetAgentId(), req.GetAgentVersion(), req.GetCsr())
+	proof, err := enrollmentProof(ctx)
+	if err != nil {
+		return nil, domainErrorToConnectError(err)
+	}
+	facts, err := clusterFacts(ctx)
+	if err != nil {
+		return nil, domainErrorToConnectError(err)
+	}
+
+	reg, err := s.link.RegisterCluster(ctx, req.GetCluster(), req.GetAgentId(), req.GetAgentVersion(), req.GetCsr(), proof)
 	if err != nil {
 		return nil, domainErrorToConnectError(err)
 	}
+	if facts != nil {
+		if err := s.link.ReportClusterFacts(ctx, req.GetCluster(), *facts); err != nil {
+			slog.Warn("failed to record cluster facts", "cluster", req.GetCluster(), "error", err)
+		}
+	}
+
+	if info, ok := connect.CallInfoForHandlerContext(ctx); ok {
+		if reg.AgentImage != "" {
+			info.ResponseHeader().Set(core.AgentImageHeader, reg.AgentImage)
+		}
+		if len(reg.ImagePullSecrets) > 0 {
+			info.ResponseHeader().Set(core.ImagePullSecretsHeader, strings.Join(reg.ImagePullSecrets, ","))
+		}
+	}
 
 	resp := &pb.RegisterResponse{}
 	resp.SetEndpoint(reg.Endpoint)
@@ -63,8 +106,12 @@ func (s *LinkService) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 
 // GetAgentManifest returns a multi-document YAML manifest for
 // installing the otterscale agent on the caller's target cluster.
-// The manifest includes a ClusterRoleBinding that grants the
-// authenticated user cluster-admin access.
+// By default the manifest includes a ClusterRoleBinding that grants
+// the authenticated user cluster-admin access; a less privileged
+// profile and the Deployment settings are selected with the request
+// headers read by manifestOptions. The returned URL serves the
+// manifest as YAML; appending "/chart.tgz" or "/kustomize.tgz" serves
+// it as a Helm chart or Kustomize base.
 func (s *LinkService) GetAgentManifest(ctx context.Context, req *pb.GetAgentManifestRequest) (*pb.GetAgentManifestResponse, error) {
 	userInfo, ok := core.UserInfoFromContext(ctx)
 	if !ok {
@@ -77,12 +124,17 @@ func (s *LinkService) GetAgentManifest(ctx context.Context, req *pb.GetAgentMani
 	cluster := req.GetCluster()
 	extraUsers := req.GetExtraUsers()
 
-	manifest, err := s.link.GenerateAgentManifest(ctx, cluster, userInfo.Subject, extraUsers)
+	opts, err := manifestOptions(ctx)
+	if err != nil {
+		return nil, domainErrorToConnectError(err)
+	}
+
+	manifest, err := s.link.GenerateAgentManifest(ctx, cluster, userInfo.Subject, extraUsers, opts)
 	if err != nil {
 		return nil, domainErrorToConnectError(err)
 	}
 
-	url, err := s.link.IssueManifestURL(ctx, cluster, userInfo.Subject, extraUsers)
+	url, err := s.link.IssueManifestURL(ctx, cluster, userInfo.Subject, extraUsers, opts)
 	if err != nil {
 		return nil, domainErrorToConnectError(err)
 	}
@@ -93,6 +145,113 @@ func (s *LinkService) GetAgentManifest(ctx context.Context, req *pb.GetAgentMani
 	return resp, nil
 }
 
+// enrollmentProof reads the agent's UID and its enrollment token or
+// previous identity from the request headers.
+func enrollmentProof(ctx context.Context) (core.EnrollmentProof, error) {
+	info, ok := connect.CallInfoForHandlerContext(ctx)
+	if !ok {
+		return core.EnrollmentProof{}, nil
+	}
+	header := info.RequestHeader()
+
+	proof := core.EnrollmentProof{
+		AgentUID: header.Get(core.AgentUIDHeader),
+		Token:    header.Get(core.EnrollmentTokenHeader),
+	}
+	for name, dst := range map[string]*[]byte{
+		core.AgentCertificateHeader: &proof.Certificate,
+		core.AgentProofHeader:       &proof.Proof,
+	} {
+		v := header.Get(name)
+		if v == "" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return core.EnrollmentProof{}, &core.ErrInvalidInput{Field: name, Message: "must be base64-encoded"}
+		}
+		*dst = b
+	}
+	return proof, nil
+}
+
+// maxManifestValuesHeaderBytes bounds the size of the
+// core.ManifestValuesHeader header. The values end up in the manifest
+// URL token.
+const maxManifestValuesHeaderBytes = 2 << 10
+
+// manifestOptions reads the manifest options from the
+// core.ManifestProfileHeader, core.ManifestBootstrapHeader,
+// core.ManifestImpersonateGroupsHeader, core.ManifestNamespacesHeader
+// and core.ManifestValuesHeader request headers. Absent headers leave
+// the defaults.
+func manifestOptions(ctx context.Context) (core.ManifestOptions, error) {
+	info, ok := connect.CallInfoForHandlerContext(ctx)
+	if !ok {
+		return core.ManifestOptions{}, nil
+	}
+	header := info.RequestHeader()
+
+	opts := core.ManifestOptions{
+		Profile:           core.ManifestProfile(header.Get(core.ManifestProfileHeader)),
+		ImpersonateGroups: splitHeaderList(header.Get(core.ManifestImpersonateGroupsHeader)),
+		Namespaces:        splitHeaderList(header.Get(core.ManifestNamespacesHeader)),
+	}
+	if v := header.Get(core.ManifestBootstrapHeader); v != "" {
+		bootstrap, err := strconv.ParseBool(v)
+		if err != nil {
+			return core.ManifestOptions{}, &core.ErrInvalidInput{Field: core.ManifestBootstrapHeader, Message: "must be true or false"}
+		}
+		opts.NoBootstrap = !bootstrap
+	}
+	if v := header.Get(core.ManifestValuesHeader); v != "" {
+		if len(v) > maxManifestValuesHeaderBytes {
+			return core.ManifestOptions{}, &core.ErrInvalidInput{Field: core.ManifestValuesHeader, Message: fmt.Sprintf("must not exceed %d bytes", maxManifestValuesHeaderBytes)}
+		}
+		if err := json.Unmarshal([]byte(v), &opts.Values); err != nil {
+			return core.ManifestOptions{}, &core.ErrInvalidInput{Field: core.ManifestValuesHeader, Message: "must be a JSON object"}
+		}
+	}
+	return opts, nil
+}
+
+// splitHeaderList splits a comma-separated header value, dropping
+// blank elements.
+func splitHeaderList(v string) []string {
+	var ret []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// maxClusterFactsHeaderBytes bounds the size of the
+// core.ClusterFactsHeader header.
+const maxClusterFactsHeaderBytes = 1 << 10
+
+// clusterFacts reads the cluster facts the agent reported, or nil if
+// it reported none.
+func clusterFacts(ctx context.Context) (*core.ClusterFacts, error) {
+	info, ok := connect.CallInfoForHandlerContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	v := info.RequestHeader().Get(core.ClusterFactsHeader)
+	if v == "" {
+		return nil, nil
+	}
+	if len(v) > maxClusterFactsHeaderBytes {
+		return nil, &core.ErrInvalidInput{Field: core.ClusterFactsHeader, Message: fmt.Sprintf("must not exceed %d bytes", maxClusterFactsHeaderBytes)}
+	}
+	var facts core.ClusterFacts
+	if err := json.Unmarshal([]byte(v), &facts); err != nil {
+		return nil, &core.ErrInvalidInput{Field: core.ClusterFactsHeader, Message: "must be a JSON object"}
+	}
+	return &facts, nil
+}
+
 // toProtoLinks converts a map of cluster names to Link domain
 // objects into a sorted slice of protobuf Link messages. Results
 // are sorted by name to ensure deterministic ordering.
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// LinkWatchHandler streams link state changes as newline-delimited
+// JSON, one linkEvent per line. The public Link API has no streaming
+// RPC, so the watch is served as a raw HTTP endpoint protected by the
+// OIDC middleware.
+type LinkWatchHandler struct {
+	link *core.LinkUseCase
+}
+
+// NewLinkWatchHandler returns a LinkWatchHandler backed by the given
+// LinkUseCase.
+func NewLinkWatchHandler(link *core.LinkUseCase) *LinkWatchHandler {
+	return &LinkWatchHandler{link: link}
+}
+
+// linkEvent is the JSON representation of a core.LinkEvent.
+type linkEvent struct {
+	Type                 core.LinkEventType `json:"type"`
+	Time                 time.Time          `json:"time"`
+	Link                 linkStatus         `json:"link"`
+	PreviousAgentVersion string             `json:"previousAgentVersion,omitempty"`
+}
+
+// ServeHTTP handles GET /link/watch. The stream starts with a SNAPSHOT
+// event for every known cluster and then reports each change until
+// the client disconnects. The optional selector query parameter
+// restricts the stream to clusters whose labels match the label
+// selector.
+func (h *LinkWatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events, err := h.link.WatchLinks(r.Context(), r.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("failed to clear write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		if err := enc.Encode(toLinkEvent(ev)); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// toLinkEvent converts a core.LinkEvent to its JSON representation.
+func toLinkEvent(ev core.LinkEvent) linkEvent {
+	return linkEvent{
+		Type:                 ev.Type,
+		Time:                 ev.Time,
+		Link:                 toLinkStatus(ev.Cluster, ev.Link),
+		PreviousAgentVersion: ev.PreviousAgentVersion,
+	}
+}
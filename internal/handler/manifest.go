@@ -30,5 +30,24 @@ func (h *ManifestHandler) VerifyManifestToken(ctx context.Context, token string)
 // RenderManifest generates the agent installation manifest for the
 // given token claims.
 func (h *ManifestHandler) RenderManifest(ctx context.Context, claims core.ManifestTokenClaims) (string, error) {
-	return h.link.GenerateAgentManifest(ctx, claims.Cluster, claims.Sub, claims.ExtraUsers)
+	return h.link.GenerateAgentManifest(ctx, claims.Cluster, claims.Sub, claims.ExtraUsers, claims.Options)
+}
+
+// RenderArchive generates the agent installation manifest for the
+// given token claims, packaged as a Helm chart or Kustomize base
+// tarball.
+func (h *ManifestHandler) RenderArchive(ctx context.Context, claims core.ManifestTokenClaims, format core.ManifestFormat) ([]byte, error) {
+	return h.link.GenerateAgentArchive(ctx, claims.Cluster, claims.Sub, claims.ExtraUsers, claims.Options, format)
+}
+
+// VerifyUninstallToken validates an HMAC-signed uninstall token and
+// returns the extracted claims.
+func (h *ManifestHandler) VerifyUninstallToken(ctx context.Context, token string) (core.ManifestTokenClaims, error) {
+	return h.link.VerifyUninstallToken(ctx, token)
+}
+
+// RenderUninstallManifest generates the agent uninstall manifest for
+// the given token claims.
+func (h *ManifestHandler) RenderUninstallManifest(ctx context.Context, claims core.ManifestTokenClaims) (string, error) {
+	return h.link.GenerateUninstallManifest(ctx, claims.Cluster)
 }
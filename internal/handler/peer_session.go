@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// maxSessionOpBodyBytes bounds the size of a forwarded session
+// operation. Clients write terminal input and port-forward data in
+// chunks well below this, base64-encoded.
+const maxSessionOpBodyBytes = 8 << 20
+
+// PeerSessionHandler applies the exec, port-forward and VNC session
+// operations that other hub replicas forward to this one. It is served
+// on the peer channel only, which authenticates the forwarding replica
+// before passing the request on.
+type PeerSessionHandler struct {
+	runtime *core.RuntimeUseCase
+}
+
+// NewPeerSessionHandler returns a PeerSessionHandler backed by the
+// given RuntimeUseCase.
+func NewPeerSessionHandler(runtime *core.RuntimeUseCase) *PeerSessionHandler {
+	return &PeerSessionHandler{runtime: runtime}
+}
+
+// ServeHTTP handles POST core.PeerSessionPath + {id}, with the session
+// ID as the "id" path value and a JSON core.SessionOp as the body.
+func (h *PeerSessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var op core.SessionOp
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxSessionOpBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&op); err != nil {
+		writeError(w, r, domainErrorToConnectError(&core.ErrInvalidInput{Field: "body", Message: err.Error()}))
+		return
+	}
+	op.SessionID = r.PathValue("id")
+
+	if err := h.runtime.ApplySessionOp(r.Context(), op); err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
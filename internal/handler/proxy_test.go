@@ -20,10 +20,35 @@ func (m *mockTunnelForProxy) ListLinks() map[string]core.Link {
 	return nil
 }
 
-func (m *mockTunnelForProxy) RegisterLink(context.Context, string, string, string, []byte) (addr string, cert []byte, err error) {
+func (m *mockTunnelForProxy) SetLinkLabels(context.Context, string, map[string]string) error {
+	return nil
+}
+
+func (m *mockTunnelForProxy) SetLinkFacts(context.Context, string, core.ClusterFacts) error {
+	return nil
+}
+
+func (m *mockTunnelForProxy) RegisterLink(context.Context, string, string, string, string, []byte) (addr string, cert []byte, err error) {
 	return "", nil, nil
 }
 
+func (m *mockTunnelForProxy) RenewLink(context.Context, string, string, []byte, []byte, []byte) (addr string, cert []byte, err error) {
+	return "", nil, nil
+}
+
+func (m *mockTunnelForProxy) VerifyIdentity(context.Context, string, []byte, []byte, []byte) (string, error) {
+	return "", nil
+}
+
+func (m *mockTunnelForProxy) WatchLinks(ctx context.Context) <-chan core.LinkEvent {
+	ch := make(chan core.LinkEvent)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
 func (m *mockTunnelForProxy) ResolveAddress(_ context.Context, _ string) (string, error) {
 	return m.address, m.addressErr
 }
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// maxRenewBodyBytes bounds the size of a renewal request. A request
+// carries a certificate, a CSR and a signature, all well below this.
+const maxRenewBodyBytes = 64 << 10
+
+// renewRequest is the JSON body of a certificate renewal request.
+// Byte fields are base64-encoded.
+type renewRequest struct {
+	Cluster     string `json:"cluster"`
+	AgentID     string `json:"agentId"`
+	Certificate []byte `json:"certificate"`
+	CSR         []byte `json:"csr"`
+	Proof       []byte `json:"proof"`
+}
+
+// renewResponse is the JSON body of a successful renewal.
+type renewResponse struct {
+	Endpoint         string   `json:"endpoint"`
+	Certificate      []byte   `json:"certificate"`
+	CACertificate    []byte   `json:"caCertificate"`
+	ServerVersion    string   `json:"serverVersion"`
+	AgentImage       string   `json:"agentImage,omitempty"`
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+// RenewHandler serves in-place agent certificate renewal. It has no
+// ConnectRPC counterpart in the public API and is not protected by
+// OIDC: the request is authenticated by a proof made with the key of
+// the agent's current certificate.
+type RenewHandler struct {
+	link *core.LinkUseCase
+}
+
+// NewRenewHandler returns a RenewHandler backed by the given
+// LinkUseCase.
+func NewRenewHandler(link *core.LinkUseCase) *RenewHandler {
+	return &RenewHandler{link: link}
+}
+
+// ServeHTTP handles POST /link/renew.
+func (h *RenewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req renewRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxRenewBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, r, domainErrorToConnectError(&core.ErrInvalidInput{Field: "body", Message: err.Error()}))
+		return
+	}
+
+	reg, err := h.link.RenewCluster(r.Context(), req.Cluster, req.AgentID, req.Certificate, req.CSR, req.Proof)
+	if err != nil {
+		writeError(w, r, domainErrorToConnectError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, renewResponse{
+		Endpoint:         reg.Endpoint,
+		Certificate:      reg.Certificate,
+		CACertificate:    reg.CACertificate,
+		ServerVersion:    reg.ServerVersion,
+		AgentImage:       reg.AgentImage,
+		ImagePullSecrets: reg.ImagePullSecrets,
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net/http"
 	"sync"
 
 	"connectrpc.com/connect"
@@ -37,6 +38,14 @@ func NewRuntimeService(runtime *core.RuntimeUseCase) *RuntimeService {
 
 var _ pb.RuntimeServiceHandler = (*RuntimeService)(nil)
 
+// setHubReplicaHeader sets the core.HubReplicaHeader of a session
+// stream, if this hub runs with peer forwarding.
+func (s *RuntimeService) setHubReplicaHeader(header http.Header) {
+	if id := s.runtime.HubReplicaID(); id != "" {
+		header.Set(core.HubReplicaHeader, id)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // PodLog
 // ---------------------------------------------------------------------------
@@ -122,7 +131,9 @@ func (s *RuntimeService) ExecuteTTY(ctx context.Context, req *pb.ExecuteTTYReque
 	}
 	defer s.runtime.CleanupExec(ctx, sess.ID)
 
-	// Send the session ID as the first message.
+	// Send the session ID as the first message, advertising the hub
+	// replica that owns the session.
+	s.setHubReplicaHeader(stream.ResponseHeader())
 	first := &pb.ExecuteTTYResponse{}
 	first.SetSessionId(sess.ID)
 	if err := stream.Send(first); err != nil {
@@ -265,7 +276,9 @@ func (s *RuntimeService) PortForward(ctx context.Context, req *pb.PortForwardReq
 	}
 	defer s.runtime.CleanupPortForward(ctx, sess.ID)
 
-	// Send the session ID as the first message.
+	// Send the session ID as the first message, advertising the hub
+	// replica that owns the session.
+	s.setHubReplicaHeader(stream.ResponseHeader())
 	first := &pb.PortForwardResponse{}
 	first.SetSessionId(sess.ID)
 	if err := stream.Send(first); err != nil {
@@ -368,7 +381,9 @@ func (s *RuntimeService) VNC(ctx context.Context, req *pb.VNCRequest, stream *co
 	}
 	defer s.runtime.CleanupVNC(ctx, sess.ID)
 
-	// Send the session ID as the first message.
+	// Send the session ID as the first message, advertising the hub
+	// replica that owns the session.
+	s.setHubReplicaHeader(stream.ResponseHeader())
 	first := &pb.VNCResponse{}
 	first.SetSessionId(sess.ID)
 	if err := stream.Send(first); err != nil {
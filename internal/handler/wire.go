@@ -5,5 +5,7 @@ import (
 )
 
 // ProviderSet is the Wire provider set for ConnectRPC service handlers,
-// the raw HTTP manifest handler, and the Prometheus reverse proxy.
-var ProviderSet = wire.NewSet(NewLinkService, NewResourceService, NewRuntimeService, NewManifestHandler, NewProxyHandler)
+// the raw HTTP manifest and renewal handlers, the Prometheus reverse
+// proxy, the link watch stream, the admin endpoints, and the handler of
+// forwarded session operations.
+var ProviderSet = wire.NewSet(NewLinkService, NewResourceService, NewRuntimeService, NewManifestHandler, NewRenewHandler, NewProxyHandler, NewLinkWatchHandler, NewAdminHandler, NewPeerSessionHandler)
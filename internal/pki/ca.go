@@ -4,10 +4,13 @@
 // The CA key pair is generated using crypto/rand (NIST SP 800-90A DRBG
 // in FIPS 140-3 mode) and must be persisted externally so that
 // restarts reload the same CA, keeping previously issued agent
-// certificates valid until they expire.
+// certificates valid until they expire. Alternatively the key can be
+// held outside the process by an external Signer (see WithSigner).
 package pki
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hkdf"
@@ -16,10 +19,14 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
+	"sync"
 	"time"
 )
 
@@ -28,26 +35,109 @@ import (
 // of a compromised key and avoid the need for explicit revocation.
 const certValidity = 24 * time.Hour
 
+// Rotation errors returned by StartRotation and FinishRotation.
+var (
+	// ErrRotationInProgress is returned when a rotation is started
+	// while a previous one has not been finished yet.
+	ErrRotationInProgress = errors.New("pki: CA rotation already in progress")
+	// ErrNoRotation is returned when a rotation is finished while
+	// none is in progress.
+	ErrNoRotation = errors.New("pki: no CA rotation in progress")
+)
+
+// authority is a single self-signed CA certificate and the signer
+// holding its key.
+type authority struct {
+	cert    *x509.Certificate
+	signer  Signer
+	certPEM []byte
+}
+
+// keyPEM returns the material persisted next to the certificate: the
+// private key for in-memory signers, or the public key when the key is
+// held by an external signer.
+func (a *authority) keyPEM() ([]byte, error) {
+	if m, ok := a.signer.(memorySigner); ok {
+		return marshalKey(m.PrivateKey)
+	}
+	return marshalPublicKey(a.signer)
+}
+
 // CA holds a self-signed certificate authority key pair and provides
 // methods for signing CSRs and generating server certificates.
+//
+// During a rotation the CA holds two authorities: the active one,
+// which signs every new agent certificate, and the retiring one,
+// which stays trusted (and keeps signing the tunnel server
+// certificate) until every agent has re-enrolled under the active
+// authority and the rotation is finished.
 type CA struct {
-	cert    *x509.Certificate
-	key     *ecdsa.PrivateKey
-	certPEM []byte
+	mu       sync.RWMutex
+	active   *authority
+	retiring *authority // nil unless a rotation is in progress
+	store    CAStore    // nil for ephemeral CAs
+
+	// hmacSecret is the persisted secret DeriveHMACKey expands, or nil
+	// to derive the keys from the CA key.
+	hmacSecret []byte
+}
+
+// CertInfo summarizes a certificate for display and comparison.
+type CertInfo struct {
+	// Fingerprint is the hex-encoded SHA-256 digest of the DER
+	// certificate.
+	Fingerprint string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// RotationStatus describes the CA's current rotation state.
+type RotationStatus struct {
+	// Active is the authority that signs new agent certificates.
+	Active CertInfo
+	// Retiring is the previous authority that is still trusted
+	// during a rotation. Nil when no rotation is in progress.
+	Retiring *CertInfo
 }
 
 // NewCA generates a new ECDSA P-256 CA key pair and self-signed
 // certificate using crypto/rand.Reader. In FIPS 140-3 mode the
 // reader is backed by a NIST SP 800-90A DRBG.
 //
+// With WithSigner, no key is generated; the certificate is
+// self-signed by the given signer instead.
+//
 // The caller is responsible for persisting CertPEM() and KeyPEM()
 // so that subsequent restarts can reload the same CA via LoadCA.
-func NewCA() (*CA, error) {
+func NewCA(opts ...CAOption) (*CA, error) {
+	o := newCAOptions(opts)
+	if o.signer != nil {
+		a, err := selfSign(o.signer)
+		if err != nil {
+			return nil, err
+		}
+		return &CA{active: a}, nil
+	}
+
+	a, err := newAuthority()
+	if err != nil {
+		return nil, err
+	}
+	return &CA{active: a}, nil
+}
+
+// newAuthority generates a fresh in-memory CA key pair and
+// self-signed certificate.
+func newAuthority() (*authority, error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, fmt.Errorf("pki: generate CA key: %w", err)
 	}
+	return selfSign(memorySigner{key})
+}
 
+// selfSign creates a self-signed CA certificate for the signer's key.
+func selfSign(signer Signer) (*authority, error) {
 	serial, err := randomSerial()
 	if err != nil {
 		return nil, err
@@ -68,7 +158,7 @@ func NewCA() (*CA, error) {
 		MaxPathLen:            0,
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
 	if err != nil {
 		return nil, fmt.Errorf("pki: create CA cert: %w", err)
 	}
@@ -80,18 +170,49 @@ func NewCA() (*CA, error) {
 
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
 
-	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+	return &authority{cert: cert, signer: signer, certPEM: certPEM}, nil
 }
 
 // LoadCA reconstructs a CA from PEM-encoded certificate and private
 // key material. It validates that the certificate is a CA and that the
 // private key matches the certificate's public key.
+//
+// The material may carry a second certificate and key block, in which
+// case the CA is loaded mid-rotation: the first pair is the active
+// authority and the second pair is the retiring one.
 func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
-	certBlock, _ := pem.Decode(certPEM)
-	if certBlock == nil {
+	certRest, keyRest := certPEM, keyPEM
+	var authorities []*authority
+	for len(bytes.TrimSpace(certRest)) > 0 && len(authorities) < 2 {
+		var certBlock, keyBlock *pem.Block
+		certBlock, certRest = pem.Decode(certRest)
+		if certBlock == nil {
+			return nil, fmt.Errorf("pki: failed to decode CA certificate PEM")
+		}
+		keyBlock, keyRest = pem.Decode(keyRest)
+		if keyBlock == nil {
+			return nil, fmt.Errorf("pki: failed to decode CA private key PEM")
+		}
+		a, err := loadAuthority(certBlock, keyBlock)
+		if err != nil {
+			return nil, err
+		}
+		authorities = append(authorities, a)
+	}
+	if len(authorities) == 0 {
 		return nil, fmt.Errorf("pki: failed to decode CA certificate PEM")
 	}
 
+	ca := &CA{active: authorities[0]}
+	if len(authorities) > 1 {
+		ca.retiring = authorities[1]
+	}
+	return ca, nil
+}
+
+// loadAuthority parses and cross-checks a single certificate and key
+// block.
+func loadAuthority(certBlock, keyBlock *pem.Block) (*authority, error) {
 	cert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("pki: parse CA cert: %w", err)
@@ -101,11 +222,6 @@ func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
 		return nil, fmt.Errorf("pki: certificate is not a CA")
 	}
 
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return nil, fmt.Errorf("pki: failed to decode CA private key PEM")
-	}
-
 	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("pki: parse CA key: %w", err)
@@ -121,46 +237,374 @@ func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
 		return nil, fmt.Errorf("pki: CA private key does not match certificate public key")
 	}
 
-	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBlock.Bytes})
+	return &authority{cert: cert, signer: memorySigner{key}, certPEM: certPEM}, nil
+}
+
+// LoadExternalCA reconstructs a CA whose key is held by signer from
+// the PEM-encoded certificate and the public key persisted in place of
+// the private key. It verifies that both match the signer's key.
+func LoadExternalCA(certPEM, publicKeyPEM []byte, signer Signer) (*CA, error) {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("pki: certificate is not a CA")
+	}
+
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil || block.Type != publicKeyBlockType {
+		return nil, fmt.Errorf("pki: stored CA key is not a public key; the CA was created with a different signer")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("pki: parse CA public key: %w", err)
+	}
+	if !samePublicKey(signer.Public(), pub) || !samePublicKey(signer.Public(), cert.PublicKey) {
+		return nil, fmt.Errorf("pki: signer key does not match the stored CA")
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return &CA{active: &authority{cert: cert, signer: signer, certPEM: certPEM}}, nil
 }
 
-// CertPEM returns the PEM-encoded CA certificate. Agents use this to
-// verify the tunnel server's identity and to be verified themselves.
+// CertPEM returns the PEM-encoded certificate of the active CA, which
+// signs every new agent certificate.
 func (ca *CA) CertPEM() []byte {
-	return ca.certPEM
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.active.certPEM
+}
+
+// TrustBundlePEM returns every CA certificate that is currently
+// trusted: the active one and, during a rotation, the retiring one.
+// Agents use this to verify the tunnel server's identity and the
+// server uses it to verify agent client certificates.
+func (ca *CA) TrustBundlePEM() []byte {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.trustBundleLocked()
+}
+
+// trustBundleLocked implements TrustBundlePEM. ca.mu must be held.
+func (ca *CA) trustBundleLocked() []byte {
+	if ca.retiring == nil {
+		return ca.active.certPEM
+	}
+	return append(bytes.Clone(ca.active.certPEM), ca.retiring.certPEM...)
 }
 
-// KeyPEM returns the PEM-encoded CA private key for external
-// persistence. The caller should store this securely (e.g. with
-// 0600 permissions) so the CA can be reloaded via LoadCA.
+// TrustPool returns an x509.CertPool containing TrustBundlePEM.
+func (ca *CA) TrustPool() *x509.CertPool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.active.cert)
+	if ca.retiring != nil {
+		pool.AddCert(ca.retiring.cert)
+	}
+	return pool
+}
+
+// KeyPEM returns the PEM-encoded private key of the active CA for
+// external persistence. The caller should store this securely (e.g.
+// with 0600 permissions) so the CA can be reloaded via LoadCA. It
+// returns ErrKeyNotExportable when the key is held by an external
+// signer.
 func (ca *CA) KeyPEM() ([]byte, error) {
-	keyDER, err := x509.MarshalECPrivateKey(ca.key)
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	if isExternal(ca.active.signer) {
+		return nil, ErrKeyNotExportable
+	}
+	return ca.active.keyPEM()
+}
+
+// Rotation reports the active authority and, during a rotation, the
+// retiring one.
+func (ca *CA) Rotation() RotationStatus {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.rotationLocked()
+}
+
+// StartRotation generates a new authority and makes it active. The
+// previous authority becomes the retiring one: it stays in the trust
+// bundle and keeps signing the tunnel server certificate so that
+// agents enrolled before the rotation can still connect. The new
+// state is persisted before it takes effect, and is decided on the
+// persisted state, so that a rotation another hub replica started
+// is not started again.
+//
+// Rotation is only supported for in-memory keys; a CA backed by an
+// external signer returns ErrRotationUnsupported.
+func (ca *CA) StartRotation(ctx context.Context) (RotationStatus, error) {
+	ca.mu.RLock()
+	external := isExternal(ca.active.signer)
+	ca.mu.RUnlock()
+	if external {
+		return RotationStatus{}, ErrRotationUnsupported
+	}
+
+	next, err := newAuthority()
 	if err != nil {
-		return nil, fmt.Errorf("pki: marshal CA key: %w", err)
+		return RotationStatus{}, err
 	}
-	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	err = ca.updateLocked(ctx, func() (active, retiring *authority, err error) {
+		if ca.retiring != nil {
+			return nil, nil, ErrRotationInProgress
+		}
+		return next, ca.active, nil
+	})
+	if err != nil {
+		return RotationStatus{}, err
+	}
+	return ca.rotationLocked(), nil
+}
+
+// FinishRotation drops the retiring authority from the trust bundle.
+// Agent certificates signed by it stop being accepted from this
+// point on, so callers should only finish a rotation once every agent
+// has re-enrolled.
+func (ca *CA) FinishRotation(ctx context.Context) (RotationStatus, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	err := ca.updateLocked(ctx, func() (active, retiring *authority, err error) {
+		if ca.retiring == nil {
+			return nil, nil, ErrNoRotation
+		}
+		return ca.active, nil, nil
+	})
+	if err != nil {
+		return RotationStatus{}, err
+	}
+	return ca.rotationLocked(), nil
 }
 
-// DeriveHMACKey deterministically derives a 32-byte HMAC key from the
-// CA's private key and a label using HKDF (RFC 5869). The derivation
-// is deterministic for the same CA key and label, so the result
-// survives server restarts as long as the same CA is loaded.
+// Refresh reloads the authorities from the backing store, so that a
+// rotation started or finished by another hub replica takes effect on
+// this one as well. CAs without a store are left unchanged.
+func (ca *CA) Refresh(ctx context.Context) error {
+	if ca.store == nil {
+		return nil
+	}
+
+	// The lock is held while loading, so that a rotation this replica
+	// persists concurrently is not undone by older material.
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	certPEM, keyPEM, err := ca.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("pki: load CA: %w", err)
+	}
+	return ca.adoptLocked(certPEM, keyPEM)
+}
+
+// rotationLocked builds a RotationStatus. ca.mu must be held.
+func (ca *CA) rotationLocked() RotationStatus {
+	status := RotationStatus{Active: certInfo(ca.active.cert)}
+	if ca.retiring != nil {
+		info := certInfo(ca.retiring.cert)
+		status.Retiring = &info
+	}
+	return status
+}
+
+// updateLocked replaces the authorities with those returned by fn and
+// persists them before they take effect. fn decides on the persisted
+// state: the authorities are reloaded from the store before it is
+// called, and if another hub replica changes them concurrently, again
+// before it is called once more. Ephemeral CAs without a store are
+// rotated in memory only. ca.mu must be held.
+func (ca *CA) updateLocked(ctx context.Context, fn func() (active, retiring *authority, err error)) error {
+	if ca.store == nil {
+		active, retiring, err := fn()
+		if err != nil {
+			return err
+		}
+		ca.active, ca.retiring = active, retiring
+		return nil
+	}
+
+	var active, retiring *authority
+	err := ca.store.Update(ctx, func(certPEM, keyPEM []byte) ([]byte, []byte, error) {
+		if err := ca.adoptLocked(certPEM, keyPEM); err != nil {
+			return nil, nil, err
+		}
+		var err error
+		if active, retiring, err = fn(); err != nil {
+			return nil, nil, err
+		}
+		return encodeAuthorities(active, retiring)
+	})
+	if errors.Is(err, ErrRotationInProgress) || errors.Is(err, ErrNoRotation) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("pki: persist CA: %w", err)
+	}
+	ca.active, ca.retiring = active, retiring
+	return nil
+}
+
+// adoptLocked replaces the authorities with the persisted material,
+// unless it matches them already. ca.mu must be held.
+func (ca *CA) adoptLocked(certPEM, keyPEM []byte) error {
+	if bytes.Equal(certPEM, ca.trustBundleLocked()) {
+		return nil
+	}
+	var (
+		loaded *CA
+		err    error
+	)
+	if isExternal(ca.active.signer) {
+		loaded, err = LoadExternalCA(certPEM, keyPEM, ca.active.signer)
+	} else {
+		loaded, err = LoadCA(certPEM, keyPEM)
+	}
+	if err != nil {
+		return err
+	}
+	ca.active, ca.retiring = loaded.active, loaded.retiring
+	return nil
+}
+
+// encodeAuthorities returns the persisted form of the given active and
+// (optional) retiring authorities.
+func encodeAuthorities(active, retiring *authority) (certPEM, keyPEM []byte, err error) {
+	certPEM = bytes.Clone(active.certPEM)
+	keyPEM, err = active.keyPEM()
+	if err != nil {
+		return nil, nil, err
+	}
+	if retiring != nil {
+		retiringKeyPEM, err := retiring.keyPEM()
+		if err != nil {
+			return nil, nil, err
+		}
+		certPEM = append(certPEM, retiring.certPEM...)
+		keyPEM = append(keyPEM, retiringKeyPEM...)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// Issuer returns the fingerprint of the trusted authority that signed
+// the PEM-encoded certificate. It returns an error if the certificate
+// was not signed by the active or the retiring authority.
+func (ca *CA) Issuer(certPEM []byte) (string, error) {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return "", err
+	}
+
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	for _, a := range []*authority{ca.active, ca.retiring} {
+		if a != nil && cert.CheckSignatureFrom(a.cert) == nil {
+			return certInfo(a.cert).Fingerprint, nil
+		}
+	}
+	return "", fmt.Errorf("pki: certificate not signed by a trusted CA")
+}
+
+// hmacKeyLen is the length of the keys returned by DeriveHMACKey.
+const hmacKeyLen = 32 // 256-bit HMAC key
+
+// hmacSecretLabel is the label external signers derive the HMAC secret
+// with.
+const hmacSecretLabel = "otterscale-hmac-secret"
+
+// DeriveHMACKey deterministically derives a 32-byte HMAC key from a
+// label. A CA loaded with WithHMACSecretStore expands the persisted
+// HMAC secret with HKDF (RFC 5869), so the key is the same on every
+// hub replica and does not change when the CA is rotated.
 //
-// This uses crypto/hkdf which is inside the Go Cryptographic Module's
-// FIPS 140-3 boundary.
+// Other CAs derive the key from the CA's private key, deterministically
+// for the same CA key and label. For in-memory keys this uses HKDF from
+// crypto/hkdf, which is inside the Go Cryptographic Module's FIPS
+// 140-3 boundary; external signers derive the key without exposing
+// the CA key. During a rotation the key is derived from the retiring
+// authority, so that starting a rotation does not invalidate
+// outstanding tokens.
 func (ca *CA) DeriveHMACKey(label string) ([]byte, error) {
-	keyDER, err := x509.MarshalECPrivateKey(ca.key)
+	ca.mu.RLock()
+	secret := ca.hmacSecret
+	source := ca.active
+	if ca.retiring != nil {
+		source = ca.retiring
+	}
+	ca.mu.RUnlock()
+
+	var (
+		key []byte
+		err error
+	)
+	if secret != nil {
+		key, err = hkdf.Expand(sha256.New, secret, label, hmacKeyLen)
+	} else {
+		key, err = source.signer.DeriveKey(label, hmacKeyLen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pki: derive HMAC key: %w", err)
+	}
+	return key, nil
+}
+
+// hmacSeed returns the HMAC secret to persist when none has been
+// stored yet. For in-memory keys it is the HKDF pseudorandom key of the
+// authority DeriveHMACKey derives its keys from otherwise, so that the
+// keys, and the tokens signed with them before the secret was
+// persisted, stay the same. External signers derive a new secret.
+func (ca *CA) hmacSeed() ([]byte, error) {
+	ca.mu.RLock()
+	source := ca.active
+	if ca.retiring != nil {
+		source = ca.retiring
+	}
+	ca.mu.RUnlock()
+
+	m, ok := source.signer.(memorySigner)
+	if !ok {
+		secret, err := source.signer.DeriveKey(hmacSecretLabel, hmacKeyLen)
+		if err != nil {
+			return nil, fmt.Errorf("pki: derive HMAC secret: %w", err)
+		}
+		return secret, nil
+	}
+	keyDER, err := x509.MarshalECPrivateKey(m.PrivateKey)
 	if err != nil {
 		return nil, fmt.Errorf("pki: marshal key for HKDF: %w", err)
 	}
-	const hmacKeyLen = 32 // 256-bit HMAC key
-	return hkdf.Key(sha256.New, keyDER, nil, label, hmacKeyLen)
+	return hkdf.Extract(sha256.New, keyDER, nil)
 }
 
 // SignCSR validates a PEM-encoded PKCS#10 certificate signing request
-// and returns a PEM-encoded X.509 certificate signed by the CA. The
-// certificate is valid for the default certValidity period.
+// and returns a PEM-encoded X.509 certificate signed by the active CA.
+// The certificate is valid for the default certValidity period.
 func (ca *CA) SignCSR(csrPEM []byte) ([]byte, error) {
+	return ca.signCSR(csrPEM, nil)
+}
+
+// SignAgentCSR is like SignCSR but binds the certificate to cluster
+// and agentUID by adding their identity URIs (see ClusterURI and
+// AgentUIDURI) as Subject Alternative Names. The URIs are set by the
+// CA, never copied from the CSR, so an agent can later prove which
+// cluster it enrolled for and as which agent.
+func (ca *CA) SignAgentCSR(csrPEM []byte, cluster, agentUID string) ([]byte, error) {
+	return ca.signCSR(csrPEM, []*url.URL{ClusterURI(cluster), AgentUIDURI(agentUID)})
+}
+
+// signCSR signs csrPEM with the active CA, adding uris as Subject
+// Alternative Names.
+func (ca *CA) signCSR(csrPEM []byte, uris []*url.URL) ([]byte, error) {
 	block, _ := pem.Decode(csrPEM)
 	if block == nil || block.Type != "CERTIFICATE REQUEST" {
 		return nil, fmt.Errorf("pki: invalid CSR PEM")
@@ -188,9 +632,14 @@ func (ca *CA) SignCSR(csrPEM []byte) ([]byte, error) {
 		NotAfter:     now.Add(certValidity),
 		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         uris,
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	ca.mu.RLock()
+	issuer := ca.active
+	ca.mu.RUnlock()
+
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, issuer.cert, csr.PublicKey, issuer.signer)
 	if err != nil {
 		return nil, fmt.Errorf("pki: sign certificate: %w", err)
 	}
@@ -201,6 +650,10 @@ func (ca *CA) SignCSR(csrPEM []byte) ([]byte, error) {
 // GenerateServerCert creates a TLS server certificate signed by the
 // CA. The hosts parameter accepts IP addresses and DNS names that are
 // added as Subject Alternative Names.
+//
+// During a rotation the certificate is signed by the retiring
+// authority, the only one trusted by agents that enrolled before the
+// rotation started.
 func (ca *CA) GenerateServerCert(hosts ...string) (certPEM, keyPEM []byte, err error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -233,7 +686,14 @@ func (ca *CA) GenerateServerCert(hosts ...string) (certPEM, keyPEM []byte, err e
 		}
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	ca.mu.RLock()
+	issuer := ca.active
+	if ca.retiring != nil {
+		issuer = ca.retiring
+	}
+	ca.mu.RUnlock()
+
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, issuer.cert, &key.PublicKey, issuer.signer)
 	if err != nil {
 		return nil, nil, fmt.Errorf("pki: create server cert: %w", err)
 	}
@@ -301,6 +761,39 @@ func DeriveAuth(agentID string, certPEM []byte) (string, error) {
 // Internal helpers
 // ---------------------------------------------------------------------------
 
+// parseCertificatePEM decodes the first PEM block and parses it as an
+// X.509 certificate.
+func parseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("pki: failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("pki: parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// certInfo summarizes a parsed certificate.
+func certInfo(cert *x509.Certificate) CertInfo {
+	sum := sha256.Sum256(cert.Raw)
+	return CertInfo{
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+}
+
+// marshalKey PEM-encodes an ECDSA private key.
+func marshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("pki: marshal CA key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
 // randomSerial generates a cryptographically random serial number.
 func randomSerial() (*big.Int, error) {
 	const serialBits = 128 // 128-bit random serial number
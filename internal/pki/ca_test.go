@@ -103,7 +103,7 @@ func TestLoadCA_Roundtrip(t *testing.T) {
 		t.Fatalf("parse signed cert: %v", err)
 	}
 	pool := x509.NewCertPool()
-	pool.AddCert(original.cert)
+	pool.AddCert(original.active.cert)
 	if _, err := cert.Verify(x509.VerifyOptions{
 		Roots:     pool,
 		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
@@ -292,7 +292,7 @@ func TestSignCSR(t *testing.T) {
 
 	// Verify the certificate was signed by the CA.
 	pool := x509.NewCertPool()
-	pool.AddCert(ca.cert)
+	pool.AddCert(ca.active.cert)
 	if _, err := cert.Verify(x509.VerifyOptions{
 		Roots:     pool,
 		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
@@ -350,7 +350,7 @@ func TestGenerateServerCert(t *testing.T) {
 
 	// Verify signed by CA.
 	pool := x509.NewCertPool()
-	pool.AddCert(ca.cert)
+	pool.AddCert(ca.active.cert)
 	if _, err := cert.Verify(x509.VerifyOptions{
 		Roots:     pool,
 		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
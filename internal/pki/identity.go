@@ -0,0 +1,47 @@
+package pki
+
+import (
+	"crypto/x509"
+	"net/url"
+)
+
+// Identity URIs bind an agent certificate to its cluster and agent UID:
+// otterscale://cluster/<name> and otterscale://agent/<uid>.
+const (
+	identityURIScheme = "otterscale"
+	clusterURIHost    = "cluster"
+	agentURIHost      = "agent"
+)
+
+// ClusterURI returns the identity URI of cluster.
+func ClusterURI(cluster string) *url.URL {
+	return &url.URL{Scheme: identityURIScheme, Host: clusterURIHost, Path: "/" + cluster}
+}
+
+// AgentUIDURI returns the identity URI of an agent UID.
+func AgentUIDURI(uid string) *url.URL {
+	return &url.URL{Scheme: identityURIScheme, Host: agentURIHost, Path: "/" + uid}
+}
+
+// CertificateCluster returns the cluster an agent certificate was
+// issued for by SignAgentCSR, or "" if it carries no cluster identity.
+func CertificateCluster(cert *x509.Certificate) string {
+	return identityURI(cert, clusterURIHost)
+}
+
+// CertificateAgentUID returns the agent UID an agent certificate was
+// issued for by SignAgentCSR, or "" if it carries no agent identity.
+func CertificateAgentUID(cert *x509.Certificate) string {
+	return identityURI(cert, agentURIHost)
+}
+
+// identityURI returns the path of the first identity URI of cert
+// with the given host, without its leading slash.
+func identityURI(cert *x509.Certificate, host string) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == identityURIScheme && u.Host == host && len(u.Path) > 1 {
+			return u.Path[1:]
+		}
+	}
+	return ""
+}
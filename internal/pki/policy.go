@@ -0,0 +1,166 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// keyReuseWindow is how long CSRPolicy remembers a public key after
+// it was first seen. It comfortably exceeds certValidity so that a key
+// cannot be re-submitted while a certificate for it may still be in
+// use.
+const keyReuseWindow = 30 * 24 * time.Hour
+
+// PolicyViolation describes why CSRPolicy rejected a CSR. The reason
+// is returned to the agent, which logs it.
+type PolicyViolation struct {
+	// Field names the part of the CSR that was rejected.
+	Field string
+	// Reason is a human-readable explanation.
+	Reason string
+	// Denied is true when the CSR is well-formed but not allowed for
+	// this agent (wrong identity, disallowed SAN or reused key), and
+	// false when it is malformed or uses an unsupported algorithm.
+	Denied bool
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("csr policy: %s: %s", v.Field, v.Reason)
+}
+
+// CSRPolicy checks agent CSRs before they are signed:
+//
+//   - the subject CN must equal the registering agent ID;
+//   - the key must be ECDSA on a FIPS-approved curve (P-256 or P-384);
+//   - Subject Alternative Names must be absent, or all match the
+//     configured pattern;
+//   - a public key is accepted only once, so that every certificate
+//     carries a fresh key.
+//
+// Seen keys are kept in memory for keyReuseWindow.
+type CSRPolicy struct {
+	sanPattern *regexp.Regexp // nil: SANs are rejected
+
+	mu   sync.Mutex
+	seen map[string]time.Time // SPKI fingerprint -> first seen
+	now  func() time.Time
+}
+
+// NewCSRPolicy returns a CSRPolicy. sanPattern, if non-empty, is a
+// regular expression that every SAN (DNS name, IP address, URI or
+// email address) must match in full; an empty pattern rejects CSRs
+// that carry any SAN.
+func NewCSRPolicy(sanPattern string) (*CSRPolicy, error) {
+	p := &CSRPolicy{
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+	if sanPattern != "" {
+		re, err := regexp.Compile("^(?:" + sanPattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("pki: invalid SAN pattern: %w", err)
+		}
+		p.sanPattern = re
+	}
+	return p, nil
+}
+
+// Check validates csrPEM for agentID and records its public key. It
+// returns a *PolicyViolation when the CSR is rejected.
+func (p *CSRPolicy) Check(csrPEM []byte, agentID string) error {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return &PolicyViolation{Field: "csr", Reason: "not a PEM-encoded certificate request"}
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return &PolicyViolation{Field: "csr", Reason: err.Error()}
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return &PolicyViolation{Field: "csr", Reason: "signature invalid: " + err.Error()}
+	}
+
+	if csr.Subject.CommonName != agentID {
+		return &PolicyViolation{
+			Field:  "common_name",
+			Reason: fmt.Sprintf("CN %q does not match agent ID %q", csr.Subject.CommonName, agentID),
+			Denied: true,
+		}
+	}
+
+	if err := checkKey(csr.PublicKey); err != nil {
+		return err
+	}
+
+	if err := p.checkSANs(csr); err != nil {
+		return err
+	}
+
+	return p.recordKey(csr.RawSubjectPublicKeyInfo)
+}
+
+// checkKey accepts ECDSA keys on P-256 and P-384.
+func checkKey(pub any) error {
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return &PolicyViolation{Field: "public_key", Reason: fmt.Sprintf("unsupported key type %T, expected ECDSA", pub)}
+	}
+	switch key.Curve {
+	case elliptic.P256(), elliptic.P384():
+		return nil
+	default:
+		return &PolicyViolation{Field: "public_key", Reason: fmt.Sprintf("unsupported curve %s, expected P-256 or P-384", key.Curve.Params().Name)}
+	}
+}
+
+// checkSANs matches every SAN against the configured pattern.
+func (p *CSRPolicy) checkSANs(csr *x509.CertificateRequest) error {
+	sans := append([]string{}, csr.DNSNames...)
+	sans = append(sans, csr.EmailAddresses...)
+	for _, ip := range csr.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, san := range sans {
+		if p.sanPattern == nil {
+			return &PolicyViolation{Field: "san", Reason: fmt.Sprintf("subject alternative names are not allowed, got %q", san), Denied: true}
+		}
+		if !p.sanPattern.MatchString(san) {
+			return &PolicyViolation{Field: "san", Reason: fmt.Sprintf("%q does not match the allowed pattern", san), Denied: true}
+		}
+	}
+	return nil
+}
+
+// recordKey rejects a public key that was already seen within
+// keyReuseWindow and remembers it otherwise.
+func (p *CSRPolicy) recordKey(spki []byte) error {
+	sum := sha256.Sum256(spki)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for k, seen := range p.seen {
+		if now.Sub(seen) > keyReuseWindow {
+			delete(p.seen, k)
+		}
+	}
+	if _, ok := p.seen[fingerprint]; ok {
+		return &PolicyViolation{Field: "public_key", Reason: "public key was already used in a previous CSR; generate a new key", Denied: true}
+	}
+	p.seen[fingerprint] = now
+	return nil
+}
@@ -0,0 +1,118 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+// csrFor builds a PEM-encoded CSR signed by key.
+func csrFor(t *testing.T, key crypto.Signer, cn string, dnsNames ...string) []byte {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatalf("create CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func ecKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func TestCSRPolicy_Check(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		sanPattern string
+		csr        []byte
+		field      string // empty: accepted
+		denied     bool
+	}{
+		{name: "P-256", csr: csrFor(t, ecKey(t, elliptic.P256()), "agent-a")},
+		{name: "P-384", csr: csrFor(t, ecKey(t, elliptic.P384()), "agent-a")},
+		{name: "garbage", csr: []byte("garbage"), field: "csr"},
+		{name: "CN mismatch", csr: csrFor(t, ecKey(t, elliptic.P256()), "agent-b"), field: "common_name", denied: true},
+		{name: "P-521", csr: csrFor(t, ecKey(t, elliptic.P521()), "agent-a"), field: "public_key"},
+		{name: "Ed25519", csr: csrFor(t, edKey, "agent-a"), field: "public_key"},
+		{name: "SAN without pattern", csr: csrFor(t, ecKey(t, elliptic.P256()), "agent-a", "agent-a.example.com"), field: "san", denied: true},
+		{name: "SAN matching pattern", sanPattern: `[a-z0-9-]+\.agents\.example\.com`, csr: csrFor(t, ecKey(t, elliptic.P256()), "agent-a", "agent-a.agents.example.com")},
+		{name: "SAN not matching pattern", sanPattern: `[a-z0-9-]+\.agents\.example\.com`, csr: csrFor(t, ecKey(t, elliptic.P256()), "agent-a", "evil.example.com"), field: "san", denied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewCSRPolicy(tt.sanPattern)
+			if err != nil {
+				t.Fatalf("NewCSRPolicy: %v", err)
+			}
+			err = p.Check(tt.csr, "agent-a")
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("expected CSR to be accepted, got %v", err)
+				}
+				return
+			}
+			var v *PolicyViolation
+			if !errors.As(err, &v) {
+				t.Fatalf("expected a PolicyViolation, got %v", err)
+			}
+			if v.Field != tt.field || v.Denied != tt.denied {
+				t.Errorf("expected field %q denied=%v, got %q denied=%v (%s)", tt.field, tt.denied, v.Field, v.Denied, v.Reason)
+			}
+		})
+	}
+}
+
+func TestCSRPolicy_KeyReuse(t *testing.T) {
+	p, err := NewCSRPolicy("")
+	if err != nil {
+		t.Fatalf("NewCSRPolicy: %v", err)
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	key := ecKey(t, elliptic.P256())
+	if err := p.Check(csrFor(t, key, "agent-a"), "agent-a"); err != nil {
+		t.Fatalf("first CSR: %v", err)
+	}
+
+	// A second CSR with the same key is rejected, even though the CSR
+	// itself differs.
+	err = p.Check(csrFor(t, key, "agent-a"), "agent-a")
+	var v *PolicyViolation
+	if !errors.As(err, &v) || v.Field != "public_key" || !v.Denied {
+		t.Fatalf("expected key reuse to be denied, got %v", err)
+	}
+
+	// Keys are forgotten after the reuse window.
+	now = now.Add(keyReuseWindow + time.Minute)
+	if err := p.Check(csrFor(t, key, "agent-a"), "agent-a"); err != nil {
+		t.Errorf("expected the key to be accepted after the reuse window, got %v", err)
+	}
+}
+
+func TestNewCSRPolicy_InvalidPattern(t *testing.T) {
+	if _, err := NewCSRPolicy("("); err == nil {
+		t.Fatal("expected an error for an invalid SAN pattern")
+	}
+}
@@ -0,0 +1,73 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// renewalContext domain-separates renewal proofs from any other
+// signature made with an agent key.
+const renewalContext = "otterscale-link-renewal-v1"
+
+// SignRenewalProof signs a renewal request for cluster with the
+// agent's current private key. The proof binds the new CSR to the
+// certificate being renewed, so only the holder of the current key
+// can obtain its successor.
+func SignRenewalProof(keyPEM []byte, cluster string, csrPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("pki: failed to decode private key PEM")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("pki: parse private key: %w", err)
+	}
+	proof, err := ecdsa.SignASN1(rand.Reader, key, renewalDigest(cluster, csrPEM))
+	if err != nil {
+		return nil, fmt.Errorf("pki: sign renewal proof: %w", err)
+	}
+	return proof, nil
+}
+
+// VerifyRenewal checks that certPEM is a currently valid client
+// certificate issued by a trusted authority and that proof was made
+// with its key over cluster and csrPEM. It returns the parsed
+// certificate so that callers can match it against their own state.
+func (ca *CA) VerifyRenewal(certPEM []byte, cluster string, csrPEM, proof []byte) (*x509.Certificate, error) {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       ca.TrustPool(),
+		CurrentTime: time.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("pki: current certificate not trusted: %w", err)
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("pki: current certificate does not contain an ECDSA public key")
+	}
+	if !ecdsa.VerifyASN1(pub, renewalDigest(cluster, csrPEM), proof) {
+		return nil, fmt.Errorf("pki: renewal proof does not match the current certificate")
+	}
+	return cert, nil
+}
+
+// renewalDigest hashes the fields covered by a renewal proof.
+func renewalDigest(cluster string, csrPEM []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(renewalContext))
+	h.Write([]byte{0})
+	h.Write([]byte(cluster))
+	h.Write([]byte{0})
+	h.Write(csrPEM)
+	return h.Sum(nil)
+}
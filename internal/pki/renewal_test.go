@@ -0,0 +1,133 @@
+package pki
+
+import (
+	"testing"
+)
+
+// issueAgent signs a fresh CSR for agentID and returns the certificate
+// and private key PEM.
+func issueAgent(t *testing.T, ca *CA, agentID string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, keyPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csrPEM, err := GenerateCSR(key, agentID)
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	certPEM, err = ca.SignCSR(csrPEM)
+	if err != nil {
+		t.Fatalf("SignCSR: %v", err)
+	}
+	return certPEM, keyPEM
+}
+
+// newCSR returns a CSR for agentID under a fresh key.
+func newCSR(t *testing.T, agentID string) []byte {
+	t.Helper()
+	key, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csrPEM, err := GenerateCSR(key, agentID)
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	return csrPEM
+}
+
+func TestVerifyRenewal(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	certPEM, keyPEM := issueAgent(t, ca, "agent-a")
+	csrPEM := newCSR(t, "agent-a")
+
+	proof, err := SignRenewalProof(keyPEM, "cluster-a", csrPEM)
+	if err != nil {
+		t.Fatalf("SignRenewalProof: %v", err)
+	}
+
+	cert, err := ca.VerifyRenewal(certPEM, "cluster-a", csrPEM, proof)
+	if err != nil {
+		t.Fatalf("VerifyRenewal: %v", err)
+	}
+	if cert.Subject.CommonName != "agent-a" {
+		t.Errorf("expected CN agent-a, got %q", cert.Subject.CommonName)
+	}
+}
+
+func TestVerifyRenewal_Rejects(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	other, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+
+	certPEM, keyPEM := issueAgent(t, ca, "agent-a")
+	_, otherKeyPEM := issueAgent(t, ca, "agent-a")
+	untrustedPEM, untrustedKeyPEM := issueAgent(t, other, "agent-a")
+	csrPEM := newCSR(t, "agent-a")
+
+	sign := func(keyPEM []byte, cluster string, csrPEM []byte) []byte {
+		proof, err := SignRenewalProof(keyPEM, cluster, csrPEM)
+		if err != nil {
+			t.Fatalf("SignRenewalProof: %v", err)
+		}
+		return proof
+	}
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		cluster string
+		csrPEM  []byte
+		proof   []byte
+	}{
+		{"wrong key", certPEM, "cluster-a", csrPEM, sign(otherKeyPEM, "cluster-a", csrPEM)},
+		{"wrong cluster", certPEM, "cluster-b", csrPEM, sign(keyPEM, "cluster-a", csrPEM)},
+		{"swapped CSR", certPEM, "cluster-a", newCSR(t, "agent-a"), sign(keyPEM, "cluster-a", csrPEM)},
+		{"untrusted certificate", untrustedPEM, "cluster-a", csrPEM, sign(untrustedKeyPEM, "cluster-a", csrPEM)},
+		{"garbage proof", certPEM, "cluster-a", csrPEM, []byte("garbage")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ca.VerifyRenewal(tt.certPEM, tt.cluster, tt.csrPEM, tt.proof); err == nil {
+				t.Fatal("expected renewal to be rejected")
+			}
+		})
+	}
+}
+
+func TestVerifyRenewal_DuringRotation(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	certPEM, keyPEM := issueAgent(t, ca, "agent-a")
+
+	if _, err := ca.StartRotation(t.Context()); err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+
+	csrPEM := newCSR(t, "agent-a")
+	proof, err := SignRenewalProof(keyPEM, "cluster-a", csrPEM)
+	if err != nil {
+		t.Fatalf("SignRenewalProof: %v", err)
+	}
+	if _, err := ca.VerifyRenewal(certPEM, "cluster-a", csrPEM, proof); err != nil {
+		t.Fatalf("expected a certificate of the retiring CA to renew: %v", err)
+	}
+
+	if _, err := ca.FinishRotation(t.Context()); err != nil {
+		t.Fatalf("FinishRotation: %v", err)
+	}
+	if _, err := ca.VerifyRenewal(certPEM, "cluster-a", csrPEM, proof); err == nil {
+		t.Fatal("expected a certificate of the retired CA to be rejected")
+	}
+}
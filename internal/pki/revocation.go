@@ -0,0 +1,252 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RevocationStore persists the JSON-encoded revocation list so that
+// revocations survive server restarts.
+type RevocationStore interface {
+	// LoadRevocations returns the persisted list, or nil if nothing
+	// has been stored yet.
+	LoadRevocations(ctx context.Context) ([]byte, error)
+	// UpdateRevocations replaces the persisted list with the list fn
+	// returns for the currently persisted one, which is nil if nothing
+	// has been stored yet. If another writer changes the list before
+	// it is replaced, fn is called again with the new list. An error
+	// returned by fn aborts the update and is returned as is.
+	UpdateRevocations(ctx context.Context, fn func(data []byte) ([]byte, error)) error
+}
+
+// Revocation is a single deny-list entry. Exactly one of Serial and
+// AgentID is set: a serial entry rejects one certificate until it
+// would have expired anyway, an agent entry rejects every certificate
+// issued to that agent and prevents it from enrolling again until the
+// entry is removed.
+type Revocation struct {
+	Serial    string    `json:"serial,omitempty"`
+	AgentID   string    `json:"agentId,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	// ExpiresAt is when the entry can be dropped. Zero for agent
+	// entries, which never expire.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// RevocationList is the deny-list of agent certificates consulted on
+// every tunnel handshake and every registration. It is safe for
+// concurrent use.
+type RevocationList struct {
+	mu      sync.RWMutex
+	serials map[string]Revocation
+	agents  map[string]Revocation
+	store   RevocationStore // nil for in-memory lists
+}
+
+// NewRevocationList returns an empty in-memory RevocationList.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		serials: make(map[string]Revocation),
+		agents:  make(map[string]Revocation),
+	}
+}
+
+// LoadRevocationList loads the revocation list from store. Subsequent
+// changes are written back to the same store.
+func LoadRevocationList(ctx context.Context, store RevocationStore) (*RevocationList, error) {
+	l := NewRevocationList()
+
+	data, err := store.LoadRevocations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pki: load revocations: %w", err)
+	}
+	if err := l.decodeLocked(data); err != nil {
+		return nil, err
+	}
+
+	l.store = store
+	return l, nil
+}
+
+// RevokeSerial rejects the certificate with the given serial number.
+// The entry is kept for certValidity, after which the certificate has
+// expired on its own.
+func (l *RevocationList) RevokeSerial(ctx context.Context, serial, reason string) error {
+	now := time.Now()
+	return l.update(ctx, func() {
+		l.serials[serial] = Revocation{Serial: serial, Reason: reason, RevokedAt: now, ExpiresAt: now.Add(certValidity)}
+	})
+}
+
+// RevokeAgent rejects every certificate issued to agentID, including
+// future ones, until RestoreAgent is called.
+func (l *RevocationList) RevokeAgent(ctx context.Context, agentID, reason string) error {
+	now := time.Now()
+	return l.update(ctx, func() {
+		l.agents[agentID] = Revocation{AgentID: agentID, Reason: reason, RevokedAt: now}
+	})
+}
+
+// RestoreAgent removes the agent entry for agentID so that it may
+// enroll again. Serial entries for certificates it held before stay
+// in place. It reports whether an entry was removed.
+func (l *RevocationList) RestoreAgent(ctx context.Context, agentID string) (bool, error) {
+	var removed bool
+	err := l.update(ctx, func() {
+		_, removed = l.agents[agentID]
+		delete(l.agents, agentID)
+	})
+	return removed, err
+}
+
+// IsRevoked reports whether cert has been revoked, either by serial
+// number or through its agent ID (the certificate's common name).
+func (l *RevocationList) IsRevoked(cert *x509.Certificate) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.serials[SerialString(cert)]; ok {
+		return true
+	}
+	_, ok := l.agents[cert.Subject.CommonName]
+	return ok
+}
+
+// IsAgentRevoked reports whether agentID has been revoked.
+func (l *RevocationList) IsAgentRevoked(agentID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.agents[agentID]
+	return ok
+}
+
+// List returns every current entry, agent entries first, each group
+// sorted by key.
+func (l *RevocationList) List() []Revocation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.entriesLocked()
+}
+
+// Refresh reloads the list from the store, so that the revocations
+// made by other hub replicas take effect on this one. In-memory lists
+// are left unchanged.
+func (l *RevocationList) Refresh(ctx context.Context) error {
+	if l.store == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := l.store.LoadRevocations(ctx)
+	if err != nil {
+		return fmt.Errorf("pki: load revocations: %w", err)
+	}
+	return l.decodeLocked(data)
+}
+
+// update applies fn under the write lock to the persisted list, drops
+// expired serial entries and persists the result, so that concurrent
+// changes of other hub replicas are kept. The change is rolled back if
+// persisting fails, so the in-memory list never diverges from the
+// store.
+func (l *RevocationList) update(ctx context.Context, fn func()) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.store == nil {
+		fn()
+		l.pruneLocked(time.Now())
+		return nil
+	}
+
+	serials, agents := l.serials, l.agents
+	err := l.store.UpdateRevocations(ctx, func(data []byte) ([]byte, error) {
+		if err := l.decodeLocked(data); err != nil {
+			return nil, err
+		}
+		fn()
+		l.pruneLocked(time.Now())
+		return json.Marshal(l.entriesLocked())
+	})
+	if err != nil {
+		l.serials, l.agents = serials, agents
+		return fmt.Errorf("pki: persist revocations: %w", err)
+	}
+	return nil
+}
+
+// decodeLocked replaces the entries with the JSON-encoded list data.
+// l.mu must be held for writing, unless l is not shared yet.
+func (l *RevocationList) decodeLocked(data []byte) error {
+	var entries []Revocation
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("pki: decode revocations: %w", err)
+		}
+	}
+	l.serials = make(map[string]Revocation)
+	l.agents = make(map[string]Revocation)
+	for _, r := range entries {
+		l.add(r)
+	}
+	return nil
+}
+
+// add inserts a decoded entry into the matching index.
+func (l *RevocationList) add(r Revocation) {
+	switch {
+	case r.Serial != "":
+		l.serials[r.Serial] = r
+	case r.AgentID != "":
+		l.agents[r.AgentID] = r
+	}
+}
+
+// pruneLocked drops serial entries whose certificate has expired.
+// l.mu must be held for writing.
+func (l *RevocationList) pruneLocked(now time.Time) {
+	for serial, r := range l.serials {
+		if !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt) {
+			delete(l.serials, serial)
+		}
+	}
+}
+
+// entriesLocked returns every entry in a stable order. l.mu must be
+// held.
+func (l *RevocationList) entriesLocked() []Revocation {
+	ret := make([]Revocation, 0, len(l.agents)+len(l.serials))
+	for _, id := range slices.Sorted(maps.Keys(l.agents)) {
+		ret = append(ret, l.agents[id])
+	}
+	for _, serial := range slices.Sorted(maps.Keys(l.serials)) {
+		ret = append(ret, l.serials[serial])
+	}
+	return ret
+}
+
+// SerialString returns the hex-encoded serial number of cert, the key
+// used by the revocation list.
+func SerialString(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// CertificateSerial returns the hex-encoded serial number of a
+// PEM-encoded certificate.
+func CertificateSerial(certPEM []byte) (string, error) {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return "", err
+	}
+	return SerialString(cert), nil
+}
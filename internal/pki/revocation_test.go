@@ -0,0 +1,147 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memRevocationStore is an in-memory RevocationStore.
+type memRevocationStore struct {
+	data []byte
+	err  error
+}
+
+func (s *memRevocationStore) LoadRevocations(_ context.Context) ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memRevocationStore) UpdateRevocations(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	if s.err != nil {
+		return s.err
+	}
+	data, err := fn(s.data)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// parseAgentCert signs a fresh CSR for agentID and parses the result.
+func parseAgentCert(t *testing.T, ca *CA, agentID string) *x509.Certificate {
+	t.Helper()
+	key, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csrPEM, err := GenerateCSR(key, agentID)
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	certPEM, err := ca.SignCSR(csrPEM)
+	if err != nil {
+		t.Fatalf("SignCSR: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	return cert
+}
+
+func TestRevocationList_SerialAndAgent(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	first := parseAgentCert(t, ca, "agent-a")
+	second := parseAgentCert(t, ca, "agent-a")
+	other := parseAgentCert(t, ca, "agent-b")
+
+	l := NewRevocationList()
+	if err := l.RevokeSerial(t.Context(), SerialString(first), "compromised"); err != nil {
+		t.Fatalf("RevokeSerial: %v", err)
+	}
+	if !l.IsRevoked(first) {
+		t.Error("expected the revoked serial to be rejected")
+	}
+	if l.IsRevoked(second) || l.IsAgentRevoked("agent-a") {
+		t.Error("expected other certificates of the agent to stay valid")
+	}
+
+	if err := l.RevokeAgent(t.Context(), "agent-a", "compromised"); err != nil {
+		t.Fatalf("RevokeAgent: %v", err)
+	}
+	if !l.IsRevoked(second) || !l.IsAgentRevoked("agent-a") {
+		t.Error("expected every certificate of the revoked agent to be rejected")
+	}
+	if l.IsRevoked(other) {
+		t.Error("expected unrelated agents to stay valid")
+	}
+
+	removed, err := l.RestoreAgent(t.Context(), "agent-a")
+	if err != nil || !removed {
+		t.Fatalf("RestoreAgent: removed=%v err=%v", removed, err)
+	}
+	if l.IsRevoked(second) {
+		t.Error("expected the restored agent's other certificates to be accepted")
+	}
+	if !l.IsRevoked(first) {
+		t.Error("expected the serial entry to survive restoring the agent")
+	}
+}
+
+func TestRevocationList_Persistence(t *testing.T) {
+	store := &memRevocationStore{}
+	l, err := LoadRevocationList(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadRevocationList: %v", err)
+	}
+	if err := l.RevokeAgent(t.Context(), "agent-a", "test"); err != nil {
+		t.Fatalf("RevokeAgent: %v", err)
+	}
+	if err := l.RevokeSerial(t.Context(), "abc", "test"); err != nil {
+		t.Fatalf("RevokeSerial: %v", err)
+	}
+
+	reloaded, err := LoadRevocationList(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadRevocationList (reload): %v", err)
+	}
+	if got := reloaded.List(); len(got) != 2 || got[0].AgentID != "agent-a" || got[1].Serial != "abc" {
+		t.Errorf("unexpected entries after reload: %+v", got)
+	}
+}
+
+func TestRevocationList_PersistFailureRollsBack(t *testing.T) {
+	store := &memRevocationStore{}
+	l, err := LoadRevocationList(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadRevocationList: %v", err)
+	}
+
+	store.err = errors.New("disk full")
+	if err := l.RevokeAgent(t.Context(), "agent-a", "test"); err == nil {
+		t.Fatal("expected persist error")
+	}
+	if l.IsAgentRevoked("agent-a") {
+		t.Error("expected the failed revocation to be rolled back")
+	}
+}
+
+func TestRevocationList_PrunesExpiredSerials(t *testing.T) {
+	l := NewRevocationList()
+	l.serials["old"] = Revocation{Serial: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+
+	if err := l.RevokeSerial(t.Context(), "new", "test"); err != nil {
+		t.Fatalf("RevokeSerial: %v", err)
+	}
+	if got := l.List(); len(got) != 1 || got[0].Serial != "new" {
+		t.Errorf("expected the expired entry to be pruned, got %+v", got)
+	}
+}
@@ -0,0 +1,221 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// signAgent signs a fresh CSR with ca and returns the certificate PEM.
+func signAgent(t *testing.T, ca *CA) []byte {
+	t.Helper()
+	key, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csrPEM, err := GenerateCSR(key, "agent")
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	certPEM, err := ca.SignCSR(csrPEM)
+	if err != nil {
+		t.Fatalf("SignCSR: %v", err)
+	}
+	return certPEM
+}
+
+// verifies reports whether certPEM chains to the CA's trust pool for
+// the given usage.
+func verifies(t *testing.T, ca *CA, certPEM []byte, usage x509.ExtKeyUsage) bool {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: ca.TrustPool(), KeyUsages: []x509.ExtKeyUsage{usage}})
+	return err == nil
+}
+
+func TestCA_Rotation_DualTrust(t *testing.T) {
+	store := &memStore{}
+	ca, err := LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	oldFingerprint := ca.Rotation().Active.Fingerprint
+	oldAgent := signAgent(t, ca)
+
+	status, err := ca.StartRotation(t.Context())
+	if err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	if status.Retiring == nil || status.Retiring.Fingerprint != oldFingerprint {
+		t.Fatalf("expected the previous CA to be retiring, got %+v", status.Retiring)
+	}
+	if status.Active.Fingerprint == oldFingerprint {
+		t.Fatal("expected a new active CA")
+	}
+
+	// Both CAs are trusted and advertised.
+	if got := bytes.Count(ca.TrustBundlePEM(), []byte("BEGIN CERTIFICATE")); got != 2 {
+		t.Errorf("expected 2 certificates in the trust bundle, got %d", got)
+	}
+	newAgent := signAgent(t, ca)
+	if !verifies(t, ca, oldAgent, x509.ExtKeyUsageClientAuth) || !verifies(t, ca, newAgent, x509.ExtKeyUsageClientAuth) {
+		t.Error("expected agents of both CAs to be trusted during the rotation")
+	}
+
+	// New CSRs are signed by the active CA; the server certificate
+	// still comes from the retiring one.
+	if issuer, err := ca.Issuer(newAgent); err != nil || issuer != status.Active.Fingerprint {
+		t.Errorf("expected new agent cert from the active CA, got %q (%v)", issuer, err)
+	}
+	serverCert, _, err := ca.GenerateServerCert("localhost")
+	if err != nil {
+		t.Fatalf("GenerateServerCert: %v", err)
+	}
+	if issuer, err := ca.Issuer(serverCert); err != nil || issuer != oldFingerprint {
+		t.Errorf("expected server cert from the retiring CA, got %q (%v)", issuer, err)
+	}
+
+	if _, err := ca.StartRotation(t.Context()); !errors.Is(err, ErrRotationInProgress) {
+		t.Errorf("expected ErrRotationInProgress, got %v", err)
+	}
+
+	// A restart mid-rotation reloads both CAs.
+	reloaded, err := LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (restart): %v", err)
+	}
+	if got := reloaded.Rotation(); got.Retiring == nil || got.Active != status.Active {
+		t.Errorf("expected the rotation to survive a restart, got %+v", got)
+	}
+
+	status, err = ca.FinishRotation(t.Context())
+	if err != nil {
+		t.Fatalf("FinishRotation: %v", err)
+	}
+	if status.Retiring != nil {
+		t.Error("expected no retiring CA after finishing the rotation")
+	}
+	if verifies(t, ca, oldAgent, x509.ExtKeyUsageClientAuth) {
+		t.Error("expected the retired CA to be untrusted")
+	}
+	if !verifies(t, ca, newAgent, x509.ExtKeyUsageClientAuth) {
+		t.Error("expected the active CA to stay trusted")
+	}
+	if _, err := ca.FinishRotation(t.Context()); !errors.Is(err, ErrNoRotation) {
+		t.Errorf("expected ErrNoRotation, got %v", err)
+	}
+
+	reloaded, err = LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (after finish): %v", err)
+	}
+	if !bytes.Equal(reloaded.TrustBundlePEM(), ca.CertPEM()) {
+		t.Error("expected only the active CA to be persisted after finishing the rotation")
+	}
+}
+
+func TestCA_Rotation_HMACKeyStable(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	before, err := ca.DeriveHMACKey("label")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	if _, err := ca.StartRotation(t.Context()); err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	during, err := ca.DeriveHMACKey("label")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	if !bytes.Equal(before, during) {
+		t.Error("expected starting a rotation not to change the HMAC key")
+	}
+}
+
+// TestCA_Rotation_HMACSecret verifies that with a persisted HMAC
+// secret the HMAC key stays the one derived from the CA key before the
+// secret was persisted, and survives finishing a rotation.
+func TestCA_Rotation_HMACSecret(t *testing.T) {
+	store := &memStore{}
+	legacy, err := LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	before, err := legacy.DeriveHMACKey("label")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+
+	ca, err := LoadOrCreateCA(t.Context(), store, WithHMACSecretStore(store))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (with HMAC secret): %v", err)
+	}
+	if store.hmacSecret == nil {
+		t.Fatal("expected the HMAC secret to be persisted")
+	}
+	if _, err := ca.StartRotation(t.Context()); err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	if _, err := ca.FinishRotation(t.Context()); err != nil {
+		t.Fatalf("FinishRotation: %v", err)
+	}
+
+	for name, ca := range map[string]*CA{"rotated": ca, "reloaded": mustLoad(t, store)} {
+		after, err := ca.DeriveHMACKey("label")
+		if err != nil {
+			t.Fatalf("DeriveHMACKey (%s): %v", name, err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Errorf("expected the %s CA to keep the HMAC key", name)
+		}
+	}
+}
+
+// TestCA_Rotation_SharedStore verifies that CAs sharing a store decide
+// on the stored rotation state, and that Refresh picks up a rotation
+// another CA started.
+func TestCA_Rotation_SharedStore(t *testing.T) {
+	store := &memStore{}
+	a, b := mustLoad(t, store), mustLoad(t, store)
+
+	status, err := a.StartRotation(t.Context())
+	if err != nil {
+		t.Fatalf("StartRotation: %v", err)
+	}
+	if _, err := b.StartRotation(t.Context()); !errors.Is(err, ErrRotationInProgress) {
+		t.Fatalf("StartRotation on the other CA: got %v, want ErrRotationInProgress", err)
+	}
+	if got := b.Rotation(); got.Active != status.Active {
+		t.Errorf("other CA active = %s, want the rotated %s", got.Active.Fingerprint, status.Active.Fingerprint)
+	}
+
+	c := mustLoad(t, store)
+	if _, err := b.FinishRotation(t.Context()); err != nil {
+		t.Fatalf("FinishRotation on the other CA: %v", err)
+	}
+	if err := c.Refresh(t.Context()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := c.Rotation(); got.Retiring != nil || got.Active != status.Active {
+		t.Errorf("refreshed rotation = %+v, want the finished rotation", got)
+	}
+}
+
+// mustLoad loads the CA of store with its HMAC secret.
+func mustLoad(t *testing.T, store *memStore) *CA {
+	t.Helper()
+	ca, err := LoadOrCreateCA(t.Context(), store, WithHMACSecretStore(store))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	return ca
+}
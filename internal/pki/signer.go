@@ -0,0 +1,113 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by CAs whose key is held by an external Signer.
+var (
+	// ErrKeyNotExportable is returned by KeyPEM when the CA key never
+	// leaves its Signer.
+	ErrKeyNotExportable = errors.New("pki: CA key is held by an external signer")
+	// ErrRotationUnsupported is returned by StartRotation when the CA
+	// key is held by an external Signer, which cannot mint a new key
+	// on demand.
+	ErrRotationUnsupported = errors.New("pki: CA rotation requires an in-memory signer")
+)
+
+// publicKeyBlockType is the PEM block type persisted in place of the
+// private key when the CA key is held by an external Signer.
+const publicKeyBlockType = "PUBLIC KEY"
+
+// Signer holds a CA private key and performs every operation that
+// needs it, so that the key itself can stay outside the process (in
+// an HSM or a remote signing service).
+//
+// Sign must produce ASN.1 ECDSA signatures, as expected by
+// crypto/x509.
+type Signer interface {
+	crypto.Signer
+	// DeriveKey deterministically derives length bytes of secret
+	// material bound to the CA key and label. The result must be
+	// stable across restarts for the same key.
+	DeriveKey(label string, length int) ([]byte, error)
+}
+
+// CAOption configures how a CA is created or loaded.
+type CAOption func(*caOptions)
+
+type caOptions struct {
+	signer      Signer
+	hmacSecrets HMACSecretStore
+}
+
+// WithSigner makes the CA sign with an external Signer instead of an
+// in-memory key. A nil signer keeps the in-memory default.
+func WithSigner(signer Signer) CAOption {
+	return func(o *caOptions) { o.signer = signer }
+}
+
+// WithHMACSecretStore makes LoadOrCreateCA derive the keys of
+// DeriveHMACKey from the secret persisted in store rather than from
+// the CA key, so that they do not change when the CA is rotated.
+func WithHMACSecretStore(store HMACSecretStore) CAOption {
+	return func(o *caOptions) { o.hmacSecrets = store }
+}
+
+func newCAOptions(opts []CAOption) caOptions {
+	var o caOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// memorySigner is the default Signer, backed by an in-memory ECDSA
+// key that is persisted with the CA certificate.
+type memorySigner struct {
+	*ecdsa.PrivateKey
+}
+
+// NewMemorySigner returns a Signer backed by key.
+func NewMemorySigner(key *ecdsa.PrivateKey) Signer {
+	return memorySigner{key}
+}
+
+// DeriveKey derives the secret with HKDF (RFC 5869) keyed by the DER
+// encoding of the private key.
+func (s memorySigner) DeriveKey(label string, length int) ([]byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(s.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("pki: marshal key for HKDF: %w", err)
+	}
+	return hkdf.Key(sha256.New, keyDER, nil, label, length)
+}
+
+// isExternal reports whether the signer holds its key outside the
+// process.
+func isExternal(signer Signer) bool {
+	_, ok := signer.(memorySigner)
+	return !ok
+}
+
+// marshalPublicKey PEM-encodes the signer's public key.
+func marshalPublicKey(signer Signer) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("pki: marshal signer public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: publicKeyBlockType, Bytes: der}), nil
+}
+
+// samePublicKey reports whether a and b are the same public key.
+func samePublicKey(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
@@ -0,0 +1,125 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// externalSigner wraps an in-memory key so that the CA treats it as a
+// key held outside the process.
+type externalSigner struct {
+	memorySigner
+}
+
+func newExternalSigner(t *testing.T) externalSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return externalSigner{memorySigner{key}}
+}
+
+func TestLoadOrCreateCA_ExternalSigner(t *testing.T) {
+	store := &memStore{}
+	signer := newExternalSigner(t)
+
+	ca, err := LoadOrCreateCA(t.Context(), store, WithSigner(signer))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+
+	// Only the public key is persisted.
+	block, _ := pem.Decode(store.keyPEM)
+	if block == nil || block.Type != publicKeyBlockType {
+		t.Fatalf("expected the store to hold a public key, got %q", store.keyPEM)
+	}
+	if _, err := ca.KeyPEM(); !errors.Is(err, ErrKeyNotExportable) {
+		t.Errorf("expected ErrKeyNotExportable, got %v", err)
+	}
+
+	// Agent certificates chain to the signer's key.
+	certPEM := signAgent(t, ca)
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("parse agent cert: %v", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     ca.TrustPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("agent certificate does not verify: %v", err)
+	}
+
+	// A restart with the same signer reloads the same CA and HMAC key.
+	reloaded, err := LoadOrCreateCA(t.Context(), store, WithSigner(signer))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (reload): %v", err)
+	}
+	if string(reloaded.CertPEM()) != string(ca.CertPEM()) {
+		t.Error("expected the reloaded CA to match the persisted one")
+	}
+	k1, err := ca.DeriveHMACKey("label")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	k2, err := reloaded.DeriveHMACKey("label")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey (reload): %v", err)
+	}
+	if string(k1) != string(k2) {
+		t.Error("expected a stable HMAC key across restarts")
+	}
+
+	if _, err := ca.StartRotation(t.Context()); !errors.Is(err, ErrRotationUnsupported) {
+		t.Errorf("expected ErrRotationUnsupported, got %v", err)
+	}
+}
+
+func TestLoadOrCreateCA_SignerMismatch(t *testing.T) {
+	store := &memStore{}
+	if _, err := LoadOrCreateCA(t.Context(), store, WithSigner(newExternalSigner(t))); err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+
+	if _, err := LoadOrCreateCA(t.Context(), store, WithSigner(newExternalSigner(t))); err == nil {
+		t.Error("expected a different signer key to be rejected")
+	}
+	if _, err := LoadOrCreateCA(t.Context(), store); err == nil {
+		t.Error("expected an in-memory CA to reject material of an external signer")
+	}
+}
+
+func TestMemorySigner_DeriveKeyStable(t *testing.T) {
+	// The in-memory signer must keep deriving the same HMAC keys as
+	// before signers were introduced, so existing tokens stay valid.
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		t.Fatalf("KeyPEM: %v", err)
+	}
+	loaded, err := LoadCA(ca.CertPEM(), keyPEM)
+	if err != nil {
+		t.Fatalf("LoadCA: %v", err)
+	}
+
+	k1, err := ca.DeriveHMACKey("manifest")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	k2, err := loaded.DeriveHMACKey("manifest")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey (loaded): %v", err)
+	}
+	if string(k1) != string(k2) || len(k1) != 32 {
+		t.Errorf("expected a stable 32-byte key, got %x and %x", k1, k2)
+	}
+}
@@ -0,0 +1,188 @@
+package pki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sentinel errors returned by CAStore implementations.
+var (
+	// ErrCANotFound indicates that no CA material has been persisted
+	// yet. LoadOrCreateCA reacts to it by generating a fresh CA.
+	ErrCANotFound = errors.New("pki: CA not found")
+	// ErrCAExists indicates that CAStore.Create lost a race against
+	// another writer (or the CA was persisted in the meantime).
+	ErrCAExists = errors.New("pki: CA already exists")
+	// ErrCAIncomplete indicates that only part of the CA material is
+	// visible, typically because a concurrent writer has not finished
+	// persisting it yet.
+	ErrCAIncomplete = errors.New("pki: CA material incomplete")
+	// ErrHMACSecretExists indicates that HMACSecretStore.CreateHMACSecret
+	// lost a race against another writer.
+	ErrHMACSecretExists = errors.New("pki: HMAC secret already exists")
+)
+
+// CAStore persists the PEM-encoded CA certificate and private key so
+// that server restarts reload the same CA instead of invalidating
+// every agent certificate and every HMAC-derived token.
+//
+// Create must be atomic with respect to other writers: exactly one
+// concurrent caller succeeds, all others receive ErrCAExists. Update
+// must replace both files atomically so that a crash never leaves a
+// certificate paired with the wrong key, and must not overwrite a
+// change another writer made after the material was read.
+type CAStore interface {
+	// Load returns the persisted certificate and key. It returns
+	// ErrCANotFound when nothing has been stored yet.
+	Load(ctx context.Context) (certPEM, keyPEM []byte, err error)
+	// Create persists the given material if and only if no CA has
+	// been stored yet. It returns ErrCAExists otherwise.
+	Create(ctx context.Context, certPEM, keyPEM []byte) error
+	// Update replaces the persisted material with the material fn
+	// returns for the currently persisted one. If another writer
+	// changes the material before it is replaced, fn is called again
+	// with the new material. An error returned by fn aborts the update
+	// and is returned as is. It is used by CA rotation once the CA has
+	// been created.
+	Update(ctx context.Context, fn func(certPEM, keyPEM []byte) ([]byte, []byte, error)) error
+}
+
+// HMACSecretStore persists the secret the keys of CA.DeriveHMACKey are
+// derived from. The secret is kept apart from the CA key pair so that
+// the derived keys, and every token signed with them, survive CA
+// rotations and agree across hub replicas.
+type HMACSecretStore interface {
+	// LoadHMACSecret returns the persisted secret, or nil if none has
+	// been stored yet.
+	LoadHMACSecret(ctx context.Context) ([]byte, error)
+	// CreateHMACSecret persists secret if and only if none has been
+	// stored yet. It returns ErrHMACSecretExists otherwise.
+	CreateHMACSecret(ctx context.Context, secret []byte) error
+}
+
+// loadRetryInterval is the delay between Load attempts while waiting
+// for a concurrent writer to finish persisting the CA.
+const loadRetryInterval = 200 * time.Millisecond
+
+// LoadOrCreateCA loads the CA from store, generating and persisting a
+// new one on first boot. When two servers race on first boot, the
+// loser of the Create call discards its freshly generated CA and
+// reloads the winner's, so every replica converges on the same CA.
+//
+// The returned CA keeps a reference to store so that rotations are
+// persisted as well.
+//
+// With WithSigner, the CA key is held by the signer: the store only
+// receives the certificate and the signer's public key, and a stored
+// CA is only accepted if it matches the signer's key.
+//
+// With WithHMACSecretStore, the keys of DeriveHMACKey are derived from
+// the secret persisted there, which is created from the loaded CA on
+// first boot.
+func LoadOrCreateCA(ctx context.Context, store CAStore, opts ...CAOption) (*CA, error) {
+	o := newCAOptions(opts)
+	ca, err := loadOrCreateCA(ctx, store, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.hmacSecrets != nil {
+		if ca.hmacSecret, err = loadOrCreateHMACSecret(ctx, o.hmacSecrets, ca); err != nil {
+			return nil, err
+		}
+	}
+	ca.store = store
+	return ca, nil
+}
+
+// loadOrCreateHMACSecret loads the HMAC secret from store, persisting
+// the seed of ca if none has been stored yet. When two servers race on
+// first boot, the loser adopts the winner's secret.
+func loadOrCreateHMACSecret(ctx context.Context, store HMACSecretStore, ca *CA) ([]byte, error) {
+	secret, err := store.LoadHMACSecret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pki: load HMAC secret: %w", err)
+	}
+	if secret != nil {
+		return secret, nil
+	}
+
+	secret, err = ca.hmacSeed()
+	if err != nil {
+		return nil, err
+	}
+	err = store.CreateHMACSecret(ctx, secret)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, ErrHMACSecretExists) {
+		return nil, fmt.Errorf("pki: persist HMAC secret: %w", err)
+	}
+	secret, err = store.LoadHMACSecret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pki: load HMAC secret: %w", err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("pki: load HMAC secret: concurrently created secret not found")
+	}
+	return secret, nil
+}
+
+// loadOrCreateCA implements LoadOrCreateCA.
+func loadOrCreateCA(ctx context.Context, store CAStore, o caOptions) (*CA, error) {
+	ca, err := loadCA(ctx, store, o)
+	if err == nil {
+		return ca, nil
+	}
+	if !errors.Is(err, ErrCANotFound) {
+		return nil, err
+	}
+
+	ca, err = NewCA(WithSigner(o.signer))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ca.active.keyPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Create(ctx, ca.CertPEM(), keyPEM)
+	if err == nil {
+		return ca, nil
+	}
+	if !errors.Is(err, ErrCAExists) {
+		return nil, fmt.Errorf("pki: persist CA: %w", err)
+	}
+
+	// Another writer won the race; wait for its material to become
+	// fully visible and adopt it.
+	for {
+		ca, err := loadCA(ctx, store, o)
+		if err == nil {
+			return ca, nil
+		}
+		if !errors.Is(err, ErrCAIncomplete) && !errors.Is(err, ErrCANotFound) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("pki: wait for concurrently created CA: %w", err)
+		case <-time.After(loadRetryInterval):
+		}
+	}
+}
+
+// loadCA reads the CA material from store and parses it.
+func loadCA(ctx context.Context, store CAStore, o caOptions) (*CA, error) {
+	certPEM, keyPEM, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if o.signer != nil {
+		return LoadExternalCA(certPEM, keyPEM, o.signer)
+	}
+	return LoadCA(certPEM, keyPEM)
+}
@@ -0,0 +1,126 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memStore is an in-memory CAStore and HMACSecretStore used to
+// exercise LoadOrCreateCA.
+type memStore struct {
+	mu         sync.Mutex
+	certPEM    []byte
+	keyPEM     []byte
+	creates    int
+	hmacSecret []byte
+}
+
+func (s *memStore) Load(_ context.Context) (certPEM, keyPEM []byte, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certPEM == nil {
+		return nil, nil, ErrCANotFound
+	}
+	return s.certPEM, s.keyPEM, nil
+}
+
+func (s *memStore) Create(_ context.Context, certPEM, keyPEM []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certPEM != nil {
+		return ErrCAExists
+	}
+	s.certPEM, s.keyPEM = certPEM, keyPEM
+	s.creates++
+	return nil
+}
+
+func (s *memStore) Update(_ context.Context, fn func(certPEM, keyPEM []byte) ([]byte, []byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	certPEM, keyPEM, err := fn(s.certPEM, s.keyPEM)
+	if err != nil {
+		return err
+	}
+	s.certPEM, s.keyPEM = certPEM, keyPEM
+	return nil
+}
+
+func (s *memStore) LoadHMACSecret(_ context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hmacSecret, nil
+}
+
+func (s *memStore) CreateHMACSecret(_ context.Context, secret []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hmacSecret != nil {
+		return ErrHMACSecretExists
+	}
+	s.hmacSecret = secret
+	return nil
+}
+
+func TestLoadOrCreateCA_PersistsOnFirstBoot(t *testing.T) {
+	store := &memStore{}
+
+	first, err := LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	second, err := LoadOrCreateCA(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (reload): %v", err)
+	}
+
+	if !bytes.Equal(first.CertPEM(), second.CertPEM()) {
+		t.Error("expected the reloaded CA to match the persisted one")
+	}
+	if store.creates != 1 {
+		t.Errorf("expected exactly one Create, got %d", store.creates)
+	}
+}
+
+func TestLoadOrCreateCA_ConcurrentFirstBoot(t *testing.T) {
+	store := &memStore{}
+
+	const writers = 8
+	certs := make([][]byte, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Go(func() {
+			ca, err := LoadOrCreateCA(t.Context(), store)
+			if err != nil {
+				t.Errorf("LoadOrCreateCA: %v", err)
+				return
+			}
+			certs[i] = ca.CertPEM()
+		})
+	}
+	wg.Wait()
+
+	for i := 1; i < writers; i++ {
+		if !bytes.Equal(certs[0], certs[i]) {
+			t.Fatalf("writer %d converged on a different CA", i)
+		}
+	}
+	if store.creates != 1 {
+		t.Errorf("expected exactly one Create, got %d", store.creates)
+	}
+}
+
+func TestLoadOrCreateCA_LoadError(t *testing.T) {
+	store := &memStore{certPEM: []byte("garbage"), keyPEM: []byte("garbage")}
+
+	_, err := LoadOrCreateCA(t.Context(), store)
+	if err == nil {
+		t.Fatal("expected error for corrupt CA material")
+	}
+	if errors.Is(err, ErrCANotFound) {
+		t.Error("corrupt material must not be treated as a missing CA")
+	}
+}
@@ -39,9 +39,9 @@ const ttlJitterFraction = 0.2
 
 // DiscoveryCache provides TTL-based caching with singleflight
 // deduplication for OpenAPI schemas. It implements
-// core.SchemaResolver and core.CacheEvictor, and reduces redundant
-// discovery API calls when multiple concurrent requests target the
-// same cluster.
+// core.SchemaResolver, core.CacheEvictor and core.ClusterEvictor, and
+// reduces redundant discovery API calls when multiple concurrent
+// requests target the same cluster.
 //
 // Entries are keyed at group/version granularity because the
 // Kubernetes OpenAPI v3 endpoint returns one document per GV. Caching
@@ -224,6 +224,20 @@ func (c *DiscoveryCache) StartEvictionLoop(ctx context.Context, interval time.Du
 	}
 }
 
+// EvictCluster drops every cached group/version of a deregistered
+// cluster.
+func (c *DiscoveryCache) EvictCluster(cluster string) {
+	prefix := cluster + "/"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.gvCache {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.gvCache, key)
+		}
+	}
+}
+
 // evictExpiredGVs removes expired entries from the group/version
 // cache. Must be called with mu held for writing.
 func (c *DiscoveryCache) evictExpiredGVs() {
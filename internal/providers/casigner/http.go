@@ -0,0 +1,185 @@
+package casigner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// Paths of the external signing service API, relative to its base URL.
+const (
+	httpPublicKeyPath = "/v1/public-key"
+	httpSignPath      = "/v1/sign"
+	httpDerivePath    = "/v1/derive"
+)
+
+// httpTimeout bounds every request to the external signing service.
+const httpTimeout = 10 * time.Second
+
+// maxHTTPResponseBytes bounds the size of a signing service response.
+const maxHTTPResponseBytes = 64 << 10
+
+// publicKeyResponse is returned by GET /v1/public-key.
+type publicKeyResponse struct {
+	// PublicKey is the PEM-encoded PKIX public key of the CA.
+	PublicKey string `json:"publicKey"`
+}
+
+// signRequest is sent to POST /v1/sign.
+type signRequest struct {
+	Digest []byte `json:"digest"`
+	// Hash names the hash function that produced Digest, e.g.
+	// "SHA-256".
+	Hash string `json:"hash"`
+}
+
+// signResponse is returned by POST /v1/sign.
+type signResponse struct {
+	// Signature is the ASN.1 DER-encoded ECDSA signature.
+	Signature []byte `json:"signature"`
+}
+
+// deriveRequest is sent to POST /v1/derive.
+type deriveRequest struct {
+	Label  string `json:"label"`
+	Length int    `json:"length"`
+}
+
+// deriveResponse is returned by POST /v1/derive.
+type deriveResponse struct {
+	Key []byte `json:"key"`
+}
+
+// HTTPSigner implements pki.Signer by delegating every private key
+// operation to an external signing service over HTTP(S). The service
+// holds the CA key and exposes three JSON endpoints: GET
+// /v1/public-key, POST /v1/sign and POST /v1/derive.
+type HTTPSigner struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	public  *ecdsa.PublicKey
+}
+
+var _ pki.Signer = (*HTTPSigner)(nil)
+
+// NewHTTPSigner connects to the signing service at baseURL and fetches
+// its public key. token, if non-empty, is sent as a bearer token.
+// roots, if non-nil, replaces the system roots for verifying the
+// service.
+func NewHTTPSigner(ctx context.Context, baseURL, token string, roots *x509.CertPool) (*HTTPSigner, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if roots != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+
+	s := &HTTPSigner{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: httpTimeout, Transport: transport},
+	}
+
+	var resp publicKeyResponse
+	if err := s.do(ctx, http.MethodGet, httpPublicKeyPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(resp.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("http signer: invalid public key PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("http signer: parse public key: %w", err)
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("http signer: public key is %T, expected ECDSA", pub)
+	}
+	s.public = ecPub
+	return s, nil
+}
+
+// Public returns the CA public key reported by the signing service.
+func (s *HTTPSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign asks the signing service to sign digest.
+func (s *HTTPSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var resp signResponse
+	req := signRequest{Digest: digest, Hash: opts.HashFunc().String()}
+	if err := s.do(context.Background(), http.MethodPost, httpSignPath, req, &resp); err != nil {
+		return nil, err
+	}
+	if !ecdsa.VerifyASN1(s.public, digest, resp.Signature) {
+		return nil, fmt.Errorf("http signer: signature does not verify against the CA public key")
+	}
+	return resp.Signature, nil
+}
+
+// DeriveKey asks the signing service for secret material bound to the
+// CA key and label.
+func (s *HTTPSigner) DeriveKey(label string, length int) ([]byte, error) {
+	var resp deriveResponse
+	if err := s.do(context.Background(), http.MethodPost, httpDerivePath, deriveRequest{Label: label, Length: length}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Key) != length {
+		return nil, fmt.Errorf("http signer: derived key has %d bytes, expected %d", len(resp.Key), length)
+	}
+	return resp.Key, nil
+}
+
+// do sends a JSON request to the signing service and decodes its JSON
+// response into out.
+func (s *HTTPSigner) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("http signer: encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("http signer: create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req) // #nosec G704 -- baseURL is operator configuration
+	if err != nil {
+		return fmt.Errorf("http signer: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseBytes))
+	if err != nil {
+		return fmt.Errorf("http signer: read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http signer: %s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("http signer: decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package casigner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+const testToken = "secret-token"
+
+// newStubService starts a signing service stub holding key.
+func newStubService(t *testing.T, key *ecdsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	authorized := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testToken {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("GET "+httpPublicKeyPath, authorized(func(w http.ResponseWriter, _ *http.Request) {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		_ = json.NewEncoder(w).Encode(publicKeyResponse{PublicKey: string(pemBytes)})
+	}))
+	mux.HandleFunc("POST "+httpSignPath, authorized(func(w http.ResponseWriter, r *http.Request) {
+		var req signRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig, err := ecdsa.SignASN1(rand.Reader, key, req.Digest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(signResponse{Signature: sig})
+	}))
+	mux.HandleFunc("POST "+httpDerivePath, authorized(func(w http.ResponseWriter, r *http.Request) {
+		var req deriveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out, err := pki.NewMemorySigner(key).DeriveKey(req.Label, req.Length)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(deriveResponse{Key: out})
+	}))
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// memStore is an in-memory pki.CAStore.
+type memStore struct {
+	certPEM, keyPEM []byte
+}
+
+func (s *memStore) Load(_ context.Context) (certPEM, keyPEM []byte, err error) {
+	if s.certPEM == nil {
+		return nil, nil, pki.ErrCANotFound
+	}
+	return s.certPEM, s.keyPEM, nil
+}
+
+func (s *memStore) Create(_ context.Context, certPEM, keyPEM []byte) error {
+	if s.certPEM != nil {
+		return pki.ErrCAExists
+	}
+	s.certPEM, s.keyPEM = certPEM, keyPEM
+	return nil
+}
+
+func (s *memStore) Update(_ context.Context, fn func(certPEM, keyPEM []byte) ([]byte, []byte, error)) error {
+	certPEM, keyPEM, err := fn(s.certPEM, s.keyPEM)
+	if err != nil {
+		return err
+	}
+	s.certPEM, s.keyPEM = certPEM, keyPEM
+	return nil
+}
+
+func TestHTTPSigner_CA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	srv := newStubService(t, key)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	signer, err := NewHTTPSigner(t.Context(), srv.URL, testToken, roots)
+	if err != nil {
+		t.Fatalf("NewHTTPSigner: %v", err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("expected the service's public key")
+	}
+
+	store := &memStore{}
+	ca, err := pki.LoadOrCreateCA(t.Context(), store, pki.WithSigner(signer))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+
+	agentKey, _, err := pki.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csr, err := pki.GenerateCSR(agentKey, "agent-a")
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	certPEM, err := ca.SignCSR(csr)
+	if err != nil {
+		t.Fatalf("SignCSR: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     ca.TrustPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("agent certificate does not verify: %v", err)
+	}
+
+	if _, _, err := ca.GenerateServerCert("127.0.0.1"); err != nil {
+		t.Errorf("GenerateServerCert: %v", err)
+	}
+
+	hmacKey, err := ca.DeriveHMACKey("manifest")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	want, err := pki.NewMemorySigner(key).DeriveKey("manifest", len(hmacKey))
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if string(hmacKey) != string(want) {
+		t.Error("expected the HMAC key derived by the service")
+	}
+}
+
+func TestHTTPSigner_Unauthorized(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	srv := newStubService(t, key)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	if _, err := NewHTTPSigner(t.Context(), srv.URL, "wrong-token", roots); err == nil {
+		t.Fatal("expected an error for a rejected token")
+	}
+}
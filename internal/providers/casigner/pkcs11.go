@@ -0,0 +1,499 @@
+//go:build cgo
+
+package casigner
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Minimal subset of the PKCS#11 v2.40 headers (pkcs11t.h, pkcs11f.h)
+// needed to sign with a token-resident key. Only the members of
+// CK_FUNCTION_LIST up to C_Sign are declared; their order is fixed by
+// the standard.
+
+typedef unsigned long CK_ULONG;
+typedef unsigned char CK_BYTE;
+typedef CK_BYTE CK_BBOOL;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_FLAGS;
+typedef CK_ULONG CK_SLOT_ID;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+
+#define CKR_OK                              0x000
+#define CKR_USER_ALREADY_LOGGED_IN          0x100
+#define CKR_CRYPTOKI_ALREADY_INITIALIZED    0x191
+#define CKF_RW_SESSION                      0x002
+#define CKF_SERIAL_SESSION                  0x004
+#define CKF_OS_LOCKING_OK                   0x002
+#define CKU_USER                            1
+#define CKA_CLASS                           0x000
+#define CKA_LABEL                           0x003
+#define CKM_SHA256_HMAC                     0x251
+#define CKM_ECDSA                           0x1041
+
+typedef struct { CK_BYTE major; CK_BYTE minor; } CK_VERSION;
+
+typedef struct {
+	CK_ULONG type;
+	void *pValue;
+	CK_ULONG ulValueLen;
+} CK_ATTRIBUTE;
+
+typedef struct {
+	CK_ULONG mechanism;
+	void *pParameter;
+	CK_ULONG ulParameterLen;
+} CK_MECHANISM;
+
+typedef struct {
+	CK_BYTE label[32];
+	CK_BYTE manufacturerID[32];
+	CK_BYTE model[16];
+	CK_BYTE serialNumber[16];
+	CK_FLAGS flags;
+	CK_ULONG ulMaxSessionCount;
+	CK_ULONG ulSessionCount;
+	CK_ULONG ulMaxRwSessionCount;
+	CK_ULONG ulRwSessionCount;
+	CK_ULONG ulMaxPinLen;
+	CK_ULONG ulMinPinLen;
+	CK_ULONG ulTotalPublicMemory;
+	CK_ULONG ulFreePublicMemory;
+	CK_ULONG ulTotalPrivateMemory;
+	CK_ULONG ulFreePrivateMemory;
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	CK_BYTE utcTime[16];
+} CK_TOKEN_INFO;
+
+typedef struct {
+	void *CreateMutex;
+	void *DestroyMutex;
+	void *LockMutex;
+	void *UnlockMutex;
+	CK_FLAGS flags;
+	void *pReserved;
+} CK_C_INITIALIZE_ARGS;
+
+typedef struct {
+	CK_VERSION version;
+	CK_RV (*C_Initialize)(void *);
+	CK_RV (*C_Finalize)(void *);
+	void *C_GetInfo;
+	void *C_GetFunctionList;
+	CK_RV (*C_GetSlotList)(CK_BBOOL, CK_SLOT_ID *, CK_ULONG *);
+	void *C_GetSlotInfo;
+	CK_RV (*C_GetTokenInfo)(CK_SLOT_ID, CK_TOKEN_INFO *);
+	void *C_GetMechanismList;
+	void *C_GetMechanismInfo;
+	void *C_InitToken;
+	void *C_InitPIN;
+	void *C_SetPIN;
+	CK_RV (*C_OpenSession)(CK_SLOT_ID, CK_FLAGS, void *, void *, CK_SESSION_HANDLE *);
+	CK_RV (*C_CloseSession)(CK_SESSION_HANDLE);
+	void *C_CloseAllSessions;
+	void *C_GetSessionInfo;
+	void *C_GetOperationState;
+	void *C_SetOperationState;
+	CK_RV (*C_Login)(CK_SESSION_HANDLE, CK_ULONG, CK_BYTE *, CK_ULONG);
+	void *C_Logout;
+	void *C_CreateObject;
+	void *C_CopyObject;
+	void *C_DestroyObject;
+	void *C_GetObjectSize;
+	CK_RV (*C_GetAttributeValue)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	void *C_SetAttributeValue;
+	CK_RV (*C_FindObjectsInit)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*C_FindObjects)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *);
+	CK_RV (*C_FindObjectsFinal)(CK_SESSION_HANDLE);
+	void *C_EncryptInit;
+	void *C_Encrypt;
+	void *C_EncryptUpdate;
+	void *C_EncryptFinal;
+	void *C_DecryptInit;
+	void *C_Decrypt;
+	void *C_DecryptUpdate;
+	void *C_DecryptFinal;
+	void *C_DigestInit;
+	void *C_Digest;
+	void *C_DigestUpdate;
+	void *C_DigestKey;
+	void *C_DigestFinal;
+	CK_RV (*C_SignInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*C_Sign)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+} CK_FUNCTION_LIST;
+
+typedef CK_RV (*get_function_list_fn)(CK_FUNCTION_LIST **);
+
+static void *p11_open(const char *path, CK_FUNCTION_LIST **fl, CK_RV *rv) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) {
+		return NULL;
+	}
+	get_function_list_fn get = (get_function_list_fn)dlsym(handle, "C_GetFunctionList");
+	if (get == NULL) {
+		dlclose(handle);
+		return NULL;
+	}
+	*rv = get(fl);
+	if (*rv != CKR_OK) {
+		dlclose(handle);
+		return NULL;
+	}
+	return handle;
+}
+
+static CK_RV p11_initialize(CK_FUNCTION_LIST *fl) {
+	CK_C_INITIALIZE_ARGS args;
+	memset(&args, 0, sizeof(args));
+	args.flags = CKF_OS_LOCKING_OK;
+	CK_RV rv = fl->C_Initialize(&args);
+	return rv == CKR_CRYPTOKI_ALREADY_INITIALIZED ? CKR_OK : rv;
+}
+
+static void p11_close(void *handle, CK_FUNCTION_LIST *fl, CK_SESSION_HANDLE session) {
+	if (session != 0) {
+		fl->C_CloseSession(session);
+	}
+	fl->C_Finalize(NULL);
+	dlclose(handle);
+}
+
+static CK_RV p11_slots(CK_FUNCTION_LIST *fl, CK_SLOT_ID *slots, CK_ULONG *count) {
+	return fl->C_GetSlotList(1, slots, count);
+}
+
+static CK_RV p11_token_label(CK_FUNCTION_LIST *fl, CK_SLOT_ID slot, CK_BYTE *label) {
+	CK_TOKEN_INFO info;
+	CK_RV rv = fl->C_GetTokenInfo(slot, &info);
+	if (rv == CKR_OK) {
+		memcpy(label, info.label, sizeof(info.label));
+	}
+	return rv;
+}
+
+static CK_RV p11_login(CK_FUNCTION_LIST *fl, CK_SLOT_ID slot, char *pin, CK_ULONG pin_len, CK_SESSION_HANDLE *session) {
+	CK_RV rv = fl->C_OpenSession(slot, CKF_SERIAL_SESSION | CKF_RW_SESSION, NULL, NULL, session);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = fl->C_Login(*session, CKU_USER, (CK_BYTE *)pin, pin_len);
+	return rv == CKR_USER_ALREADY_LOGGED_IN ? CKR_OK : rv;
+}
+
+static CK_RV p11_find(CK_FUNCTION_LIST *fl, CK_SESSION_HANDLE session, CK_ULONG class, char *label, CK_ULONG label_len, CK_OBJECT_HANDLE *obj, CK_ULONG *found) {
+	CK_ATTRIBUTE tmpl[2] = {
+		{CKA_CLASS, &class, sizeof(class)},
+		{CKA_LABEL, label, label_len},
+	};
+	CK_RV rv = fl->C_FindObjectsInit(session, tmpl, 2);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = fl->C_FindObjects(session, obj, 1, found);
+	CK_RV final = fl->C_FindObjectsFinal(session);
+	return rv != CKR_OK ? rv : final;
+}
+
+static CK_RV p11_attribute(CK_FUNCTION_LIST *fl, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE obj, CK_ULONG type, void *value, CK_ULONG *len) {
+	CK_ATTRIBUTE attr = {type, value, *len};
+	CK_RV rv = fl->C_GetAttributeValue(session, obj, &attr, 1);
+	*len = attr.ulValueLen;
+	return rv;
+}
+
+static CK_RV p11_sign(CK_FUNCTION_LIST *fl, CK_SESSION_HANDLE session, CK_ULONG mechanism, CK_OBJECT_HANDLE key, CK_BYTE *data, CK_ULONG data_len, CK_BYTE *sig, CK_ULONG *sig_len) {
+	CK_MECHANISM mech = {mechanism, NULL, 0};
+	CK_RV rv = fl->C_SignInit(session, &mech, key);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return fl->C_Sign(session, data, data_len, sig, sig_len);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"unsafe"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// PKCS#11 object classes and attributes used by PKCS11Signer.
+const (
+	ckoPublicKey  = 2
+	ckoPrivateKey = 3
+	ckoSecretKey  = 4
+	ckaECParams   = 0x180
+	ckaECPoint    = 0x181
+)
+
+// maxSlots bounds the number of token slots inspected.
+const maxSlots = 64
+
+// Named curve OIDs accepted for the CA key (RFC 5480).
+var (
+	oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// PKCS11Config selects the token and objects used by PKCS11Signer.
+type PKCS11Config struct {
+	// Module is the path of the PKCS#11 shared library.
+	Module string
+	// TokenLabel selects the token by its label.
+	TokenLabel string
+	// PIN is the token's user PIN.
+	PIN string
+	// KeyLabel is the CKA_LABEL of the CA's EC key pair.
+	KeyLabel string
+	// HMACKeyLabel is the CKA_LABEL of the generic secret key used to
+	// derive HMAC keys with CKM_SHA256_HMAC.
+	HMACKeyLabel string
+}
+
+// PKCS11Signer implements pki.Signer with a CA key that never leaves
+// a PKCS#11 token. A single logged-in session is shared by all
+// operations and serialized by a mutex.
+type PKCS11Signer struct {
+	mu      sync.Mutex
+	handle  unsafe.Pointer
+	fl      *C.CK_FUNCTION_LIST
+	session C.CK_SESSION_HANDLE
+	key     C.CK_OBJECT_HANDLE
+	hmacKey C.CK_OBJECT_HANDLE
+	public  *ecdsa.PublicKey
+}
+
+var _ pki.Signer = (*PKCS11Signer)(nil)
+
+// NewPKCS11Signer loads the PKCS#11 module, logs into the token
+// labeled cfg.TokenLabel and looks up the CA key pair and HMAC secret.
+// Call Close to log out and unload the module.
+func NewPKCS11Signer(cfg PKCS11Config) (*PKCS11Signer, error) {
+	path := C.CString(cfg.Module)
+	defer C.free(unsafe.Pointer(path))
+
+	s := &PKCS11Signer{}
+	var rv C.CK_RV
+	s.handle = C.p11_open(path, &s.fl, &rv)
+	if s.handle == nil {
+		if rv != 0 {
+			return nil, pkcs11Error("C_GetFunctionList", rv)
+		}
+		return nil, fmt.Errorf("pkcs11: load module %s: %s", cfg.Module, C.GoString(C.dlerror()))
+	}
+	if rv := C.p11_initialize(s.fl); rv != 0 {
+		C.dlclose(s.handle)
+		return nil, pkcs11Error("C_Initialize", rv)
+	}
+
+	if err := s.open(cfg); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open logs into the configured token and resolves the key objects.
+func (s *PKCS11Signer) open(cfg PKCS11Config) error {
+	slot, err := s.findToken(cfg.TokenLabel)
+	if err != nil {
+		return err
+	}
+
+	pin := C.CString(cfg.PIN)
+	defer C.free(unsafe.Pointer(pin))
+	if rv := C.p11_login(s.fl, slot, pin, C.CK_ULONG(len(cfg.PIN)), &s.session); rv != 0 {
+		return pkcs11Error("C_Login", rv)
+	}
+
+	if s.key, err = s.findObject(ckoPrivateKey, cfg.KeyLabel); err != nil {
+		return err
+	}
+	if s.hmacKey, err = s.findObject(ckoSecretKey, cfg.HMACKeyLabel); err != nil {
+		return err
+	}
+	pub, err := s.findObject(ckoPublicKey, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	s.public, err = s.publicKey(pub)
+	return err
+}
+
+// findToken returns the slot of the token labeled label.
+func (s *PKCS11Signer) findToken(label string) (C.CK_SLOT_ID, error) {
+	slots := make([]C.CK_SLOT_ID, maxSlots)
+	count := C.CK_ULONG(len(slots))
+	if rv := C.p11_slots(s.fl, &slots[0], &count); rv != 0 {
+		return 0, pkcs11Error("C_GetSlotList", rv)
+	}
+
+	for _, slot := range slots[:count] {
+		var raw [32]C.CK_BYTE
+		if rv := C.p11_token_label(s.fl, slot, &raw[0]); rv != 0 {
+			return 0, pkcs11Error("C_GetTokenInfo", rv)
+		}
+		// Token labels are blank-padded to 32 bytes.
+		if string(bytes.TrimRight(C.GoBytes(unsafe.Pointer(&raw[0]), C.int(len(raw))), " ")) == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: token %q not found", label)
+}
+
+// findObject returns the object of the given class labeled label.
+func (s *PKCS11Signer) findObject(class uint, label string) (C.CK_OBJECT_HANDLE, error) {
+	cLabel := C.CString(label)
+	defer C.free(unsafe.Pointer(cLabel))
+
+	var obj C.CK_OBJECT_HANDLE
+	var found C.CK_ULONG
+	if rv := C.p11_find(s.fl, s.session, C.CK_ULONG(class), cLabel, C.CK_ULONG(len(label)), &obj, &found); rv != 0 {
+		return 0, pkcs11Error("C_FindObjects", rv)
+	}
+	if found == 0 {
+		return 0, fmt.Errorf("pkcs11: object %q of class %d not found", label, class)
+	}
+	return obj, nil
+}
+
+// attribute reads a variable-length attribute of obj.
+func (s *PKCS11Signer) attribute(obj C.CK_OBJECT_HANDLE, typ uint) ([]byte, error) {
+	var n C.CK_ULONG
+	if rv := C.p11_attribute(s.fl, s.session, obj, C.CK_ULONG(typ), nil, &n); rv != 0 {
+		return nil, pkcs11Error("C_GetAttributeValue", rv)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("pkcs11: attribute 0x%x is empty", typ)
+	}
+	buf := make([]byte, n)
+	if rv := C.p11_attribute(s.fl, s.session, obj, C.CK_ULONG(typ), unsafe.Pointer(&buf[0]), &n); rv != 0 {
+		return nil, pkcs11Error("C_GetAttributeValue", rv)
+	}
+	return buf[:n], nil
+}
+
+// publicKey reads the EC public key object obj.
+func (s *PKCS11Signer) publicKey(obj C.CK_OBJECT_HANDLE) (*ecdsa.PublicKey, error) {
+	params, err := s.attribute(obj, ckaECParams)
+	if err != nil {
+		return nil, err
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("pkcs11: parse EC params: %w", err)
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidP384):
+		curve = elliptic.P384()
+	default:
+		return nil, fmt.Errorf("pkcs11: unsupported curve %s", oid)
+	}
+
+	point, err := s.attribute(obj, ckaECPoint)
+	if err != nil {
+		return nil, err
+	}
+	// CKA_EC_POINT is a DER OCTET STRING; some modules return the raw
+	// point instead.
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+	pub, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: parse EC point: %w", err)
+	}
+	return pub, nil
+}
+
+// Public returns the CA public key stored on the token.
+func (s *PKCS11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs digest with CKM_ECDSA and returns an ASN.1 signature.
+func (s *PKCS11Signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	raw, err := s.sign(C.CKM_ECDSA, s.key, digest)
+	if err != nil {
+		return nil, err
+	}
+	// CKM_ECDSA returns r || s, each padded to the curve size.
+	half := len(raw) / 2
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: encode signature: %w", err)
+	}
+	return sig, nil
+}
+
+// DeriveKey computes CKM_SHA256_HMAC over label with the token's HMAC
+// secret and expands the result with HKDF. The secret never leaves the
+// token, and the output is stable for the same secret and label.
+func (s *PKCS11Signer) DeriveKey(label string, length int) ([]byte, error) {
+	mac, err := s.sign(C.CKM_SHA256_HMAC, s.hmacKey, []byte(label))
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, mac, nil, label, length)
+}
+
+// sign runs a single-part C_Sign operation.
+func (s *PKCS11Signer) sign(mechanism C.CK_ULONG, key C.CK_OBJECT_HANDLE, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("pkcs11: nothing to sign")
+	}
+	const maxSignatureLen = 512
+	out := make([]byte, maxSignatureLen)
+	n := C.CK_ULONG(len(out))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := C.p11_sign(s.fl, s.session, mechanism, key,
+		(*C.CK_BYTE)(unsafe.Pointer(&data[0])), C.CK_ULONG(len(data)),
+		(*C.CK_BYTE)(unsafe.Pointer(&out[0])), &n)
+	if rv != 0 {
+		return nil, pkcs11Error("C_Sign", rv)
+	}
+	return out[:n], nil
+}
+
+// Close closes the session and unloads the module.
+func (s *PKCS11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handle == nil {
+		return nil
+	}
+	C.p11_close(s.handle, s.fl, s.session)
+	s.handle = nil
+	return nil
+}
+
+// pkcs11Error formats a failed PKCS#11 call.
+func pkcs11Error(fn string, rv C.CK_RV) error {
+	return fmt.Errorf("pkcs11: %s failed: CKR 0x%x", fn, uint(rv))
+}
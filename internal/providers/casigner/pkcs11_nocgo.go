@@ -0,0 +1,34 @@
+//go:build !cgo
+
+package casigner
+
+import (
+	"errors"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// PKCS11Config selects the token and objects used by the PKCS#11
+// signer.
+type PKCS11Config struct {
+	Module       string
+	TokenLabel   string
+	PIN          string
+	KeyLabel     string
+	HMACKeyLabel string
+}
+
+// PKCS11Signer is unavailable in binaries built without cgo.
+type PKCS11Signer struct {
+	pki.Signer
+}
+
+// NewPKCS11Signer always fails: loading a PKCS#11 module requires cgo.
+func NewPKCS11Signer(PKCS11Config) (*PKCS11Signer, error) {
+	return nil, errors.New("pkcs11: this binary was built without cgo; rebuild with CGO_ENABLED=1 to use the pkcs11 signer")
+}
+
+// Close is a no-op.
+func (s *PKCS11Signer) Close() error {
+	return nil
+}
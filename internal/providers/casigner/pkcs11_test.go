@@ -0,0 +1,66 @@
+//go:build cgo
+
+package casigner
+
+import (
+	"os"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// TestPKCS11Signer_CA runs against a real token, e.g. SoftHSM:
+//
+//	softhsm2-util --init-token --free --label otterscale --pin 1234 --so-pin 5678
+//	pkcs11-tool --module $MODULE --token-label otterscale --login --pin 1234 \
+//	    --keypairgen --key-type EC:prime256v1 --label otterscale-ca
+//	pkcs11-tool --module $MODULE --token-label otterscale --login --pin 1234 \
+//	    --keygen --key-type GENERIC:32 --label otterscale-ca-hmac --usage-sign
+//	OTTERSCALE_TEST_PKCS11_MODULE=$MODULE OTTERSCALE_TEST_PKCS11_TOKEN=otterscale \
+//	    OTTERSCALE_TEST_PKCS11_PIN=1234 go test ./internal/providers/casigner/
+func TestPKCS11Signer_CA(t *testing.T) {
+	module := os.Getenv("OTTERSCALE_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("OTTERSCALE_TEST_PKCS11_MODULE not set")
+	}
+
+	signer, err := NewPKCS11Signer(PKCS11Config{
+		Module:       module,
+		TokenLabel:   os.Getenv("OTTERSCALE_TEST_PKCS11_TOKEN"),
+		PIN:          os.Getenv("OTTERSCALE_TEST_PKCS11_PIN"),
+		KeyLabel:     "otterscale-ca",
+		HMACKeyLabel: "otterscale-ca-hmac",
+	})
+	if err != nil {
+		t.Fatalf("NewPKCS11Signer: %v", err)
+	}
+	t.Cleanup(func() { _ = signer.Close() })
+
+	ca, err := pki.NewCA(pki.WithSigner(signer))
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	key, _, err := pki.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	csr, err := pki.GenerateCSR(key, "agent-a")
+	if err != nil {
+		t.Fatalf("GenerateCSR: %v", err)
+	}
+	if _, err := ca.SignCSR(csr); err != nil {
+		t.Errorf("SignCSR: %v", err)
+	}
+
+	k1, err := ca.DeriveHMACKey("manifest")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	k2, err := ca.DeriveHMACKey("manifest")
+	if err != nil {
+		t.Fatalf("DeriveHMACKey: %v", err)
+	}
+	if string(k1) != string(k2) {
+		t.Error("expected a deterministic HMAC key")
+	}
+}
@@ -0,0 +1,87 @@
+// Package casigner implements pki.Signer backends that keep the tunnel
+// CA key outside the server process: a PKCS#11 token and an external
+// signing service reached over HTTP.
+package casigner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// Supported values for the server.ca.signer configuration key.
+const (
+	SignerMemory = "memory"
+	SignerPKCS11 = "pkcs11"
+	SignerHTTP   = "http"
+)
+
+// ProvideSigner is a Wire provider that returns the Signer selected by
+// the server.ca.signer configuration key. The memory signer is
+// represented by a nil Signer: the CA then generates its own key and
+// persists it in the CA store. The cleanup function releases the
+// backend.
+func ProvideSigner(conf *config.Config) (pki.Signer, func(), error) {
+	switch signer := conf.ServerCASigner(); signer {
+	case SignerMemory:
+		return nil, func() {}, nil
+	case SignerPKCS11:
+		s, err := NewPKCS11Signer(PKCS11Config{
+			Module:       conf.ServerCAPKCS11Module(),
+			TokenLabel:   conf.ServerCAPKCS11Token(),
+			PIN:          conf.ServerCAPKCS11PIN(),
+			KeyLabel:     conf.ServerCAPKCS11KeyLabel(),
+			HMACKeyLabel: conf.ServerCAPKCS11HMACLabel(),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("ca signer: %w", err)
+		}
+		return s, func() { _ = s.Close() }, nil
+	case SignerHTTP:
+		s, err := newHTTPSignerFromConfig(conf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ca signer: %w", err)
+		}
+		return s, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("ca signer: unsupported signer %q (expected %q, %q or %q)", signer, SignerMemory, SignerPKCS11, SignerHTTP)
+	}
+}
+
+// newHTTPSignerFromConfig builds an HTTPSigner from the server.ca.http
+// configuration keys.
+func newHTTPSignerFromConfig(conf *config.Config) (*HTTPSigner, error) {
+	if conf.ServerCAHTTPURL() == "" {
+		return nil, fmt.Errorf("http signer: server.ca.http.url is required")
+	}
+
+	var token string
+	if path := conf.ServerCAHTTPTokenFile(); path != "" {
+		data, err := os.ReadFile(path) // #nosec G304 -- path is operator configuration
+		if err != nil {
+			return nil, fmt.Errorf("http signer: read token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	var roots *x509.CertPool
+	if path := conf.ServerCAHTTPCAFile(); path != "" {
+		data, err := os.ReadFile(path) // #nosec G304 -- path is operator configuration
+		if err != nil {
+			return nil, fmt.Errorf("http signer: read CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("http signer: no certificates found in %s", path)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+	return NewHTTPSigner(ctx, conf.ServerCAHTTPURL(), token, roots)
+}
@@ -0,0 +1,293 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+const (
+	// certFileName is the file holding the PEM-encoded CA certificate.
+	certFileName = "ca.crt"
+	// keyFileName is the file holding the PEM-encoded CA private key.
+	keyFileName = "ca.key"
+	// caLinkName is the symlink pointing at the generation directory
+	// that holds the current certificate and key.
+	caLinkName = "ca"
+	// loadAttempts bounds how often Load resolves the symlink again
+	// when a concurrent Save removed the generation it was reading.
+	loadAttempts = 3
+	// hmacSecretFileName is the file holding the HMAC secret the
+	// tokens of the hub are signed with.
+	hmacSecretFileName = "hmac.key"
+	// secretFilePerm restricts CA material to the owning user.
+	secretFilePerm = 0o600
+	// dirPerm restricts the CA directory to the owning user.
+	dirPerm = 0o700
+)
+
+// FileStore persists the CA as a ca.crt / ca.key file pair inside a
+// directory. It implements pki.CAStore.
+//
+// Every version of the pair is written to its own generation
+// directory, and the "ca" symlink points at the current one. Create
+// elects a single winner by creating the symlink, which fails
+// atomically with EEXIST for every other writer, and Save replaces it
+// by renaming a new symlink over it. Either way both files become
+// visible in a single step, so a crash never leaves a key without its
+// certificate or paired with the wrong one.
+//
+// A pair written directly into the directory by earlier versions is
+// still loaded, and replaced by the first Update.
+//
+// Updates are serialized within the process only, so the directory
+// must not be shared by several hub replicas.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex // serializes read-modify-write cycles
+}
+
+// Verify at compile time that FileStore satisfies pki.CAStore and
+// pki.HMACSecretStore.
+var (
+	_ pki.CAStore         = (*FileStore)(nil)
+	_ pki.HMACSecretStore = (*FileStore)(nil)
+)
+
+// NewFileStore returns a FileStore rooted at dir. The directory is
+// created on first write if it does not exist.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Load reads the certificate and key of the current generation. It
+// returns pki.ErrCANotFound when nothing has been stored yet.
+func (s *FileStore) Load(_ context.Context) (certPEM, keyPEM []byte, err error) {
+	for attempt := 1; ; attempt++ {
+		gen, err := os.Readlink(filepath.Join(s.dir, caLinkName))
+		if errors.Is(err, fs.ErrNotExist) {
+			return s.loadLegacy()
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolve CA: %w", err)
+		}
+
+		certPEM, keyPEM, err = readPair(filepath.Join(s.dir, gen))
+		if errors.Is(err, fs.ErrNotExist) && attempt < loadAttempts {
+			// A concurrent Save replaced the generation and removed
+			// it before both files were read; resolve the new one.
+			continue
+		}
+		return certPEM, keyPEM, err
+	}
+}
+
+// loadLegacy reads a pair written directly into the store directory.
+// It returns pki.ErrCANotFound when neither file exists and
+// pki.ErrCAIncomplete when only the key has been written.
+func (s *FileStore) loadLegacy() (certPEM, keyPEM []byte, err error) {
+	keyPEM, err = os.ReadFile(filepath.Join(s.dir, keyFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, pki.ErrCANotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CA key: %w", err)
+	}
+
+	certPEM, err = os.ReadFile(filepath.Join(s.dir, certFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s exists without %s", pki.ErrCAIncomplete, keyFileName, certFileName)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CA cert: %w", err)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// Create writes the material to a new generation and links it into
+// place. It returns pki.ErrCAExists if a CA is already present.
+func (s *FileStore) Create(_ context.Context, certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(s.dir, dirPerm); err != nil {
+		return fmt.Errorf("create CA dir: %w", err)
+	}
+	if _, err := os.Lstat(filepath.Join(s.dir, keyFileName)); err == nil {
+		return pki.ErrCAExists
+	}
+
+	gen, err := s.writeGeneration(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(gen, filepath.Join(s.dir, caLinkName)); err != nil {
+		os.RemoveAll(filepath.Join(s.dir, gen))
+		if errors.Is(err, fs.ErrExist) {
+			return pki.ErrCAExists
+		}
+		return fmt.Errorf("link CA: %w", err)
+	}
+	return nil
+}
+
+// Update replaces the material with the material fn returns for the
+// current one. The result is written to a new generation, the symlink
+// is atomically pointed at it, and the previous generation is removed.
+func (s *FileStore) Update(ctx context.Context, fn func(certPEM, keyPEM []byte) ([]byte, []byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certPEM, keyPEM, err := s.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if certPEM, keyPEM, err = fn(certPEM, keyPEM); err != nil {
+		return err
+	}
+	return s.save(certPEM, keyPEM)
+}
+
+// save writes the material to a new generation and atomically points
+// the symlink at it, then removes the previous generation.
+func (s *FileStore) save(certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(s.dir, dirPerm); err != nil {
+		return fmt.Errorf("create CA dir: %w", err)
+	}
+
+	gen, err := s.writeGeneration(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	link := filepath.Join(s.dir, caLinkName)
+	prev, _ := os.Readlink(link)
+
+	tmpLink := filepath.Join(s.dir, "."+gen+".tmp")
+	if err := os.Symlink(gen, tmpLink); err != nil {
+		os.RemoveAll(filepath.Join(s.dir, gen))
+		return fmt.Errorf("link CA: %w", err)
+	}
+	if err := os.Rename(tmpLink, link); err != nil {
+		os.Remove(tmpLink)
+		os.RemoveAll(filepath.Join(s.dir, gen))
+		return fmt.Errorf("replace CA: %w", err)
+	}
+
+	if prev != "" {
+		os.RemoveAll(filepath.Join(s.dir, prev))
+	} else {
+		os.Remove(filepath.Join(s.dir, keyFileName))
+		os.Remove(filepath.Join(s.dir, certFileName))
+	}
+	return nil
+}
+
+// writeGeneration writes the material to a new generation directory
+// and returns its name relative to the store directory.
+func (s *FileStore) writeGeneration(certPEM, keyPEM []byte) (string, error) {
+	dir, err := os.MkdirTemp(s.dir, "ca-*")
+	if err != nil {
+		return "", fmt.Errorf("create CA generation: %w", err)
+	}
+	for name, data := range map[string][]byte{keyFileName: keyPEM, certFileName: certPEM} {
+		tmp, err := writeTemp(dir, data)
+		if err == nil {
+			err = os.Rename(tmp, filepath.Join(dir, name))
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return filepath.Base(dir), nil
+}
+
+// readPair reads the certificate and key files in dir.
+func readPair(dir string) (certPEM, keyPEM []byte, err error) {
+	keyPEM, err = os.ReadFile(filepath.Join(dir, keyFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CA key: %w", err)
+	}
+	certPEM, err = os.ReadFile(filepath.Join(dir, certFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CA cert: %w", err)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// LoadHMACSecret reads the HMAC secret file. It returns nil if the
+// file does not exist yet.
+func (s *FileStore) LoadHMACSecret(_ context.Context) ([]byte, error) {
+	return s.readData(hmacSecretFileName)
+}
+
+// CreateHMACSecret writes the secret to a temporary file and links it
+// into place. It returns pki.ErrHMACSecretExists if the file is
+// already present.
+func (s *FileStore) CreateHMACSecret(_ context.Context, secret []byte) error {
+	if err := os.MkdirAll(s.dir, dirPerm); err != nil {
+		return fmt.Errorf("create CA dir: %w", err)
+	}
+
+	tmp, err := writeTemp(s.dir, secret)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", hmacSecretFileName, err)
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, filepath.Join(s.dir, hmacSecretFileName)); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return pki.ErrHMACSecretExists
+		}
+		return fmt.Errorf("link %s: %w", hmacSecretFileName, err)
+	}
+	return nil
+}
+
+// readData reads the named file in the store directory. It returns nil
+// if the file does not exist yet.
+func (s *FileStore) readData(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// writeTemp writes data to a new owner-only temporary file in dir and
+// returns its path. The file is fsynced so that a crash after linking
+// never exposes a truncated PEM block.
+func writeTemp(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, ".ca-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+
+	if err := f.Chmod(secretFilePerm); err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
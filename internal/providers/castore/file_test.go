@@ -0,0 +1,224 @@
+package castore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+func TestFileStore_LoadMissing(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "ca"))
+
+	_, _, err := store.Load(t.Context())
+	if !errors.Is(err, pki.ErrCANotFound) {
+		t.Fatalf("expected ErrCANotFound, got %v", err)
+	}
+}
+
+func TestFileStore_CreateAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	store := NewFileStore(dir)
+
+	if err := store.Create(t.Context(), []byte("cert"), []byte("key")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	certPEM, keyPEM, err := store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(certPEM) != "cert" || string(keyPEM) != "key" {
+		t.Errorf("unexpected material: cert=%q key=%q", certPEM, keyPEM)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, caLinkName, keyFileName))
+	if err != nil {
+		t.Fatalf("stat key: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != secretFilePerm {
+		t.Errorf("expected key perm %o, got %o", secretFilePerm, perm)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected only the link and its generation, got %d entries", len(entries))
+	}
+}
+
+func TestFileStore_CreateTwice(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+
+	if err := store.Create(t.Context(), []byte("cert-1"), []byte("key-1")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	err := store.Create(t.Context(), []byte("cert-2"), []byte("key-2"))
+	if !errors.Is(err, pki.ErrCAExists) {
+		t.Fatalf("expected ErrCAExists, got %v", err)
+	}
+
+	certPEM, _, err := store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(certPEM) != "cert-1" {
+		t.Errorf("expected first writer to win, got %q", certPEM)
+	}
+}
+
+func TestFileStore_Incomplete(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, keyFileName), []byte("key"), secretFilePerm); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	_, _, err := NewFileStore(dir).Load(t.Context())
+	if !errors.Is(err, pki.ErrCAIncomplete) {
+		t.Fatalf("expected ErrCAIncomplete, got %v", err)
+	}
+}
+
+// TestFileStore_CreateInterrupted verifies that a Create that crashed
+// before linking its generation leaves no CA behind, so that the next
+// Create succeeds.
+func TestFileStore_CreateInterrupted(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	if err := os.MkdirAll(store.dir, dirPerm); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	if _, err := store.writeGeneration([]byte("cert-1"), []byte("key-1")); err != nil {
+		t.Fatalf("writeGeneration: %v", err)
+	}
+
+	if _, _, err := store.Load(t.Context()); !errors.Is(err, pki.ErrCANotFound) {
+		t.Fatalf("expected ErrCANotFound, got %v", err)
+	}
+	if err := store.Create(t.Context(), []byte("cert-2"), []byte("key-2")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	certPEM, _, err := store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(certPEM) != "cert-2" {
+		t.Errorf("expected the created CA, got %q", certPEM)
+	}
+}
+
+// TestFileStore_Legacy verifies that a pair written directly into the
+// directory is loaded, blocks Create and is replaced by Update.
+func TestFileStore_Legacy(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{keyFileName: "key-1", certFileName: "cert-1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), secretFilePerm); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	store := NewFileStore(dir)
+
+	certPEM, _, err := store.Load(t.Context())
+	if err != nil || string(certPEM) != "cert-1" {
+		t.Fatalf("Load = %q, %v, want the legacy CA", certPEM, err)
+	}
+	if err := store.Create(t.Context(), []byte("cert-2"), []byte("key-2")); !errors.Is(err, pki.ErrCAExists) {
+		t.Fatalf("expected ErrCAExists, got %v", err)
+	}
+
+	if err := store.Update(t.Context(), replaceCA(t, "cert-1", "cert-3", "key-3")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	certPEM, keyPEM, err := store.Load(t.Context())
+	if err != nil || string(certPEM) != "cert-3" || string(keyPEM) != "key-3" {
+		t.Fatalf("Load = %q, %q, %v, want the saved CA", certPEM, keyPEM, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, keyFileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the legacy key to be removed, got %v", err)
+	}
+}
+
+func TestFileStore_LoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := pki.LoadOrCreateCA(t.Context(), NewFileStore(dir))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	second, err := pki.LoadOrCreateCA(t.Context(), NewFileStore(dir))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA (restart): %v", err)
+	}
+	if string(first.CertPEM()) != string(second.CertPEM()) {
+		t.Error("expected the same CA after restart")
+	}
+}
+
+// replaceCA returns an Update function that checks the current
+// certificate and replaces the material with cert and key.
+func replaceCA(t *testing.T, current, cert, key string) func(certPEM, keyPEM []byte) ([]byte, []byte, error) {
+	return func(certPEM, _ []byte) ([]byte, []byte, error) {
+		if string(certPEM) != current {
+			t.Errorf("Update called with %q, want %q", certPEM, current)
+		}
+		return []byte(cert), []byte(key), nil
+	}
+}
+
+func TestFileStore_Update(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+
+	if err := store.Update(t.Context(), replaceCA(t, "", "cert-1", "key-1")); !errors.Is(err, pki.ErrCANotFound) {
+		t.Fatalf("expected ErrCANotFound before Create, got %v", err)
+	}
+	if err := store.Create(t.Context(), []byte("cert-1"), []byte("key-1")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := store.Update(t.Context(), replaceCA(t, "cert-1", "cert-2", "key-2")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	certPEM, keyPEM, err := store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(certPEM) != "cert-2" || string(keyPEM) != "key-2" {
+		t.Errorf("expected saved material, got cert=%q key=%q", certPEM, keyPEM)
+	}
+
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected the previous generation to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestFileStore_HMACSecret(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "ca"))
+
+	if secret, err := store.LoadHMACSecret(t.Context()); err != nil || secret != nil {
+		t.Fatalf("LoadHMACSecret = %q, %v; want nothing stored", secret, err)
+	}
+	if err := store.CreateHMACSecret(t.Context(), []byte("secret-1")); err != nil {
+		t.Fatalf("CreateHMACSecret: %v", err)
+	}
+	if err := store.CreateHMACSecret(t.Context(), []byte("secret-2")); !errors.Is(err, pki.ErrHMACSecretExists) {
+		t.Fatalf("expected ErrHMACSecretExists, got %v", err)
+	}
+
+	// Rotating the CA leaves the secret alone.
+	if err := store.Create(t.Context(), []byte("cert-1"), []byte("key-1")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := store.Update(t.Context(), replaceCA(t, "cert-1", "cert-2", "key-2")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if secret, err := store.LoadHMACSecret(t.Context()); err != nil || string(secret) != "secret-1" {
+		t.Errorf("LoadHMACSecret = %q, %v; want the first secret", secret, err)
+	}
+}
@@ -0,0 +1,76 @@
+// Package castore implements pki.CAStore and pki.HMACSecretStore
+// backends that persist the tunnel CA and the HMAC secret of the hub
+// across server restarts: files on disk and Kubernetes Secrets in the
+// hub's own cluster. The hub registries are kept by package hubstore.
+package castore
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// Supported values for the server.ca.store configuration key.
+const (
+	StoreFile   = "file"
+	StoreSecret = "secret"
+)
+
+// Store is implemented by every backend in this package.
+type Store interface {
+	pki.CAStore
+	pki.HMACSecretStore
+}
+
+// ProvideStore is a Wire provider that returns the backend selected by
+// the server.ca.store configuration key. Hub replicas forwarding to
+// each other share their CA through the store, which requires the
+// secret store: files on disk are local to each replica.
+func ProvideStore(conf *config.Config) (Store, error) {
+	switch store := conf.ServerCAStore(); store {
+	case StoreFile:
+		if conf.ServerPeerURL() != "" || conf.ServerPeerAddress() != "" {
+			return nil, fmt.Errorf("ca store: peer forwarding requires the %q store, so that the hub replicas share their CA", StoreSecret)
+		}
+		return NewFileStore(conf.ServerCADir()), nil
+	case StoreSecret:
+		cfg, err := kubeConfig()
+		if err != nil {
+			return nil, fmt.Errorf("ca store: load kubernetes config: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("ca store: create kubernetes client: %w", err)
+		}
+		return NewSecretStore(client, conf.ServerCASecretNamespace(), conf.ServerCASecretName()), nil
+	default:
+		return nil, fmt.Errorf("ca store: unsupported store %q (expected %q or %q)", store, StoreFile, StoreSecret)
+	}
+}
+
+// ProvideCAStore is a Wire provider that returns store as a CAStore.
+func ProvideCAStore(store Store) pki.CAStore {
+	return store
+}
+
+// ProvideHMACSecretStore is a Wire provider that returns store as an
+// HMACSecretStore. The HMAC secret is kept next to the CA it is first
+// derived from.
+func ProvideHMACSecretStore(store Store) pki.HMACSecretStore {
+	return store
+}
+
+// kubeConfig returns the in-cluster config, or the user's kubeconfig
+// when OTTERSCALE_DEBUG is set for local development.
+func kubeConfig() (*rest.Config, error) {
+	if os.Getenv("OTTERSCALE_DEBUG") != "" {
+		return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	}
+	return rest.InClusterConfig()
+}
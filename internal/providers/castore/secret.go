@@ -0,0 +1,174 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// SecretStore persists the CA and its HMAC secret in a
+// kubernetes.io/tls Secret in the hub's own cluster.
+//
+// First-boot races are resolved by the API server: Create issues a
+// plain POST, so exactly one replica succeeds and every other one
+// receives AlreadyExists, which is mapped to pki.ErrCAExists.
+type SecretStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// hmacSecretKey is the Secret data key holding the HMAC secret the
+// tokens of the hub are signed with.
+const hmacSecretKey = "hmac.key"
+
+// Verify at compile time that SecretStore satisfies pki.CAStore and
+// pki.HMACSecretStore.
+var (
+	_ pki.CAStore         = (*SecretStore)(nil)
+	_ pki.HMACSecretStore = (*SecretStore)(nil)
+)
+
+// NewSecretStore returns a SecretStore that keeps the CA in the Secret
+// namespace/name, through client.
+func NewSecretStore(client kubernetes.Interface, namespace, name string) *SecretStore {
+	return &SecretStore{client: client, namespace: namespace, name: name}
+}
+
+// Load reads the tls.crt and tls.key entries of the Secret. It returns
+// pki.ErrCANotFound when the Secret does not exist.
+func (s *SecretStore) Load(ctx context.Context) (certPEM, keyPEM []byte, err error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil, pki.ErrCANotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read secret %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	certPEM = secret.Data[corev1.TLSCertKey]
+	keyPEM = secret.Data[corev1.TLSPrivateKeyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return nil, nil, fmt.Errorf("%w: secret %s/%s is missing %s or %s",
+			pki.ErrCAIncomplete, s.namespace, s.name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// Create stores the material in a new Secret. It returns
+// pki.ErrCAExists if the Secret already exists.
+func (s *SecretStore) Create(ctx context.Context, certPEM, keyPEM []byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "otterscale",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+
+	_, err := s.client.CoreV1().Secrets(s.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return pki.ErrCAExists
+	}
+	if err != nil {
+		return fmt.Errorf("create secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+// Update replaces the tls.crt and tls.key entries of the existing
+// Secret with the material fn returns for the current entries, in a
+// single update. The update carries the resourceVersion that was read,
+// so a concurrent writer causes a conflict instead of a lost update;
+// fn is then called again with the material that writer persisted.
+func (s *SecretStore) Update(ctx context.Context, fn func(certPEM, keyPEM []byte) ([]byte, []byte, error)) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+
+	var fnErr error
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return pki.ErrCANotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		certPEM, keyPEM, err := fn(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			fnErr = err
+			return err
+		}
+
+		secret = secret.DeepCopy()
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[corev1.TLSCertKey] = certPEM
+		secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	if fnErr != nil || errors.Is(err, pki.ErrCANotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("update secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+// LoadHMACSecret reads the hmac.key entry of the CA Secret. It returns
+// nil if the Secret or the entry does not exist yet.
+func (s *SecretStore) LoadHMACSecret(ctx context.Context) ([]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return secret.Data[hmacSecretKey], nil
+}
+
+// CreateHMACSecret adds the hmac.key entry to the existing Secret. It
+// returns pki.ErrHMACSecretExists if the entry is already present. The
+// update carries the resourceVersion that was read, and is retried on
+// conflicts, so that of two concurrent writers only one adds the
+// entry.
+func (s *SecretStore) CreateHMACSecret(ctx context.Context, secret []byte) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := secrets.Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("read secret %s/%s: %w", s.namespace, s.name, err)
+		}
+		if _, ok := current.Data[hmacSecretKey]; ok {
+			return pki.ErrHMACSecretExists
+		}
+
+		current = current.DeepCopy()
+		if current.Data == nil {
+			current.Data = map[string][]byte{}
+		}
+		current.Data[hmacSecretKey] = secret
+		_, err = secrets.Update(ctx, current, metav1.UpdateOptions{})
+		return err
+	})
+}
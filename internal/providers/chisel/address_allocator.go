@@ -36,6 +36,16 @@ func (a *addressAllocator) allocate(cluster string) (string, error) {
 	return "", fmt.Errorf("exhausted loopback address space (%d hosts)", maxHosts)
 }
 
+// reserve marks host as allocated, for example when restoring a
+// persisted link. It returns false if host is already in use.
+func (a *addressAllocator) reserve(host string) bool {
+	if _, exists := a.usedHosts[host]; exists {
+		return false
+	}
+	a.usedHosts[host] = struct{}{}
+	return true
+}
+
 // release returns a previously allocated host to the pool.
 func (a *addressAllocator) release(host string) {
 	delete(a.usedHosts, host)
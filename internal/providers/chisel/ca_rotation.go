@@ -0,0 +1,70 @@
+package chisel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+var _ core.CARotator = (*Service)(nil)
+
+// CARotation returns the active and, during a rotation, the retiring
+// tunnel CA.
+func (s *Service) CARotation(_ context.Context) (core.CARotation, error) {
+	return toCoreRotation(s.ca.Rotation()), nil
+}
+
+// StartCARotation generates a new tunnel CA. New agent certificates
+// are signed by it immediately, while the tunnel keeps presenting a
+// server certificate from the retiring CA and trusting client
+// certificates from both.
+func (s *Service) StartCARotation(ctx context.Context) (core.CARotation, error) {
+	status, err := s.ca.StartRotation(ctx)
+	if err != nil {
+		return core.CARotation{}, toRotationError(err)
+	}
+	s.log.Info("CA rotation started", "active", status.Active.Fingerprint)
+	return toCoreRotation(status), nil
+}
+
+// FinishCARotation stops trusting the retiring CA. The tunnel switches
+// to a server certificate signed by the active CA on the next
+// handshake.
+func (s *Service) FinishCARotation(ctx context.Context) (core.CARotation, error) {
+	status, err := s.ca.FinishRotation(ctx)
+	if err != nil {
+		return core.CARotation{}, toRotationError(err)
+	}
+	s.log.Info("CA rotation finished", "active", status.Active.Fingerprint)
+	return toCoreRotation(status), nil
+}
+
+// toRotationError maps pki rotation errors to domain errors.
+func toRotationError(err error) error {
+	if errors.Is(err, pki.ErrRotationInProgress) || errors.Is(err, pki.ErrNoRotation) || errors.Is(err, pki.ErrRotationUnsupported) {
+		return &core.DomainError{Code: core.ErrorCodeFailedPrecondition, Message: err.Error()}
+	}
+	return err
+}
+
+// toCoreRotation converts a pki.RotationStatus into its domain
+// representation.
+func toCoreRotation(status pki.RotationStatus) core.CARotation {
+	ret := core.CARotation{Active: toCoreCertificate(status.Active)}
+	if status.Retiring != nil {
+		retiring := toCoreCertificate(*status.Retiring)
+		ret.Retiring = &retiring
+	}
+	return ret
+}
+
+// toCoreCertificate converts a pki.CertInfo into a core.CACertificate.
+func toCoreCertificate(info pki.CertInfo) core.CACertificate {
+	return core.CACertificate{
+		Fingerprint: info.Fingerprint,
+		NotBefore:   info.NotBefore,
+		NotAfter:    info.NotAfter,
+	}
+}
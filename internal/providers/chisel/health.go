@@ -2,23 +2,25 @@ package chisel
 
 import (
 	"context"
+	"io"
 	"net"
+	"net/http"
+	"slices"
 	"strconv"
 	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/otterscale/otterscale/internal/core"
 )
 
 const (
-	// healthCheckInterval is how often the health check probes every
-	// registered cluster's tunnel endpoint.
-	healthCheckInterval = 15 * time.Second
+	// maxProbeBodyBytes bounds the probe response body that is read.
+	maxProbeBodyBytes = 4 << 10
 
-	// healthDialTimeout is the TCP dial timeout used when probing a
-	// cluster's tunnel endpoint.
-	healthDialTimeout = 2 * time.Second
-
-	// healthFailThreshold is the number of consecutive probe failures
-	// required before a cluster is automatically deregistered.
-	healthFailThreshold = 3
+	// maxConcurrentProbes bounds the health probes of a round that are
+	// in flight at the same time.
+	maxConcurrentProbes = 32
 )
 
 // HealthCheckListener wraps the Service's health check loop as a
@@ -47,29 +49,191 @@ func (h *HealthCheckListener) Stop(_ context.Context) error {
 	return nil
 }
 
-// clusterSnapshot returns a copy of the cluster-to-host mapping so
-// that health checks can iterate without holding the lock.
-func (s *Service) clusterSnapshot() map[string]string {
+// replicaSnapshot returns the host-to-cluster mapping of the replicas
+// of the connected links, and of the disconnected replicas within
+// their grace period, so that health checks can iterate without
+// holding the lock. Loopback hosts are unique across clusters.
+func (s *Service) replicaSnapshot() map[string]string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	snapshot := make(map[string]string, len(s.links))
 	for name, entry := range s.links {
-		snapshot[name] = entry.Host
+		for _, r := range entry.Replicas {
+			if entry.Connected || !r.DisconnectedAt.IsZero() {
+				snapshot[r.Host] = name
+			}
+		}
 	}
 	return snapshot
 }
 
-// runHealthCheck periodically probes every registered cluster's
-// tunnel endpoint via TCP dial. Clusters that fail healthFailThreshold
-// consecutive probes are automatically deregistered.
+// markDisconnected marks the replica of cluster served from host as
+// disconnected after it failed the threshold of probes of its health
+// policy. The replica keeps its loopback host and chisel user for the
+// grace period, and the cluster is disconnected once none of its
+// replicas is left connected. It returns when the replica was marked,
+// and false if the replica is gone.
+func (s *Service) markDisconnected(ctx context.Context, cluster, host string, now time.Time) (time.Time, bool) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok {
+		return time.Time{}, false
+	}
+	i := replicaIndex(link.Replicas, host)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	if since := link.Replicas[i].DisconnectedAt; !since.IsZero() {
+		return since, true
+	}
+	if !link.Connected {
+		return time.Time{}, false
+	}
+
+	replicas := slices.Clone(link.Replicas)
+	replicas[i].DisconnectedAt = now
+	link = withReplicas(link, replicas)
+	link.Connected = anyConnected(replicas)
+	s.links[cluster] = link
+	if link.Connected {
+		s.log.Info("agent replica disconnected", "cluster", cluster, "host", host)
+		return now, true
+	}
+	s.log.Info("cluster disconnected", "cluster", cluster, "grace_period", s.health.policy(cluster).GracePeriod)
+	s.saveLocked(cluster, link)
+	s.publishLocked(core.LinkEventDisconnected, cluster, link)
+	return now, true
+}
+
+// reconnectReplica clears the disconnected mark of the replica of
+// cluster served from host after its agent answered a probe within the
+// grace period, reconnecting the cluster if it was disconnected.
+func (s *Service) reconnectReplica(ctx context.Context, cluster, host string) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok {
+		return
+	}
+	i := replicaIndex(link.Replicas, host)
+	if i < 0 || link.Replicas[i].DisconnectedAt.IsZero() {
+		return
+	}
+
+	replicas := slices.Clone(link.Replicas)
+	replicas[i].DisconnectedAt = time.Time{}
+	wasConnected := link.Connected
+	link = withReplicas(link, replicas)
+	link.Connected = true
+	s.links[cluster] = link
+	if wasConnected {
+		s.log.Info("agent replica reconnected", "cluster", cluster, "host", host)
+		return
+	}
+	s.log.Info("cluster reconnected", "cluster", cluster)
+	s.saveLocked(cluster, link)
+}
+
+// setReplicaHealth records the result of a health probe of the
+// cluster's replica served from host. Requests fail over to another
+// replica as soon as the active one turns unreachable or degraded,
+// without waiting for it to be disconnected. A change of the health
+// is published as a link event; latency is recorded silently.
+func (s *Service) setReplicaHealth(cluster, host string, health core.LinkHealth, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok || !link.Connected {
+		return
+	}
+	i := replicaIndex(link.Replicas, host)
+	if i < 0 {
+		return
+	}
+	prev := link.Replicas[i].Health
+	replicas := slices.Clone(link.Replicas)
+	replicas[i].Health = health
+	replicas[i].Healthy = health != core.LinkUnreachable
+	if health != core.LinkUnreachable {
+		replicas[i].Latency = latency
+	}
+	prevHost := link.Host
+	link = withReplicas(link, replicas)
+	s.links[cluster] = link
+
+	if health == prev {
+		return
+	}
+	if link.Host != prevHost {
+		s.log.Info("cluster failed over", "cluster", cluster, "agent", link.User, "host", link.Host)
+	}
+	var typ core.LinkEventType
+	switch health {
+	case core.LinkUnreachable:
+		typ = core.LinkEventUnhealthy
+	case core.LinkDegraded:
+		typ = core.LinkEventDegraded
+	default:
+		typ = core.LinkEventRecovered
+	}
+	s.publishLocked(typ, cluster, link)
+}
+
+// probeReplica sends a health probe through the tunnel endpoint addr
+// of a replica to the agent's core.AgentHealthPath and returns the
+// resulting health and the probe's round-trip time. An error means the
+// agent did not answer. Agents predating the health endpoint proxy
+// the probe to kube-apiserver; their answer only shows the agent is
+// reachable and counts as healthy.
+func probeReplica(ctx context.Context, client *http.Client, addr string) (core.LinkHealth, time.Duration, error) {
+	target := "http://" + addr + core.AgentHealthPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+	if err != nil {
+		return core.LinkUnreachable, 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req) // #nosec G704 -- the target is a loopback tunnel endpoint
+	if err != nil {
+		return core.LinkUnreachable, 0, err
+	}
+	latency := time.Since(start)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeBodyBytes))
+	_ = resp.Body.Close()
+
+	if resp.Header.Get(core.AgentHealthHeader) != "" && resp.StatusCode != http.StatusOK {
+		return core.LinkDegraded, latency, nil
+	}
+	return core.LinkHealthy, latency, nil
+}
+
+// runHealthCheck periodically probes the agent of every replica of the
+// connected clusters over HTTP through its tunnel. A replica whose
+// agent answers is healthy, or degraded if kube-apiserver is not
+// ready. A replica whose agent does not answer is unreachable; after
+// the fail threshold of its cluster's health policy it is marked as
+// disconnected, and once the grace period is over it is evicted. Every
+// round first refreshes the links other hub replicas own and drops the
+// links other replicas revoked.
 //
 // The method blocks until ctx is canceled.
 func (s *Service) runHealthCheck(ctx context.Context) {
-	ticker := time.NewTicker(healthCheckInterval)
+	ticker := time.NewTicker(s.health.Interval)
 	defer ticker.Stop()
 
-	dialer := &net.Dialer{Timeout: healthDialTimeout}
+	// Every probe opens a new connection, and thereby a new channel
+	// through the tunnel, so that a stale connection cannot mask a
+	// broken tunnel.
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
 	failCounts := make(map[string]int)
 
 	for {
@@ -77,66 +241,119 @@ func (s *Service) runHealthCheck(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.checkClusters(ctx, dialer, failCounts)
+			s.refreshRemote(ctx)
+			s.dropRevokedLinks(ctx)
+			s.checkClusters(ctx, client, failCounts)
 		}
 	}
 }
 
+// probeResult is the outcome of the health probe of a replica.
+type probeResult struct {
+	host    string
+	cluster string
+	health  core.LinkHealth
+	latency time.Duration
+	err     error
+}
+
+// probeReplicas probes every replica of snapshot concurrently, at most
+// maxConcurrentProbes at a time, each bounded by the probe timeout of
+// its cluster's health policy, so that a round takes about as long as
+// its slowest probe rather than the sum of all probes.
+func (s *Service) probeReplicas(ctx context.Context, client *http.Client, snapshot map[string]string) []probeResult {
+	results := make([]probeResult, 0, len(snapshot))
+	for host, cluster := range snapshot {
+		results = append(results, probeResult{host: host, cluster: cluster})
+	}
+
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentProbes)
+	for i := range results {
+		r := &results[i]
+		g.Go(func() error {
+			probeCtx, cancel := context.WithTimeout(ctx, s.health.policy(r.cluster).ProbeTimeout)
+			defer cancel()
+			r.health, r.latency, r.err = probeReplica(probeCtx, client, net.JoinHostPort(r.host, strconv.Itoa(tunnelPort)))
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results
+}
+
 // checkClusters performs a single round of health checks across all
-// registered clusters. failCounts is mutated in place to track
-// consecutive failures per cluster.
-func (s *Service) checkClusters(ctx context.Context, dialer *net.Dialer, failCounts map[string]int) {
-	snapshot := s.clusterSnapshot()
-
-	// Clean up failCounts for clusters that are no longer registered.
-	for name := range failCounts {
-		if _, ok := snapshot[name]; !ok {
-			delete(failCounts, name)
+// replicas of the registered clusters. The replicas are probed
+// concurrently; the results are applied afterwards on the calling
+// goroutine. failCounts is keyed by replica host and mutated in place
+// to track consecutive failures per replica.
+func (s *Service) checkClusters(ctx context.Context, client *http.Client, failCounts map[string]int) {
+	snapshot := s.replicaSnapshot()
+
+	// Clean up failCounts for replicas that are no longer registered.
+	for host := range failCounts {
+		if _, ok := snapshot[host]; !ok {
+			delete(failCounts, host)
 		}
 	}
 
-	for cluster, host := range snapshot {
-		addr := net.JoinHostPort(host, strconv.Itoa(tunnelPort))
-		conn, err := dialer.DialContext(ctx, "tcp", addr)
-		if err == nil {
-			if closeErr := conn.Close(); closeErr != nil {
-				s.log.Debug("failed to close health check connection", "cluster", cluster, "error", closeErr)
-			}
-			if failCounts[cluster] > 0 {
-				s.log.Debug("cluster recovered", "cluster", cluster)
+	results := s.probeReplicas(ctx, client, snapshot)
+
+	// Don't count context cancellation as a probe failure.
+	if ctx.Err() != nil {
+		return
+	}
+
+	for _, r := range results {
+		host, cluster := r.host, r.cluster
+		if r.err == nil {
+			if failCounts[host] > 0 {
+				s.log.Debug("replica reachable again", "cluster", cluster, "host", host)
 			}
-			delete(failCounts, cluster)
+			s.reconnectReplica(ctx, cluster, host)
+			s.setReplicaHealth(cluster, host, r.health, r.latency)
+			delete(failCounts, host)
+			s.touchCluster(ctx, cluster, host)
 			continue
 		}
 
-		// Don't count context cancellation as a probe failure.
-		if ctx.Err() != nil {
-			return
+		policy := s.health.policy(cluster)
+		failCounts[host]++
+		if failCounts[host] == 1 {
+			s.setReplicaHealth(cluster, host, core.LinkUnreachable, 0)
 		}
-
-		failCounts[cluster]++
 		s.log.Debug("probe failed",
 			"cluster", cluster,
-			"address", addr,
-			"consecutive_failures", failCounts[cluster],
-			"error", err,
+			"host", host,
+			"consecutive_failures", failCounts[host],
+			"error", r.err,
 		)
+		if failCounts[host] < policy.FailThreshold {
+			continue
+		}
 
-		if failCounts[cluster] >= healthFailThreshold {
-			// Verify the host hasn't changed since the snapshot was
-			// taken. A concurrent re-registration would assign a new
-			// host; deregistering in that case would be incorrect.
-			s.mu.RLock()
-			current, exists := s.links[cluster]
-			s.mu.RUnlock()
-			if exists && current.Host == host {
-				s.log.Info("deregistering disconnected cluster",
-					"cluster", cluster,
-					"consecutive_failures", failCounts[cluster],
-				)
-				s.DeregisterCluster(cluster)
-			}
-			delete(failCounts, cluster)
+		// markDisconnected and evictReplica verify that the replica
+		// still exists. A concurrent re-registration would assign a
+		// new host; disconnecting in that case would be incorrect.
+		now := time.Now()
+		since, ok := s.markDisconnected(ctx, cluster, host, now)
+		if ok && now.Sub(since) < policy.GracePeriod {
+			continue
+		}
+		changed, lost := s.evictReplica(ctx, cluster, host)
+		switch {
+		case lost:
+			s.log.Info("cluster evicted",
+				"cluster", cluster,
+				"consecutive_failures", failCounts[host],
+			)
+		case changed:
+			s.log.Info("agent replica evicted",
+				"cluster", cluster,
+				"host", host,
+				"consecutive_failures", failCounts[host],
+			)
 		}
+		delete(failCounts, host)
 	}
 }
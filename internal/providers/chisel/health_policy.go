@@ -0,0 +1,159 @@
+package chisel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// Defaults of the health check, as used by NewService.
+const (
+	// DefaultHealthCheckInterval is how often the health check probes
+	// the agent replicas of every registered cluster.
+	DefaultHealthCheckInterval = 15 * time.Second
+
+	// DefaultHealthProbeTimeout bounds a health probe, including the
+	// agent's kube-apiserver readiness check.
+	DefaultHealthProbeTimeout = 5 * time.Second
+
+	// DefaultHealthFailThreshold is the number of consecutive probe
+	// failures after which a replica is marked as disconnected.
+	DefaultHealthFailThreshold = 3
+
+	// DefaultHealthGracePeriod is how long a disconnected replica keeps
+	// its allocation before it is evicted.
+	DefaultHealthGracePeriod = 5 * time.Minute
+)
+
+// HealthPolicy controls when the health check gives up on the agent
+// replicas of a cluster.
+type HealthPolicy struct {
+	// ProbeTimeout bounds a health probe, including the agent's
+	// kube-apiserver readiness check. It must be shorter than the
+	// check interval.
+	ProbeTimeout time.Duration
+	// FailThreshold is the number of consecutive failed probes after
+	// which a replica is marked as disconnected.
+	FailThreshold int
+	// GracePeriod is how long a disconnected replica keeps its
+	// loopback host and chisel user, and is still probed, before it
+	// is evicted. A replica whose agent answers again within the
+	// grace period is reconnected without registering again. Zero
+	// evicts a replica as soon as it is disconnected.
+	GracePeriod time.Duration
+}
+
+// validate checks that p can be applied with the given check
+// interval. The probes of a round run concurrently, so a round takes
+// as long as its slowest probe; a probe timeout below the interval
+// keeps every round within the interval.
+func (p HealthPolicy) validate(interval time.Duration) error {
+	switch {
+	case p.ProbeTimeout <= 0:
+		return fmt.Errorf("probe timeout must be positive, got %s", p.ProbeTimeout)
+	case p.ProbeTimeout >= interval:
+		return fmt.Errorf("probe timeout must be shorter than the interval %s, got %s", interval, p.ProbeTimeout)
+	case p.FailThreshold < 1:
+		return fmt.Errorf("fail threshold must be at least 1, got %d", p.FailThreshold)
+	case p.GracePeriod < 0:
+		return fmt.Errorf("grace period must not be negative, got %s", p.GracePeriod)
+	}
+	return nil
+}
+
+// HealthConfig is the health check configuration of a Service.
+type HealthConfig struct {
+	// Interval is how often every replica is probed.
+	Interval time.Duration
+	// Default is the policy of the clusters without an override.
+	Default HealthPolicy
+	// Overrides holds the policies of individual clusters, keyed by
+	// cluster name, such as sites behind high-latency satellite
+	// links.
+	Overrides map[string]HealthPolicy
+}
+
+// DefaultHealthConfig returns the health check configuration of
+// NewService.
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		Interval: DefaultHealthCheckInterval,
+		Default: HealthPolicy{
+			ProbeTimeout:  DefaultHealthProbeTimeout,
+			FailThreshold: DefaultHealthFailThreshold,
+			GracePeriod:   DefaultHealthGracePeriod,
+		},
+	}
+}
+
+// NewHealthConfig returns a HealthConfig with the given interval and
+// default policy, and the per-cluster overrides parsed from entries of
+// the form <cluster>.<setting>=<value>, where setting is
+// probe-timeout, fail-threshold or grace-period, for example
+// "edge-1.probe-timeout=20s". The settings a cluster does not override
+// are taken from the default policy.
+func NewHealthConfig(interval time.Duration, def HealthPolicy, overrides []string) (HealthConfig, error) {
+	if interval <= 0 {
+		return HealthConfig{}, fmt.Errorf("health check: interval must be positive, got %s", interval)
+	}
+	if err := def.validate(interval); err != nil {
+		return HealthConfig{}, fmt.Errorf("health check: %w", err)
+	}
+
+	cfg := HealthConfig{Interval: interval, Default: def}
+	for _, entry := range overrides {
+		key, value, ok := strings.Cut(entry, "=")
+		cluster, setting, okKey := strings.Cut(strings.TrimSpace(key), ".")
+		if !ok || !okKey {
+			return HealthConfig{}, fmt.Errorf("health check override %q: want <cluster>.<setting>=<value>", entry)
+		}
+		if err := core.ValidateClusterName(cluster); err != nil {
+			return HealthConfig{}, fmt.Errorf("health check override %q: %w", entry, err)
+		}
+
+		if cfg.Overrides == nil {
+			cfg.Overrides = make(map[string]HealthPolicy)
+		}
+		p, ok := cfg.Overrides[cluster]
+		if !ok {
+			p = def
+		}
+		if err := p.set(setting, strings.TrimSpace(value)); err != nil {
+			return HealthConfig{}, fmt.Errorf("health check override %q: %w", entry, err)
+		}
+		cfg.Overrides[cluster] = p
+	}
+	for cluster, p := range cfg.Overrides {
+		if err := p.validate(interval); err != nil {
+			return HealthConfig{}, fmt.Errorf("health check override of cluster %s: %w", cluster, err)
+		}
+	}
+	return cfg, nil
+}
+
+// set parses value into the setting of p.
+func (p *HealthPolicy) set(setting, value string) error {
+	var err error
+	switch setting {
+	case "probe-timeout":
+		p.ProbeTimeout, err = time.ParseDuration(value)
+	case "fail-threshold":
+		p.FailThreshold, err = strconv.Atoi(value)
+	case "grace-period":
+		p.GracePeriod, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown setting %q, want probe-timeout, fail-threshold or grace-period", setting)
+	}
+	return err
+}
+
+// policy returns the health policy of cluster.
+func (c HealthConfig) policy(cluster string) HealthPolicy {
+	if p, ok := c.Overrides[cluster]; ok {
+		return p
+	}
+	return c.Default
+}
@@ -0,0 +1,61 @@
+package chisel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewHealthConfig(t *testing.T) {
+	def := DefaultHealthConfig().Default
+
+	cfg, err := NewHealthConfig(30*time.Second, def, []string{
+		"edge-1.probe-timeout=20s",
+		" edge-1.fail-threshold = 6",
+		"edge-2.grace-period=0s",
+	})
+	if err != nil {
+		t.Fatalf("NewHealthConfig: %v", err)
+	}
+
+	if cfg.Interval != 30*time.Second {
+		t.Errorf("Interval = %s, want 30s", cfg.Interval)
+	}
+	if got, want := cfg.policy("edge-1"), (HealthPolicy{ProbeTimeout: 20 * time.Second, FailThreshold: 6, GracePeriod: def.GracePeriod}); got != want {
+		t.Errorf("policy(edge-1) = %+v, want %+v", got, want)
+	}
+	if got, want := cfg.policy("edge-2"), (HealthPolicy{ProbeTimeout: def.ProbeTimeout, FailThreshold: def.FailThreshold}); got != want {
+		t.Errorf("policy(edge-2) = %+v, want %+v", got, want)
+	}
+	if got := cfg.policy("prod"); got != def {
+		t.Errorf("policy(prod) = %+v, want the default %+v", got, def)
+	}
+}
+
+func TestNewHealthConfig_Invalid(t *testing.T) {
+	def := DefaultHealthConfig().Default
+
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		def       HealthPolicy
+		overrides []string
+	}{
+		{"zero interval", 0, def, nil},
+		{"zero fail threshold", time.Minute, HealthPolicy{ProbeTimeout: time.Second}, nil},
+		{"default probe timeout exceeds interval", 5 * time.Second, HealthPolicy{ProbeTimeout: 10 * time.Second, FailThreshold: 1}, nil},
+		{"missing setting", time.Minute, def, []string{"edge-1=20s"}},
+		{"invalid cluster", time.Minute, def, []string{"Edge_1.probe-timeout=20s"}},
+		{"unknown setting", time.Minute, def, []string{"edge-1.interval=1m"}},
+		{"malformed value", time.Minute, def, []string{"edge-1.fail-threshold=many"}},
+		{"negative grace period", time.Minute, def, []string{"edge-1.grace-period=-1m"}},
+		{"probe timeout exceeds interval", time.Minute, def, []string{"edge-1.probe-timeout=1m"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHealthConfig(tt.interval, tt.def, tt.overrides); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
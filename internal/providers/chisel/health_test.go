@@ -0,0 +1,208 @@
+package chisel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+func TestProbeReplica(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    core.LinkHealth
+	}{
+		{
+			name: "ready",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set(core.AgentHealthHeader, "ready")
+			},
+			want: core.LinkHealthy,
+		},
+		{
+			name: "kube-apiserver not ready",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set(core.AgentHealthHeader, "not-ready")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			want: core.LinkDegraded,
+		},
+		{
+			name:    "agent without health endpoint",
+			handler: http.NotFound,
+			want:    core.LinkHealthy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			health, latency, err := probeReplica(t.Context(), srv.Client(), strings.TrimPrefix(srv.URL, "http://"))
+			if err != nil {
+				t.Fatalf("probeReplica: %v", err)
+			}
+			if health != tt.want {
+				t.Errorf("health = %q, want %q", health, tt.want)
+			}
+			if latency <= 0 {
+				t.Errorf("latency = %v, want it recorded", latency)
+			}
+			if path != core.AgentHealthPath {
+				t.Errorf("probed %q, want %q", path, core.AgentHealthPath)
+			}
+		})
+	}
+}
+
+func TestProbeReplica_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	if health, _, err := probeReplica(t.Context(), http.DefaultClient, addr); err == nil || health != core.LinkUnreachable {
+		t.Errorf("got %q, %v; want unreachable with an error", health, err)
+	}
+}
+
+// TestProbeReplicas_Concurrent verifies that the replicas of a round
+// are probed concurrently: every agent only answers once all probes
+// have arrived, which sequential probes would never achieve.
+func TestProbeReplicas_Concurrent(t *testing.T) {
+	const replicas = 8
+
+	var arrived sync.WaitGroup
+	arrived.Add(replicas)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.Header().Set(core.AgentHealthHeader, "ready")
+	}))
+	defer srv.Close()
+
+	// Route the probe of every loopback host to the test agent.
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}}
+
+	s := NewService(nil, pki.NewRevocationList(), nil)
+	s.health.Default.ProbeTimeout = 5 * time.Second
+	snapshot := make(map[string]string, replicas)
+	for i := range replicas {
+		snapshot[fmt.Sprintf("127.0.0.%d", i+1)] = "prod"
+	}
+
+	results := s.probeReplicas(t.Context(), client, snapshot)
+	if len(results) != replicas {
+		t.Fatalf("got %d results, want %d", len(results), replicas)
+	}
+	for _, r := range results {
+		if r.err != nil || r.health != core.LinkHealthy {
+			t.Errorf("probe of %s = %q, %v; want healthy", r.host, r.health, r.err)
+		}
+	}
+}
+
+func TestDisconnectGracePeriod(t *testing.T) {
+	s := NewService(nil, pki.NewRevocationList(), nil)
+	events := s.WatchLinks(t.Context())
+	nextEvent := func(want core.LinkEventType) {
+		t.Helper()
+		if ev := <-events; ev.Type != want {
+			t.Fatalf("event = %s, want %s", ev.Type, want)
+		}
+	}
+
+	now := time.Now()
+	s.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.1", User: "agent-1", Healthy: true, Health: core.LinkHealthy, RegisteredAt: now},
+	})
+
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkUnreachable, 0)
+	nextEvent(core.LinkEventUnhealthy)
+	since, ok := s.markDisconnected(t.Context(), "prod", "127.0.0.1", now)
+	if !ok || !since.Equal(now) {
+		t.Fatalf("markDisconnected = %v, %v; want %v, true", since, ok, now)
+	}
+	nextEvent(core.LinkEventDisconnected)
+
+	link, listed := s.ListLinks()["prod"]
+	if !listed || link.Connected {
+		t.Fatalf("listed = %v, connected = %v; want the cluster listed as disconnected", listed, link.Connected)
+	}
+	if _, err := s.ResolveAddress(t.Context(), "prod"); err == nil {
+		t.Error("expected a disconnected cluster not to resolve")
+	}
+	if _, probed := s.replicaSnapshot()["127.0.0.1"]; !probed {
+		t.Error("expected a replica within its grace period to be probed")
+	}
+	if again, _ := s.markDisconnected(t.Context(), "prod", "127.0.0.1", now.Add(time.Minute)); !again.Equal(now) {
+		t.Errorf("markDisconnected again = %v, want the first mark %v", again, now)
+	}
+
+	// The agent answers again within the grace period.
+	s.reconnectReplica(t.Context(), "prod", "127.0.0.1")
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkHealthy, time.Millisecond)
+	nextEvent(core.LinkEventRecovered)
+	if _, err := s.ResolveAddress(t.Context(), "prod"); err != nil {
+		t.Fatalf("ResolveAddress after reconnecting: %v", err)
+	}
+
+	// The agent stays away past the grace period.
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkUnreachable, 0)
+	nextEvent(core.LinkEventUnhealthy)
+	s.markDisconnected(t.Context(), "prod", "127.0.0.1", now)
+	nextEvent(core.LinkEventDisconnected)
+	if changed, lost := s.evictReplica(t.Context(), "prod", "127.0.0.1"); !changed || !lost {
+		t.Fatalf("evictReplica = %v, %v; want the cluster's last tunnel evicted", changed, lost)
+	}
+	if _, probed := s.replicaSnapshot()["127.0.0.1"]; probed {
+		t.Error("expected an evicted replica not to be probed")
+	}
+	if link, listed := s.ListLinks()["prod"]; !listed || link.Connected {
+		t.Errorf("listed = %v, connected = %v; want the evicted cluster listed as disconnected", listed, link.Connected)
+	}
+}
+
+func TestDisconnectGracePeriod_OtherReplicaConnected(t *testing.T) {
+	s := NewService(nil, pki.NewRevocationList(), nil)
+
+	now := time.Now()
+	s.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.1", User: "agent-old", Healthy: true, Health: core.LinkHealthy, RegisteredAt: now.Add(-time.Minute)},
+		{Host: "127.0.0.2", User: "agent-new", Healthy: true, Health: core.LinkHealthy, RegisteredAt: now},
+	})
+
+	s.setReplicaHealth("prod", "127.0.0.2", core.LinkUnreachable, 0)
+	s.markDisconnected(t.Context(), "prod", "127.0.0.2", now)
+
+	link := s.ListLinks()["prod"]
+	if !link.Connected || link.User != "agent-old" || len(link.Replicas) != 2 {
+		t.Fatalf("got %+v, want the cluster connected through agent-old, keeping both replicas", link)
+	}
+
+	if changed, lost := s.evictReplica(t.Context(), "prod", "127.0.0.2"); !changed || lost {
+		t.Fatalf("evictReplica = %v, %v; want the replica dropped", changed, lost)
+	}
+	if link := s.ListLinks()["prod"]; !link.Connected || len(link.Replicas) != 1 {
+		t.Errorf("got %+v, want only agent-old, connected", link)
+	}
+}
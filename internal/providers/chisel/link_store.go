@@ -0,0 +1,320 @@
+package chisel
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+const (
+	// linkStoreTimeout bounds every write to the link store, so that
+	// a slow store cannot stall registrations or the health check.
+	linkStoreTimeout = 5 * time.Second
+
+	// lastSeenSaveInterval is how often the last-seen time of a
+	// healthy link is written back to the store. Registrations,
+	// renewals and disconnects are always written.
+	lastSeenSaveInterval = 5 * time.Minute
+
+	// remoteStaleAfter is how long after its last-seen time the link
+	// of another hub replica is considered disconnected. The owner
+	// writes the last-seen time of a healthy link every
+	// lastSeenSaveInterval, so a staler link belongs to a replica
+	// that has gone away.
+	remoteStaleAfter = 2*lastSeenSaveInterval + time.Minute
+)
+
+// LoadService returns a Service like NewService whose links are
+// restored from store. Restored links are disconnected until their
+// agent registers again, but keep their loopback host reserved.
+// Subsequent link changes are written back to the same store.
+//
+// With peer forwarding enabled, several hub replicas share the store:
+// links are written with replica as their owner, and the links that
+// other live replicas own are tracked, and refreshed by the health
+// check, instead of being restored, so that their requests are
+// forwarded to the owner.
+//
+// The health check of the links follows health.
+func LoadService(ctx context.Context, ca *pki.CA, revocations *pki.RevocationList, policy *pki.CSRPolicy, store core.LinkStore, replica core.HubReplica, health HealthConfig) (*Service, error) {
+	s := NewService(ca, revocations, policy)
+	s.replica = replica
+	s.health = health
+
+	records, err := store.LoadLinks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load links: %w", err)
+	}
+	for _, rec := range records {
+		if s.ownedElsewhere(rec) && remoteConnected(rec) {
+			s.remote[rec.Cluster] = rec
+			continue
+		}
+		if !s.addrs.reserve(rec.Host) {
+			s.log.Warn("skipping persisted link with a conflicting host", "cluster", rec.Cluster, "host", rec.Host)
+			continue
+		}
+		// Only the active replica is persisted; the others get a
+		// new host when they register again.
+		s.links[rec.Cluster] = withReplicas(core.Link{
+			FirstSeen: rec.FirstSeen,
+			Labels:    rec.Labels,
+			Facts:     rec.Facts,
+		}, []core.LinkReplica{{
+			Host:         rec.Host,
+			User:         rec.AgentID,
+			AgentVersion: rec.AgentVersion,
+			LastSeen:     rec.LastSeen,
+		}})
+		s.savedSeen[rec.Cluster] = rec.LastSeen
+	}
+
+	s.store = store
+	s.log.Info("links restored", "count", len(s.links))
+	return s, nil
+}
+
+// touchCluster records a successful probe of the cluster's replica
+// served from host. The last-seen time is persisted at most every
+// lastSeenSaveInterval.
+func (s *Service) touchCluster(ctx context.Context, cluster, host string) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok || !link.Connected {
+		return
+	}
+	i := replicaIndex(link.Replicas, host)
+	if i < 0 {
+		return
+	}
+	replicas := slices.Clone(link.Replicas)
+	replicas[i].LastSeen = time.Now()
+	link = withReplicas(link, replicas)
+	s.links[cluster] = link
+	if link.LastSeen.Sub(s.savedSeen[cluster]) >= lastSeenSaveInterval {
+		s.saveLocked(cluster, link)
+	}
+}
+
+// linkWrites holds the link store writes that were queued while the
+// service lock was held, keyed by cluster. A queued write is superseded
+// by a later one of the same cluster; active marks the clusters whose
+// writes a goroutine is performing, so that the writes of a cluster
+// reach the store one at a time and in order.
+type linkWrites struct {
+	mu      sync.Mutex
+	pending map[string]linkWrite
+	active  map[string]bool
+}
+
+// linkWrite is a queued write of a cluster's link: rec is saved, or
+// the record is deleted if remove is set.
+type linkWrite struct {
+	rec    core.LinkRecord
+	remove bool
+}
+
+// queue queues w as the next write of cluster.
+func (w *linkWrites) queue(cluster string, op linkWrite) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending == nil {
+		w.pending = make(map[string]linkWrite)
+		w.active = make(map[string]bool)
+	}
+	w.pending[cluster] = op
+}
+
+// claim returns the clusters with queued writes that no other
+// goroutine is performing, and marks them as active.
+func (w *linkWrites) claim() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var clusters []string
+	for cluster := range w.pending {
+		if !w.active[cluster] {
+			w.active[cluster] = true
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// next removes and returns the queued write of the active cluster, or
+// releases the cluster if none is left.
+func (w *linkWrites) next(cluster string) (linkWrite, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	op, ok := w.pending[cluster]
+	if !ok {
+		delete(w.active, cluster)
+		return linkWrite{}, false
+	}
+	delete(w.pending, cluster)
+	return op, true
+}
+
+// saveLocked queues the write of link to the store, which the caller
+// performs with writeLinks once it released s.mu. s.mu must be held.
+func (s *Service) saveLocked(cluster string, link core.Link) {
+	if s.store == nil {
+		return
+	}
+	// Do not take the link over from the replica that now holds its
+	// tunnel.
+	if rec, ok := s.remote[cluster]; ok && !link.Connected && remoteConnected(rec) {
+		return
+	}
+	s.writes.queue(cluster, linkWrite{rec: core.LinkRecord{
+		Cluster:      cluster,
+		AgentID:      link.User,
+		AgentVersion: link.AgentVersion,
+		Host:         link.Host,
+		FirstSeen:    link.FirstSeen,
+		LastSeen:     link.LastSeen,
+		Labels:       link.Labels,
+		Facts:        link.Facts,
+		Connected:    link.Connected,
+		HubReplica:   s.replicaID(),
+		PeerURL:      s.replica.PeerURL,
+	}})
+	s.savedSeen[cluster] = link.LastSeen
+}
+
+// deleteLocked queues the removal of the cluster's link from the
+// store, which the caller performs with writeLinks once it released
+// s.mu. s.mu must be held.
+func (s *Service) deleteLocked(cluster string) {
+	delete(s.savedSeen, cluster)
+	if s.store == nil {
+		return
+	}
+	s.writes.queue(cluster, linkWrite{rec: core.LinkRecord{Cluster: cluster}, remove: true})
+}
+
+// writeLinks performs the queued link store writes. Callers that
+// queue writes defer it before acquiring s.mu, so that a slow store
+// never stalls other callers while the lock is held. Failures are
+// logged rather than returned: the in-memory registry stays
+// authoritative, and the next change of the link writes it again.
+func (s *Service) writeLinks(ctx context.Context) {
+	for _, cluster := range s.writes.claim() {
+		for {
+			op, ok := s.writes.next(cluster)
+			if !ok {
+				break
+			}
+			s.writeLink(ctx, op)
+		}
+	}
+}
+
+// writeLink performs a single queued write.
+func (s *Service) writeLink(ctx context.Context, op linkWrite) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), linkStoreTimeout)
+	defer cancel()
+
+	if op.remove {
+		// A stale record only lists the cluster as disconnected after
+		// the next restart.
+		if err := s.store.DeleteLink(ctx, op.rec.Cluster); err != nil {
+			s.log.Warn("failed to delete persisted link", "cluster", op.rec.Cluster, "error", err)
+		}
+		return
+	}
+	if err := s.store.SaveLink(ctx, op.rec); err != nil {
+		s.log.Warn("failed to persist link", "cluster", op.rec.Cluster, "error", err)
+		// Write the last-seen time again on the next probe.
+		s.mu.Lock()
+		if s.savedSeen[op.rec.Cluster].Equal(op.rec.LastSeen) {
+			delete(s.savedSeen, op.rec.Cluster)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// replicaID returns the owner written to the store: the ID of this
+// replica with peer forwarding enabled, and none otherwise, so that a
+// single hub keeps its links across restarts whatever its host name.
+func (s *Service) replicaID() string {
+	if s.replica.PeerURL == "" {
+		return ""
+	}
+	return s.replica.ID
+}
+
+// ownedElsewhere reports whether rec was written by another hub
+// replica with peer forwarding enabled.
+func (s *Service) ownedElsewhere(rec core.LinkRecord) bool {
+	return s.replica.PeerURL != "" && rec.HubReplica != "" && rec.HubReplica != s.replica.ID && rec.PeerURL != ""
+}
+
+// remoteConnected reports whether rec describes a tunnel that is up
+// on the replica that wrote it.
+func remoteConnected(rec core.LinkRecord) bool {
+	return rec.Connected && time.Since(rec.LastSeen) < remoteStaleAfter
+}
+
+// remoteLink returns the Link of the record of another hub replica.
+func remoteLink(rec core.LinkRecord) core.Link {
+	return core.Link{
+		User:         rec.AgentID,
+		AgentVersion: rec.AgentVersion,
+		Connected:    remoteConnected(rec),
+		FirstSeen:    rec.FirstSeen,
+		LastSeen:     rec.LastSeen,
+		Labels:       rec.Labels,
+		Facts:        rec.Facts,
+		HubReplica:   rec.HubReplica,
+	}
+}
+
+// refreshRemote reloads the links that other hub replicas own from
+// the store. A cluster whose agent moved to another replica is
+// released here once the other replica holds its tunnel, keeping the
+// local link only while this replica still holds a tunnel of it. It
+// is a no-op unless peer forwarding is enabled.
+func (s *Service) refreshRemote(ctx context.Context) {
+	if s.store == nil || s.replica.PeerURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, linkStoreTimeout)
+	defer cancel()
+
+	records, err := s.store.LoadLinks(ctx)
+	if err != nil {
+		s.log.Warn("failed to refresh links of other hub replicas", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remote := make(map[string]core.LinkRecord)
+	for _, rec := range records {
+		if !s.ownedElsewhere(rec) {
+			continue
+		}
+		remote[rec.Cluster] = rec
+		if link, ok := s.links[rec.Cluster]; ok && !link.Connected && remoteConnected(rec) {
+			for _, r := range link.Replicas {
+				s.releaseReplicaLocked(nil, r)
+			}
+			delete(s.links, rec.Cluster)
+			delete(s.savedSeen, rec.Cluster)
+			s.log.Info("cluster moved to another hub replica", "cluster", rec.Cluster, "hub_replica", rec.HubReplica)
+		}
+	}
+	s.remote = remote
+}
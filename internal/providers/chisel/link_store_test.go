@@ -0,0 +1,78 @@
+package chisel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// blockingLinkStore is a core.LinkStore whose first SaveLink blocks
+// until release is closed.
+type blockingLinkStore struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu    sync.Mutex
+	saved []time.Time
+}
+
+func (b *blockingLinkStore) LoadLinks(context.Context) ([]core.LinkRecord, error) {
+	return nil, nil
+}
+
+func (b *blockingLinkStore) SaveLink(_ context.Context, rec core.LinkRecord) error {
+	b.mu.Lock()
+	first := len(b.saved) == 0
+	b.saved = append(b.saved, rec.LastSeen)
+	b.mu.Unlock()
+	if first {
+		close(b.started)
+		<-b.release
+	}
+	return nil
+}
+
+func (b *blockingLinkStore) DeleteLink(context.Context, string) error {
+	return nil
+}
+
+// TestWriteLinks_OutsideLock verifies that link store writes are
+// performed without holding the service lock, and that the writes of a
+// cluster reach the store in order.
+func TestWriteLinks_OutsideLock(t *testing.T) {
+	store := &blockingLinkStore{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewService(nil, pki.NewRevocationList(), nil)
+	s.store = store
+
+	first, second := time.Unix(1, 0), time.Unix(2, 0)
+	save := func(seen time.Time) {
+		s.mu.Lock()
+		s.saveLocked("prod", withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+			{Host: "127.0.0.1", User: "agent-1", LastSeen: seen},
+		}))
+		s.mu.Unlock()
+		s.writeLinks(t.Context())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		save(first)
+	}()
+	<-store.started
+
+	// The first write is blocked in the store: the lock is free, and a
+	// second write is queued behind it instead of overtaking it.
+	s.ListLinks()
+	save(second)
+	close(store.release)
+	<-done
+
+	if len(store.saved) != 2 || !store.saved[0].Equal(first) || !store.saved[1].Equal(second) {
+		t.Errorf("saved = %v, want %v then %v", store.saved, first, second)
+	}
+}
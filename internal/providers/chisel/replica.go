@@ -0,0 +1,81 @@
+package chisel
+
+import (
+	"slices"
+
+	chserver "github.com/jpillora/chisel/server"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// maxReplicas bounds the number of agent replicas of a cluster, and
+// thereby the loopback hosts and chisel users it holds. Registering
+// beyond the bound evicts the least recently seen replica, which is
+// usually an agent pod that has already gone away.
+const maxReplicas = 8
+
+// withReplicas returns link with replicas as its replicas, most
+// recently registered first, and its endpoint fields and last-seen
+// time derived from them. replicas is sorted in place, so it must not
+// be shared with another link.
+func withReplicas(link core.Link, replicas []core.LinkReplica) core.Link {
+	slices.SortStableFunc(replicas, func(a, b core.LinkReplica) int {
+		return b.RegisteredAt.Compare(a.RegisteredAt)
+	})
+	link.Replicas = replicas
+
+	if i := activeReplica(replicas); i >= 0 {
+		active := replicas[i]
+		link.Host = active.Host
+		link.User = active.User
+		link.AgentVersion = active.AgentVersion
+		link.CAFingerprint = active.CAFingerprint
+		link.Serial = active.Serial
+		link.Health = active.Health
+		link.Latency = active.Latency
+	}
+	for _, r := range replicas {
+		if r.LastSeen.After(link.LastSeen) {
+			link.LastSeen = r.LastSeen
+		}
+	}
+	return link
+}
+
+// activeReplica returns the index of the replica requests are routed
+// to: the most recently registered healthy replica, else the most
+// recently registered reachable one, else the most recently registered
+// one. replicas must be sorted as by withReplicas. It returns -1 for
+// no replicas.
+func activeReplica(replicas []core.LinkReplica) int {
+	if len(replicas) == 0 {
+		return -1
+	}
+	if i := slices.IndexFunc(replicas, func(r core.LinkReplica) bool { return r.Healthy && r.Health != core.LinkDegraded }); i >= 0 {
+		return i
+	}
+	if i := slices.IndexFunc(replicas, func(r core.LinkReplica) bool { return r.Healthy }); i >= 0 {
+		return i
+	}
+	return 0
+}
+
+// replicaIndex returns the index of the replica served from host, or
+// -1 if there is none.
+func replicaIndex(replicas []core.LinkReplica, host string) int {
+	return slices.IndexFunc(replicas, func(r core.LinkReplica) bool { return r.Host == host })
+}
+
+// anyConnected reports whether one of replicas is not disconnected.
+func anyConnected(replicas []core.LinkReplica) bool {
+	return slices.ContainsFunc(replicas, func(r core.LinkReplica) bool { return r.DisconnectedAt.IsZero() })
+}
+
+// releaseReplicaLocked deletes the replica's chisel user and returns
+// its loopback host to the pool. srv may be nil. s.mu must be held.
+func (s *Service) releaseReplicaLocked(srv *chserver.Server, r core.LinkReplica) {
+	if srv != nil {
+		srv.DeleteUser(r.User)
+	}
+	s.addrs.release(r.Host)
+}
@@ -0,0 +1,89 @@
+package chisel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+func TestReplicaFailover(t *testing.T) {
+	s := NewService(nil, pki.NewRevocationList(), nil)
+
+	now := time.Now()
+	s.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.1", User: "agent-old", Healthy: true, RegisteredAt: now.Add(-time.Minute)},
+		{Host: "127.0.0.2", User: "agent-new", Healthy: true, RegisteredAt: now},
+	})
+	resolve := func() string {
+		t.Helper()
+		addr, err := s.ResolveAddress(t.Context(), "prod")
+		if err != nil {
+			t.Fatalf("ResolveAddress: %v", err)
+		}
+		return addr
+	}
+
+	if got, want := resolve(), "http://127.0.0.2:16598"; got != want {
+		t.Fatalf("got %q, want the most recently registered replica %q", got, want)
+	}
+
+	s.setReplicaHealth("prod", "127.0.0.2", core.LinkUnreachable, 0)
+	if got, want := resolve(), "http://127.0.0.1:16598"; got != want {
+		t.Fatalf("got %q, want failover to %q", got, want)
+	}
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkUnreachable, 0)
+	if got, want := resolve(), "http://127.0.0.2:16598"; got != want {
+		t.Fatalf("got %q, want %q while no replica is healthy", got, want)
+	}
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkHealthy, time.Millisecond)
+
+	changed, lost := s.evictReplica(t.Context(), "prod", "127.0.0.2")
+	if !changed || lost {
+		t.Fatalf("evictReplica = %v, %v; want the replica dropped", changed, lost)
+	}
+	if link := s.ListLinks()["prod"]; len(link.Replicas) != 1 || link.User != "agent-old" {
+		t.Fatalf("got replicas %+v, want only agent-old", link.Replicas)
+	}
+
+	changed, lost = s.evictReplica(t.Context(), "prod", "127.0.0.1")
+	if !changed || !lost {
+		t.Fatalf("evictReplica = %v, %v; want the cluster lost", changed, lost)
+	}
+	if _, err := s.ResolveAddress(t.Context(), "prod"); err == nil {
+		t.Fatal("expected a disconnected cluster not to resolve")
+	}
+	if link := s.ListLinks()["prod"]; len(link.Replicas) != 1 {
+		t.Fatalf("got %d replicas, want the last one kept", len(link.Replicas))
+	}
+}
+
+func TestReplicaHealth_PrefersHealthyOverDegraded(t *testing.T) {
+	s := NewService(nil, pki.NewRevocationList(), nil)
+	events := s.WatchLinks(t.Context())
+
+	now := time.Now()
+	s.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.1", User: "agent-old", Healthy: true, Health: core.LinkHealthy, RegisteredAt: now.Add(-time.Minute)},
+		{Host: "127.0.0.2", User: "agent-new", Healthy: true, Health: core.LinkHealthy, RegisteredAt: now},
+	})
+
+	s.setReplicaHealth("prod", "127.0.0.2", core.LinkDegraded, 40*time.Millisecond)
+	link := s.ListLinks()["prod"]
+	if link.User != "agent-old" || link.Health != core.LinkHealthy {
+		t.Fatalf("active = %s (%s), want the healthy agent-old", link.User, link.Health)
+	}
+	if ev := <-events; ev.Type != core.LinkEventDegraded {
+		t.Errorf("event = %s, want %s", ev.Type, core.LinkEventDegraded)
+	}
+
+	s.setReplicaHealth("prod", "127.0.0.1", core.LinkUnreachable, 0)
+	link = s.ListLinks()["prod"]
+	if link.User != "agent-new" || link.Health != core.LinkDegraded || link.Latency != 40*time.Millisecond {
+		t.Fatalf("active = %s (%s, %v), want the degraded agent-new at 40ms", link.User, link.Health, link.Latency)
+	}
+	if ev := <-events; ev.Type != core.LinkEventUnhealthy {
+		t.Errorf("event = %s, want %s", ev.Type, core.LinkEventUnhealthy)
+	}
+}
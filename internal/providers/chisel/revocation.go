@@ -0,0 +1,196 @@
+package chisel
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"slices"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+var _ core.CertificateRevoker = (*Service)(nil)
+
+// RevokeCluster adds the current certificate serials and agent IDs of
+// every replica of the cluster to the revocation list, deletes the
+// chisel users, releases the loopback hosts, drops the persisted link
+// and closes the agents' TLS connections. Deleting the chisel users
+// alone only prevents new SSH sessions; closing the connections is
+// what terminates the running ones.
+//
+// A cluster whose tunnel terminates on another hub replica has its
+// agent revoked in the shared revocation list and its shared link
+// record dropped; the owner releases the link and closes the agent's
+// connections once it sees the revocation (see dropRevokedLinks).
+func (s *Service) RevokeCluster(ctx context.Context, cluster, reason string) error {
+	s.mu.RLock()
+	link, local := s.links[cluster]
+	rec, remote := s.remote[cluster]
+	s.mu.RUnlock()
+
+	replicas := link.Replicas
+	switch {
+	case local:
+	case remote:
+		// The record of another hub replica does not carry the
+		// certificate serial.
+		replicas = []core.LinkReplica{{User: rec.AgentID}}
+	default:
+		return &core.ErrClusterNotFound{Cluster: cluster}
+	}
+
+	// The revocations are written to the store without holding s.mu,
+	// so that a slow store does not stall the other callers.
+	for _, r := range replicas {
+		// Links restored from the store do not know their serial;
+		// the agent revocation covers every certificate of the
+		// agent.
+		if r.Serial != "" {
+			if err := s.revocations.RevokeSerial(ctx, r.Serial, reason); err != nil {
+				return fmt.Errorf("revoke certificate: %w", err)
+			}
+		}
+		if err := s.revocations.RevokeAgent(ctx, r.User, reason); err != nil {
+			return fmt.Errorf("revoke agent: %w", err)
+		}
+	}
+
+	if !local {
+		s.dropRemote(ctx, rec)
+		s.log.Warn("cluster revoked on another hub replica",
+			"cluster", cluster,
+			"hub_replica", rec.HubReplica,
+			"reason", reason,
+		)
+		return nil
+	}
+	s.dropLink(ctx, cluster)
+
+	kicked := 0
+	if t := s.tunnel.Load(); t != nil {
+		kicked = t.CloseConnections(s.revocations.IsRevoked)
+	}
+
+	s.log.Warn("cluster revoked",
+		"cluster", cluster,
+		"replicas", len(replicas),
+		"reason", reason,
+		"connections_closed", kicked,
+	)
+	return nil
+}
+
+// dropLink releases the replicas of cluster and drops its link. The
+// link may have changed since it was revoked; its current replicas are
+// released, unless it was removed meanwhile.
+func (s *Service) dropLink(ctx context.Context, cluster string) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok {
+		return
+	}
+	srv := s.server.Load()
+	for _, r := range link.Replicas {
+		s.releaseReplicaLocked(srv, r)
+	}
+	delete(s.links, cluster)
+	s.deleteLocked(cluster)
+	s.publishLocked(core.LinkEventDeregistered, cluster, link)
+}
+
+// dropRemote drops the link of rec, whose tunnel terminates on another
+// hub replica, and its shared record.
+func (s *Service) dropRemote(ctx context.Context, rec core.LinkRecord) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.remote, rec.Cluster)
+	s.deleteLocked(rec.Cluster)
+	s.publishLocked(core.LinkEventDeregistered, rec.Cluster, remoteLink(rec))
+}
+
+// dropRevokedLinks releases the links whose agents are all revoked and
+// closes their connections. It lets the replica holding a tunnel act on
+// a revocation made by another hub replica, which only reaches it
+// through the shared revocation list. It is a no-op unless peer
+// forwarding is enabled.
+func (s *Service) dropRevokedLinks(ctx context.Context) {
+	if s.replica.PeerURL == "" {
+		return
+	}
+	s.mu.RLock()
+	var revoked []string
+	for cluster, link := range s.links {
+		if !slices.ContainsFunc(link.Replicas, func(r core.LinkReplica) bool {
+			return !s.revocations.IsAgentRevoked(r.User)
+		}) {
+			revoked = append(revoked, cluster)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(revoked) == 0 {
+		return
+	}
+	for _, cluster := range revoked {
+		s.dropLink(ctx, cluster)
+		s.log.Warn("cluster revoked by another hub replica", "cluster", cluster)
+	}
+	if t := s.tunnel.Load(); t != nil {
+		t.CloseConnections(s.revocations.IsRevoked)
+	}
+}
+
+// Revocations returns every current deny-list entry.
+func (s *Service) Revocations(_ context.Context) ([]core.Revocation, error) {
+	entries := s.revocations.List()
+	ret := make([]core.Revocation, 0, len(entries))
+	for _, r := range entries {
+		ret = append(ret, core.Revocation{
+			Serial:    r.Serial,
+			AgentID:   r.AgentID,
+			Reason:    r.Reason,
+			RevokedAt: r.RevokedAt,
+			ExpiresAt: r.ExpiresAt,
+		})
+	}
+	return ret, nil
+}
+
+// RestoreAgent lifts the revocation of agentID. It returns an
+// ErrorCodeNotFound domain error if the agent is not revoked.
+func (s *Service) RestoreAgent(ctx context.Context, agentID string) error {
+	removed, err := s.revocations.RestoreAgent(ctx, agentID)
+	if err != nil {
+		return fmt.Errorf("restore agent: %w", err)
+	}
+	if !removed {
+		return &core.DomainError{
+			Code:    core.ErrorCodeNotFound,
+			Message: fmt.Sprintf("agent %q is not revoked", agentID),
+		}
+	}
+	s.log.Info("agent revocation lifted", "agent", agentID)
+	return nil
+}
+
+// verifyNotRevoked is a tls.Config.VerifyConnection hook that rejects
+// client certificates on the revocation list.
+func (s *Service) verifyNotRevoked(certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	if s.revocations.IsRevoked(certs[0]) {
+		s.log.Warn("rejected revoked client certificate",
+			"agent", certs[0].Subject.CommonName,
+			"serial", pki.SerialString(certs[0]),
+		)
+		return fmt.Errorf("client certificate %s has been revoked", pki.SerialString(certs[0]))
+	}
+	return nil
+}
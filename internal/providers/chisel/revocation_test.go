@@ -0,0 +1,143 @@
+package chisel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// memLinkStore is an in-memory core.LinkStore.
+type memLinkStore struct {
+	mu      sync.Mutex
+	records map[string]core.LinkRecord
+}
+
+func (m *memLinkStore) LoadLinks(context.Context) ([]core.LinkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]core.LinkRecord, 0, len(m.records))
+	for _, rec := range m.records {
+		ret = append(ret, rec)
+	}
+	return ret, nil
+}
+
+func (m *memLinkStore) SaveLink(_ context.Context, rec core.LinkRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[rec.Cluster] = rec
+	return nil
+}
+
+func (m *memLinkStore) DeleteLink(_ context.Context, cluster string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, cluster)
+	return nil
+}
+
+// TestRevokeCluster_RemoteOwner verifies that revoking a cluster whose
+// tunnel terminates on another hub replica revokes its agent in the
+// shared revocation list and drops the shared record, and that the
+// owner then releases the link.
+func TestRevokeCluster_RemoteOwner(t *testing.T) {
+	ctx := t.Context()
+	revocations := pki.NewRevocationList()
+	rec := core.LinkRecord{
+		Cluster:    "prod",
+		AgentID:    "agent-1",
+		Host:       "127.0.0.2",
+		LastSeen:   time.Now(),
+		Connected:  true,
+		HubReplica: "hub-0",
+		PeerURL:    "https://hub-0:8443",
+	}
+	store := &memLinkStore{records: map[string]core.LinkRecord{"prod": rec}}
+
+	owner := NewService(nil, revocations, nil)
+	owner.replica = core.HubReplica{ID: "hub-0", PeerURL: "https://hub-0:8443"}
+	owner.store = store
+	owner.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.2", User: "agent-1", LastSeen: rec.LastSeen},
+	})
+
+	other := NewService(nil, revocations, nil)
+	other.replica = core.HubReplica{ID: "hub-1", PeerURL: "https://hub-1:8443"}
+	other.store = store
+	other.remote["prod"] = rec
+
+	if err := other.RevokeCluster(ctx, "prod", "test"); err != nil {
+		t.Fatalf("RevokeCluster: %v", err)
+	}
+	if !revocations.IsAgentRevoked("agent-1") {
+		t.Error("agent of the remote link is not revoked")
+	}
+	if _, ok := other.ListLinks()["prod"]; ok {
+		t.Error("remote link still listed")
+	}
+	if records, _ := store.LoadLinks(ctx); len(records) != 0 {
+		t.Errorf("records = %v, want the shared record dropped", records)
+	}
+
+	owner.dropRevokedLinks(ctx)
+	if _, ok := owner.ListLinks()["prod"]; ok {
+		t.Error("owner still lists the revoked link")
+	}
+	if records, _ := store.LoadLinks(ctx); len(records) != 0 {
+		t.Errorf("records = %v, want the owner not to write the link back", records)
+	}
+}
+
+// blockingRevocationStore is a pki.RevocationStore whose updates block
+// until release is closed.
+type blockingRevocationStore struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRevocationStore) LoadRevocations(context.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (b *blockingRevocationStore) UpdateRevocations(_ context.Context, fn func([]byte) ([]byte, error)) error {
+	close(b.started)
+	<-b.release
+	_, err := fn(nil)
+	return err
+}
+
+// TestRevokeCluster_OutsideLock verifies that the revocations are
+// written to the store without holding the service lock.
+func TestRevokeCluster_OutsideLock(t *testing.T) {
+	store := &blockingRevocationStore{started: make(chan struct{}), release: make(chan struct{})}
+	revocations, err := pki.LoadRevocationList(t.Context(), store)
+	if err != nil {
+		t.Fatalf("LoadRevocationList: %v", err)
+	}
+	s := NewService(nil, revocations, nil)
+	s.links["prod"] = withReplicas(core.Link{Connected: true}, []core.LinkReplica{
+		{Host: "127.0.0.2", User: "agent-1", LastSeen: time.Now()},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RevokeCluster(t.Context(), "prod", "test")
+	}()
+	<-store.started
+
+	// The revocation is blocked in the store: the lock is free.
+	if _, ok := s.ListLinks()["prod"]; !ok {
+		t.Error("link dropped before it was revoked")
+	}
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatalf("RevokeCluster: %v", err)
+	}
+	if _, ok := s.ListLinks()["prod"]; ok {
+		t.Error("revoked link still listed")
+	}
+}
@@ -7,18 +7,22 @@ package chisel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"maps"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	chserver "github.com/jpillora/chisel/server"
 
 	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/transport/tunnel"
 )
 
 // tunnelPort is the fixed port shared by all cluster tunnels.
@@ -34,26 +38,52 @@ const maxHosts = 254 * 254 * 254
 // loopback addresses, and provisions chisel users for each agent.
 // It implements core.TunnelProvider and transport.TunnelService.
 type Service struct {
-	server atomic.Pointer[chserver.Server]
-	ca     *pki.CA
-	log    *slog.Logger
-	addrs  *addressAllocator
+	server      atomic.Pointer[chserver.Server]
+	tunnel      atomic.Pointer[tunnel.Server] // set by BuildTunnelListener
+	ca          *pki.CA
+	revocations *pki.RevocationList
+	policy      *pki.CSRPolicy
+	log         *slog.Logger
+	addrs       *addressAllocator
 
-	mu    sync.RWMutex
-	links map[string]core.Link // cluster name -> tunnel state
+	mu        sync.RWMutex
+	links     map[string]core.Link // cluster name -> tunnel state
+	store     core.LinkStore       // nil for in-memory links
+	savedSeen map[string]time.Time // cluster name -> last persisted LastSeen
+	writes    linkWrites           // store writes queued under mu
+
+	// replica identifies this hub process in the store. Peer
+	// forwarding is enabled if it has a PeerURL.
+	replica core.HubReplica
+	// remote holds the stored links whose tunnel terminates on
+	// another hub replica, keyed by cluster name.
+	remote map[string]core.LinkRecord
+
+	// health controls the health check of the replicas.
+	health HealthConfig
+
+	events *core.LinkEventBroadcaster
 }
 
 // NewService returns a new Service backed by chisel. The CA is
-// required for signing agent CSRs and must be provided at
+// required for signing agent CSRs, the CSR policy is checked before
+// every signature and the revocation list is consulted on every
+// registration and tunnel handshake; all must be provided at
 // construction time (dependency injection).
 // The underlying chisel server is lazily initialized by the tunnel
 // transport layer; see tunnel.NewServer.
-func NewService(ca *pki.CA) *Service {
+func NewService(ca *pki.CA, revocations *pki.RevocationList, policy *pki.CSRPolicy) *Service {
 	return &Service{
-		ca:    ca,
-		log:   slog.Default().With("component", "tunnel-provider"),
-		addrs: newAddressAllocator(),
-		links: make(map[string]core.Link),
+		ca:          ca,
+		revocations: revocations,
+		policy:      policy,
+		log:         slog.Default().With("component", "tunnel-provider"),
+		addrs:       newAddressAllocator(),
+		links:       make(map[string]core.Link),
+		savedSeen:   make(map[string]time.Time),
+		remote:      make(map[string]core.LinkRecord),
+		health:      DefaultHealthConfig(),
+		events:      core.NewLinkEventBroadcaster(),
 	}
 }
 
@@ -74,44 +104,99 @@ func (s *Service) CA() *pki.CA {
 	return s.ca
 }
 
-// CACertPEM returns the PEM-encoded CA certificate so that agents
-// can verify the tunnel server's identity via mTLS.
+// CACertPEM returns the PEM-encoded CA trust bundle so that agents
+// can verify the tunnel server's identity via mTLS. During a CA
+// rotation the bundle holds both the active and the retiring CA.
 func (s *Service) CACertPEM() []byte {
-	return s.ca.CertPEM()
+	return s.ca.TrustBundlePEM()
 }
 
-// ListLinks returns the names of all currently registered links.
+// ListLinks returns every known link. Links restored from the store,
+// or whose tunnel was lost, are listed with Connected unset until
+// their agent registers again. Links whose tunnel terminates on
+// another hub replica are listed with their HubReplica set, unless
+// this replica holds a tunnel of the cluster itself.
 func (s *Service) ListLinks() map[string]core.Link {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return maps.Clone(s.links)
+	ret := maps.Clone(s.links)
+	for cluster, rec := range s.remote {
+		if link, ok := ret[cluster]; ok && link.Connected {
+			continue
+		}
+		ret[cluster] = remoteLink(rec)
+	}
+	return ret
+}
+
+// WatchLinks returns a channel of the link events that occur after
+// the call.
+func (s *Service) WatchLinks(ctx context.Context) <-chan core.LinkEvent {
+	return s.events.Subscribe(ctx)
+}
+
+// publishLocked emits an event of the given type for the cluster's
+// link. s.mu must be held, so that events are published in the order
+// the changes were made.
+func (s *Service) publishLocked(typ core.LinkEventType, cluster string, link core.Link) {
+	s.events.Publish(core.LinkEvent{Type: typ, Cluster: cluster, Time: time.Now(), Link: link})
+}
+
+// SetLinkLabels replaces the labels of the cluster's link and
+// persists them.
+func (s *Service) SetLinkLabels(ctx context.Context, cluster string, set map[string]string) error {
+	return s.updateLink(ctx, cluster, func(link *core.Link) {
+		link.Labels = maps.Clone(set)
+	})
+}
+
+// SetLinkFacts records the facts reported by the cluster's agent and
+// persists them.
+func (s *Service) SetLinkFacts(ctx context.Context, cluster string, facts core.ClusterFacts) error {
+	return s.updateLink(ctx, cluster, func(link *core.Link) {
+		link.Facts = facts
+	})
+}
+
+// updateLink applies fn to the cluster's link and persists the result.
+func (s *Service) updateLink(ctx context.Context, cluster string, fn func(link *core.Link)) error {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok {
+		return &core.ErrClusterNotFound{Cluster: cluster}
+	}
+	fn(&link)
+	s.links[cluster] = link
+	s.saveLocked(cluster, link)
+	s.publishLocked(core.LinkEventUpdated, cluster, link)
+	return nil
 }
 
-// RegisterLink validates and signs the agent's CSR, associates a
-// cluster with a unique loopback host, creates a chisel user with a
+// RegisterLink validates and signs the agent's CSR, associates the
+// agent with a unique loopback host, creates a chisel user with a
 // password derived from the signed certificate, and returns the
 // tunnel endpoint and the PEM-encoded signed certificate.
 //
-// If the cluster was previously registered, the old host allocation
-// is released first so that re-registration always moves the cluster
-// to a fresh address.
-func (s *Service) RegisterLink(_ context.Context, cluster, agentID, agentVersion string, csrPEM []byte) (endpoint string, certPEM []byte, err error) {
-	// Sign the agent's CSR with the internal CA.
-	certPEM, err = s.ca.SignCSR(csrPEM)
-	if err != nil {
-		return "", nil, fmt.Errorf("sign CSR: %w", err)
+// Each agent ID is a replica of the cluster, so several agent pods
+// can serve one cluster. A replica that registers again is moved to a
+// fresh address. Replicas of another agent UID, which remain after the
+// cluster was re-homed, and the replica of a disconnected link are
+// dropped. The cluster keeps its first-seen time, labels and facts.
+func (s *Service) RegisterLink(ctx context.Context, cluster, agentID, agentUID, agentVersion string, csrPEM []byte) (endpoint string, certPEM []byte, err error) {
+	if s.revocations.IsAgentRevoked(agentID) {
+		return "", nil, &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("agent %q has been revoked", agentID),
+		}
 	}
 
-	// Derive the chisel password from the signed certificate so
-	// that both server and agent can compute it independently.
-	auth, err := pki.DeriveAuth(agentID, certPEM)
+	cert, err := s.issue(cluster, agentID, agentUID, csrPEM)
 	if err != nil {
-		return "", nil, fmt.Errorf("derive auth: %w", err)
-	}
-	_, pass, ok := parseAuth(auth)
-	if !ok {
-		return "", nil, fmt.Errorf("invalid auth format: expected user:pass, got %q", auth)
+		return "", nil, err
 	}
 
 	srv := s.server.Load()
@@ -119,73 +204,376 @@ func (s *Service) RegisterLink(_ context.Context, cluster, agentID, agentVersion
 		return "", nil, &core.ErrNotReady{Subsystem: "chisel server"}
 	}
 
+	defer s.writeLinks(ctx)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Release the previous host and user for this cluster, if any,
-	// so that stale credentials do not accumulate in chisel.
-	if prev, ok := s.links[cluster]; ok {
-		srv.DeleteUser(prev.User)
-		s.addrs.release(prev.Host)
-		delete(s.links, cluster)
-	}
-
 	host, err := s.addrs.allocate(cluster)
 	if err != nil {
 		return "", nil, err
 	}
 
-	// Restrict the user to reverse-tunneling only the allocated
-	// host:port combination. The regex anchors prevent the agent
-	// from binding arbitrary endpoints.
-	allowed := fmt.Sprintf("^R:%s:%d(:.*)?$", regexp.QuoteMeta(host), tunnelPort)
-	if err := srv.AddUser(agentID, pass, allowed); err != nil {
+	// Release the replicas that are replaced, so that stale
+	// credentials do not accumulate in chisel.
+	now := time.Now()
+	prev, registered := s.links[cluster]
+	if !registered {
+		prev.FirstSeen = now
+	}
+	var replaced, evicted []core.LinkReplica
+	replicas := make([]core.LinkReplica, 0, len(prev.Replicas)+1)
+	for _, r := range prev.Replicas {
+		if !prev.Connected || r.User == agentID || r.AgentUID != agentUID {
+			replaced = append(replaced, r)
+			continue
+		}
+		replicas = append(replicas, r)
+	}
+	if len(replicas) >= maxReplicas {
+		slices.SortFunc(replicas, func(a, b core.LinkReplica) int {
+			return b.LastSeen.Compare(a.LastSeen)
+		})
+		evicted = replicas[maxReplicas-1:]
+		replicas = replicas[:maxReplicas-1]
+	}
+
+	// The replaced replicas are only released once the new one is
+	// added, so that a failure leaves the link unchanged.
+	if err := srv.AddUser(agentID, cert.pass, allowedRemote(host)); err != nil {
 		s.addrs.release(host)
 		return "", nil, err
 	}
+	for _, r := range replaced {
+		if r.User == agentID {
+			// AddUser replaced the chisel user of the agent.
+			s.releaseReplicaLocked(nil, r)
+			continue
+		}
+		s.releaseReplicaLocked(srv, r)
+	}
+	for _, r := range evicted {
+		s.log.Info("evicting agent replica", "cluster", cluster, "agent", r.User, "last_seen", r.LastSeen)
+		s.releaseReplicaLocked(srv, r)
+	}
+
+	replicas = append(replicas, core.LinkReplica{
+		Host:          host,
+		User:          agentID,
+		AgentUID:      agentUID,
+		AgentVersion:  agentVersion,
+		CAFingerprint: cert.issuer,
+		Serial:        cert.serial,
+		Healthy:       true,
+		Health:        core.LinkHealthy,
+		RegisteredAt:  now,
+		LastSeen:      now,
+	})
+	link := withReplicas(prev, replicas)
+	link.Connected = true
+	s.links[cluster] = link
+	s.saveLocked(cluster, link)
 
-	s.links[cluster] = core.Link{
-		Host:         host,
-		User:         agentID,
-		AgentVersion: agentVersion,
+	s.publishLocked(core.LinkEventRegistered, cluster, link)
+	if registered && prev.AgentVersion != link.AgentVersion {
+		s.events.Publish(core.LinkEvent{
+			Type:                 core.LinkEventVersionChanged,
+			Cluster:              cluster,
+			Time:                 now,
+			Link:                 link,
+			PreviousAgentVersion: prev.AgentVersion,
+		})
 	}
 
-	return fmt.Sprintf("%s:%d", host, tunnelPort), certPEM, nil
+	return fmt.Sprintf("%s:%d", host, tunnelPort), cert.certPEM, nil
 }
 
-// DeregisterCluster removes a cluster's tunnel allocation, deleting
-// the chisel user and releasing the loopback host. It is a no-op if
-// the cluster is not currently registered.
-func (s *Service) DeregisterCluster(cluster string) {
+// RenewLink issues a successor to the agent's current certificate
+// without tearing down its tunnel. The request must carry a proof
+// signed with the key of the certificate currently registered for the
+// cluster. The cluster keeps its loopback host and chisel user; only
+// the user's password is replaced, so the running session continues
+// and the agent's next reconnect uses the renewed credentials.
+func (s *Service) RenewLink(ctx context.Context, cluster, agentID string, currentCertPEM, csrPEM, proof []byte) (endpoint string, certPEM []byte, err error) {
+	current, err := s.ca.VerifyRenewal(currentCertPEM, cluster, csrPEM, proof)
+	if err != nil {
+		return "", nil, &core.DomainError{Code: core.ErrorCodePermissionDenied, Message: "renewal not authorized", Cause: err}
+	}
+	if current.Subject.CommonName != agentID {
+		return "", nil, &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("current certificate was issued to %q, not %q", current.Subject.CommonName, agentID),
+		}
+	}
+	if s.revocations.IsRevoked(current) {
+		return "", nil, &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("agent %q has been revoked", agentID),
+		}
+	}
+
+	cert, err := s.issue(cluster, agentID, pki.CertificateAgentUID(current), csrPEM)
+	if err != nil {
+		return "", nil, err
+	}
+
 	srv := s.server.Load()
 	if srv == nil {
-		return
+		return "", nil, &core.ErrNotReady{Subsystem: "chisel server"}
+	}
+
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the certificate currently registered for one of the
+	// cluster's replicas may be renewed; an older certificate of the
+	// same agent, or one whose replica has since been re-registered,
+	// has to register.
+	link, ok := s.links[cluster]
+	if !ok {
+		return "", nil, &core.ErrClusterNotFound{Cluster: cluster}
+	}
+	if !link.Connected {
+		return "", nil, &core.DomainError{
+			Code:    core.ErrorCodeFailedPrecondition,
+			Message: fmt.Sprintf("cluster %s is disconnected; the agent has to register again", cluster),
+		}
+	}
+	i := slices.IndexFunc(link.Replicas, func(r core.LinkReplica) bool {
+		return r.User == agentID && r.Serial == pki.SerialString(current)
+	})
+	if i < 0 {
+		return "", nil, &core.DomainError{
+			Code:    core.ErrorCodeFailedPrecondition,
+			Message: fmt.Sprintf("certificate %s is not the current certificate of cluster %s", pki.SerialString(current), cluster),
+		}
+	}
+
+	replicas := slices.Clone(link.Replicas)
+	replica := &replicas[i]
+	if err := srv.AddUser(agentID, cert.pass, allowedRemote(replica.Host)); err != nil {
+		return "", nil, err
+	}
+	replica.CAFingerprint = cert.issuer
+	replica.Serial = cert.serial
+	replica.LastSeen = time.Now()
+	link = withReplicas(link, replicas)
+	s.links[cluster] = link
+	s.saveLocked(cluster, link)
+
+	s.log.Info("certificate renewed", "cluster", cluster, "agent", agentID, "serial", cert.serial)
+
+	return fmt.Sprintf("%s:%d", replica.Host, tunnelPort), cert.certPEM, nil
+}
+
+// VerifyIdentity checks that the agent holds the key of a certificate
+// previously issued for cluster and returns the agent UID it was
+// issued for, so that the agent may register again without an
+// enrollment token. The certificate may have been issued to another
+// agent ID, since the ID changes whenever the agent pod is replaced,
+// and unlike RenewLink it need not be the cluster's current
+// certificate. It must not be revoked, by serial or by agent.
+func (s *Service) VerifyIdentity(_ context.Context, cluster string, certPEM, csrPEM, proof []byte) (string, error) {
+	cert, err := s.ca.VerifyRenewal(certPEM, cluster, csrPEM, proof)
+	if err != nil {
+		return "", &core.DomainError{Code: core.ErrorCodeUnauthenticated, Message: "previous agent identity not accepted", Cause: err}
+	}
+	if got := pki.CertificateCluster(cert); got != cluster {
+		return "", &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("certificate %s was not issued for cluster %s", pki.SerialString(cert), cluster),
+		}
+	}
+	uid := pki.CertificateAgentUID(cert)
+	if uid == "" {
+		return "", &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("certificate %s carries no agent UID", pki.SerialString(cert)),
+		}
+	}
+	if s.revocations.IsRevoked(cert) {
+		return "", &core.DomainError{
+			Code:    core.ErrorCodePermissionDenied,
+			Message: fmt.Sprintf("certificate %s has been revoked", pki.SerialString(cert)),
+		}
+	}
+	return uid, nil
+}
+
+// issuedCert is an agent certificate together with the values derived
+// from it.
+type issuedCert struct {
+	certPEM []byte
+	pass    string // chisel password derived from the certificate
+	issuer  string // fingerprint of the signing CA
+	serial  string
+}
+
+// issue checks the agent's CSR against the CSR policy, signs it with
+// the internal CA for cluster and agentUID and derives the chisel
+// password from the resulting certificate.
+func (s *Service) issue(cluster, agentID, agentUID string, csrPEM []byte) (issuedCert, error) {
+	if err := s.policy.Check(csrPEM, agentID); err != nil {
+		return issuedCert{}, toPolicyError(err)
+	}
+
+	certPEM, err := s.ca.SignAgentCSR(csrPEM, cluster, agentUID)
+	if err != nil {
+		return issuedCert{}, fmt.Errorf("sign CSR: %w", err)
+	}
+	issuer, err := s.ca.Issuer(certPEM)
+	if err != nil {
+		return issuedCert{}, fmt.Errorf("resolve certificate issuer: %w", err)
+	}
+	serial, err := pki.CertificateSerial(certPEM)
+	if err != nil {
+		return issuedCert{}, fmt.Errorf("read certificate serial: %w", err)
 	}
 
+	// Derive the chisel password from the signed certificate so
+	// that both server and agent can compute it independently.
+	auth, err := pki.DeriveAuth(agentID, certPEM)
+	if err != nil {
+		return issuedCert{}, fmt.Errorf("derive auth: %w", err)
+	}
+	_, pass, ok := parseAuth(auth)
+	if !ok {
+		return issuedCert{}, fmt.Errorf("invalid auth format: expected user:pass, got %q", auth)
+	}
+
+	return issuedCert{certPEM: certPEM, pass: pass, issuer: issuer, serial: serial}, nil
+}
+
+// toPolicyError converts a CSR policy violation into a domain error:
+// CSRs that are well-formed but not allowed for the agent are denied,
+// malformed or unsupported ones are invalid input.
+func toPolicyError(err error) error {
+	var v *pki.PolicyViolation
+	if !errors.As(err, &v) {
+		return err
+	}
+	if v.Denied {
+		return &core.DomainError{Code: core.ErrorCodePermissionDenied, Message: v.Error()}
+	}
+	return &core.ErrInvalidInput{Field: "csr." + v.Field, Message: v.Reason}
+}
+
+// allowedRemote returns the chisel address pattern that restricts a
+// user to reverse-tunneling only the allocated host:port combination.
+// The regex anchors prevent the agent from binding arbitrary
+// endpoints.
+func allowedRemote(host string) string {
+	return fmt.Sprintf("^R:%s:%d(:.*)?$", regexp.QuoteMeta(host), tunnelPort)
+}
+
+// DeregisterCluster removes a cluster's tunnel allocation, deleting
+// the chisel users, releasing the loopback hosts of every replica and
+// dropping the persisted link. It is a no-op if the cluster is not
+// known.
+func (s *Service) DeregisterCluster(ctx context.Context, cluster string) {
+	defer s.writeLinks(ctx)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	entry, ok := s.links[cluster]
 	if !ok {
-		return
+		rec, ok := s.remote[cluster]
+		if !ok {
+			return
+		}
+		entry = remoteLink(rec)
+	}
+	srv := s.server.Load()
+	for _, r := range entry.Replicas {
+		s.releaseReplicaLocked(srv, r)
 	}
-	srv.DeleteUser(entry.User)
-	s.addrs.release(entry.Host)
 	delete(s.links, cluster)
+	delete(s.remote, cluster)
+	s.deleteLocked(cluster)
+	s.publishLocked(core.LinkEventDeregistered, cluster, entry)
 }
 
-// ResolveAddress returns the HTTP base URL for the given cluster's
-// tunnel endpoint. Returns an error if the cluster is not registered.
+// evictReplica releases the replica of cluster served from host, once
+// its grace period as a disconnected replica is over. The last replica
+// of a cluster is kept, with its loopback host reserved, so that the
+// cluster stays listed as disconnected; only its chisel user is
+// deleted. It reports whether the link changed, and whether the
+// cluster lost its last tunnel with it.
+func (s *Service) evictReplica(ctx context.Context, cluster, host string) (changed, lost bool) {
+	defer s.writeLinks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[cluster]
+	if !ok {
+		return false, false
+	}
+	i := replicaIndex(link.Replicas, host)
+	if i < 0 || (!link.Connected && link.Replicas[i].DisconnectedAt.IsZero()) {
+		return false, false
+	}
+	srv := s.server.Load()
+	wasConnected := link.Connected
+
+	if len(link.Replicas) > 1 {
+		s.releaseReplicaLocked(srv, link.Replicas[i])
+		replicas := slices.Delete(slices.Clone(link.Replicas), i, i+1)
+		link = withReplicas(link, replicas)
+		link.Connected = anyConnected(replicas)
+		s.links[cluster] = link
+		s.saveLocked(cluster, link)
+		if wasConnected && !link.Connected {
+			s.publishLocked(core.LinkEventDisconnected, cluster, link)
+		} else {
+			s.publishLocked(core.LinkEventUpdated, cluster, link)
+		}
+		return true, false
+	}
+
+	if srv != nil {
+		srv.DeleteUser(link.User)
+	}
+	replicas := slices.Clone(link.Replicas)
+	replicas[i].DisconnectedAt = time.Time{}
+	link = withReplicas(link, replicas)
+	link.Connected = false
+	s.links[cluster] = link
+	s.saveLocked(cluster, link)
+	if wasConnected {
+		s.publishLocked(core.LinkEventDisconnected, cluster, link)
+	}
+	return true, true
+}
+
+// ResolveAddress returns the HTTP base URL for the tunnel endpoint of
+// the given cluster's active replica, which is a healthy one whenever
+// possible. Returns an *core.ErrClusterOnPeer if the cluster's tunnel
+// terminates on another hub replica, and an error if the cluster is
+// not registered or all its agents are disconnected.
 func (s *Service) ResolveAddress(_ context.Context, cluster string) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	entry, ok := s.links[cluster]
-	if !ok {
-		return "", &core.ErrClusterNotFound{Cluster: cluster}
+	if ok && entry.Connected {
+		return fmt.Sprintf("http://%s:%d", entry.Host, tunnelPort), nil
+	}
+	rec, remote := s.remote[cluster]
+	if remote && remoteConnected(rec) {
+		return "", &core.ErrClusterOnPeer{
+			Cluster: cluster,
+			Replica: core.HubReplica{ID: rec.HubReplica, PeerURL: rec.PeerURL},
+		}
 	}
 
-	return fmt.Sprintf("http://%s:%d", entry.Host, tunnelPort), nil
+	switch {
+	case ok:
+		return "", &core.ErrClusterDisconnected{Cluster: cluster, LastSeen: entry.LastSeen}
+	case remote:
+		return "", &core.ErrClusterDisconnected{Cluster: cluster, LastSeen: rec.LastSeen}
+	default:
+		return "", &core.ErrClusterNotFound{Cluster: cluster}
+	}
 }
 
 // parseAuth splits a "user:pass" string into its components.
@@ -1,82 +1,63 @@
 package chisel
 
 import (
-	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"sync"
 
+	"github.com/otterscale/otterscale/internal/pki"
 	"github.com/otterscale/otterscale/internal/transport"
 	"github.com/otterscale/otterscale/internal/transport/tunnel"
 )
 
-// BuildTunnelListener generates a server TLS certificate for the
-// given host, writes the mTLS materials to a temporary directory,
-// and returns a fully configured tunnel transport.Listener. The
-// caller is responsible for starting the listener via transport.Serve.
-// The temporary certificate files are cleaned up when the listener
-// stops.
+// BuildTunnelListener returns a fully configured tunnel
+// transport.Listener for the given address. The listener terminates
+// mTLS itself: the server certificate for host and the pool of
+// trusted client CAs are resolved on every handshake, so that a CA
+// rotation takes effect without restarting the tunnel, and every
+// client certificate is checked against the revocation list. The
+// traffic of every agent connection is recorded for the cluster of its
+// certificate. The caller is responsible for starting the listener via
+// transport.Serve.
 func (s *Service) BuildTunnelListener(address, host string) (transport.Listener, error) {
-	serverCert, serverKey, err := s.ca.GenerateServerCert(host)
-	if err != nil {
+	certs := &serverCertCache{ca: s.ca, host: host}
+	if _, err := certs.get(); err != nil {
 		return nil, fmt.Errorf("generate server cert: %w", err)
 	}
 
-	certDir, err := os.MkdirTemp("", "otterscale-tls-server-*")
-	if err != nil {
-		return nil, fmt.Errorf("create cert dir: %w", err)
-	}
-
-	caFile := filepath.Join(certDir, "ca.pem")
-	certFile := filepath.Join(certDir, "cert.pem")
-	keyFile := filepath.Join(certDir, "key.pem")
+	slog.Info("tunnel CA initialized", "subject", "otterscale-ca")
 
-	const secretFilePerm = 0o600 // owner-only read/write for TLS files
-	if err := os.WriteFile(caFile, s.ca.CertPEM(), secretFilePerm); err != nil {
-		os.RemoveAll(certDir)
-		return nil, fmt.Errorf("write CA cert: %w", err)
-	}
-	if err := os.WriteFile(certFile, serverCert, secretFilePerm); err != nil {
-		os.RemoveAll(certDir)
-		return nil, fmt.Errorf("write server cert: %w", err)
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.get()
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return s.verifyNotRevoked(cs.PeerCertificates)
+		},
 	}
-	if err := os.WriteFile(keyFile, serverKey, secretFilePerm); err != nil {
-		os.RemoveAll(certDir)
-		return nil, fmt.Errorf("write server key: %w", err)
+	tlsConfig := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.ClientCAs = s.ca.TrustPool()
+			return cfg, nil
+		},
 	}
 
-	slog.Info("tunnel CA initialized", "subject", "otterscale-ca")
-
 	tunnelSrv, err := tunnel.NewServer(
 		tunnel.WithAddress(address),
-		tunnel.WithTLSCert(certFile),
-		tunnel.WithTLSKey(keyFile),
-		tunnel.WithTLSCA(caFile),
+		tunnel.WithTLSConfig(tlsConfig),
+		tunnel.WithCertificateCluster(pki.CertificateCluster),
 		tunnel.WithServer(s.ServerRef()),
 	)
 	if err != nil {
-		os.RemoveAll(certDir)
 		return nil, fmt.Errorf("create tunnel server: %w", err)
 	}
-
-	return &tunnelListenerWithCleanup{
-		Listener: tunnelSrv,
-		certDir:  certDir,
-	}, nil
-}
-
-// tunnelListenerWithCleanup wraps a transport.Listener and removes
-// the temporary TLS certificate directory when stopped.
-type tunnelListenerWithCleanup struct {
-	transport.Listener
-	certDir string
-}
-
-func (l *tunnelListenerWithCleanup) Stop(ctx context.Context) error {
-	err := l.Listener.Stop(ctx)
-	os.RemoveAll(l.certDir)
-	return err
+	s.tunnel.Store(tunnelSrv)
+	return tunnelSrv, nil
 }
 
 // BuildHealthListener returns a transport.Listener that periodically
@@ -85,3 +66,46 @@ func (l *tunnelListenerWithCleanup) Stop(ctx context.Context) error {
 func (s *Service) BuildHealthListener() transport.Listener {
 	return NewHealthCheckListener(s)
 }
+
+// serverCertCache holds the tunnel server certificate and regenerates
+// it once it no longer chains to the CA's trust bundle, which happens
+// when a CA rotation is finished and the authority that signed it is
+// dropped.
+type serverCertCache struct {
+	ca   *pki.CA
+	host string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// get returns the cached certificate, regenerating it if necessary.
+func (c *serverCertCache) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && c.valid() {
+		return c.cert, nil
+	}
+
+	certPEM, keyPEM, err := c.ca.GenerateServerCert(c.host)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load server key pair: %w", err)
+	}
+	c.cert = &cert
+	return c.cert, nil
+}
+
+// valid reports whether the cached certificate still verifies against
+// the CA's current trust bundle. c.mu must be held.
+func (c *serverCertCache) valid() bool {
+	_, err := c.cert.Leaf.Verify(x509.VerifyOptions{
+		Roots:     c.ca.TrustPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err == nil
+}
@@ -91,6 +91,24 @@ func (c *Client) EnsureRobotAccount(ctx context.Context, clusterName string) (*c
 	return c.createRobot(ctx, clusterName, password)
 }
 
+// DeleteRobotAccount deletes the robot account of the given cluster
+// name. A missing robot is not an error.
+func (c *Client) DeleteRobotAccount(ctx context.Context, clusterName string) error {
+	password, err := c.adminPassword(ctx)
+	if err != nil {
+		return err
+	}
+
+	robotID, err := c.findRobotID(ctx, clusterName, password)
+	if errors.Is(err, errRobotNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find robot: %w", err)
+	}
+	return c.deleteRobot(ctx, robotID, password)
+}
+
 // adminPassword returns the Harbor admin password, reading it from
 // the Kubernetes Secret on first call and caching the result.
 func (c *Client) adminPassword(ctx context.Context) (string, error) {
@@ -270,7 +288,7 @@ func (c *Client) findRobotID(ctx context.Context, clusterName, password string)
 		}
 	}
 
-	return 0, fmt.Errorf("harbor: robot for cluster %q not found", clusterName)
+	return 0, fmt.Errorf("%w: cluster %q", errRobotNotFound, clusterName)
 }
 
 // deleteRobot sends DELETE /api/v2.0/robots/{id}.
@@ -296,6 +314,9 @@ func (c *Client) deleteRobot(ctx context.Context, robotID int, password string)
 	return nil
 }
 
+// errRobotNotFound signals that no robot exists for a cluster.
+var errRobotNotFound = errors.New("harbor: robot not found")
+
 // errConflict signals that a robot with the same name already exists.
 type errConflict struct {
 	cluster string
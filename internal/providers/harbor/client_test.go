@@ -291,3 +291,56 @@ func TestDeleteRobot_Error(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestDeleteRobotAccount(t *testing.T) {
+	var deleteCalled bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == testRobotsPath:
+			if err := json.NewEncoder(w).Encode([]robotListItem{
+				{ID: 50, Name: "robot$test-cluster"},
+			}); err != nil {
+				t.Errorf("encode response: %v", err)
+			}
+
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v2.0/robots/50":
+			deleteCalled = true
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.password = testPassword
+
+	if err := c.DeleteRobotAccount(t.Context(), "test-cluster"); err != nil {
+		t.Fatalf("DeleteRobotAccount: %v", err)
+	}
+	if !deleteCalled {
+		t.Error("expected DELETE to be called")
+	}
+}
+
+func TestDeleteRobotAccount_NotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewEncoder(w).Encode([]robotListItem{}); err != nil {
+			t.Errorf("encode response: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.password = testPassword
+
+	if err := c.DeleteRobotAccount(t.Context(), "missing-cluster"); err != nil {
+		t.Fatalf("DeleteRobotAccount: %v", err)
+	}
+}
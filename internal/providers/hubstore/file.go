@@ -0,0 +1,194 @@
+package hubstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+const (
+	// revocationsFileName is the file holding the JSON-encoded
+	// revocation list.
+	revocationsFileName = "revocations.json"
+	// enrollmentTokensFileName is the file holding the JSON-encoded
+	// enrollment token registry.
+	enrollmentTokensFileName = "enrollment-tokens.json"
+	// clusterPinsFileName is the file holding the JSON-encoded
+	// cluster pins.
+	clusterPinsFileName = "cluster-pins.json"
+	// clusterApprovalsFileName is the file holding the JSON-encoded
+	// cluster approvals.
+	clusterApprovalsFileName = "cluster-approvals.json"
+	// upgradePolicyFileName is the file holding the JSON-encoded agent
+	// upgrade policy.
+	upgradePolicyFileName = "upgrade-policy.json"
+	// fileMode restricts the registry files to the owning user.
+	fileMode = 0o600
+	// dirPerm restricts the store directory to the owning user.
+	dirPerm = 0o700
+)
+
+// FileStore persists each hub registry as a JSON file inside a
+// directory, which is the CA directory of a castore.FileStore. Every
+// update atomically renames a new file over the previous one.
+//
+// Updates are serialized within the process only, so the directory
+// must not be shared by several hub replicas.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex // serializes read-modify-write cycles
+}
+
+// Verify at compile time that FileStore satisfies pki.RevocationStore,
+// core.EnrollmentStore, core.ClusterPinStore, core.ClusterApprovalStore
+// and core.UpgradePolicyStore.
+var (
+	_ pki.RevocationStore       = (*FileStore)(nil)
+	_ core.EnrollmentStore      = (*FileStore)(nil)
+	_ core.ClusterPinStore      = (*FileStore)(nil)
+	_ core.ClusterApprovalStore = (*FileStore)(nil)
+	_ core.UpgradePolicyStore   = (*FileStore)(nil)
+)
+
+// NewFileStore returns a FileStore rooted at dir. The directory is
+// created on first write if it does not exist.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// LoadRevocations reads the revocation list file. It returns nil if
+// the file does not exist yet.
+func (s *FileStore) LoadRevocations(_ context.Context) ([]byte, error) {
+	return s.readData(revocationsFileName)
+}
+
+// UpdateRevocations atomically replaces the revocation list file with
+// the result of fn.
+func (s *FileStore) UpdateRevocations(_ context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(revocationsFileName, fn)
+}
+
+// LoadEnrollmentTokens reads the enrollment token file. It returns nil
+// if the file does not exist yet.
+func (s *FileStore) LoadEnrollmentTokens(_ context.Context) ([]byte, error) {
+	return s.readData(enrollmentTokensFileName)
+}
+
+// UpdateEnrollmentTokens atomically replaces the enrollment token file
+// with the result of fn.
+func (s *FileStore) UpdateEnrollmentTokens(_ context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(enrollmentTokensFileName, fn)
+}
+
+// LoadClusterPins reads the cluster pin file. It returns nil if the
+// file does not exist yet.
+func (s *FileStore) LoadClusterPins(_ context.Context) ([]byte, error) {
+	return s.readData(clusterPinsFileName)
+}
+
+// UpdateClusterPins atomically replaces the cluster pin file with the
+// result of fn.
+func (s *FileStore) UpdateClusterPins(_ context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(clusterPinsFileName, fn)
+}
+
+// LoadClusterApprovals reads the cluster approval file. It returns nil
+// if the file does not exist yet.
+func (s *FileStore) LoadClusterApprovals(_ context.Context) ([]byte, error) {
+	return s.readData(clusterApprovalsFileName)
+}
+
+// UpdateClusterApprovals atomically replaces the cluster approval file
+// with the result of fn.
+func (s *FileStore) UpdateClusterApprovals(_ context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(clusterApprovalsFileName, fn)
+}
+
+// LoadUpgradePolicy reads the upgrade policy file. It returns nil if
+// the file does not exist yet.
+func (s *FileStore) LoadUpgradePolicy(_ context.Context) ([]byte, error) {
+	return s.readData(upgradePolicyFileName)
+}
+
+// UpdateUpgradePolicy atomically replaces the upgrade policy file with
+// the result of fn.
+func (s *FileStore) UpdateUpgradePolicy(_ context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(upgradePolicyFileName, fn)
+}
+
+// readData reads the named file in the store directory. It returns nil
+// if the file does not exist yet.
+func (s *FileStore) readData(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// updateData atomically replaces the named file in the store directory
+// with the result of fn for its current content. Nothing is written if
+// fn returns the content unchanged.
+func (s *FileStore) updateData(name string, fn func(data []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.readData(name)
+	if err != nil {
+		return err
+	}
+	data, err := fn(current)
+	if err != nil {
+		return err
+	}
+	if current != nil && bytes.Equal(data, current) {
+		return nil
+	}
+	return s.writeData(name, data)
+}
+
+// writeData atomically replaces the named file in the store directory.
+// The new content is fsynced before the rename, so that a crash never
+// exposes a truncated file.
+func (s *FileStore) writeData(name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, dirPerm); err != nil {
+		return fmt.Errorf("create store dir: %w", err)
+	}
+
+	f, err := os.CreateTemp(s.dir, ".state-*.tmp")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	err = f.Chmod(fileMode)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("replace %s: %w", name, err)
+	}
+	return nil
+}
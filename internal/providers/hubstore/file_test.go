@@ -0,0 +1,40 @@
+package hubstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore_Update(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	store := NewFileStore(dir)
+
+	if got, err := store.LoadClusterPins(t.Context()); err != nil || got != nil {
+		t.Fatalf("LoadClusterPins = %q, %v, want nothing stored", got, err)
+	}
+	if err := store.UpdateClusterPins(t.Context(), replaceData(t, "", "pins")); err != nil {
+		t.Fatalf("UpdateClusterPins: %v", err)
+	}
+	if got, err := store.LoadClusterPins(t.Context()); err != nil || string(got) != "pins" {
+		t.Fatalf("LoadClusterPins = %q, %v, want the saved pins", got, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, clusterPinsFileName))
+	if err != nil {
+		t.Fatalf("stat %s: %v", clusterPinsFileName, err)
+	}
+	if perm := info.Mode().Perm(); perm != fileMode {
+		t.Errorf("%s mode = %o, want %o", clusterPinsFileName, perm, fileMode)
+	}
+
+	errAbort := errors.New("abort")
+	err = store.UpdateClusterPins(t.Context(), func([]byte) ([]byte, error) { return nil, errAbort })
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("UpdateClusterPins = %v, want the error of fn", err)
+	}
+	if got, err := store.LoadClusterPins(t.Context()); err != nil || string(got) != "pins" {
+		t.Errorf("LoadClusterPins = %q, %v, want the pins unchanged", got, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package hubstore implements pki.RevocationStore, core.EnrollmentStore,
+// core.ClusterPinStore, core.ClusterApprovalStore and
+// core.UpgradePolicyStore backends that persist the hub registries
+// across server restarts: files on disk and a Kubernetes Secret in the
+// hub's own cluster. The registries are kept next to the tunnel CA, in
+// the backend the server.ca.store configuration key selects.
+package hubstore
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/providers/castore"
+)
+
+// Store is implemented by every backend in this package.
+type Store interface {
+	pki.RevocationStore
+	core.EnrollmentStore
+	core.ClusterPinStore
+	core.ClusterApprovalStore
+	core.UpgradePolicyStore
+}
+
+// ProvideStore is a Wire provider that returns the backend selected by
+// the server.ca.store configuration key. It is built once and shared
+// by every hub registry. Hub replicas forwarding to each other share
+// their registries through the store, which requires the secret store:
+// files on disk are local to each replica.
+func ProvideStore(conf *config.Config) (Store, error) {
+	switch store := conf.ServerCAStore(); store {
+	case castore.StoreFile:
+		if conf.ServerPeerURL() != "" || conf.ServerPeerAddress() != "" {
+			return nil, fmt.Errorf("hub store: peer forwarding requires the %q store, so that the hub replicas share their state", castore.StoreSecret)
+		}
+		return NewFileStore(conf.ServerCADir()), nil
+	case castore.StoreSecret:
+		cfg, err := kubeConfig()
+		if err != nil {
+			return nil, fmt.Errorf("hub store: load kubernetes config: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("hub store: create kubernetes client: %w", err)
+		}
+		return NewSecretStore(client, conf.ServerCASecretNamespace(), conf.ServerCAStateSecretName(), conf.ServerCASecretName()), nil
+	default:
+		return nil, fmt.Errorf("hub store: unsupported store %q (expected %q or %q)", store, castore.StoreFile, castore.StoreSecret)
+	}
+}
+
+// ProvideRevocationStore is a Wire provider that returns store as a
+// RevocationStore.
+func ProvideRevocationStore(store Store) pki.RevocationStore {
+	return store
+}
+
+// ProvideEnrollmentStore is a Wire provider that returns store as an
+// EnrollmentStore.
+func ProvideEnrollmentStore(store Store) core.EnrollmentStore {
+	return store
+}
+
+// ProvideClusterPinStore is a Wire provider that returns store as a
+// ClusterPinStore.
+func ProvideClusterPinStore(store Store) core.ClusterPinStore {
+	return store
+}
+
+// ProvideClusterApprovalStore is a Wire provider that returns store as
+// a ClusterApprovalStore.
+func ProvideClusterApprovalStore(store Store) core.ClusterApprovalStore {
+	return store
+}
+
+// ProvideUpgradePolicyStore is a Wire provider that returns store as an
+// UpgradePolicyStore.
+func ProvideUpgradePolicyStore(store Store) core.UpgradePolicyStore {
+	return store
+}
+
+// kubeConfig returns the in-cluster config, or the user's kubeconfig
+// when OTTERSCALE_DEBUG is set for local development.
+func kubeConfig() (*rest.Config, error) {
+	if os.Getenv("OTTERSCALE_DEBUG") != "" {
+		return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	}
+	return rest.InClusterConfig()
+}
@@ -0,0 +1,235 @@
+package hubstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// SecretStore persists the hub registries (revocations, enrollment
+// tokens, cluster pins, cluster approvals and the upgrade policy) in
+// an Opaque state Secret in the hub's own cluster. It is kept apart
+// from the CA Secret of a castore.SecretStore, so that registry churn
+// never rewrites the CA Secret.
+type SecretStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	caName    string
+}
+
+// State Secret data keys holding the JSON-encoded revocation list,
+// enrollment token registry, cluster pins, cluster approvals and agent
+// upgrade policy. Hubs before the state Secret was introduced kept
+// them in the CA Secret, where they are still read from until they
+// are first written.
+const (
+	revocationsKey      = "revocations.json"
+	enrollmentTokensKey = "enrollment-tokens.json"
+	clusterPinsKey      = "cluster-pins.json"
+	clusterApprovalsKey = "cluster-approvals.json"
+	upgradePolicyKey    = "upgrade-policy.json"
+)
+
+// Verify at compile time that SecretStore satisfies
+// pki.RevocationStore, core.EnrollmentStore, core.ClusterPinStore,
+// core.ClusterApprovalStore and core.UpgradePolicyStore.
+var (
+	_ pki.RevocationStore       = (*SecretStore)(nil)
+	_ core.EnrollmentStore      = (*SecretStore)(nil)
+	_ core.ClusterPinStore      = (*SecretStore)(nil)
+	_ core.ClusterApprovalStore = (*SecretStore)(nil)
+	_ core.UpgradePolicyStore   = (*SecretStore)(nil)
+)
+
+// NewSecretStore returns a SecretStore that keeps the hub registries
+// in the Secret namespace/name, through client. Entries missing there
+// are read from the CA Secret namespace/caName.
+func NewSecretStore(client kubernetes.Interface, namespace, name, caName string) *SecretStore {
+	return &SecretStore{client: client, namespace: namespace, name: name, caName: caName}
+}
+
+// LoadRevocations reads the revocations.json entry of the state
+// Secret. It returns nil if the entry does not exist yet.
+func (s *SecretStore) LoadRevocations(ctx context.Context) ([]byte, error) {
+	return s.readData(ctx, revocationsKey)
+}
+
+// UpdateRevocations replaces the revocations.json entry of the state
+// Secret with the result of fn.
+func (s *SecretStore) UpdateRevocations(ctx context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(ctx, revocationsKey, fn)
+}
+
+// LoadEnrollmentTokens reads the enrollment-tokens.json entry of the
+// state Secret. It returns nil if the entry does not exist yet.
+func (s *SecretStore) LoadEnrollmentTokens(ctx context.Context) ([]byte, error) {
+	return s.readData(ctx, enrollmentTokensKey)
+}
+
+// UpdateEnrollmentTokens replaces the enrollment-tokens.json entry of
+// the state Secret with the result of fn.
+func (s *SecretStore) UpdateEnrollmentTokens(ctx context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(ctx, enrollmentTokensKey, fn)
+}
+
+// LoadClusterPins reads the cluster-pins.json entry of the state
+// Secret. It returns nil if the entry does not exist yet.
+func (s *SecretStore) LoadClusterPins(ctx context.Context) ([]byte, error) {
+	return s.readData(ctx, clusterPinsKey)
+}
+
+// UpdateClusterPins replaces the cluster-pins.json entry of the state
+// Secret with the result of fn.
+func (s *SecretStore) UpdateClusterPins(ctx context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(ctx, clusterPinsKey, fn)
+}
+
+// LoadClusterApprovals reads the cluster-approvals.json entry of the
+// state Secret. It returns nil if the entry does not exist yet.
+func (s *SecretStore) LoadClusterApprovals(ctx context.Context) ([]byte, error) {
+	return s.readData(ctx, clusterApprovalsKey)
+}
+
+// UpdateClusterApprovals replaces the cluster-approvals.json entry of
+// the state Secret with the result of fn.
+func (s *SecretStore) UpdateClusterApprovals(ctx context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(ctx, clusterApprovalsKey, fn)
+}
+
+// LoadUpgradePolicy reads the upgrade-policy.json entry of the
+// state Secret. It returns nil if the entry does not exist yet.
+func (s *SecretStore) LoadUpgradePolicy(ctx context.Context) ([]byte, error) {
+	return s.readData(ctx, upgradePolicyKey)
+}
+
+// UpdateUpgradePolicy replaces the upgrade-policy.json entry of the
+// state Secret with the result of fn.
+func (s *SecretStore) UpdateUpgradePolicy(ctx context.Context, fn func(data []byte) ([]byte, error)) error {
+	return s.updateData(ctx, upgradePolicyKey, fn)
+}
+
+// readData reads a data entry of the state Secret, falling back to the
+// legacy entry of the CA Secret. It returns nil if neither Secret holds
+// the entry.
+func (s *SecretStore) readData(ctx context.Context, key string) ([]byte, error) {
+	state, _, err := s.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := state.Data[key]; ok {
+		return data, nil
+	}
+	return s.readLegacy(ctx, key)
+}
+
+// updateData replaces a data entry of the state Secret with the result
+// of fn for the current entry, creating the Secret if it is missing.
+// The update carries the resourceVersion that was read, so a
+// concurrent writer causes a conflict instead of a lost update; fn is
+// then called again with the entry that writer persisted. Nothing is
+// written if fn returns the entry unchanged, and an error returned by
+// fn is returned as is.
+func (s *SecretStore) updateData(ctx context.Context, key string, fn func(data []byte) ([]byte, error)) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+
+	var fnErr error
+	err := retry.OnError(retry.DefaultRetry, isWriteConflict, func() error {
+		state, exists, err := s.getState(ctx)
+		if err != nil {
+			return err
+		}
+		current, ok := state.Data[key]
+		if !ok {
+			if current, err = s.readLegacy(ctx, key); err != nil {
+				return err
+			}
+		}
+
+		data, err := fn(current)
+		if err != nil {
+			fnErr = err
+			return err
+		}
+		if ok && bytes.Equal(data, current) {
+			return nil
+		}
+
+		if !exists {
+			state.Data = map[string][]byte{key: data}
+			_, err = secrets.Create(ctx, state, metav1.CreateOptions{})
+			return err
+		}
+		state = state.DeepCopy()
+		if state.Data == nil {
+			state.Data = map[string][]byte{}
+		}
+		state.Data[key] = data
+		_, err = secrets.Update(ctx, state, metav1.UpdateOptions{})
+		return err
+	})
+	if fnErr != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("write secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+// getState reads the state Secret and reports whether it exists. If
+// it does not exist yet, a new, empty state Secret is returned.
+func (s *SecretStore) getState(ctx context.Context) (secret *corev1.Secret, exists bool, err error) {
+	secret, err = s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return s.newStateSecret(nil), false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return secret, true, nil
+}
+
+// readLegacy reads a data entry that hubs before the state Secret kept
+// in the CA Secret. It returns nil if the Secret or the entry does not
+// exist.
+func (s *SecretStore) readLegacy(ctx context.Context, key string) ([]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.caName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secret %s/%s: %w", s.namespace, s.caName, err)
+	}
+	return secret.Data[key], nil
+}
+
+// newStateSecret returns a new state Secret holding data.
+func (s *SecretStore) newStateSecret(data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "otterscale",
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+// isWriteConflict reports whether err is caused by a concurrent writer
+// of the state Secret, which updated or created it first.
+func isWriteConflict(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
@@ -0,0 +1,118 @@
+package hubstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestSecretStore_StateSecret verifies that the hub registries are
+// written to the state Secret, leaving the CA Secret untouched, and
+// that entries of hubs that kept them in the CA Secret are still read.
+func TestSecretStore_StateSecret(t *testing.T) {
+	ctx := t.Context()
+	client := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "otterscale-ca", Namespace: "otterscale-system"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+			revocationsKey:          []byte("legacy"),
+		},
+	})
+	store := NewSecretStore(client, "otterscale-system", "otterscale-hub-state", "otterscale-ca")
+
+	got, err := store.LoadRevocations(ctx)
+	if err != nil {
+		t.Fatalf("LoadRevocations: %v", err)
+	}
+	if string(got) != "legacy" {
+		t.Errorf("LoadRevocations = %q, want the legacy entry", got)
+	}
+
+	if err := store.UpdateRevocations(ctx, replaceData(t, "legacy", "current")); err != nil {
+		t.Fatalf("UpdateRevocations: %v", err)
+	}
+	if err := store.UpdateClusterPins(ctx, replaceData(t, "", "pins")); err != nil {
+		t.Fatalf("UpdateClusterPins: %v", err)
+	}
+	if got, err := store.LoadRevocations(ctx); err != nil || string(got) != "current" {
+		t.Errorf("LoadRevocations = %q, %v, want the saved entry", got, err)
+	}
+
+	state, err := client.CoreV1().Secrets("otterscale-system").Get(ctx, "otterscale-hub-state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get state secret: %v", err)
+	}
+	if !bytes.Equal(state.Data[revocationsKey], []byte("current")) || !bytes.Equal(state.Data[clusterPinsKey], []byte("pins")) {
+		t.Errorf("state secret data = %q, want both saved entries", state.Data)
+	}
+
+	ca, err := client.CoreV1().Secrets("otterscale-system").Get(ctx, "otterscale-ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get CA secret: %v", err)
+	}
+	if _, ok := ca.Data[clusterPinsKey]; ok || string(ca.Data[revocationsKey]) != "legacy" {
+		t.Errorf("CA secret data = %q, want it unchanged", ca.Data)
+	}
+}
+
+// TestSecretStore_UpdateConflict verifies that an update that loses a
+// race against another writer is applied again on top of that writer's
+// change instead of overwriting it.
+func TestSecretStore_UpdateConflict(t *testing.T) {
+	ctx := t.Context()
+	client := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "otterscale-hub-state", Namespace: "otterscale-system", ResourceVersion: "1"},
+		Data:       map[string][]byte{revocationsKey: []byte("a")},
+	})
+	store := NewSecretStore(client, "otterscale-system", "otterscale-hub-state", "otterscale-ca")
+
+	// The first update races with another writer that appends "b".
+	raced := false
+	client.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if raced {
+			return false, nil, nil
+		}
+		raced = true
+		secret := action.(k8stesting.UpdateAction).GetObject().(*corev1.Secret).DeepCopy()
+		secret.Data[revocationsKey] = []byte("ab")
+		if err := client.Tracker().Update(corev1.SchemeGroupVersion.WithResource("secrets"), secret, secret.Namespace); err != nil {
+			t.Fatalf("concurrent update: %v", err)
+		}
+		return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, errors.New("object has been modified"))
+	})
+
+	var calls []string
+	err := store.UpdateRevocations(ctx, func(data []byte) ([]byte, error) {
+		calls = append(calls, string(data))
+		return append(bytes.Clone(data), 'c'), nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateRevocations: %v", err)
+	}
+	if len(calls) != 2 || calls[1] != "ab" {
+		t.Errorf("fn called with %q, want a retry on the concurrent change", calls)
+	}
+	if got, err := store.LoadRevocations(ctx); err != nil || string(got) != "abc" {
+		t.Errorf("LoadRevocations = %q, %v, want both changes", got, err)
+	}
+}
+
+// replaceData returns an update function that checks the current data
+// and replaces it with data.
+func replaceData(t *testing.T, current, data string) func([]byte) ([]byte, error) {
+	return func(got []byte) ([]byte, error) {
+		if string(got) != current {
+			t.Errorf("update called with %q, want %q", got, current)
+		}
+		return []byte(data), nil
+	}
+}
@@ -9,7 +9,9 @@
 package kubernetes
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"sync"
@@ -27,6 +29,16 @@ import (
 // bounded and cannot block indefinitely.
 const clientTimeout = 30 * time.Second
 
+// PeerRouter builds the client configs that reach a cluster through
+// the hub replica its tunnel terminates on. It is implemented by the
+// peer forwarding provider.
+type PeerRouter interface {
+	// PeerConfig returns a rest.Config that reaches cluster through
+	// replica. The config authenticates to the replica on its own,
+	// including for connection upgrades.
+	PeerConfig(cluster string, replica core.HubReplica) (*rest.Config, error)
+}
+
 // clusterTransport holds a cached HTTP transport for a single cluster.
 // The transport is shared across users because impersonation is
 // handled via HTTP headers (WrapTransport), not at the transport
@@ -35,23 +47,32 @@ const clientTimeout = 30 * time.Second
 // impersonation config.
 type clusterTransport struct {
 	address string
+	caData  []byte // trust bundle of a peer channel transport
 	rt      http.RoundTripper
 }
 
 // Kubernetes is the shared foundation for discoveryClient and
 // resourceRepo. It resolves cluster names to tunnel addresses and
-// builds impersonated rest.Configs. Transports are cached per-cluster
-// and invalidated when the tunnel address changes.
+// builds impersonated rest.Configs. Clusters whose tunnel terminates
+// on another hub replica are reached through that replica. Transports
+// are cached per-cluster and invalidated when the tunnel address
+// changes.
 type Kubernetes struct {
 	mu         sync.Mutex
 	tunnel     core.TunnelProvider
+	peers      PeerRouter
 	transports map[string]*clusterTransport // keyed by cluster name
 }
 
-// New creates a Kubernetes helper bound to the given TunnelProvider.
-func New(tunnel core.TunnelProvider) *Kubernetes {
+// Verify at compile time that *Kubernetes satisfies core.ClusterEvictor.
+var _ core.ClusterEvictor = (*Kubernetes)(nil)
+
+// New creates a Kubernetes helper bound to the given TunnelProvider
+// and PeerRouter.
+func New(tunnel core.TunnelProvider, peers PeerRouter) *Kubernetes {
 	return &Kubernetes{
 		tunnel:     tunnel,
+		peers:      peers,
 		transports: make(map[string]*clusterTransport),
 	}
 }
@@ -73,21 +94,18 @@ func (k *Kubernetes) impersonationConfig(ctx context.Context, cluster string) (*
 		return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
 	}
 
-	address, err := k.tunnel.ResolveAddress(ctx, cluster)
+	base, err := k.baseConfig(ctx, cluster)
 	if err != nil {
-		// Cluster is no longer registered; evict stale cached
-		// clients and their TCP connections.
-		k.evictClients(cluster)
-		return nil, err // ResolveAddress already returns *core.ErrClusterNotFound
+		return nil, err
 	}
 
-	rt, err := k.roundTripper(cluster, address)
+	rt, err := k.roundTripper(cluster, base)
 	if err != nil {
 		return nil, err
 	}
 
 	cfg := &rest.Config{
-		Host: address,
+		Host: base.Host,
 		Impersonate: rest.ImpersonationConfig{
 			UserName: userInfo.Subject,
 			Groups:   userInfo.Groups,
@@ -112,48 +130,65 @@ func (k *Kubernetes) streamConfig(ctx context.Context, cluster string) (*rest.Co
 		}
 	}
 
-	address, err := k.tunnel.ResolveAddress(ctx, cluster)
+	base, err := k.baseConfig(ctx, cluster)
 	if err != nil {
-		// Cluster is no longer registered; evict stale cached
-		// clients and their TCP connections.
-		k.evictClients(cluster)
-		return nil, err // ResolveAddress already returns *core.ErrClusterNotFound
+		return nil, err
 	}
 
-	return &rest.Config{
-		Host: address,
-		Impersonate: rest.ImpersonationConfig{
-			UserName: userInfo.Subject,
-			Groups:   userInfo.Groups,
-		},
-	}, nil
+	cfg := rest.CopyConfig(base)
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: userInfo.Subject,
+		Groups:   userInfo.Groups,
+	}
+	return cfg, nil
 }
 
-// roundTripper returns a cached HTTP transport for the given cluster.
-// If the cached transport's address does not match the current tunnel
-// address (e.g. after cluster re-registration), the stale entry is
-// evicted and a fresh transport is created.
+// baseConfig returns the unauthenticated rest.Config that reaches the
+// given cluster: its tunnel address if the tunnel terminates on this
+// hub replica, or the peer channel of the replica it terminates on.
+func (k *Kubernetes) baseConfig(ctx context.Context, cluster string) (*rest.Config, error) {
+	address, err := k.tunnel.ResolveAddress(ctx, cluster)
+	if err == nil {
+		return &rest.Config{Host: address}, nil
+	}
+
+	var onPeer *core.ErrClusterOnPeer
+	if errors.As(err, &onPeer) {
+		return k.peers.PeerConfig(cluster, onPeer.Replica)
+	}
+
+	// Cluster is no longer registered; evict stale cached clients and
+	// their TCP connections.
+	k.evictClients(cluster)
+	return nil, err // ResolveAddress already returns *core.ErrClusterNotFound
+}
+
+// roundTripper returns a cached HTTP transport for the given cluster,
+// built from base. If the cached transport's address or trust bundle
+// does not match base (e.g. after cluster re-registration, after the
+// tunnel moved to another hub replica, or after a CA rotation changed
+// the trust bundle of the peer channel), the stale entry is evicted
+// and a fresh transport is created.
 //
 // Transports are shared across users because impersonation is handled
 // via HTTP headers, not at the transport level. This avoids creating
 // new TCP connections on every request.
-func (k *Kubernetes) roundTripper(cluster, address string) (http.RoundTripper, error) {
+func (k *Kubernetes) roundTripper(cluster string, base *rest.Config) (http.RoundTripper, error) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
 
-	if entry, ok := k.transports[cluster]; ok && entry.address == address {
+	if entry, ok := k.transports[cluster]; ok && entry.address == base.Host && bytes.Equal(entry.caData, base.CAData) {
 		return entry.rt, nil
 	}
 
-	// Address changed or first access — create a fresh transport.
+	// Address or trust bundle changed, or first access — create a fresh transport.
 	// Close idle connections on the old transport to avoid leaking
 	// TCP connections to a stale tunnel address.
 	if old, ok := k.transports[cluster]; ok {
 		closeTransport(old.rt)
 	}
 
-	cfg := &rest.Config{Host: address}
-	rt, err := rest.TransportFor(cfg)
+	rt, err := rest.TransportFor(base)
 	if err != nil {
 		return nil, &core.DomainError{
 			Code:    core.ErrorCodeInternal,
@@ -163,7 +198,8 @@ func (k *Kubernetes) roundTripper(cluster, address string) (http.RoundTripper, e
 	}
 
 	k.transports[cluster] = &clusterTransport{
-		address: address,
+		address: base.Host,
+		caData:  bytes.Clone(base.CAData),
 		rt:      rt,
 	}
 	return rt, nil
@@ -182,6 +218,11 @@ func (k *Kubernetes) evictClients(cluster string) {
 	}
 }
 
+// EvictCluster drops the cached transport of a deregistered cluster.
+func (k *Kubernetes) EvictCluster(cluster string) {
+	k.evictClients(cluster)
+}
+
 // closeTransport closes idle connections on the transport if it
 // supports the CloseIdleConnections method (e.g. *http.Transport).
 func closeTransport(rt http.RoundTripper) {
@@ -497,6 +497,9 @@ func (r *runtimeRepo) dialVNCWebSocket(ctx context.Context, config *rest.Config,
 	for _, g := range config.Impersonate.Groups {
 		headers.Add("Impersonate-Group", g)
 	}
+	if err := addWrapperHeaders(headers, config, rawURL); err != nil {
+		return nil, &core.DomainError{Code: core.ErrorCodeInternal, Message: "build VNC request headers", Cause: err}
+	}
 
 	conn, resp, err := dialer.DialContext(ctx, wsURL, headers)
 	if err != nil {
@@ -508,6 +511,40 @@ func (r *runtimeRepo) dialVNCWebSocket(ctx context.Context, config *rest.Config,
 	return conn, nil
 }
 
+// addWrapperHeaders adds the headers the WrapTransport of config sets,
+// such as the token of the peer channel between hub replicas, to
+// headers. The WebSocket dialer does not go through an
+// http.RoundTripper, so the wrapper is run against a request that is
+// never sent.
+func addWrapperHeaders(headers http.Header, config *rest.Config, rawURL string) error {
+	if config.WrapTransport == nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	capture := &headerCapture{}
+	if _, err := config.WrapTransport(capture).RoundTrip(req); err != nil {
+		return err
+	}
+	for k, v := range capture.header {
+		headers[k] = v
+	}
+	return nil
+}
+
+// headerCapture is an http.RoundTripper that records the headers of
+// the request it is given instead of sending it.
+type headerCapture struct {
+	header http.Header
+}
+
+func (c *headerCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.header = req.Header
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
 // copyVNCBidirectional copies data between the WebSocket connection
 // and the VNC session's stdin/stdout pipes.
 func (r *runtimeRepo) copyVNCBidirectional(ctx context.Context, wsConn *websocket.Conn, opts core.VNCOptions) error {
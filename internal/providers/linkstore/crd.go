@@ -0,0 +1,146 @@
+package linkstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// tunnelLinkResource identifies the TunnelLink custom resource. Its
+// definition is in manifests/crds/otterscale.io_tunnellinks.yaml.
+var tunnelLinkResource = schema.GroupVersionResource{
+	Group:    "otterscale.io",
+	Version:  "v1alpha1",
+	Resource: "tunnellinks",
+}
+
+const (
+	// tunnelLinkKind is the kind of the TunnelLink custom resource.
+	tunnelLinkKind = "TunnelLink"
+	// fieldManager is the server-side apply field manager that owns
+	// the TunnelLink spec.
+	fieldManager = "otterscale-server"
+)
+
+// CRDStore persists every link as a TunnelLink custom resource named
+// after its cluster in the hub's own cluster. Writes use server-side
+// apply, so they need no read-modify-write cycle. It implements
+// core.LinkStore.
+type CRDStore struct {
+	client    dynamic.Interface
+	namespace string
+}
+
+// Verify at compile time that CRDStore satisfies core.LinkStore.
+var _ core.LinkStore = (*CRDStore)(nil)
+
+// NewCRDStore returns a CRDStore that reads and writes TunnelLink
+// resources in namespace through client.
+func NewCRDStore(client dynamic.Interface, namespace string) *CRDStore {
+	return &CRDStore{client: client, namespace: namespace}
+}
+
+// LoadLinks lists the TunnelLink resources in the namespace.
+func (s *CRDStore) LoadLinks(ctx context.Context) ([]core.LinkRecord, error) {
+	list, err := s.resource().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list tunnel links in %s: %w", s.namespace, err)
+	}
+
+	ret := make([]core.LinkRecord, 0, len(list.Items))
+	for i := range list.Items {
+		rec, err := fromUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, rec)
+	}
+	return ret, nil
+}
+
+// SaveLink applies the TunnelLink resource of rec.Cluster.
+func (s *CRDStore) SaveLink(ctx context.Context, rec core.LinkRecord) error {
+	obj, err := toUnstructured(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.resource().Apply(ctx, rec.Cluster, obj, metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("apply tunnel link %s/%s: %w", s.namespace, rec.Cluster, err)
+	}
+	return nil
+}
+
+// DeleteLink deletes the TunnelLink resource of cluster.
+func (s *CRDStore) DeleteLink(ctx context.Context, cluster string) error {
+	err := s.resource().Delete(ctx, cluster, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete tunnel link %s/%s: %w", s.namespace, cluster, err)
+	}
+	return nil
+}
+
+// resource returns the TunnelLink client for the store's namespace.
+func (s *CRDStore) resource() dynamic.ResourceInterface {
+	return s.client.Resource(tunnelLinkResource).Namespace(s.namespace)
+}
+
+// toUnstructured converts rec into a TunnelLink resource whose spec
+// carries the record's JSON fields.
+func toUnstructured(rec core.LinkRecord) (*unstructured.Unstructured, error) {
+	spec, err := recordToMap(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	obj.SetAPIVersion(tunnelLinkResource.GroupVersion().String())
+	obj.SetKind(tunnelLinkKind)
+	obj.SetName(rec.Cluster)
+	obj.SetLabels(map[string]string{
+		"app.kubernetes.io/managed-by": "otterscale",
+	})
+	return obj, nil
+}
+
+// fromUnstructured converts a TunnelLink resource into a LinkRecord.
+func fromUnstructured(obj *unstructured.Unstructured) (core.LinkRecord, error) {
+	spec, ok, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil || !ok {
+		return core.LinkRecord{}, fmt.Errorf("tunnel link %s has no valid spec", obj.GetName())
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return core.LinkRecord{}, fmt.Errorf("encode tunnel link %s: %w", obj.GetName(), err)
+	}
+	var rec core.LinkRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return core.LinkRecord{}, fmt.Errorf("decode tunnel link %s: %w", obj.GetName(), err)
+	}
+	if rec.Cluster == "" {
+		rec.Cluster = obj.GetName()
+	}
+	return rec, nil
+}
+
+// recordToMap returns the JSON object form of rec.
+func recordToMap(rec core.LinkRecord) (map[string]any, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("encode tunnel link %s: %w", rec.Cluster, err)
+	}
+	var ret map[string]any
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("encode tunnel link %s: %w", rec.Cluster, err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,152 @@
+package linkstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+const (
+	// linksFileName is the file holding the JSON-encoded links.
+	linksFileName = "links.json"
+	// filePerm restricts the links file to the owning user.
+	filePerm = 0o600
+	// dirPerm restricts the links directory to the owning user.
+	dirPerm = 0o700
+)
+
+// FileStore persists every link in a single JSON file inside a
+// directory. Each change rewrites the file and renames it into place,
+// so readers never observe a partial write. It implements
+// core.LinkStore and is meant for single-replica hubs.
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Verify at compile time that FileStore satisfies core.LinkStore.
+var _ core.LinkStore = (*FileStore)(nil)
+
+// NewFileStore returns a FileStore rooted at dir. The directory is
+// created on first write if it does not exist.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// LoadLinks reads the links file. It returns no links if the file
+// does not exist yet.
+func (s *FileStore) LoadLinks(_ context.Context) ([]core.LinkRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Collect(maps.Values(links)), nil
+}
+
+// SaveLink creates or replaces the record of rec.Cluster.
+func (s *FileStore) SaveLink(_ context.Context, rec core.LinkRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links, err := s.read()
+	if err != nil {
+		return err
+	}
+	links[rec.Cluster] = rec
+	return s.write(links)
+}
+
+// DeleteLink removes the record of cluster.
+func (s *FileStore) DeleteLink(_ context.Context, cluster string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := links[cluster]; !ok {
+		return nil
+	}
+	delete(links, cluster)
+	return s.write(links)
+}
+
+// read decodes the links file into a map keyed by cluster. s.mu must
+// be held.
+func (s *FileStore) read() (map[string]core.LinkRecord, error) {
+	links := make(map[string]core.LinkRecord)
+
+	data, err := os.ReadFile(filepath.Join(s.dir, linksFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return links, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", linksFileName, err)
+	}
+
+	var records []core.LinkRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", linksFileName, err)
+	}
+	for _, rec := range records {
+		links[rec.Cluster] = rec
+	}
+	return links, nil
+}
+
+// write atomically replaces the links file with links, sorted by
+// cluster. s.mu must be held.
+func (s *FileStore) write(links map[string]core.LinkRecord) error {
+	records := slices.SortedFunc(maps.Values(links), func(a, b core.LinkRecord) int {
+		return strings.Compare(a.Cluster, b.Cluster)
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", linksFileName, err)
+	}
+
+	if err := os.MkdirAll(s.dir, dirPerm); err != nil {
+		return fmt.Errorf("create links dir: %w", err)
+	}
+	f, err := os.CreateTemp(s.dir, ".links-*.tmp")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", linksFileName, err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if err := f.Chmod(filePerm); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", linksFileName, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", linksFileName, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", linksFileName, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", linksFileName, err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, linksFileName)); err != nil {
+		return fmt.Errorf("replace %s: %w", linksFileName, err)
+	}
+	return nil
+}
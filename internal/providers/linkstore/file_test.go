@@ -0,0 +1,70 @@
+package linkstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+func TestFileStore_LoadMissing(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "links"))
+
+	links, err := store.LoadLinks(t.Context())
+	if err != nil {
+		t.Fatalf("LoadLinks: %v", err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("expected no links, got %+v", links)
+	}
+}
+
+func TestFileStore_SaveAndDelete(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "links")
+	store := NewFileStore(dir)
+	seen := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, cluster := range []string{"b", "a"} {
+		rec := core.LinkRecord{Cluster: cluster, AgentID: "agent-" + cluster, Host: "127.1.1.1", FirstSeen: seen, LastSeen: seen}
+		if err := store.SaveLink(t.Context(), rec); err != nil {
+			t.Fatalf("SaveLink: %v", err)
+		}
+	}
+	updated := core.LinkRecord{
+		Cluster:   "a",
+		AgentID:   "agent-a2",
+		Host:      "127.1.1.2",
+		FirstSeen: seen,
+		LastSeen:  seen.Add(time.Hour),
+		Labels:    map[string]string{"env": "prod"},
+		Facts:     core.ClusterFacts{KubernetesVersion: "v1.34.1", NodeCount: 3, Provider: "aws"},
+	}
+	if err := store.SaveLink(t.Context(), updated); err != nil {
+		t.Fatalf("SaveLink: %v", err)
+	}
+	if err := store.DeleteLink(t.Context(), "b"); err != nil {
+		t.Fatalf("DeleteLink: %v", err)
+	}
+	if err := store.DeleteLink(t.Context(), "missing"); err != nil {
+		t.Fatalf("DeleteLink of a missing link: %v", err)
+	}
+
+	links, err := NewFileStore(dir).LoadLinks(t.Context())
+	if err != nil {
+		t.Fatalf("LoadLinks: %v", err)
+	}
+	if len(links) != 1 || !reflect.DeepEqual(links[0], updated) {
+		t.Fatalf("links = %+v, want only %+v", links, updated)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, linksFileName))
+	if err != nil {
+		t.Fatalf("stat links file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != filePerm {
+		t.Errorf("links file permissions = %o, want %o", perm, filePerm)
+	}
+}
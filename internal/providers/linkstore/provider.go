@@ -0,0 +1,52 @@
+// Package linkstore implements core.LinkStore backends that persist
+// the tunnel link registry across hub restarts: a JSON file on disk
+// and TunnelLink custom resources in the hub's own cluster.
+package linkstore
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// Supported values for the server.links.store configuration key.
+const (
+	StoreFile = "file"
+	StoreCRD  = "crd"
+)
+
+// ProvideLinkStore is a Wire provider that returns the LinkStore
+// selected by the server.links.store configuration key.
+func ProvideLinkStore(conf *config.Config) (core.LinkStore, error) {
+	switch store := conf.ServerLinksStore(); store {
+	case StoreFile:
+		return NewFileStore(conf.ServerLinksDir()), nil
+	case StoreCRD:
+		cfg, err := kubeConfig()
+		if err != nil {
+			return nil, fmt.Errorf("link store: load kubernetes config: %w", err)
+		}
+		client, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("link store: create kubernetes client: %w", err)
+		}
+		return NewCRDStore(client, conf.ServerLinksNamespace()), nil
+	default:
+		return nil, fmt.Errorf("link store: unsupported store %q (expected %q or %q)", store, StoreFile, StoreCRD)
+	}
+}
+
+// kubeConfig returns the in-cluster config, or the user's kubeconfig
+// when OTTERSCALE_DEBUG is set for local development.
+func kubeConfig() (*rest.Config, error) {
+	if os.Getenv("OTTERSCALE_DEBUG") != "" {
+		return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	}
+	return rest.InClusterConfig()
+}
@@ -0,0 +1,335 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"text/template"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// archiveDir is the top-level directory of agent archives, and the
+// name of the Helm chart.
+const archiveDir = "otterscale-agent"
+
+// archiveFile is a file of an agent archive, relative to archiveDir.
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+// RenderAgentArchive renders the agent manifest as a gzipped tarball
+// holding a Helm chart or a Kustomize base. The Helm chart deploys the
+// resources of the manifest verbatim and templates the Deployment from
+// values that default to params.Values. The Kustomize base holds the
+// manifest split into the Deployment, for overlays to patch, and the
+// other resources.
+func (r *Renderer) RenderAgentArchive(params *core.ManifestParams, format core.ManifestFormat) ([]byte, error) {
+	data := newAgentManifestData(params)
+
+	var resources bytes.Buffer
+	if err := agentManifestTmpl.ExecuteTemplate(&resources, "agent-resources", data); err != nil {
+		return nil, fmt.Errorf("render agent resources: %w", err)
+	}
+
+	var files []archiveFile
+	switch format {
+	case core.ManifestFormatHelm:
+		chart, err := renderArchiveTemplate(chartTmpl, newChartData(params))
+		if err != nil {
+			return nil, err
+		}
+		values, err := renderArchiveTemplate(chartValuesTmpl, newChartValues(params, data))
+		if err != nil {
+			return nil, err
+		}
+		files = []archiveFile{
+			{"Chart.yaml", chart},
+			{"values.yaml", values},
+			// The resources are included with .Files.Get so that Helm
+			// does not evaluate template actions in user-provided
+			// strings such as user names.
+			{"files/resources.yaml", resources.Bytes()},
+			{"templates/resources.yaml", []byte(`{{ .Files.Get "files/resources.yaml" }}` + "\n")},
+			{"templates/deployment.yaml", []byte(chartDeploymentYAML)},
+		}
+
+	case core.ManifestFormatKustomize:
+		var deployment bytes.Buffer
+		if err := agentManifestTmpl.ExecuteTemplate(&deployment, "agent-deployment", data); err != nil {
+			return nil, fmt.Errorf("render agent deployment: %w", err)
+		}
+		kustomization, err := renderArchiveTemplate(kustomizationTmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		files = []archiveFile{
+			{"kustomization.yaml", kustomization},
+			{"resources.yaml", resources.Bytes()},
+			{"deployment.yaml", deployment.Bytes()},
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q", format)
+	}
+	return writeArchive(files)
+}
+
+// renderArchiveTemplate executes tmpl with data.
+func renderArchiveTemplate(tmpl *template.Template, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render %s: %w", tmpl.Name(), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeArchive returns a gzipped tarball of files under archiveDir.
+// The modification times are left zero so that the same files always
+// produce the same archive.
+func writeArchive(files []archiveFile) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     archiveDir + "/" + f.name,
+			Mode:     0o644,
+			Size:     int64(len(f.data)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("write archive header: %w", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, fmt.Errorf("write archive file: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("close archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("close archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// chartData holds the template parameters of Chart.yaml.
+type chartData struct {
+	Cluster string
+	// Version is the chart version, the agent version without the "v"
+	// prefix, since Helm requires chart versions to be semantic
+	// versions.
+	Version    string
+	AppVersion string
+}
+
+// newChartData returns the Chart.yaml parameters of params. Agent
+// versions that are not semantic versions, such as development builds,
+// are packaged as chart version 0.0.0.
+func newChartData(params *core.ManifestParams) chartData {
+	version := "0.0.0"
+	if v, err := core.ParseAgentVersion(params.Version); err == nil {
+		version = v.String()
+	}
+	return chartData{Cluster: params.Cluster, Version: version, AppVersion: params.Version}
+}
+
+// chartValues holds the default values of the Helm chart. Every field
+// is encoded with toJSON; nil maps and slices are replaced by empty
+// ones so that they render as {} and [] rather than null.
+type chartValues struct {
+	Cluster           string
+	ServerURL         string
+	TunnelURL         string
+	HarborURL         string
+	Image             string
+	ImagePullSecrets  []string
+	Bootstrap         bool
+	Replicas          int
+	Resources         map[string]map[string]string
+	NodeSelector      map[string]string
+	Tolerations       []core.AgentToleration
+	PriorityClassName string
+	Proxy             core.AgentProxy
+}
+
+// newChartValues returns the default chart values of params.
+func newChartValues(params *core.ManifestParams, data agentManifestData) chartValues {
+	resources := map[string]map[string]string{}
+	if r := params.Values.Resources.Requests; len(r) > 0 {
+		resources["requests"] = r
+	}
+	if l := params.Values.Resources.Limits; len(l) > 0 {
+		resources["limits"] = l
+	}
+	v := chartValues{
+		Cluster:           params.Cluster,
+		ServerURL:         params.ServerURL,
+		TunnelURL:         params.TunnelURL,
+		HarborURL:         params.HarborURL,
+		Image:             params.Image,
+		ImagePullSecrets:  params.ImagePullSecrets,
+		Bootstrap:         params.Bootstrap,
+		Replicas:          data.Replicas,
+		Resources:         resources,
+		NodeSelector:      params.Values.NodeSelector,
+		Tolerations:       params.Values.Tolerations,
+		PriorityClassName: params.Values.PriorityClassName,
+		Proxy:             params.Values.Proxy,
+	}
+	if v.ImagePullSecrets == nil {
+		v.ImagePullSecrets = []string{}
+	}
+	if v.NodeSelector == nil {
+		v.NodeSelector = map[string]string{}
+	}
+	if v.Tolerations == nil {
+		v.Tolerations = []core.AgentToleration{}
+	}
+	return v
+}
+
+var (
+	chartTmpl         = newArchiveTemplate("Chart.yaml", chartYAML)
+	chartValuesTmpl   = newArchiveTemplate("values.yaml", chartValuesYAML)
+	kustomizationTmpl = newArchiveTemplate("kustomization.yaml", kustomizationYAML)
+)
+
+// newArchiveTemplate parses an archive file template with the
+// functions of the agent manifest template.
+func newArchiveTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).
+		Funcs(template.FuncMap{"yamlQuote": yamlQuote, "toJSON": toJSON}).
+		Parse(text))
+}
+
+const chartYAML = `apiVersion: v2
+name: ` + archiveDir + `
+description: {{ yamlQuote (printf "The otterscale agent of cluster %s" .Cluster) }}
+type: application
+version: {{ yamlQuote .Version }}
+appVersion: {{ yamlQuote .AppVersion }}
+`
+
+const chartValuesYAML = `# Values of the otterscale agent of cluster {{ yamlQuote .Cluster }},
+# as generated by the hub.
+
+# Connection of the agent to the hub.
+cluster: {{ toJSON .Cluster }}
+serverURL: {{ toJSON .ServerURL }}
+tunnelURL: {{ toJSON .TunnelURL }}
+harborURL: {{ toJSON .HarborURL }}
+
+# The agent replaces the image when it updates itself.
+image: {{ toJSON .Image }}
+imagePullSecrets: {{ toJSON .ImagePullSecrets }}
+
+# Whether the agent bootstraps the cluster. The RBAC of the chart was
+# generated for this setting.
+bootstrap: {{ toJSON .Bootstrap }}
+
+replicas: {{ toJSON .Replicas }}
+resources: {{ toJSON .Resources }}
+nodeSelector: {{ toJSON .NodeSelector }}
+tolerations: {{ toJSON .Tolerations }}
+priorityClassName: {{ toJSON .PriorityClassName }}
+
+# HTTP proxy the agent reaches the hub through.
+proxy:
+  http: {{ toJSON .Proxy.HTTP }}
+  https: {{ toJSON .Proxy.HTTPS }}
+  noProxy: {{ toJSON .Proxy.NoProxy }}
+`
+
+// chartDeploymentYAML is the Helm template of the agent Deployment. It
+// mirrors agentDeploymentYAML with the settings taken from the chart
+// values.
+const chartDeploymentYAML = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: otterscale-agent
+  namespace: otterscale-system
+  labels:
+    helm.sh/chart: {{ printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | quote }}
+spec:
+  replicas: {{ .Values.replicas }}
+  # Keeps the old agent pod until the new one has been up for a while,
+  # so that a self-update to a crashing image can be reverted.
+  minReadySeconds: 30
+  selector:
+    matchLabels:
+      app: otterscale-agent
+  template:
+    metadata:
+      labels:
+        app: otterscale-agent
+    spec:
+      serviceAccountName: otterscale-agent
+      {{- with .Values.priorityClassName }}
+      priorityClassName: {{ . | quote }}
+      {{- end }}
+      {{- with .Values.nodeSelector }}
+      nodeSelector:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with .Values.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with .Values.imagePullSecrets }}
+      imagePullSecrets:
+        {{- range . }}
+        - name: {{ . | quote }}
+        {{- end }}
+      {{- end }}
+      containers:
+        - name: otterscale
+          image: {{ .Values.image | quote }}
+          args:
+            - agent
+          {{- with .Values.resources }}
+          resources:
+            {{- toYaml . | nindent 12 }}
+          {{- end }}
+          env:
+            - name: OTTERSCALE_AGENT_SERVER_URL
+              value: {{ .Values.serverURL | quote }}
+            - name: OTTERSCALE_AGENT_TUNNEL_SERVER_URL
+              value: {{ .Values.tunnelURL | quote }}
+            - name: OTTERSCALE_AGENT_CLUSTER
+              value: {{ .Values.cluster | quote }}
+            {{- if not .Values.bootstrap }}
+            - name: OTTERSCALE_AGENT_BOOTSTRAP
+              value: "false"
+            {{- end }}
+            {{- with .Values.harborURL }}
+            - name: OTTERSCALE_AGENT_HARBOR_URL
+              value: {{ . | quote }}
+            {{- end }}
+            {{- with .Values.proxy.http }}
+            - name: HTTP_PROXY
+              value: {{ . | quote }}
+            {{- end }}
+            {{- with .Values.proxy.https }}
+            - name: HTTPS_PROXY
+              value: {{ . | quote }}
+            {{- end }}
+            {{- with .Values.proxy.noProxy }}
+            - name: NO_PROXY
+              value: {{ . | quote }}
+            {{- end }}
+`
+
+const kustomizationYAML = `# Kustomize base of the otterscale agent of cluster {{ yamlQuote .Cluster }},
+# as generated by the hub. Overlays patch the otterscale-agent
+# Deployment in deployment.yaml, e.g. its replicas, resources,
+# nodeSelector, tolerations, priorityClassName or proxy environment.
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - resources.yaml
+  - deployment.yaml
+`
@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/otterscale/otterscale/internal/config"
 	"github.com/otterscale/otterscale/internal/core"
@@ -11,18 +12,45 @@ import (
 // ProvideAgentManifestConfig is a Wire provider that extracts the
 // external URLs from the server configuration and derives an HMAC key
 // for signing stateless manifest tokens. The HMAC key is derived from
-// the CA's private key via HKDF. Since the CA is ephemeral (regenerated
-// on each server start), tokens issued before a restart become invalid
-// and agents will automatically request new ones.
+// the CA's private key via HKDF. Since the CA is persisted through a
+// pki.CAStore, the key (and therefore every outstanding manifest URL)
+// survives server restarts. The agent image settings are included as
+// well.
 func ProvideAgentManifestConfig(conf *config.Config, ca *pki.CA) (core.AgentManifestConfig, error) {
 	hmacKey, err := ca.DeriveHMACKey("manifest-token")
 	if err != nil {
 		return core.AgentManifestConfig{}, fmt.Errorf("derive HMAC key: %w", err)
 	}
+	digests, err := parseImageDigests(conf.ServerAgentImageDigests())
+	if err != nil {
+		return core.AgentManifestConfig{}, err
+	}
 	return core.AgentManifestConfig{
 		ServerURL: conf.ServerExternalURL(),
 		TunnelURL: conf.ServerExternalTunnelURL(),
 		HMACKey:   hmacKey,
 		HarborURL: conf.ServerHarborURL(),
+		Image: core.AgentImageConfig{
+			Repository:  conf.ServerAgentImageRepository(),
+			Digests:     digests,
+			PullSecrets: conf.ServerAgentImagePullSecrets(),
+		},
 	}, nil
 }
+
+// parseImageDigests parses version=digest pairs into a map keyed by
+// version. It returns nil for no pairs, which disables digest pinning.
+func parseImageDigests(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	digests := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		version, digest, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("agent image digest %q: want version=sha256:...", pair)
+		}
+		digests[strings.TrimSpace(version)] = strings.TrimSpace(digest)
+	}
+	return digests, nil
+}
@@ -27,26 +27,56 @@ func NewRenderer() *Renderer {
 
 // RenderAgentManifest produces a multi-document YAML manifest for
 // installing the otterscale agent on a target Kubernetes cluster.
-// The manifest includes a Namespace, ServiceAccount,
-// ClusterRoleBinding (binding userName to cluster-admin), and a
-// Deployment that runs the agent with the correct server/tunnel URLs.
+// The manifest includes a Namespace, ServiceAccount, the RBAC of the
+// profile (for the full profile a ClusterRoleBinding of userName to
+// cluster-admin), the enrollment Secret, and a Deployment that runs
+// the agent with the correct server/tunnel URLs.
 func (r *Renderer) RenderAgentManifest(params *core.ManifestParams) (string, error) {
+	var buf bytes.Buffer
+	for _, name := range []string{"agent-resources", "agent-deployment"} {
+		if err := agentManifestTmpl.ExecuteTemplate(&buf, name, newAgentManifestData(params)); err != nil {
+			return "", fmt.Errorf("render agent manifest: %w", err)
+		}
+	}
+	return buf.String(), nil
+}
+
+// newAgentManifestData returns the template parameters of the agent
+// manifest of params.
+func newAgentManifestData(params *core.ManifestParams) agentManifestData {
 	data := agentManifestData{
 		Cluster:           params.Cluster,
-		ClusterAdminUsers: append([]string{params.UserName}, params.ExtraUsers...),
+		Users:             append([]string{params.UserName}, params.ExtraUsers...),
+		Full:              params.Profile == "" || params.Profile == core.ManifestProfileFull,
+		Namespaces:        params.Namespaces,
+		Bootstrap:         params.Bootstrap,
+		ImpersonateGroups: params.ImpersonateGroups,
+		Replicas:          max(params.Values.Replicas, 1),
+		Values:            params.Values,
 		Image:             params.Image,
+		ImagePullSecrets:  params.ImagePullSecrets,
 		ServerURL:         params.ServerURL,
 		TunnelURL:         params.TunnelURL,
 		HarborURL:         params.HarborURL,
+		EnrollmentToken:   params.EnrollmentToken,
 	}
 	if params.HarborCreds != nil {
 		data.HarborRobotName = params.HarborCreds.Name
 		data.HarborRobotSecret = params.HarborCreds.Secret
 	}
+	return data
+}
 
+// RenderUninstallManifest produces a multi-document YAML manifest
+// listing every resource the agent manifest installs, identified by
+// kind and name only, so that "kubectl delete -f" removes the agent
+// from the target cluster. The Deployment comes first so that the
+// agent stops before its credentials are removed, and the Namespace
+// last.
+func (r *Renderer) RenderUninstallManifest(cluster string) (string, error) {
 	var buf bytes.Buffer
-	if err := agentManifestTmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("render agent manifest: %w", err)
+	if err := uninstallManifestTmpl.Execute(&buf, uninstallManifestData{Cluster: cluster}); err != nil {
+		return "", fmt.Errorf("render uninstall manifest: %w", err)
 	}
 	return buf.String(), nil
 }
@@ -54,14 +84,36 @@ func (r *Renderer) RenderAgentManifest(params *core.ManifestParams) (string, err
 // agentManifestData holds the template parameters for agent manifest
 // generation.
 type agentManifestData struct {
-	Cluster           string
-	ClusterAdminUsers []string
+	Cluster string
+	// Users are bound to cluster-admin if Full is set, or to admin in
+	// each of Namespaces.
+	Users             []string
+	Full              bool
+	Namespaces        []string
+	Bootstrap         bool
+	ImpersonateGroups []string
+	Replicas          int
+	Values            core.AgentDeploymentValues
 	Image             string
+	ImagePullSecrets  []string
 	ServerURL         string
 	TunnelURL         string
 	HarborURL         string
 	HarborRobotName   string
 	HarborRobotSecret string
+	EnrollmentToken   string
+}
+
+// uninstallManifestData holds the template parameters for uninstall
+// manifest generation.
+type uninstallManifestData struct {
+	Cluster string
+}
+
+// toJSON encodes v as JSON, which is valid YAML flow style.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
 }
 
 // yamlQuote produces a JSON-encoded string (with surrounding quotes)
@@ -74,15 +126,18 @@ func yamlQuote(s string) string {
 }
 
 // agentManifestTmpl is the parsed Go template for generating agent
-// installation manifests. The "yamlQuote" function produces a
+// installation manifests. It defines the "agent-resources" template,
+// rendering everything but the agent Deployment, and the
+// "agent-deployment" template. The "yamlQuote" function produces a
 // JSON-encoded string that is safe for YAML double-quoted contexts.
 var agentManifestTmpl = template.Must(
 	template.New("agent-manifest").
-		Funcs(template.FuncMap{"yamlQuote": yamlQuote}).
-		Parse(agentManifestYAML),
+		Funcs(template.FuncMap{"yamlQuote": yamlQuote, "toJSON": toJSON}).
+		Parse(`{{ define "agent-resources" }}` + agentResourcesYAML + `{{ end }}` +
+			`{{ define "agent-deployment" }}` + agentDeploymentYAML + `{{ end }}`),
 )
 
-const agentManifestYAML = `---
+const agentResourcesYAML = `---
 apiVersion: v1
 kind: Namespace
 metadata:
@@ -101,11 +156,29 @@ metadata:
 rules:
   # The agent proxies authenticated user requests to the local
   # kube-apiserver using impersonation headers. It must be allowed
-  # to impersonate any user and group so that RBAC on the target
-  # cluster enforces the actual caller's permissions.
+  # to impersonate any user and the callers' groups so that RBAC on
+  # the target cluster enforces the actual caller's permissions.
+{{- if .ImpersonateGroups }}
+  - apiGroups: [""]
+    resources: ["users"]
+    verbs: ["impersonate"]
+  - apiGroups: [""]
+    resources: ["groups"]
+    resourceNames:
+{{- range .ImpersonateGroups }}
+      - {{ yamlQuote . }}
+{{- end }}
+    verbs: ["impersonate"]
+{{- else }}
   - apiGroups: [""]
     resources: ["users", "groups"]
     verbs: ["impersonate"]
+{{- end }}
+  # The agent reports the node count of the cluster to the hub.
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list"]
+{{- if .Bootstrap }}
   # Bootstrap: core resources required by FluxCD.
   - apiGroups: [""]
     resources: ["namespaces", "serviceaccounts", "services", "configmaps", "secrets", "resourcequotas"]
@@ -138,6 +211,7 @@ rules:
   - apiGroups: ["source.toolkit.fluxcd.io"]
     resources: ["gitrepositories", "helmrepositories"]
     verbs: ["get", "create", "patch"]
+{{- end }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
@@ -159,11 +233,19 @@ metadata:
   namespace: otterscale-system
 rules:
   # The agent self-updates by patching its own Deployment image when
-  # the server advertises a newer version.
+  # the server advertises a newer version, and reverts the patch if the
+  # new version does not register in time.
   - apiGroups: ["apps"]
     resources: ["deployments"]
     resourceNames: ["otterscale-agent"]
     verbs: ["get", "patch"]
+  # The agent reads its enrollment token and records its latest
+  # certificate so that a restarted pod can register again once the
+  # single-use token has been spent.
+  - apiGroups: [""]
+    resources: ["secrets"]
+    resourceNames: ["otterscale-agent-enrollment"]
+    verbs: ["get", "patch"]
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: RoleBinding
@@ -178,13 +260,14 @@ roleRef:
   kind: Role
   name: otterscale-agent
   apiGroup: rbac.authorization.k8s.io
+{{- if .Full }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
 metadata:
   name: otterscale-cluster-admin
 subjects:
-{{- range .ClusterAdminUsers }}
+{{- range .Users }}
   - kind: User
     name: {{ yamlQuote . }}
     apiGroup: rbac.authorization.k8s.io
@@ -237,14 +320,62 @@ roleRef:
   kind: ClusterRole
   name: otterscale-storageclass-reader
   apiGroup: rbac.authorization.k8s.io
+{{- end }}
+{{- range $ns := .Namespaces }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: otterscale-namespace-admin
+  namespace: {{ yamlQuote $ns }}
+  labels:
+    app.kubernetes.io/managed-by: otterscale
+subjects:
+{{- range $.Users }}
+  - kind: User
+    name: {{ yamlQuote . }}
+    apiGroup: rbac.authorization.k8s.io
+{{- end }}
+roleRef:
+  kind: ClusterRole
+  name: admin
+  apiGroup: rbac.authorization.k8s.io
+{{- end }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: otterscale-agent-enrollment
+  namespace: otterscale-system
+type: Opaque
+stringData:
+  token: {{ yamlQuote .EnrollmentToken }}
+{{- if .HarborRobotName }}
 ---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: otterscale-harbor-robot
+  namespace: otterscale-system
+type: Opaque
+stringData:
+  HARBOR_URL: {{ yamlQuote .HarborURL }}
+  HARBOR_ROBOT_NAME: {{ yamlQuote .HarborRobotName }}
+  HARBOR_ROBOT_SECRET: {{ yamlQuote .HarborRobotSecret }}
+{{- end }}
+`
+
+const agentDeploymentYAML = `---
 apiVersion: apps/v1
 kind: Deployment
 metadata:
   name: otterscale-agent
   namespace: otterscale-system
 spec:
-  replicas: 1
+  replicas: {{ .Replicas }}
+  # Keeps the old agent pod until the new one has been up for a while,
+  # so that a self-update to a crashing image can be reverted.
+  minReadySeconds: 30
   selector:
     matchLabels:
       app: otterscale-agent
@@ -254,11 +385,29 @@ spec:
         app: otterscale-agent
     spec:
       serviceAccountName: otterscale-agent
+{{- with .Values.PriorityClassName }}
+      priorityClassName: {{ yamlQuote . }}
+{{- end }}
+{{- with .Values.NodeSelector }}
+      nodeSelector: {{ toJSON . }}
+{{- end }}
+{{- with .Values.Tolerations }}
+      tolerations: {{ toJSON . }}
+{{- end }}
+{{- if .ImagePullSecrets }}
+      imagePullSecrets:
+{{- range .ImagePullSecrets }}
+        - name: {{ yamlQuote . }}
+{{- end }}
+{{- end }}
       containers:
         - name: otterscale
           image: {{ yamlQuote .Image }}
           args:
             - agent
+{{- if or .Values.Resources.Requests .Values.Resources.Limits }}
+          resources: {{ toJSON .Values.Resources }}
+{{- end }}
           env:
             - name: OTTERSCALE_AGENT_SERVER_URL
               value: {{ yamlQuote .ServerURL }}
@@ -266,21 +415,115 @@ spec:
               value: {{ yamlQuote .TunnelURL }}
             - name: OTTERSCALE_AGENT_CLUSTER
               value: {{ yamlQuote .Cluster }}
+{{- if not .Bootstrap }}
+            - name: OTTERSCALE_AGENT_BOOTSTRAP
+              value: "false"
+{{- end }}
 {{- if .HarborURL }}
             - name: OTTERSCALE_AGENT_HARBOR_URL
               value: {{ yamlQuote .HarborURL }}
 {{- end }}
-{{- if .HarborRobotName }}
+{{- with .Values.Proxy.HTTP }}
+            - name: HTTP_PROXY
+              value: {{ yamlQuote . }}
+{{- end }}
+{{- with .Values.Proxy.HTTPS }}
+            - name: HTTPS_PROXY
+              value: {{ yamlQuote . }}
+{{- end }}
+{{- with .Values.Proxy.NoProxy }}
+            - name: NO_PROXY
+              value: {{ yamlQuote . }}
+{{- end }}
+`
+
+// uninstallManifestTmpl is the parsed Go template for generating agent
+// uninstall manifests.
+var uninstallManifestTmpl = template.Must(
+	template.New("uninstall-manifest").
+		Funcs(template.FuncMap{"yamlQuote": yamlQuote}).
+		Parse(uninstallManifestYAML),
+)
+
+const uninstallManifestYAML = `# Removes the otterscale agent of cluster {{ yamlQuote .Cluster }}:
+#   kubectl delete --ignore-not-found -f <this file>
+# Namespace admin bindings of the namespace-scoped profile are removed
+# with:
+#   kubectl delete rolebindings -A -l app.kubernetes.io/managed-by=otterscale
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: otterscale-agent
+  namespace: otterscale-system
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: otterscale-agent-enrollment
+  namespace: otterscale-system
 ---
 apiVersion: v1
 kind: Secret
 metadata:
   name: otterscale-harbor-robot
   namespace: otterscale-system
-type: Opaque
-stringData:
-  HARBOR_URL: {{ yamlQuote .HarborURL }}
-  HARBOR_ROBOT_NAME: {{ yamlQuote .HarborRobotName }}
-  HARBOR_ROBOT_SECRET: {{ yamlQuote .HarborRobotSecret }}
-{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: otterscale-agent
+  namespace: otterscale-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: otterscale-agent
+  namespace: otterscale-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: otterscale-agent
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: otterscale-agent
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: otterscale-cluster-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: otterscale-node-reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: otterscale-node-reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: otterscale-storageclass-reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: otterscale-storageclass-reader
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: otterscale-agent
+  namespace: otterscale-system
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: otterscale-system
 `
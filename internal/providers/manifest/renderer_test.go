@@ -0,0 +1,284 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// renderObjects renders the agent manifest of params and returns its
+// objects keyed by "Kind/namespace/name".
+func renderObjects(t *testing.T, params *core.ManifestParams) map[string]*unstructured.Unstructured {
+	t.Helper()
+	out, err := NewRenderer().RenderAgentManifest(params)
+	if err != nil {
+		t.Fatalf("RenderAgentManifest: %v", err)
+	}
+
+	objects := make(map[string]*unstructured.Unstructured)
+	decoder := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(out), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(obj); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("decode manifest: %v\n%s", err, out)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objects[obj.GetKind()+"/"+obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	return objects
+}
+
+// ruleResources returns the resources of every rule of the agent
+// ClusterRole.
+func ruleResources(t *testing.T, objects map[string]*unstructured.Unstructured) []string {
+	t.Helper()
+	rules, _, _ := unstructured.NestedSlice(objects["ClusterRole//otterscale-agent"].Object, "rules")
+	var ret []string
+	for _, rule := range rules {
+		resources, _, _ := unstructured.NestedStringSlice(rule.(map[string]any), "resources")
+		ret = append(ret, resources...)
+	}
+	return ret
+}
+
+func TestRenderAgentManifest_Full(t *testing.T) {
+	objects := renderObjects(t, &core.ManifestParams{
+		Cluster:    "prod",
+		UserName:   "alice",
+		ExtraUsers: []string{"bob"},
+		Profile:    core.ManifestProfileFull,
+		Bootstrap:  true,
+		Image:      "ghcr.io/otterscale/otterscale:v1.0.0",
+	})
+
+	binding, ok := objects["ClusterRoleBinding//otterscale-cluster-admin"]
+	if !ok {
+		t.Fatal("expected the cluster-admin binding")
+	}
+	subjects, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+	if len(subjects) != 2 {
+		t.Errorf("cluster-admin subjects = %v, want alice and bob", subjects)
+	}
+	if !slices.Contains(ruleResources(t, objects), "customresourcedefinitions") {
+		t.Error("expected the bootstrap permissions")
+	}
+}
+
+func TestRenderAgentManifest_ProxyOnly(t *testing.T) {
+	objects := renderObjects(t, &core.ManifestParams{
+		Cluster:           "prod",
+		UserName:          "alice",
+		Profile:           core.ManifestProfileProxyOnly,
+		ImpersonateGroups: []string{"devs", "ops"},
+		Image:             "ghcr.io/otterscale/otterscale:v1.0.0",
+	})
+
+	for _, key := range []string{
+		"ClusterRoleBinding//otterscale-cluster-admin",
+		"ClusterRoleBinding//otterscale-node-reader",
+		"ClusterRoleBinding//otterscale-storageclass-reader",
+	} {
+		if _, ok := objects[key]; ok {
+			t.Errorf("unexpected %s", key)
+		}
+	}
+	if slices.Contains(ruleResources(t, objects), "customresourcedefinitions") {
+		t.Error("unexpected bootstrap permissions")
+	}
+
+	rules, _, _ := unstructured.NestedSlice(objects["ClusterRole//otterscale-agent"].Object, "rules")
+	var groups []string
+	for _, rule := range rules {
+		if resources, _, _ := unstructured.NestedStringSlice(rule.(map[string]any), "resources"); slices.Equal(resources, []string{"groups"}) {
+			groups, _, _ = unstructured.NestedStringSlice(rule.(map[string]any), "resourceNames")
+		}
+	}
+	if !slices.Equal(groups, []string{"devs", "ops"}) {
+		t.Errorf("impersonable groups = %v, want [devs ops]", groups)
+	}
+
+	containers, _, _ := unstructured.NestedSlice(objects["Deployment/otterscale-system/otterscale-agent"].Object, "spec", "template", "spec", "containers")
+	env, _, _ := unstructured.NestedSlice(containers[0].(map[string]any), "env")
+	if !slices.ContainsFunc(env, func(e any) bool {
+		m := e.(map[string]any)
+		return m["name"] == "OTTERSCALE_AGENT_BOOTSTRAP" && m["value"] == "false"
+	}) {
+		t.Error("expected bootstrap to be disabled")
+	}
+}
+
+func TestRenderAgentManifest_NamespaceScoped(t *testing.T) {
+	objects := renderObjects(t, &core.ManifestParams{
+		Cluster:    "prod",
+		UserName:   "alice",
+		Profile:    core.ManifestProfileNamespaceScoped,
+		Namespaces: []string{"team-a", "team-b"},
+		Image:      "ghcr.io/otterscale/otterscale:v1.0.0",
+	})
+
+	if _, ok := objects["ClusterRoleBinding//otterscale-cluster-admin"]; ok {
+		t.Error("unexpected cluster-admin binding")
+	}
+	for _, ns := range []string{"team-a", "team-b"} {
+		binding, ok := objects["RoleBinding/"+ns+"/otterscale-namespace-admin"]
+		if !ok {
+			t.Fatalf("expected an admin binding in %s", ns)
+		}
+		if role, _, _ := unstructured.NestedString(binding.Object, "roleRef", "name"); role != "admin" {
+			t.Errorf("role in %s = %q, want admin", ns, role)
+		}
+	}
+}
+
+func TestRenderAgentManifest_Values(t *testing.T) {
+	seconds := int64(60)
+	objects := renderObjects(t, &core.ManifestParams{
+		Cluster:   "prod",
+		UserName:  "alice",
+		Profile:   core.ManifestProfileFull,
+		Bootstrap: true,
+		Image:     "ghcr.io/otterscale/otterscale:v1.0.0",
+		Values: core.AgentDeploymentValues{
+			Replicas:          2,
+			Resources:         core.AgentResources{Requests: map[string]string{"cpu": "100m"}},
+			NodeSelector:      map[string]string{"node-role.kubernetes.io/infra": ""},
+			Tolerations:       []core.AgentToleration{{Key: "infra", Operator: "Exists", Effect: "NoExecute", TolerationSeconds: &seconds}},
+			PriorityClassName: "system-cluster-critical",
+			Proxy:             core.AgentProxy{HTTPS: "http://proxy.example.com:3128"},
+		},
+	})
+
+	deploy := objects["Deployment/otterscale-system/otterscale-agent"].Object
+	if replicas, _, _ := unstructured.NestedInt64(deploy, "spec", "replicas"); replicas != 2 {
+		t.Errorf("replicas = %d, want 2", replicas)
+	}
+	if class, _, _ := unstructured.NestedString(deploy, "spec", "template", "spec", "priorityClassName"); class != "system-cluster-critical" {
+		t.Errorf("priorityClassName = %q", class)
+	}
+	if selector, _, _ := unstructured.NestedStringMap(deploy, "spec", "template", "spec", "nodeSelector"); len(selector) != 1 {
+		t.Errorf("nodeSelector = %v", selector)
+	}
+	if tolerations, _, _ := unstructured.NestedSlice(deploy, "spec", "template", "spec", "tolerations"); len(tolerations) != 1 {
+		t.Errorf("tolerations = %v", tolerations)
+	}
+	containers, _, _ := unstructured.NestedSlice(deploy, "spec", "template", "spec", "containers")
+	container := containers[0].(map[string]any)
+	if cpu, _, _ := unstructured.NestedString(container, "resources", "requests", "cpu"); cpu != "100m" {
+		t.Errorf("cpu request = %q, want 100m", cpu)
+	}
+	env, _, _ := unstructured.NestedSlice(container, "env")
+	if !slices.ContainsFunc(env, func(e any) bool {
+		m := e.(map[string]any)
+		return m["name"] == "HTTPS_PROXY" && m["value"] == "http://proxy.example.com:3128"
+	}) {
+		t.Error("expected the HTTPS_PROXY environment variable")
+	}
+}
+
+// readArchive returns the files of a gzipped tarball by name.
+func readArchive(t *testing.T, archive []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = string(data)
+	}
+	return files
+}
+
+func TestRenderAgentArchive_Helm(t *testing.T) {
+	archive, err := NewRenderer().RenderAgentArchive(&core.ManifestParams{
+		Cluster:   "prod",
+		UserName:  "{{ .Release.Name }}",
+		Profile:   core.ManifestProfileFull,
+		Bootstrap: true,
+		Version:   "v1.2.3",
+		Image:     "ghcr.io/otterscale/otterscale:v1.2.3",
+		Values:    core.AgentDeploymentValues{Replicas: 2},
+	}, core.ManifestFormatHelm)
+	if err != nil {
+		t.Fatalf("RenderAgentArchive: %v", err)
+	}
+	files := readArchive(t, archive)
+
+	for _, name := range []string{"Chart.yaml", "values.yaml", "files/resources.yaml", "templates/resources.yaml", "templates/deployment.yaml"} {
+		if _, ok := files["otterscale-agent/"+name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if chart := files["otterscale-agent/Chart.yaml"]; !strings.Contains(chart, `version: "1.2.3"`) {
+		t.Errorf("Chart.yaml = %s, want version 1.2.3", chart)
+	}
+
+	var values struct {
+		Replicas     int               `json:"replicas"`
+		NodeSelector map[string]string `json:"nodeSelector"`
+	}
+	if err := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(files["otterscale-agent/values.yaml"]), 4096).Decode(&values); err != nil {
+		t.Fatalf("decode values: %v", err)
+	}
+	if values.Replicas != 2 {
+		t.Errorf("replicas value = %d, want 2", values.Replicas)
+	}
+	if values.NodeSelector == nil {
+		t.Error("expected an empty nodeSelector value")
+	}
+
+	// User-provided strings stay out of Helm templates.
+	for name, data := range files {
+		if strings.HasPrefix(name, "otterscale-agent/templates/") && strings.Contains(data, ".Release.Name") {
+			t.Errorf("%s contains a user-provided template action", name)
+		}
+	}
+}
+
+func TestRenderAgentArchive_Kustomize(t *testing.T) {
+	archive, err := NewRenderer().RenderAgentArchive(&core.ManifestParams{
+		Cluster:  "prod",
+		UserName: "alice",
+		Profile:  core.ManifestProfileFull,
+		Image:    "ghcr.io/otterscale/otterscale:v1.2.3",
+	}, core.ManifestFormatKustomize)
+	if err != nil {
+		t.Fatalf("RenderAgentArchive: %v", err)
+	}
+	files := readArchive(t, archive)
+
+	if !strings.Contains(files["otterscale-agent/kustomization.yaml"], "- deployment.yaml") {
+		t.Errorf("kustomization.yaml = %s", files["otterscale-agent/kustomization.yaml"])
+	}
+	if !strings.Contains(files["otterscale-agent/deployment.yaml"], "kind: Deployment") {
+		t.Error("expected the Deployment in deployment.yaml")
+	}
+	if strings.Contains(files["otterscale-agent/resources.yaml"], "kind: Deployment") {
+		t.Error("unexpected Deployment in resources.yaml")
+	}
+}
@@ -4,12 +4,19 @@
 package otterscale
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"connectrpc.com/connect"
+
 	linkv1 "github.com/otterscale/api/link/v1"
 
 	"github.com/otterscale/otterscale/internal/core"
@@ -50,11 +57,17 @@ var _ core.TunnelConsumer = (*linkRegistrar)(nil)
 // Register generates a fresh ECDSA key pair and CSR, then calls the
 // link service's Register RPC. The server signs the CSR with its
 // internal CA and returns the signed certificate, CA certificate,
-// tunnel endpoint, and the server's own version. A new key pair is
-// generated on every call to provide forward secrecy. The private
-// key is returned inside the Registration to guarantee the cert/key
-// pair is always consistent (no TOCTOU race).
-func (f *linkRegistrar) Register(ctx context.Context, serverURL, cluster string) (core.Registration, error) {
+// tunnel endpoint, and the agent version and image to run. A new key
+// pair is generated on every call to provide forward secrecy. The
+// private key is returned inside the Registration to guarantee the
+// cert/key pair is always consistent (no TOCTOU race).
+//
+// The request is authorized through headers: with creds' previous
+// certificate and a proof made with its key over the new CSR if the
+// agent has registered before, with the enrollment token otherwise.
+// The agent UID is always sent; the hub pins the cluster to it. facts
+// are sent JSON-encoded unless they are empty.
+func (f *linkRegistrar) Register(ctx context.Context, serverURL, cluster string, creds core.AgentCredentials, facts core.ClusterFacts) (core.Registration, error) {
 	key, keyPEM, err := pki.GenerateKey()
 	if err != nil {
 		return core.Registration{}, fmt.Errorf("generate key pair: %w", err)
@@ -72,17 +85,135 @@ func (f *linkRegistrar) Register(ctx context.Context, serverURL, cluster string)
 	req.SetCsr(csrPEM)
 	req.SetAgentVersion(f.agentVersion)
 
+	ctx, call := connect.NewClientContext(ctx)
+	call.RequestHeader().Set(core.AgentUIDHeader, creds.UID)
+	if facts != (core.ClusterFacts{}) {
+		data, err := json.Marshal(facts)
+		if err != nil {
+			return core.Registration{}, fmt.Errorf("encode cluster facts: %w", err)
+		}
+		call.RequestHeader().Set(core.ClusterFactsHeader, string(data))
+	}
+	switch {
+	case creds.HasIdentity():
+		proof, err := pki.SignRenewalProof(creds.PrivateKeyPEM, cluster, csrPEM)
+		if err != nil {
+			return core.Registration{}, err
+		}
+		call.RequestHeader().Set(core.AgentCertificateHeader, base64.StdEncoding.EncodeToString(creds.Certificate))
+		call.RequestHeader().Set(core.AgentProofHeader, base64.StdEncoding.EncodeToString(proof))
+	case creds.Token != "":
+		call.RequestHeader().Set(core.EnrollmentTokenHeader, creds.Token)
+	}
+
 	resp, err := client.Register(ctx, req)
 	if err != nil {
 		return core.Registration{}, err
 	}
 
+	var pullSecrets []string
+	if v := call.ResponseHeader().Get(core.ImagePullSecretsHeader); v != "" {
+		pullSecrets = strings.Split(v, ",")
+	}
+	return core.Registration{
+		Endpoint:         resp.GetEndpoint(),
+		Certificate:      resp.GetCertificate(),
+		CACertificate:    resp.GetCaCertificate(),
+		PrivateKeyPEM:    keyPEM,
+		AgentID:          f.agentID,
+		ServerVersion:    resp.GetServerVersion(),
+		AgentImage:       call.ResponseHeader().Get(core.AgentImageHeader),
+		ImagePullSecrets: pullSecrets,
+	}, nil
+}
+
+// renewRequest and renewResponse mirror the JSON bodies served by the
+// link server at core.RenewPath.
+type renewRequest struct {
+	Cluster     string `json:"cluster"`
+	AgentID     string `json:"agentId"`
+	Certificate []byte `json:"certificate"`
+	CSR         []byte `json:"csr"`
+	Proof       []byte `json:"proof"`
+}
+
+type renewResponse struct {
+	Endpoint         string   `json:"endpoint"`
+	Certificate      []byte   `json:"certificate"`
+	CACertificate    []byte   `json:"caCertificate"`
+	ServerVersion    string   `json:"serverVersion"`
+	AgentImage       string   `json:"agentImage"`
+	ImagePullSecrets []string `json:"imagePullSecrets"`
+}
+
+// maxRenewResponseBytes bounds the size of a renewal response.
+const maxRenewResponseBytes = 64 << 10
+
+// Renew generates a fresh key pair and CSR and asks the link server
+// to sign it in place of current.Certificate. The request carries a
+// proof signed with current.PrivateKeyPEM, which authenticates the
+// agent without a full registration.
+func (f *linkRegistrar) Renew(ctx context.Context, serverURL, cluster string, current core.Registration) (core.Registration, error) {
+	key, keyPEM, err := pki.GenerateKey()
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("generate key pair: %w", err)
+	}
+
+	csrPEM, err := pki.GenerateCSR(key, f.agentID)
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("generate CSR: %w", err)
+	}
+
+	proof, err := pki.SignRenewalProof(current.PrivateKeyPEM, cluster, csrPEM)
+	if err != nil {
+		return core.Registration{}, err
+	}
+
+	body, err := json.Marshal(renewRequest{
+		Cluster:     cluster,
+		AgentID:     f.agentID,
+		Certificate: current.Certificate,
+		CSR:         csrPEM,
+		Proof:       proof,
+	})
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("encode renew request: %w", err)
+	}
+
+	url := strings.TrimRight(serverURL, "/") + core.RenewPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("create renew request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req) // #nosec G704 -- serverURL is operator configuration
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("renew: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRenewResponseBytes))
+	if err != nil {
+		return core.Registration{}, fmt.Errorf("read renew response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return core.Registration{}, fmt.Errorf("renew: server returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var out renewResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return core.Registration{}, fmt.Errorf("decode renew response: %w", err)
+	}
+
 	return core.Registration{
-		Endpoint:      resp.GetEndpoint(),
-		Certificate:   resp.GetCertificate(),
-		CACertificate: resp.GetCaCertificate(),
-		PrivateKeyPEM: keyPEM,
-		AgentID:       f.agentID,
-		ServerVersion: resp.GetServerVersion(),
+		Endpoint:         out.Endpoint,
+		Certificate:      out.Certificate,
+		CACertificate:    out.CACertificate,
+		PrivateKeyPEM:    keyPEM,
+		AgentID:          f.agentID,
+		ServerVersion:    out.ServerVersion,
+		AgentImage:       out.AgentImage,
+		ImagePullSecrets: out.ImagePullSecrets,
 	}, nil
 }
@@ -0,0 +1,204 @@
+// Package peer implements the channel through which the replicas of a
+// horizontally scaled hub forward requests to the cluster tunnels that
+// terminate on one another.
+//
+// Every replica serves the channel over TLS with a certificate of the
+// tunnel CA, which the replicas load from the shared CA store, and
+// authenticates callers with short-lived tokens, bound to the request
+// they authorize, signed with a key derived from the HMAC secret
+// persisted next to the CA. Agents hold certificates of that CA as
+// well, but never see the secret, which survives CA rotations.
+//
+// A link is owned by the replica its agent registered with, so the
+// registration and the tunnel of an agent have to reach the same
+// replica, for example through ClientIP session affinity on the
+// Service in front of the replicas.
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/config"
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+const (
+	// hmacKeyLabel is the label the token key is derived with from
+	// the persisted HMAC secret.
+	hmacKeyLabel = "peer-forwarding"
+
+	// tokenTTL is how long a token is accepted after it was issued.
+	// Every request carries a fresh token; a streaming request is only
+	// authenticated when it starts.
+	tokenTTL = time.Minute
+
+	// impersonateHeaderPrefix prefixes the Kubernetes impersonation
+	// headers, which tokens are bound to.
+	impersonateHeaderPrefix = "Impersonate-"
+)
+
+// Forwarder serves the peer channel of this hub replica, relaying the
+// requests of other replicas to the cluster tunnels it terminates, and
+// builds the client configs with which this replica reaches the
+// others.
+type Forwarder struct {
+	tunnel  core.TunnelProvider
+	ca      *pki.CA
+	key     []byte
+	replica core.HubReplica
+	log     *slog.Logger
+	now     func() time.Time
+
+	srv      *http.Server // set by BuildPeerListener
+	sessions http.Handler // set by BuildPeerListener
+
+	clientMu     sync.Mutex
+	client       *http.Client // for forwarded session operations
+	clientBundle []byte       // trust bundle client was built with
+}
+
+// NewForwarder returns a Forwarder for replica that relays requests to
+// the tunnels of tunnel.
+func NewForwarder(tunnel core.TunnelProvider, ca *pki.CA, replica core.HubReplica) (*Forwarder, error) {
+	key, err := ca.DeriveHMACKey(hmacKeyLabel)
+	if err != nil {
+		return nil, fmt.Errorf("peer: %w", err)
+	}
+	return &Forwarder{
+		tunnel:  tunnel,
+		ca:      ca,
+		key:     key,
+		replica: replica,
+		log:     slog.Default().With("component", "peer-forwarder"),
+		now:     time.Now,
+	}, nil
+}
+
+// ProvideForwarder is a Wire provider that returns the Forwarder of
+// the configured hub replica. The peer address and URL have to be set
+// together.
+func ProvideForwarder(conf *config.Config, ca *pki.CA, tunnel core.TunnelProvider) (*Forwarder, error) {
+	replica := core.HubReplica{ID: conf.ServerReplicaID(), PeerURL: conf.ServerPeerURL()}
+	if (conf.ServerPeerAddress() == "") != (replica.PeerURL == "") {
+		return nil, errors.New("peer: the peer address and URL must be set together")
+	}
+	if replica.PeerURL != "" {
+		u, err := url.Parse(replica.PeerURL)
+		if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+			return nil, fmt.Errorf("peer: peer URL must be an https URL, got %q", replica.PeerURL)
+		}
+		if replica.ID == "" {
+			return nil, errors.New("peer: the hub replica ID must not be empty")
+		}
+	}
+	return NewForwarder(tunnel, ca, replica)
+}
+
+// PeerConfig returns a rest.Config that reaches cluster through the
+// peer channel of replica. The config carries the CA trust bundle and
+// adds a fresh token to every request, including the upgrade requests
+// of streaming executors.
+func (f *Forwarder) PeerConfig(cluster string, replica core.HubReplica) (*rest.Config, error) {
+	if replica.PeerURL == "" {
+		return nil, &core.DomainError{
+			Code:    core.ErrorCodeUnavailable,
+			Message: fmt.Sprintf("hub replica %s serving cluster %s has no peer URL", replica.ID, cluster),
+		}
+	}
+	return &rest.Config{
+		Host:            strings.TrimRight(replica.PeerURL, "/") + core.PeerClusterPath + cluster,
+		TLSClientConfig: rest.TLSClientConfig{CAData: f.ca.TrustBundlePEM()},
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &tokenRoundTripper{forwarder: f, cluster: cluster, next: rt}
+		},
+	}, nil
+}
+
+// tokenRoundTripper adds a peer token for cluster to every request.
+type tokenRoundTripper struct {
+	forwarder *Forwarder
+	cluster   string
+	next      http.RoundTripper
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(core.PeerTokenHeader, t.forwarder.token(t.cluster, req))
+	return t.next.RoundTrip(req)
+}
+
+// token returns a token that authorizes req for subject, a cluster
+// name or a session, until tokenTTL from now. The token is bound to
+// the request scope of req, so that it cannot be replayed for another
+// method, path or identity.
+func (f *Forwarder) token(subject string, req *http.Request) string {
+	expiry := strconv.FormatInt(f.now().Add(tokenTTL).Unix(), 10)
+	return expiry + "." + base64.RawURLEncoding.EncodeToString(f.mac(subject, expiry+"\n"+requestScope(req)))
+}
+
+// verify checks that token was issued for subject and the request
+// scope of req with the shared key and has not expired. Tokens
+// expiring further ahead than tokenTTL, allowing for clock skew
+// between replicas, are refused.
+func (f *Forwarder) verify(token, subject string, req *http.Request) error {
+	expiry, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("malformed peer token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, f.mac(subject, expiry+"\n"+requestScope(req))) {
+		return errors.New("invalid peer token")
+	}
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errors.New("malformed peer token")
+	}
+	now := f.now()
+	if expiresAt := time.Unix(exp, 0); now.After(expiresAt) || expiresAt.Sub(now) > 2*tokenTTL {
+		return errors.New("expired peer token")
+	}
+	return nil
+}
+
+// requestScope returns what a token for req is bound to: the method,
+// the request URI and the impersonation headers, sorted by name.
+func requestScope(req *http.Request) string {
+	var names []string
+	for name := range req.Header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), impersonateHeaderPrefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString(req.Method + "\n" + req.URL.RequestURI())
+	for _, name := range names {
+		for _, v := range req.Header[name] {
+			b.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strconv.Quote(v))
+		}
+	}
+	return b.String()
+}
+
+// mac returns the HMAC of subject and value.
+func (f *Forwarder) mac(subject, value string) []byte {
+	h := hmac.New(sha256.New, f.key)
+	h.Write([]byte(subject + "\n" + value))
+	return h.Sum(nil)
+}
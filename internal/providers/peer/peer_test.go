@@ -0,0 +1,192 @@
+package peer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// stubTunnel implements core.TunnelProvider, resolving every cluster
+// to address or err.
+type stubTunnel struct {
+	core.TunnelProvider
+	address string
+	err     error
+}
+
+func (s *stubTunnel) ResolveAddress(context.Context, string) (string, error) {
+	return s.address, s.err
+}
+
+func newTestForwarder(t *testing.T, tunnel core.TunnelProvider) *Forwarder {
+	t.Helper()
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	f, err := NewForwarder(tunnel, ca, core.HubReplica{ID: "hub-a", PeerURL: "https://hub-a.example:8301"})
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	return f
+}
+
+func TestToken(t *testing.T) {
+	f := newTestForwarder(t, &stubTunnel{})
+	req := httptest.NewRequest(http.MethodGet, core.PeerClusterPath+"prod/api/v1/pods", http.NoBody)
+	token := f.token("prod", req)
+
+	if err := f.verify(token, "prod", req); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := f.verify(token, "staging", req); err == nil {
+		t.Error("expected a token of another cluster to be refused")
+	}
+	if err := f.verify("garbage", "prod", req); err == nil {
+		t.Error("expected a malformed token to be refused")
+	}
+
+	other := newTestForwarder(t, &stubTunnel{})
+	if err := other.verify(token, "prod", req); err == nil {
+		t.Error("expected a token signed with another CA to be refused")
+	}
+
+	f.now = func() time.Time { return time.Now().Add(2 * tokenTTL) }
+	if err := f.verify(token, "prod", req); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+}
+
+// TestToken_BoundToRequest verifies that a token cannot be replayed for
+// another method, path, query or identity.
+func TestToken_BoundToRequest(t *testing.T) {
+	f := newTestForwarder(t, &stubTunnel{})
+	newRequest := func(method, target string, impersonate ...string) *http.Request {
+		req := httptest.NewRequest(method, target, http.NoBody)
+		for _, group := range impersonate {
+			req.Header.Add("Impersonate-Group", group)
+		}
+		req.Header.Set("Impersonate-User", "alice")
+		return req
+	}
+	path := core.PeerClusterPath + "prod/api/v1/namespaces/default/pods"
+	token := f.token("prod", newRequest(http.MethodGet, path, "dev"))
+
+	if err := f.verify(token, "prod", newRequest(http.MethodGet, path, "dev")); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	for name, req := range map[string]*http.Request{
+		"method":      newRequest(http.MethodDelete, path, "dev"),
+		"path":        newRequest(http.MethodGet, path+"/web", "dev"),
+		"query":       newRequest(http.MethodGet, path+"?watch=true", "dev"),
+		"impersonate": newRequest(http.MethodGet, path, "dev", "system:masters"),
+	} {
+		if err := f.verify(token, "prod", req); err == nil {
+			t.Errorf("expected a token replayed with another %s to be refused", name)
+		}
+	}
+}
+
+func TestServeHTTP_RefusesMissingToken(t *testing.T) {
+	f := newTestForwarder(t, &stubTunnel{address: "http://127.0.0.1:1"})
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/clusters/prod/api", http.NoBody)
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestServeHTTP_ResolveErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not found", &core.ErrClusterNotFound{Cluster: "prod"}, http.StatusNotFound},
+		{"disconnected", &core.ErrClusterDisconnected{Cluster: "prod"}, http.StatusServiceUnavailable},
+		{"on another peer", &core.ErrClusterOnPeer{Cluster: "prod", Replica: core.HubReplica{ID: "hub-b"}}, http.StatusMisdirectedRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestForwarder(t, &stubTunnel{err: tt.err})
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/clusters/prod/api", http.NoBody)
+			req.Header.Set(core.PeerTokenHeader, f.token("prod", req))
+			rec := httptest.NewRecorder()
+			f.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerConfig_ForwardsToTunnel(t *testing.T) {
+	var gotPath, gotToken, gotUser string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotToken = r.Header.Get(core.PeerTokenHeader)
+		gotUser = r.Header.Get("Impersonate-User")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	f := newTestForwarder(t, &stubTunnel{address: backend.URL})
+	peerSrv := httptest.NewServer(f)
+	defer peerSrv.Close()
+
+	cfg, err := f.PeerConfig("prod", core.HubReplica{ID: "hub-a", PeerURL: peerSrv.URL})
+	if err != nil {
+		t.Fatalf("PeerConfig: %v", err)
+	}
+	cfg.Impersonate = rest.ImpersonationConfig{UserName: "alice"}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		t.Fatalf("TransportFor: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, cfg.Host+"/api/v1/namespaces", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "ok" {
+		t.Fatalf("response = %d %q, want 200 \"ok\"", resp.StatusCode, body)
+	}
+	if gotPath != "/api/v1/namespaces" {
+		t.Errorf("backend path = %q, want /api/v1/namespaces", gotPath)
+	}
+	if gotToken != "" {
+		t.Error("expected the peer token to be stripped before the tunnel")
+	}
+	if gotUser != "alice" {
+		t.Errorf("Impersonate-User = %q, want alice", gotUser)
+	}
+}
+
+func TestPeerConfig_NoPeerURL(t *testing.T) {
+	f := newTestForwarder(t, &stubTunnel{})
+	if _, err := f.PeerConfig("prod", core.HubReplica{ID: "hub-b"}); err == nil {
+		t.Error("expected an error for a replica without a peer URL")
+	}
+}
@@ -0,0 +1,225 @@
+package peer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/transport"
+)
+
+// readHeaderTimeout bounds the request headers of the peer channel.
+// Request bodies and responses are not bounded, since watches, logs
+// and exec sessions stream for as long as the caller wants.
+const readHeaderTimeout = 10 * time.Second
+
+// Verify at compile time that *Forwarder satisfies
+// transport.PeerService.
+var _ transport.PeerService = (*Forwarder)(nil)
+
+// BuildPeerListener returns the listener of the peer channel on
+// address, or nil if peer forwarding is disabled. The channel is
+// served over TLS with a certificate of the CA for the host of the
+// replica's peer URL. Session operations forwarded by other replicas
+// are passed to sessions, with the session ID as the "id" path value.
+func (f *Forwarder) BuildPeerListener(address string, sessions http.Handler) (transport.Listener, error) {
+	if f.replica.PeerURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(f.replica.PeerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse peer URL %q: %w", f.replica.PeerURL, err)
+	}
+	certs := &serverCert{ca: f.ca, host: u.Hostname()}
+	if _, err := certs.get(); err != nil {
+		return nil, fmt.Errorf("generate peer server cert: %w", err)
+	}
+
+	f.sessions = sessions
+	f.srv = &http.Server{
+		Addr:              address,
+		Handler:           f,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(f.log.Handler(), slog.LevelWarn),
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return certs.get()
+			},
+		},
+	}
+	return f, nil
+}
+
+// Start serves the peer channel, blocking until Stop is called.
+func (f *Forwarder) Start(ctx context.Context) error {
+	f.srv.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+
+	f.log.Info("starting", "address", f.srv.Addr, "replica", f.replica.ID, "peer_url", f.replica.PeerURL)
+
+	if err := f.srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("peer serve: %w", err)
+	}
+	return nil
+}
+
+// Stop gracefully drains the peer channel. If the graceful shutdown
+// exceeds the context deadline it forces an immediate close.
+func (f *Forwarder) Stop(ctx context.Context) error {
+	f.log.Info("shutting down")
+	if err := f.srv.Shutdown(ctx); err != nil {
+		f.log.Error("graceful shutdown failed, forcing close", "error", err)
+		return f.srv.Close()
+	}
+	return nil
+}
+
+// ServeHTTP serves the peer channel. Requests under
+// core.PeerSessionPath are forwarded session operations, see
+// serveSession. Requests of the form /clusters/{cluster}/{path...}
+// that carry a valid peer token for the cluster and the request are
+// relayed to the tunnel of the cluster, as /{path...}. Upgrade
+// requests, as used by exec and port-forward, are relayed as such,
+// and responses are flushed as they arrive so that watches and logs
+// stream. Requests are never forwarded to another replica again: a
+// cluster whose tunnel this replica does not terminate yields
+// 421 Misdirected Request.
+func (f *Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutPrefix(r.URL.Path, core.PeerSessionPath); ok {
+		f.serveSession(w, r, id)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, core.PeerClusterPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cluster, path, _ := strings.Cut(rest, "/")
+	if core.ValidateClusterName(cluster) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := f.verify(r.Header.Get(core.PeerTokenHeader), cluster, r); err != nil {
+		f.log.Warn("peer request refused", "cluster", cluster, "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	address, err := f.tunnel.ResolveAddress(r.Context(), cluster)
+	if err != nil {
+		http.Error(w, err.Error(), resolveStatus(err))
+		return
+	}
+	target, err := url.Parse(address)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = "/" + path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Header.Del(core.PeerTokenHeader)
+		},
+		FlushInterval: -1,
+		ErrorLog:      slog.NewLogLogger(f.log.Handler(), slog.LevelWarn),
+	}
+	proxy.ServeHTTP(w, r) // #nosec G704 -- the target is the cluster's loopback tunnel
+}
+
+// serveSession passes a forwarded operation on session id to the
+// sessions handler, if it carries a valid peer token for the session.
+func (f *Forwarder) serveSession(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := f.verify(r.Header.Get(core.PeerTokenHeader), sessionOpSubject+id, r); err != nil {
+		f.log.Warn("peer session request refused", "session", id, "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if f.sessions == nil {
+		http.NotFound(w, r)
+		return
+	}
+	r.SetPathValue("id", id)
+	f.sessions.ServeHTTP(w, r)
+}
+
+// resolveStatus returns the HTTP status of a ResolveAddress error.
+func resolveStatus(err error) int {
+	var (
+		notFound     *core.ErrClusterNotFound
+		disconnected *core.ErrClusterDisconnected
+		onPeer       *core.ErrClusterOnPeer
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &disconnected):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &onPeer):
+		return http.StatusMisdirectedRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// serverCert holds the peer server certificate and regenerates it
+// once it no longer chains to the CA's trust bundle, which happens
+// when a CA rotation is finished.
+type serverCert struct {
+	ca   *pki.CA
+	host string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// get returns the cached certificate, regenerating it if necessary.
+func (c *serverCert) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && c.valid() {
+		return c.cert, nil
+	}
+
+	certPEM, keyPEM, err := c.ca.GenerateServerCert(c.host)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load peer server key pair: %w", err)
+	}
+	c.cert = &cert
+	return c.cert, nil
+}
+
+// valid reports whether the cached certificate still verifies against
+// the CA's current trust bundle. c.mu must be held.
+func (c *serverCert) valid() bool {
+	_, err := c.cert.Leaf.Verify(x509.VerifyOptions{
+		Roots:     c.ca.TrustPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err == nil
+}
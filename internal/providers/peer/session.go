@@ -0,0 +1,158 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+const (
+	// sessionIDSubject and sessionOpSubject prefix the HMAC subjects of
+	// session IDs and of the tokens of forwarded session operations.
+	// Cluster names cannot contain "/", so neither collides with the
+	// tokens of the cluster paths.
+	sessionIDSubject = "session-id/"
+	sessionOpSubject = "session/"
+
+	// maxErrorBodyBytes bounds the error responses of forwarded
+	// session operations that are read.
+	maxErrorBodyBytes = 4 << 10
+)
+
+// Verify at compile time that *Forwarder satisfies core.SessionRouter.
+var _ core.SessionRouter = (*Forwarder)(nil)
+
+// ReplicaID returns the ID of this hub replica, or "" if peer
+// forwarding is disabled.
+func (f *Forwarder) ReplicaID() string {
+	if f.replica.PeerURL == "" {
+		return ""
+	}
+	return f.replica.ID
+}
+
+// NewSessionID returns a new session ID owned by this replica. With
+// peer forwarding enabled the ID has the form
+// <uuid>.<owner>.<signature>, where owner encodes the ID and peer URL
+// of this replica and the signature lets every replica of the hub
+// trust them; otherwise it is a plain UUID.
+func (f *Forwarder) NewSessionID() string {
+	id := uuid.NewString()
+	if f.replica.PeerURL == "" {
+		return id
+	}
+	owner := base64.RawURLEncoding.EncodeToString([]byte(f.replica.ID + "\n" + f.replica.PeerURL))
+	return id + "." + owner + "." + base64.RawURLEncoding.EncodeToString(f.mac(sessionIDSubject+id, owner))
+}
+
+// SessionOwner returns the replica encoded in sessionID and true if
+// that is another replica. Plain UUIDs and IDs whose signature does
+// not verify are reported as local.
+func (f *Forwarder) SessionOwner(sessionID string) (core.HubReplica, bool) {
+	parts := strings.Split(sessionID, ".")
+	if len(parts) != 3 {
+		return core.HubReplica{}, false
+	}
+	id, owner, sig := parts[0], parts[1], parts[2]
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, f.mac(sessionIDSubject+id, owner)) {
+		return core.HubReplica{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(owner)
+	if err != nil {
+		return core.HubReplica{}, false
+	}
+	replicaID, peerURL, ok := strings.Cut(string(raw), "\n")
+	if !ok || replicaID == f.replica.ID {
+		return core.HubReplica{}, false
+	}
+	return core.HubReplica{ID: replicaID, PeerURL: peerURL}, true
+}
+
+// ForwardSessionOp applies op on replica through its peer channel.
+// A session the replica no longer has yields ErrSessionNotFound.
+func (f *Forwarder) ForwardSessionOp(ctx context.Context, replica core.HubReplica, op core.SessionOp) error {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("encode session operation: %w", err)
+	}
+	target := strings.TrimRight(replica.PeerURL, "/") + core.PeerSessionPath + op.SessionID
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build session operation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(core.PeerTokenHeader, f.token(sessionOpSubject+op.SessionID, req))
+
+	resp, err := f.sessionClient().Do(req) // #nosec G704 -- the target is signed by a replica of this hub
+	if err != nil {
+		return &core.DomainError{
+			Code:    core.ErrorCodeUnavailable,
+			Message: fmt.Sprintf("forward session operation to hub replica %s", replica.ID),
+			Cause:   err,
+		}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < http.StatusMultipleChoices:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return &core.ErrSessionNotFound{Resource: sessionResource(op.Kind), ID: op.SessionID}
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return &core.DomainError{
+			Code:    core.ErrorCodeUnavailable,
+			Message: fmt.Sprintf("hub replica %s: %s: %s", replica.ID, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+}
+
+// sessionResource returns the session resource name of kind, as
+// reported by core.ErrSessionNotFound.
+func sessionResource(kind core.SessionOpKind) string {
+	switch kind {
+	case core.SessionOpWritePortForward:
+		return "portforward-session"
+	case core.SessionOpWriteVNC:
+		return "vnc-session"
+	default:
+		return "exec-session"
+	}
+}
+
+// sessionClient returns the HTTP client for forwarded session
+// operations, rebuilding it when the CA trust bundle changes so that
+// connections survive a CA rotation.
+func (f *Forwarder) sessionClient() *http.Client {
+	f.clientMu.Lock()
+	defer f.clientMu.Unlock()
+
+	bundle := f.ca.TrustBundlePEM()
+	if f.client != nil && bytes.Equal(bundle, f.clientBundle) {
+		return f.client
+	}
+	if f.client != nil {
+		f.client.CloseIdleConnections()
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    f.ca.TrustPool(),
+	}
+	f.client = &http.Client{Transport: transport}
+	f.clientBundle = bundle
+	return f.client
+}
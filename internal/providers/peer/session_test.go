@@ -0,0 +1,122 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// newReplicaPair returns the forwarders of two replicas of one hub.
+func newReplicaPair(t *testing.T) (a, b *Forwarder) {
+	t.Helper()
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	a, err = NewForwarder(&stubTunnel{}, ca, core.HubReplica{ID: "hub-a", PeerURL: "https://hub-a.example:8301"})
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	b, err = NewForwarder(&stubTunnel{}, ca, core.HubReplica{ID: "hub-b", PeerURL: "https://hub-b.example:8301"})
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	return a, b
+}
+
+func TestSessionOwner(t *testing.T) {
+	a, b := newReplicaPair(t)
+	id := a.NewSessionID()
+
+	if _, remote := a.SessionOwner(id); remote {
+		t.Error("expected the issuing replica to own the session")
+	}
+	owner, remote := b.SessionOwner(id)
+	if !remote || owner != a.replica {
+		t.Errorf("owner = %+v, %v, want %+v, true", owner, remote, a.replica)
+	}
+
+	if _, remote := b.SessionOwner(id + "x"); remote {
+		t.Error("expected a tampered session ID to be reported as local")
+	}
+	if _, remote := b.SessionOwner("3f1c2d9e-0000-4000-8000-000000000000"); remote {
+		t.Error("expected a plain UUID to be reported as local")
+	}
+	other := newTestForwarder(t, &stubTunnel{})
+	if _, remote := other.SessionOwner(id); remote {
+		t.Error("expected a session ID of another hub to be reported as local")
+	}
+}
+
+func TestNewSessionID_WithoutPeerForwarding(t *testing.T) {
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	f, err := NewForwarder(&stubTunnel{}, ca, core.HubReplica{ID: "hub-a"})
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	if id := f.NewSessionID(); len(id) != 36 {
+		t.Errorf("session ID = %q, want a plain UUID", id)
+	}
+	if f.ReplicaID() != "" {
+		t.Errorf("ReplicaID = %q, want empty", f.ReplicaID())
+	}
+}
+
+func TestForwardSessionOp(t *testing.T) {
+	a, b := newReplicaPair(t)
+	id := a.NewSessionID()
+
+	var got core.SessionOp
+	a.sessions = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got.SessionID = r.PathValue("id")
+		if got.SessionID == "gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	owner := core.HubReplica{ID: "hub-a", PeerURL: srv.URL}
+
+	op := core.SessionOp{Kind: core.SessionOpWriteExec, SessionID: id, Data: []byte("ls\n")}
+	if err := b.ForwardSessionOp(t.Context(), owner, op); err != nil {
+		t.Fatalf("ForwardSessionOp: %v", err)
+	}
+	if got.Kind != op.Kind || got.SessionID != id || string(got.Data) != "ls\n" {
+		t.Errorf("applied %+v, want %+v", got, op)
+	}
+
+	var notFound *core.ErrSessionNotFound
+	err := b.ForwardSessionOp(t.Context(), owner, core.SessionOp{Kind: core.SessionOpWriteVNC, SessionID: "gone"})
+	if !errors.As(err, &notFound) || notFound.Resource != "vnc-session" {
+		t.Errorf("expected ErrSessionNotFound for a vnc-session, got %v", err)
+	}
+}
+
+func TestServeSession_RefusesTokenOfOtherSession(t *testing.T) {
+	a, _ := newReplicaPair(t)
+	a.sessions = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("unexpected session operation")
+	})
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, core.PeerSessionPath+"s1", http.NoBody)
+	req.Header.Set(core.PeerTokenHeader, a.token(sessionOpSubject+"s2", req))
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
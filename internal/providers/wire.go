@@ -1,5 +1,5 @@
 // Package providers aggregates all infrastructure-layer implementations
-// (chisel, kubernetes, otterscale, cache) into a single Wire provider set.
+// (chisel, peer, kubernetes, otterscale, cache, castore, casigner, linkstore) into a single Wire provider set.
 package providers
 
 import (
@@ -7,12 +7,17 @@ import (
 
 	"github.com/otterscale/otterscale/internal/core"
 	"github.com/otterscale/otterscale/internal/providers/cache"
+	"github.com/otterscale/otterscale/internal/providers/casigner"
+	"github.com/otterscale/otterscale/internal/providers/castore"
 	"github.com/otterscale/otterscale/internal/providers/chisel"
 	"github.com/otterscale/otterscale/internal/providers/harbor"
 	"github.com/otterscale/otterscale/internal/providers/helm"
+	"github.com/otterscale/otterscale/internal/providers/hubstore"
 	"github.com/otterscale/otterscale/internal/providers/kubernetes"
+	"github.com/otterscale/otterscale/internal/providers/linkstore"
 	"github.com/otterscale/otterscale/internal/providers/manifest"
 	"github.com/otterscale/otterscale/internal/providers/otterscale"
+	"github.com/otterscale/otterscale/internal/providers/peer"
 	"github.com/otterscale/otterscale/internal/transport"
 )
 
@@ -23,11 +28,33 @@ func ProvideDiscoveryCache(discovery core.DiscoveryClient) *cache.DiscoveryCache
 	return cache.NewDiscoveryCache(discovery, cache.DefaultTTL)
 }
 
+// ProvideClusterEvictors collects the adapters that keep per-cluster
+// state, so that a deregistered cluster is dropped from all of them.
+func ProvideClusterEvictors(k *kubernetes.Kubernetes, discovery *cache.DiscoveryCache, sessions *core.SessionStore) []core.ClusterEvictor {
+	return []core.ClusterEvictor{k, discovery, sessions}
+}
+
 // ProviderSet is the Wire provider set for all external adapters.
 var ProviderSet = wire.NewSet(
-	chisel.NewService,
+	castore.ProvideStore,
+	castore.ProvideCAStore,
+	casigner.ProvideSigner,
+	castore.ProvideHMACSecretStore,
+	hubstore.ProvideStore,
+	hubstore.ProvideRevocationStore,
+	hubstore.ProvideEnrollmentStore,
+	hubstore.ProvideClusterPinStore,
+	hubstore.ProvideClusterApprovalStore,
+	hubstore.ProvideUpgradePolicyStore,
+	linkstore.ProvideLinkStore,
 	wire.Bind(new(core.TunnelProvider), new(*chisel.Service)),
+	wire.Bind(new(core.CARotator), new(*chisel.Service)),
+	wire.Bind(new(core.CertificateRevoker), new(*chisel.Service)),
 	wire.Bind(new(transport.TunnelService), new(*chisel.Service)),
+	peer.ProvideForwarder,
+	wire.Bind(new(kubernetes.PeerRouter), new(*peer.Forwarder)),
+	wire.Bind(new(transport.PeerService), new(*peer.Forwarder)),
+	wire.Bind(new(core.SessionRouter), new(*peer.Forwarder)),
 	manifest.NewRenderer,
 	wire.Bind(new(core.ManifestRenderer), new(*manifest.Renderer)),
 	kubernetes.New,
@@ -40,4 +67,5 @@ var ProviderSet = wire.NewSet(
 	ProvideDiscoveryCache,
 	wire.Bind(new(core.SchemaResolver), new(*cache.DiscoveryCache)),
 	wire.Bind(new(core.CacheEvictor), new(*cache.DiscoveryCache)),
+	ProvideClusterEvictors,
 )
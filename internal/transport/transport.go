@@ -5,6 +5,7 @@ package transport
 import (
 	"context"
 	"errors"
+	"net/http"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -37,6 +38,15 @@ type TunnelService interface {
 	BuildHealthListener() Listener
 }
 
+// PeerService provides the channel through which the replicas of a
+// horizontally scaled hub forward requests to one another's tunnels.
+type PeerService interface {
+	// BuildPeerListener returns the listener serving the peer channel
+	// on address, or nil if peer forwarding is disabled. Session
+	// operations forwarded by other replicas are passed to sessions.
+	BuildPeerListener(address string, sessions http.Handler) (Listener, error)
+}
+
 // Serve runs all listeners concurrently and coordinates graceful
 // shutdown. When ctx is canceled or any listener returns an error,
 // all listeners are started first, then a single goroutine waits for
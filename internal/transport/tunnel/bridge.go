@@ -23,24 +23,48 @@ import (
 type Bridge struct {
 	pipeListener *pipe.Listener
 	tcpListener  net.Listener
+	cluster      string
+	metrics      *Metrics
 	log          *slog.Logger
 	wg           sync.WaitGroup
 }
 
+// BridgeOption configures a Bridge.
+type BridgeOption func(*Bridge)
+
+// WithBridgeCluster configures the cluster the relayed traffic is
+// recorded for.
+func WithBridgeCluster(cluster string) BridgeOption {
+	return func(b *Bridge) { b.cluster = cluster }
+}
+
+// WithBridgeMetrics configures the Metrics the relayed traffic is
+// recorded to. Defaults to the Metrics of the global MeterProvider.
+func WithBridgeMetrics(m *Metrics) BridgeOption {
+	return func(b *Bridge) { b.metrics = m }
+}
+
 // NewBridge creates a Bridge that feeds connections into pl.
 // It binds to an ephemeral localhost TCP port immediately so that
 // Port() is available before Start is called.
-func NewBridge(ctx context.Context, pl *pipe.Listener) (*Bridge, error) {
+func NewBridge(ctx context.Context, pl *pipe.Listener, opts ...BridgeOption) (*Bridge, error) {
 	var lc net.ListenConfig
 	ln, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, fmt.Errorf("bridge listen: %w", err)
 	}
-	return &Bridge{
+	b := &Bridge{
 		pipeListener: pl,
 		tcpListener:  ln,
 		log:          slog.Default().With("component", "tunnel-bridge"),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.metrics == nil {
+		b.metrics = defaultMetrics()
+	}
+	return b, nil
 }
 
 // Port returns the TCP port the bridge is listening on. The tunnel
@@ -104,7 +128,8 @@ func (b *Bridge) Stop(_ context.Context) error {
 //
 // When either copy direction finishes (typically because the HTTP
 // handler closed its end of the pipe), both connections are closed so
-// the other direction terminates as well.
+// the other direction terminates as well. The traffic is recorded to
+// the bridge's Metrics.
 func (b *Bridge) relay(tcpConn net.Conn) {
 	defer b.wg.Done()
 
@@ -114,21 +139,23 @@ func (b *Bridge) relay(tcpConn net.Conn) {
 		tcpConn.Close()
 		return
 	}
+	stats := b.metrics.open(b.cluster)
 
 	errc := make(chan error, 2)
 	go func() {
-		_, err := io.Copy(tcpConn, pipeConn) // pipe → TCP
+		_, err := io.Copy(stats.transmitted(tcpConn), pipeConn) // pipe → TCP
 		errc <- err
 	}()
 	go func() {
-		_, err := io.Copy(pipeConn, tcpConn) // TCP → pipe
+		_, err := io.Copy(stats.received(pipeConn), tcpConn) // TCP → pipe
 		errc <- err
 	}()
 
-	<-errc // first direction done
+	err = <-errc // first direction done
 	pipeConn.Close()
 	tcpConn.Close()
 	<-errc // second direction done
+	stats.close(err)
 }
 
 // ErrBridgeRequired is returned when a Bridge is expected but nil.
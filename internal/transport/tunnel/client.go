@@ -41,6 +41,10 @@ type RegisterResult struct {
 // RegisterFunc registers an agent and returns mTLS credentials.
 type RegisterFunc func(ctx context.Context, serverURL, cluster string) (*RegisterResult, error)
 
+// RenewFunc exchanges the current credentials for a successor issued
+// to the same agent, without re-registering.
+type RenewFunc func(ctx context.Context, serverURL, cluster string, current *RegisterResult) (*RegisterResult, error)
+
 // ClientOption configures a Client.
 type ClientOption func(*Client)
 
@@ -48,9 +52,11 @@ type ClientOption func(*Client)
 // registration, reconnection, and exponential backoff. It uses mTLS
 // for tunnel authentication.
 type Client struct {
-	mu      sync.Mutex       // protects inner and certDir
+	mu      sync.Mutex       // protects inner, certDir, current and pending
 	inner   *chclient.Client // owned lifecycle, not exported
 	certDir string           // temp directory for TLS cert files
+	current *RegisterResult  // credentials of the running session
+	pending *RegisterResult  // renewed credentials for the next dial
 
 	cluster          string
 	serverURL        string
@@ -62,6 +68,7 @@ type Client struct {
 	baseRetryDelay   time.Duration
 	maxRetryDelay    time.Duration
 	register         RegisterFunc
+	renew            RenewFunc
 	log              *slog.Logger
 }
 
@@ -115,6 +122,13 @@ func WithRegister(register RegisterFunc) ClientOption {
 	return func(c *Client) { c.register = register }
 }
 
+// WithRenew configures the function used to renew the client
+// certificate before it expires. Without it the client only obtains
+// new credentials by re-registering.
+func WithRenew(renew RenewFunc) ClientOption {
+	return func(c *Client) { c.renew = renew }
+}
+
 // WithLogger configures a structured logger. Defaults to slog.Default
 // with "component" and "cluster" attributes.
 func WithLogger(log *slog.Logger) ClientOption {
@@ -214,15 +228,30 @@ func (c *Client) Stop(_ context.Context) error {
 	return c.inner.Close()
 }
 
-// dial registers with the link server, writes mTLS credentials to
-// temp files, and creates a new chisel client configured for mTLS.
+// dial obtains credentials, writes them to temp files, and creates a
+// new chisel client configured for mTLS. Renewed credentials are used
+// once if available; otherwise the client registers with the link
+// server.
 func (c *Client) dial(ctx context.Context) (*chclient.Client, error) {
-	result, err := c.register(ctx, c.serverURL, c.cluster)
-	if err != nil {
-		return nil, fmt.Errorf("register: %w", err)
+	c.mu.Lock()
+	result := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	if result == nil {
+		var err error
+		result, err = c.register(ctx, c.serverURL, c.cluster)
+		if err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+		c.log.Info("registered", "endpoint", result.Endpoint)
+	} else {
+		c.log.Info("reconnecting with renewed certificate", "endpoint", result.Endpoint)
 	}
 
-	c.log.Info("registered", "endpoint", result.Endpoint)
+	c.mu.Lock()
+	c.current = result
+	c.mu.Unlock()
 
 	// Write mTLS credentials to a temp directory.
 	dir, err := os.MkdirTemp("", "otterscale-tls-*")
@@ -292,10 +321,22 @@ func tcpKeepAliveDialer(ctx context.Context, network, addr string) (net.Conn, er
 }
 
 // runSession starts the inner chisel client and waits for it to finish.
-// It always closes the inner client before returning.
+// It always closes the inner client before returning. While the
+// session is up, the client certificate is renewed in the background
+// when a RenewFunc is configured.
 func (c *Client) runSession(ctx context.Context, inner *chclient.Client) error {
 	c.log.Info("connecting", "server", c.tunnelServerURL)
 
+	if c.renew != nil {
+		sessionCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Go(func() { c.renewLoop(sessionCtx) })
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	}
+
 	if err := inner.Start(ctx); err != nil {
 		if closeErr := inner.Close(); closeErr != nil {
 			c.log.Warn("failed to close inner client after start failure", "error", closeErr)
@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// meterName is the instrumentation scope of the tunnel metrics.
+const meterName = "github.com/otterscale/otterscale/internal/transport/tunnel"
+
+// Error kinds recorded by Metrics.
+const (
+	errorHandshake = "handshake" // TLS handshake of an agent failed
+	errorDial      = "dial"      // the relay target could not be reached
+	errorCopy      = "copy"      // a relayed connection broke
+)
+
+// Metrics records the traffic relayed through the tunnels, labelled
+// by cluster: the bytes received and transmitted, the active
+// connections, their duration and the relay errors. On the hub, every
+// agent connection carries the traffic of the loopback host of one
+// agent replica; on the agent, the Bridge relays every connection the
+// hub opens through the tunnel. It is safe for concurrent use.
+type Metrics struct {
+	io       metric.Int64Counter
+	active   metric.Int64UpDownCounter
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+// NewMetrics returns Metrics whose instruments are created with the
+// meter of mp.
+func NewMetrics(mp metric.MeterProvider) (*Metrics, error) {
+	meter := mp.Meter(meterName)
+
+	ioCounter, err := meter.Int64Counter("otterscale.tunnel.io",
+		metric.WithUnit("By"),
+		metric.WithDescription("Bytes relayed through the tunnel, by direction as seen from this process."))
+	if err != nil {
+		return nil, err
+	}
+	active, err := meter.Int64UpDownCounter("otterscale.tunnel.connections.active",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Connections currently relayed through the tunnel."))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := meter.Float64Histogram("otterscale.tunnel.connection.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of the connections relayed through the tunnel."))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := meter.Int64Counter("otterscale.tunnel.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Tunnel connections that failed, by error type."))
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{io: ioCounter, active: active, duration: duration, errors: errs}, nil
+}
+
+// defaultMetrics returns the Metrics of the global MeterProvider,
+// which the Server and Bridge record to unless configured otherwise.
+// Instruments of the global provider forward to the provider set
+// later through otel.SetMeterProvider.
+var defaultMetrics = sync.OnceValue(func() *Metrics {
+	m, err := NewMetrics(otel.GetMeterProvider())
+	if err != nil {
+		m, _ = NewMetrics(noop.NewMeterProvider())
+	}
+	return m
+})
+
+// recordError counts a failed connection of cluster.
+func (m *Metrics) recordError(cluster, kind string) {
+	m.errors.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("cluster", cluster),
+		attribute.String("error.type", kind),
+	))
+}
+
+// open records a new relayed connection of cluster and returns the
+// stats the connection is recorded with until it is closed.
+func (m *Metrics) open(cluster string) *connStats {
+	attrs := attribute.NewSet(attribute.String("cluster", cluster))
+	c := &connStats{
+		m:       m,
+		cluster: cluster,
+		attrs:   metric.WithAttributeSet(attrs),
+		receive: metric.WithAttributeSet(attribute.NewSet(
+			attribute.String("cluster", cluster),
+			attribute.String("network.io.direction", "receive"),
+		)),
+		transmit: metric.WithAttributeSet(attribute.NewSet(
+			attribute.String("cluster", cluster),
+			attribute.String("network.io.direction", "transmit"),
+		)),
+		start: time.Now(),
+	}
+	m.active.Add(context.Background(), 1, c.attrs)
+	return c
+}
+
+// connStats records the traffic of a single relayed connection.
+type connStats struct {
+	m        *Metrics
+	cluster  string
+	attrs    metric.MeasurementOption
+	receive  metric.MeasurementOption
+	transmit metric.MeasurementOption
+	start    time.Time
+}
+
+// received returns a writer that forwards to w and counts the bytes
+// as received from the remote end of the tunnel.
+func (c *connStats) received(w io.Writer) io.Writer {
+	return &countingWriter{w: w, add: func(n int64) { c.m.io.Add(context.Background(), n, c.receive) }}
+}
+
+// transmitted returns a writer that forwards to w and counts the
+// bytes as transmitted to the remote end of the tunnel.
+func (c *connStats) transmitted(w io.Writer) io.Writer {
+	return &countingWriter{w: w, add: func(n int64) { c.m.io.Add(context.Background(), n, c.transmit) }}
+}
+
+// close records the end of the connection. err is the error the
+// first finished copy direction returned; errors caused by closing
+// the connections are not counted.
+func (c *connStats) close(err error) {
+	c.m.active.Add(context.Background(), -1, c.attrs)
+	c.m.duration.Record(context.Background(), time.Since(c.start).Seconds(), c.attrs)
+	if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+		c.m.recordError(c.cluster, errorCopy)
+	}
+}
+
+// countingWriter passes writes on to w and reports the number of
+// bytes written to add. Counting every write keeps the byte counters
+// current for long-lived connections.
+type countingWriter struct {
+	w   io.Writer
+	add func(n int64)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		cw.add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/otterscale/otterscale/internal/transport/pipe"
+)
+
+// TestBridge_RecordsMetrics verifies that the bridge records the bytes,
+// connections and connection duration of the relayed traffic for its
+// cluster.
+func TestBridge_RecordsMetrics(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	metrics, err := NewMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatalf("NewMetrics: %v", err)
+	}
+
+	pl := pipe.NewListener()
+	defer pl.Close()
+
+	bridge, err := NewBridge(t.Context(), pl, WithBridgeCluster("prod"), WithBridgeMetrics(metrics))
+	if err != nil {
+		t.Fatalf("NewBridge: %v", err)
+	}
+	go func() {
+		if err := bridge.Start(t.Context()); err != nil {
+			t.Logf("bridge.Start: %v", err)
+		}
+	}()
+
+	go echoConnection(t, pl, 0)
+	verifyRoundTrip(t.Context(), t, fmt.Sprintf("127.0.0.1:%d", bridge.Port()), 0)
+
+	// The relay records the connection once both directions are done,
+	// after the client has already read its answer.
+	var got map[string]metricdata.Aggregation
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		got = collect(t, reader)
+		if h, ok := got["otterscale.tunnel.connection.duration"].(metricdata.Histogram[float64]); ok && len(h.DataPoints) == 1 && h.DataPoints[0].Count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection duration not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	const msgLen = int64(len("msg-0"))
+	ioSum := got["otterscale.tunnel.io"].(metricdata.Sum[int64])
+	for _, dir := range []string{"receive", "transmit"} {
+		if v := sumValue(ioSum, attribute.String("cluster", "prod"), attribute.String("network.io.direction", dir)); v != msgLen {
+			t.Errorf("%s bytes = %d, want %d", dir, v, msgLen)
+		}
+	}
+	active := got["otterscale.tunnel.connections.active"].(metricdata.Sum[int64])
+	if v := sumValue(active, attribute.String("cluster", "prod")); v != 0 {
+		t.Errorf("active connections = %d, want 0", v)
+	}
+	if _, ok := got["otterscale.tunnel.errors"]; ok {
+		t.Error("expected no errors to be recorded")
+	}
+}
+
+// collect returns the aggregations reader holds, keyed by metric name.
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(t.Context(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	ret := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			ret[m.Name] = m.Data
+		}
+	}
+	return ret
+}
+
+// sumValue returns the value of the data point of sum with exactly the
+// given attributes.
+func sumValue(sum metricdata.Sum[int64], attrs ...attribute.KeyValue) int64 {
+	want := attribute.NewSet(attrs...)
+	for _, dp := range sum.DataPoints {
+		if dp.Attributes.Equals(&want) {
+			return dp.Value
+		}
+	}
+	return -1
+}
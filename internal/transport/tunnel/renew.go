@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// renewFraction is the share of a certificate's lifetime after which
+// the client asks for a successor. Renewing at two thirds leaves
+// enough headroom for retries before the certificate expires.
+const renewFraction = 2.0 / 3.0
+
+// renewLoop renews the session's certificate whenever it reaches
+// renewFraction of its lifetime, until ctx is canceled. Renewed
+// credentials are stored as pending and picked up by the next dial;
+// the running session is left untouched.
+func (c *Client) renewLoop(ctx context.Context) {
+	bo := newBackoff(c.baseRetryDelay, c.maxRetryDelay)
+
+	for {
+		c.mu.Lock()
+		current := c.current
+		c.mu.Unlock()
+		if current == nil {
+			return
+		}
+
+		at, err := renewAt(current.CertPEM)
+		if err != nil {
+			c.log.Warn("cannot schedule certificate renewal", "error", err)
+			return
+		}
+		if !sleepCtx(ctx, time.Until(at)) {
+			return
+		}
+
+		next, err := c.renew(ctx, c.serverURL, c.cluster, current)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.Warn("certificate renewal failed, retrying", "error", err, "retry_in", bo.current)
+			if !sleepCtx(ctx, bo.Next()) {
+				return
+			}
+			continue
+		}
+		bo.Reset()
+
+		c.mu.Lock()
+		c.current = next
+		c.pending = next
+		c.mu.Unlock()
+
+		c.log.Info("certificate renewed", "endpoint", next.Endpoint)
+	}
+}
+
+// renewAt returns the time at which the PEM-encoded certificate should
+// be renewed.
+func renewAt(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, errors.New("tunnel: invalid certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * renewFraction)), nil
+}
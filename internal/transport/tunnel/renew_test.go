@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestRenewAt(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(90 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	at, err := renewAt(certPEM)
+	if err != nil {
+		t.Fatalf("renewAt: %v", err)
+	}
+	if want := notBefore.Add(60 * time.Hour); !at.Equal(want) {
+		t.Errorf("expected renewal at %v, got %v", want, at)
+	}
+
+	if _, err := renewAt([]byte("garbage")); err == nil {
+		t.Error("expected an error for invalid PEM")
+	}
+}
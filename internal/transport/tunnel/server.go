@@ -2,9 +2,13 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"sync"
 	"sync/atomic"
 
 	"github.com/google/uuid"
@@ -22,7 +26,15 @@ type Server struct {
 	tlsCert   string // file path to server certificate
 	tlsKey    string // file path to server private key
 	tlsCA     string // file path to CA certificate (enables mTLS)
+	tlsConfig *tls.Config
+	clusterOf func(*x509.Certificate) string // cluster of an agent certificate
+	metrics   *Metrics
 	log       *slog.Logger
+
+	mu       sync.Mutex
+	frontend net.Listener           // TLS front-end, set while serving with tlsConfig
+	backend  *http.Server           // serves chisel in process, set with frontend
+	conns    map[*tls.Conn]struct{} // established TLS connections
 }
 
 // WithAddress configures the listen address (e.g. ":8300").
@@ -47,6 +59,16 @@ func WithTLSCA(path string) ServerOption {
 	return func(s *Server) { s.tlsCA = path }
 }
 
+// WithTLSConfig configures a TLS configuration that is consulted for
+// every incoming connection. It takes precedence over WithTLSCert,
+// WithTLSKey and WithTLSCA: the server terminates TLS itself and
+// hands the decrypted stream to chisel in process, so that
+// the server certificate and the trusted client CAs can change
+// without a restart (e.g. through tls.Config.GetConfigForClient).
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) { s.tlsConfig = cfg }
+}
+
 // WithServer injects a shared atomic server reference. The reference
 // is typically owned by a TunnelProvider; init will store the fully
 // initialized server into it so that both sides share the same
@@ -55,6 +77,20 @@ func WithServer(ref *atomic.Pointer[chserver.Server]) ServerOption {
 	return func(s *Server) { s.serverRef = ref }
 }
 
+// WithCertificateCluster configures the function that returns the
+// cluster an agent's client certificate was issued for, which the
+// traffic of the agent's connection is recorded for. Traffic is only
+// recorded when the Server terminates TLS itself, see WithTLSConfig.
+func WithCertificateCluster(fn func(*x509.Certificate) string) ServerOption {
+	return func(s *Server) { s.clusterOf = fn }
+}
+
+// WithMetrics configures the Metrics the relayed traffic is recorded
+// to. Defaults to the Metrics of the global MeterProvider.
+func WithMetrics(m *Metrics) ServerOption {
+	return func(s *Server) { s.metrics = m }
+}
+
 // WithServerLogger configures a structured logger. Defaults to
 // slog.Default with a "component" attribute.
 func WithServerLogger(log *slog.Logger) ServerOption {
@@ -75,6 +111,9 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 	if s.log == nil {
 		s.log = slog.Default().With("component", "tunnel-server")
 	}
+	if s.metrics == nil {
+		s.metrics = defaultMetrics()
+	}
 	if err := s.init(); err != nil {
 		return nil, fmt.Errorf("tunnel server init: %w", err)
 	}
@@ -88,9 +127,13 @@ func (s *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("parse address %q: %w", s.address, err)
 	}
 
+	srv := s.serverRef.Load()
+	if s.tlsConfig != nil {
+		return s.serveTLS(ctx, srv)
+	}
+
 	s.log.Info("starting", "address", s.address)
 
-	srv := s.serverRef.Load()
 	if err := srv.StartContext(ctx, host, port); err != nil {
 		return fmt.Errorf("tunnel server start: %w", err)
 	}
@@ -105,6 +148,18 @@ func (s *Server) Stop(_ context.Context) error {
 		return nil
 	}
 	s.log.Info("shutting down")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tlsConfig != nil {
+		if s.frontend != nil {
+			s.frontend.Close()
+		}
+		if s.backend != nil {
+			return s.backend.Close()
+		}
+		return nil
+	}
 	return srv.Close()
 }
 
@@ -117,7 +172,9 @@ func (s *Server) init() error {
 	}
 
 	// Configure TLS for mTLS when certificate paths are provided.
-	if s.tlsCert != "" && s.tlsKey != "" {
+	// A dynamic TLS config is terminated by the Server itself, which
+	// serves chisel in process.
+	if s.tlsConfig == nil && s.tlsCert != "" && s.tlsKey != "" {
 		cfg.TLS = chserver.TLSConfig{
 			Cert: s.tlsCert,
 			Key:  s.tlsKey,
@@ -0,0 +1,207 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	_ "unsafe" // for go:linkname
+
+	chserver "github.com/jpillora/chisel/server"
+
+	"github.com/otterscale/otterscale/internal/transport/pipe"
+)
+
+// handshakeTimeout bounds the TLS handshake of a single connection so
+// that idle or misbehaving clients cannot hold relay goroutines. It
+// bounds the request headers chisel reads after the handshake, too.
+const handshakeTimeout = 10 * time.Second
+
+// handleChisel is the HTTP handler of srv, which chisel only serves on
+// a listener of its own. Calling it directly lets the Server hand the
+// decrypted connections to chisel in process, so that chisel has no
+// network presence that would bypass the client certificate checks.
+//
+//go:linkname handleChisel github.com/jpillora/chisel/server.(*Server).handleClientHandler
+func handleChisel(srv *chserver.Server, w http.ResponseWriter, r *http.Request)
+
+// serveTLS accepts TLS connections on the public address and relays
+// each decrypted stream to chisel through an in-memory pipe. It blocks
+// until ctx is canceled or Stop is called.
+func (s *Server) serveTLS(ctx context.Context, srv *chserver.Server) error {
+	backend := pipe.NewListener()
+	httpSrv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleChisel(srv, w, r)
+		}),
+		ReadHeaderTimeout: handshakeTimeout,
+		ErrorLog:          slog.NewLogLogger(s.log.Handler(), slog.LevelWarn),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("tunnel listen: %w", err)
+	}
+	s.mu.Lock()
+	s.frontend = ln
+	s.backend = httpSrv
+	s.mu.Unlock()
+
+	s.log.Info("starting", "address", s.address)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpSrv.Serve(backend)
+	}()
+
+	// Close the listener when the context is done so that Accept
+	// unblocks.
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				s.log.Warn("temporary accept error", "error", err)
+				continue
+			}
+			ln.Close()
+			httpSrv.Close()
+			wg.Wait()
+			return fmt.Errorf("tunnel accept: %w", err)
+		}
+
+		wg.Go(func() {
+			s.relay(ctx, tls.Server(conn, s.tlsConfig), backend)
+		})
+	}
+
+	httpSrv.Close()
+	err = <-served
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// relay completes the TLS handshake with the client and copies data
+// bidirectionally between the decrypted stream and chisel. Both
+// connections are closed when either direction finishes or ctx is
+// canceled. The traffic is recorded for the cluster of the client
+// certificate.
+func (s *Server) relay(ctx context.Context, conn *tls.Conn, backend *pipe.Listener) {
+	defer conn.Close()
+
+	hsCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	err := conn.HandshakeContext(hsCtx)
+	cancel()
+	if err != nil {
+		s.log.Debug("tls handshake failed", "remote", conn.RemoteAddr().String(), "error", err)
+		s.metrics.recordError("", errorHandshake)
+		return
+	}
+	cluster := s.cluster(conn)
+
+	s.track(conn)
+	defer s.untrack(conn)
+
+	upstream, err := backend.Dial()
+	if err != nil {
+		s.log.Warn("dial tunnel backend failed", "error", err)
+		s.metrics.recordError(cluster, errorDial)
+		return
+	}
+	defer upstream.Close()
+	stats := s.metrics.open(cluster)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stats.received(upstream), conn) // client → chisel
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(stats.transmitted(conn), upstream) // chisel → client
+		errc <- err
+	}()
+
+	err = <-errc // first direction done
+	conn.Close()
+	upstream.Close()
+	<-errc // second direction done
+	stats.close(err)
+}
+
+// cluster returns the cluster the client certificate of conn was
+// issued for, or "" if it is unknown.
+func (s *Server) cluster(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+	if s.clusterOf == nil || len(certs) == 0 {
+		return ""
+	}
+	return s.clusterOf(certs[0])
+}
+
+// CloseConnections closes every established TLS connection whose
+// client certificate matches and returns how many were closed. It is
+// used to kick agents whose certificate has been revoked: chisel has
+// no way to terminate a session once the SSH handshake is done.
+func (s *Server) CloseConnections(match func(cert *x509.Certificate) bool) int {
+	s.mu.Lock()
+	var victims []*tls.Conn
+	for conn := range s.conns {
+		certs := conn.ConnectionState().PeerCertificates
+		if len(certs) > 0 && match(certs[0]) {
+			victims = append(victims, conn)
+			delete(s.conns, conn)
+		}
+	}
+	s.mu.Unlock()
+
+	// Close outside the lock: tls.Conn.Close may block while sending
+	// the close_notify alert.
+	for _, conn := range victims {
+		conn.Close()
+	}
+	return len(victims)
+}
+
+// track registers an established connection for CloseConnections.
+func (s *Server) track(conn *tls.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*tls.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+}
+
+// untrack removes a connection registered by track.
+func (s *Server) untrack(conn *tls.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
@@ -0,0 +1,136 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/providers/chisel"
+)
+
+// TestTunnelCARotation verifies that the tunnel accepts agents of both
+// CAs during a rotation and only agents of the new CA afterwards,
+// without restarting the listener.
+func TestTunnelCARotation(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	address := startTunnelListener(t, tunnel)
+
+	oldAgent := enrollAgent(t, tunnel, "agent-old")
+	oldBundle := tunnel.CACertPEM()
+	if err := tunnelHealth(address, oldAgent, oldBundle); err != nil {
+		t.Fatalf("agent before rotation: %v", err)
+	}
+
+	if _, err := tunnel.StartCARotation(t.Context()); err != nil {
+		t.Fatalf("StartCARotation: %v", err)
+	}
+	newAgent := enrollAgent(t, tunnel, "agent-new")
+	newBundle := tunnel.CACertPEM()
+
+	// During the rotation old agents keep working with the bundle
+	// they already have, and new agents work with the dual bundle.
+	if err := tunnelHealth(address, oldAgent, oldBundle); err != nil {
+		t.Errorf("old agent during rotation: %v", err)
+	}
+	if err := tunnelHealth(address, newAgent, newBundle); err != nil {
+		t.Errorf("new agent during rotation: %v", err)
+	}
+
+	if _, err := tunnel.FinishCARotation(t.Context()); err != nil {
+		t.Fatalf("FinishCARotation: %v", err)
+	}
+	if err := tunnelHealth(address, newAgent, newBundle); err != nil {
+		t.Errorf("new agent after rotation: %v", err)
+	}
+	if err := tunnelHealth(address, oldAgent, newBundle); err == nil {
+		t.Error("expected an agent of the retired CA to be rejected")
+	}
+}
+
+// startTunnelListener starts the tunnel listener of svc on a free
+// loopback port and returns its address.
+func startTunnelListener(t *testing.T, svc *chisel.Service) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve port: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	lis, err := svc.BuildTunnelListener(address, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("BuildTunnelListener: %v", err)
+	}
+	go func() { _ = lis.Start(t.Context()) }()
+	t.Cleanup(func() { _ = lis.Stop(t.Context()) })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			return address
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("tunnel listener did not start on %s", address)
+	return ""
+}
+
+// enrollAgent registers an agent and returns its client certificate.
+func enrollAgent(t *testing.T, svc *chisel.Service, agentID string) tls.Certificate {
+	t.Helper()
+	key, keyPEM, err := pki.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	csr, err := pki.GenerateCSR(key, agentID)
+	if err != nil {
+		t.Fatalf("generate CSR: %v", err)
+	}
+	_, certPEM, err := svc.RegisterLink(t.Context(), "cluster-"+agentID, agentID, testAgentUID("cluster-"+agentID), "test", csr)
+	if err != nil {
+		t.Fatalf("register %s: %v", agentID, err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load key pair: %v", err)
+	}
+	return cert
+}
+
+// tunnelHealth performs an mTLS request against chisel's health
+// endpoint through the tunnel listener.
+func tunnelHealth(address string, cert tls.Certificate, bundle []byte) error {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(bundle)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}},
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("https://" + address + "/health")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package integration
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+	"github.com/otterscale/otterscale/internal/providers/chisel"
+	"github.com/otterscale/otterscale/internal/providers/linkstore"
+)
+
+// TestLinkStoreSurvivesRestart verifies that links persisted by one
+// service are listed as disconnected by its successor, keep their
+// first-seen time, labels and facts when the agent registers again,
+// and are dropped
+// from the store on deregistration.
+func TestLinkStoreSurvivesRestart(t *testing.T) {
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	policy, err := pki.NewCSRPolicy("")
+	if err != nil {
+		t.Fatalf("create CSR policy: %v", err)
+	}
+	store := linkstore.NewFileStore(t.TempDir())
+	load := func() *chisel.Service {
+		t.Helper()
+		svc, err := chisel.LoadService(t.Context(), ca, pki.NewRevocationList(), policy, store, core.HubReplica{}, chisel.DefaultHealthConfig())
+		if err != nil {
+			t.Fatalf("LoadService: %v", err)
+		}
+		initTunnelServer(t, svc)
+		return svc
+	}
+
+	const cluster = "cluster-a"
+	first := load()
+	if _, _, err := first.RegisterLink(t.Context(), cluster, "agent-a", testAgentUID(cluster), "v1", generateCSR(t, "agent-a")); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	labels := map[string]string{"env": "prod", "region": "eu-west"}
+	facts := core.ClusterFacts{KubernetesVersion: "v1.34.1", NodeCount: 3, Provider: "aws"}
+	if err := first.SetLinkLabels(t.Context(), cluster, labels); err != nil {
+		t.Fatalf("set labels: %v", err)
+	}
+	if err := first.SetLinkFacts(t.Context(), cluster, facts); err != nil {
+		t.Fatalf("set facts: %v", err)
+	}
+	registered := first.ListLinks()[cluster]
+
+	restarted := load()
+	restored, ok := restarted.ListLinks()[cluster]
+	if !ok {
+		t.Fatal("expected the link to survive the restart")
+	}
+	if restored.Connected || restored.User != "agent-a" || restored.AgentVersion != "v1" || restored.Host != registered.Host {
+		t.Fatalf("restored link = %+v, want disconnected agent-a v1 on %s", restored, registered.Host)
+	}
+	if !restored.FirstSeen.Equal(registered.FirstSeen) {
+		t.Errorf("first seen = %v, want %v", restored.FirstSeen, registered.FirstSeen)
+	}
+	var disconnected *core.ErrClusterDisconnected
+	if _, err := restarted.ResolveAddress(t.Context(), cluster); !errors.As(err, &disconnected) {
+		t.Fatalf("expected ErrClusterDisconnected, got %v", err)
+	}
+
+	if _, _, err := restarted.RegisterLink(t.Context(), cluster, "agent-b", testAgentUID(cluster), "v2", generateCSR(t, "agent-b")); err != nil {
+		t.Fatalf("register again: %v", err)
+	}
+	reconnected := restarted.ListLinks()[cluster]
+	if !reconnected.Connected || reconnected.User != "agent-b" {
+		t.Fatalf("link = %+v, want connected agent-b", reconnected)
+	}
+	if !reconnected.FirstSeen.Equal(registered.FirstSeen) {
+		t.Errorf("first seen = %v, want %v", reconnected.FirstSeen, registered.FirstSeen)
+	}
+	if !maps.Equal(reconnected.Labels, labels) || reconnected.Facts != facts {
+		t.Errorf("labels = %v, facts = %+v, want %v and %+v", reconnected.Labels, reconnected.Facts, labels, facts)
+	}
+	if _, err := restarted.ResolveAddress(t.Context(), cluster); err != nil {
+		t.Fatalf("resolve after registering again: %v", err)
+	}
+
+	restarted.DeregisterCluster(t.Context(), cluster)
+	if _, ok := load().ListLinks()[cluster]; ok {
+		t.Fatal("expected the deregistered link to be dropped from the store")
+	}
+}
+
+// TestLinkStoreSharedByHubReplicas verifies that a hub replica sharing
+// the link store with the replica a cluster is connected to lists the
+// cluster as connected there, and resolves it to that replica.
+func TestLinkStoreSharedByHubReplicas(t *testing.T) {
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	policy, err := pki.NewCSRPolicy("")
+	if err != nil {
+		t.Fatalf("create CSR policy: %v", err)
+	}
+	store := linkstore.NewFileStore(t.TempDir())
+	load := func(replica core.HubReplica) *chisel.Service {
+		t.Helper()
+		svc, err := chisel.LoadService(t.Context(), ca, pki.NewRevocationList(), policy, store, replica, chisel.DefaultHealthConfig())
+		if err != nil {
+			t.Fatalf("LoadService: %v", err)
+		}
+		initTunnelServer(t, svc)
+		return svc
+	}
+
+	hubA := core.HubReplica{ID: "hub-a", PeerURL: "https://hub-a.hub:8301"}
+	hubB := core.HubReplica{ID: "hub-b", PeerURL: "https://hub-b.hub:8301"}
+
+	const cluster = "cluster-a"
+	a := load(hubA)
+	if _, _, err := a.RegisterLink(t.Context(), cluster, "agent-a", testAgentUID(cluster), "v1", generateCSR(t, "agent-a")); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	b := load(hubB)
+	link, ok := b.ListLinks()[cluster]
+	if !ok {
+		t.Fatal("expected the link of the other replica to be listed")
+	}
+	if !link.Connected || link.HubReplica != hubA.ID {
+		t.Fatalf("link = %+v, want connected on %s", link, hubA.ID)
+	}
+
+	var onPeer *core.ErrClusterOnPeer
+	if _, err := b.ResolveAddress(t.Context(), cluster); !errors.As(err, &onPeer) {
+		t.Fatalf("expected ErrClusterOnPeer, got %v", err)
+	}
+	if onPeer.Replica != hubA {
+		t.Errorf("replica = %+v, want %+v", onPeer.Replica, hubA)
+	}
+	if _, err := a.ResolveAddress(t.Context(), cluster); err != nil {
+		t.Errorf("owning replica: ResolveAddress: %v", err)
+	}
+}
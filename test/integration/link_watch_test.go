@@ -0,0 +1,49 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// TestTunnelWatchLinks verifies that registrations, agent version
+// changes and revocations are published as link events.
+func TestTunnelWatchLinks(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	initTunnelServer(t, tunnel)
+	events := tunnel.WatchLinks(t.Context())
+
+	const cluster = "cluster-watch"
+	register := func(version string) {
+		t.Helper()
+		if _, _, err := tunnel.RegisterLink(t.Context(), cluster, "agent-watch", testAgentUID(cluster), version, generateCSR(t, "agent-watch")); err != nil {
+			t.Fatalf("RegisterLink(%s): %v", version, err)
+		}
+	}
+	register("v1")
+	register("v2")
+	if err := tunnel.RevokeCluster(t.Context(), cluster, "test"); err != nil {
+		t.Fatalf("RevokeCluster: %v", err)
+	}
+
+	want := []core.LinkEventType{
+		core.LinkEventRegistered,
+		core.LinkEventRegistered,
+		core.LinkEventVersionChanged,
+		core.LinkEventDeregistered,
+	}
+	for _, typ := range want {
+		select {
+		case ev := <-events:
+			if ev.Type != typ || ev.Cluster != cluster {
+				t.Fatalf("got %s %s, want %s %s", ev.Type, ev.Cluster, typ, cluster)
+			}
+			if ev.Type == core.LinkEventVersionChanged && (ev.PreviousAgentVersion != "v1" || ev.Link.AgentVersion != "v2") {
+				t.Errorf("version change %s -> %s, want v1 -> v2", ev.PreviousAgentVersion, ev.Link.AgentVersion)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", typ)
+		}
+	}
+}
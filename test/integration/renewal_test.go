@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/otterscale/otterscale/internal/core"
+	"github.com/otterscale/otterscale/internal/pki"
+)
+
+// TestTunnelRenewLink verifies that renewing keeps the cluster's
+// endpoint, replaces its certificate, moves it onto the active CA
+// during a rotation and refuses to renew a superseded certificate.
+func TestTunnelRenewLink(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	address := startTunnelListener(t, tunnel)
+
+	const agentID, cluster = "agent-renew", "cluster-agent-renew"
+	key, keyPEM, err := pki.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	csr, err := pki.GenerateCSR(key, agentID)
+	if err != nil {
+		t.Fatalf("generate CSR: %v", err)
+	}
+	endpoint, certPEM, err := tunnel.RegisterLink(t.Context(), cluster, agentID, testAgentUID(cluster), "test", csr)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	before := tunnel.ListLinks()[cluster]
+
+	if _, err := tunnel.StartCARotation(t.Context()); err != nil {
+		t.Fatalf("StartCARotation: %v", err)
+	}
+
+	renew := func(certPEM, keyPEM []byte) (endpoint string, newCertPEM, newKeyPEM []byte, err error) {
+		key, newKeyPEM, err := pki.GenerateKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		csr, err := pki.GenerateCSR(key, agentID)
+		if err != nil {
+			t.Fatalf("generate CSR: %v", err)
+		}
+		proof, err := pki.SignRenewalProof(keyPEM, cluster, csr)
+		if err != nil {
+			t.Fatalf("sign proof: %v", err)
+		}
+		endpoint, newCertPEM, err = tunnel.RenewLink(t.Context(), cluster, agentID, certPEM, csr, proof)
+		return endpoint, newCertPEM, newKeyPEM, err
+	}
+
+	renewedEndpoint, renewedCertPEM, renewedKeyPEM, err := renew(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("RenewLink: %v", err)
+	}
+	if renewedEndpoint != endpoint {
+		t.Errorf("expected renewal to keep endpoint %s, got %s", endpoint, renewedEndpoint)
+	}
+
+	after := tunnel.ListLinks()[cluster]
+	if after.Serial == before.Serial {
+		t.Error("expected the link to record the renewed serial")
+	}
+	if after.CAFingerprint == before.CAFingerprint {
+		t.Error("expected the renewed certificate to be issued by the new CA")
+	}
+
+	// The superseded certificate cannot be renewed again.
+	_, _, _, err = renew(certPEM, keyPEM)
+	if code, _ := core.DomainErrorCode(err); code != core.ErrorCodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a superseded certificate, got %v", err)
+	}
+
+	// Once the old CA is retired the renewed agent keeps working.
+	if _, err := tunnel.FinishCARotation(t.Context()); err != nil {
+		t.Fatalf("FinishCARotation: %v", err)
+	}
+	renewed, err := tls.X509KeyPair(renewedCertPEM, renewedKeyPEM)
+	if err != nil {
+		t.Fatalf("load key pair: %v", err)
+	}
+	if err := tunnelHealth(address, renewed, tunnel.CACertPEM()); err != nil {
+		t.Errorf("renewed agent after rotation: %v", err)
+	}
+}
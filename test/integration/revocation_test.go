@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/otterscale/otterscale/internal/core"
+)
+
+// TestTunnelRevokeCluster verifies that revoking a cluster closes its
+// established tunnel connection, rejects its certificate on the next
+// handshake and blocks it from registering again.
+func TestTunnelRevokeCluster(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	address := startTunnelListener(t, tunnel)
+
+	revoked := enrollAgent(t, tunnel, "agent-revoked")
+	kept := enrollAgent(t, tunnel, "agent-kept")
+	bundle := tunnel.CACertPEM()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(bundle)
+	conn, err := tls.Dial("tcp", address, &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{revoked},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("dial before revocation: %v", err)
+	}
+	defer conn.Close()
+	// Complete a request so the server has finished the handshake
+	// and tracks the connection.
+	if _, err := conn.Write([]byte("GET /health HTTP/1.1\r\nHost: tunnel\r\n\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 64)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if err := tunnel.RevokeCluster(t.Context(), "cluster-agent-revoked", "test"); err != nil {
+		t.Fatalf("RevokeCluster: %v", err)
+	}
+
+	// The established connection is closed by the server.
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("set deadline: %v", err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.Fatal("expected the revoked connection to be closed")
+		}
+	}
+
+	if err := tunnelHealth(address, revoked, bundle); err == nil {
+		t.Error("expected the revoked certificate to be rejected")
+	}
+	if err := tunnelHealth(address, kept, bundle); err != nil {
+		t.Errorf("expected other agents to keep working: %v", err)
+	}
+
+	_, _, err = tunnel.RegisterLink(t.Context(), "cluster-agent-revoked", "agent-revoked", testAgentUID("cluster-agent-revoked"), "test", generateCSR(t, "agent-revoked"))
+	if code, ok := core.DomainErrorCode(err); !ok || code != core.ErrorCodePermissionDenied {
+		t.Errorf("expected PermissionDenied when re-registering, got %v", err)
+	}
+
+	if err := tunnel.RestoreAgent(t.Context(), "agent-revoked"); err != nil {
+		t.Fatalf("RestoreAgent: %v", err)
+	}
+	if _, _, err := tunnel.RegisterLink(t.Context(), "cluster-agent-revoked", "agent-revoked", testAgentUID("cluster-agent-revoked"), "test", generateCSR(t, "agent-revoked")); err != nil {
+		t.Errorf("expected the restored agent to register: %v", err)
+	}
+}
@@ -16,19 +16,16 @@ import (
 func TestLinkRegisterClusterUsesSingleSharedTunnelPort(t *testing.T) {
 	tunnel := newTestTunnel(t)
 	initTunnelServer(t, tunnel)
-	link, err := core.NewLinkUseCase(tunnel, "test", testManifestConfig(), manifest.NewRenderer(), nil)
-	if err != nil {
-		t.Fatalf("create link use case: %v", err)
-	}
+	link, tokens := newTestLink(t, tunnel)
 
 	csrA := generateCSR(t, "agent-a")
 	csrB := generateCSR(t, "agent-b")
 
-	regA, err := link.RegisterCluster(t.Context(), "cluster-a", "agent-a", "test", csrA)
+	regA, err := link.RegisterCluster(t.Context(), "cluster-a", "agent-a", "test", csrA, enrollmentToken(t, tokens, "cluster-a"))
 	if err != nil {
 		t.Fatalf("register cluster-a: %v", err)
 	}
-	regB, err := link.RegisterCluster(t.Context(), "cluster-b", "agent-b", "test", csrB)
+	regB, err := link.RegisterCluster(t.Context(), "cluster-b", "agent-b", "test", csrB, enrollmentToken(t, tokens, "cluster-b"))
 	if err != nil {
 		t.Fatalf("register cluster-b: %v", err)
 	}
@@ -61,28 +58,30 @@ func TestLinkRegisterClusterUsesSingleSharedTunnelPort(t *testing.T) {
 	}
 }
 
-func TestLinkRegisterClusterLatestAgentWinsForSameCluster(t *testing.T) {
+func TestLinkRegisterClusterAddsReplicasForSameCluster(t *testing.T) {
 	tunnel := newTestTunnel(t)
 	initTunnelServer(t, tunnel)
-	link, err := core.NewLinkUseCase(tunnel, "test", testManifestConfig(), manifest.NewRenderer(), nil)
-	if err != nil {
-		t.Fatalf("create link use case: %v", err)
-	}
+	link, tokens := newTestLink(t, tunnel)
 
-	csr1 := generateCSR(t, "agent-r-1")
+	csr1, key1 := generateCSRAndKey(t, "agent-r-1")
 	csr2 := generateCSR(t, "agent-r-2")
 
-	_, err = link.RegisterCluster(t.Context(), "cluster-r", "agent-r-1", "test", csr1)
+	reg1, err := link.RegisterCluster(t.Context(), "cluster-r", "agent-r-1", "test", csr1, enrollmentToken(t, tokens, "cluster-r"))
 	if err != nil {
 		t.Fatalf("register agent-r-1: %v", err)
 	}
-	reg2, err := link.RegisterCluster(t.Context(), "cluster-r", "agent-r-2", "test", csr2)
+	// The second agent shares the identity of the first one, as the
+	// agents of a cluster do through their enrollment Secret.
+	reg2, err := link.RegisterCluster(t.Context(), "cluster-r", "agent-r-2", "test", csr2, previousIdentity(t, "cluster-r", reg1, key1, csr2))
 	if err != nil {
 		t.Fatalf("register agent-r-2: %v", err)
 	}
+	if reg1.Endpoint == reg2.Endpoint {
+		t.Fatalf("expected distinct endpoints for the replicas, got %q", reg1.Endpoint)
+	}
 
-	// After re-registration the route must resolve to the latest
-	// agent's endpoint regardless of whether the host was reused.
+	// Both agents serve the cluster; requests are routed to the
+	// latest one while it is healthy.
 	addr, err := tunnel.ResolveAddress(t.Context(), "cluster-r")
 	if err != nil {
 		t.Fatalf("resolve: %v", err)
@@ -96,25 +95,29 @@ func TestLinkRegisterClusterLatestAgentWinsForSameCluster(t *testing.T) {
 	if len(links) != 1 || slices.Collect(maps.Keys(links))[0] != "cluster-r" {
 		t.Fatalf("expected exactly one cluster 'cluster-r', got %v", links)
 	}
+	var agents []string
+	for _, r := range links["cluster-r"].Replicas {
+		agents = append(agents, r.User)
+	}
+	if !slices.Equal(agents, []string{"agent-r-2", "agent-r-1"}) {
+		t.Fatalf("expected replicas agent-r-2 and agent-r-1, got %v", agents)
+	}
 }
 
 func TestLinkRegisterClusterReregisterAndReplaceAcrossAgents(t *testing.T) {
 	tunnel := newTestTunnel(t)
 	initTunnelServer(t, tunnel)
-	link, err := core.NewLinkUseCase(tunnel, "test", testManifestConfig(), manifest.NewRenderer(), nil)
-	if err != nil {
-		t.Fatalf("create link use case: %v", err)
-	}
+	link, tokens := newTestLink(t, tunnel)
 
-	csrA := generateCSR(t, "agent-a")
-	csrB := generateCSR(t, "agent-b")
+	csrA, keyA := generateCSRAndKey(t, "agent-a")
+	csrB, keyB := generateCSRAndKey(t, "agent-b")
 
-	regA1, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-a", "test", csrA)
+	regA1, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-a", "test", csrA, enrollmentToken(t, tokens, "cluster-z"))
 	if err != nil {
 		t.Fatalf("register agent-a #1: %v", err)
 	}
 
-	regB, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-b", "test", csrB)
+	regB, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-b", "test", csrB, previousIdentity(t, "cluster-z", regA1, keyA, csrB))
 	if err != nil {
 		t.Fatalf("register agent-b: %v", err)
 	}
@@ -129,7 +132,14 @@ func TestLinkRegisterClusterReregisterAndReplaceAcrossAgents(t *testing.T) {
 		t.Fatalf("expected resolve to point to agent-b endpoint %q, got %q", regB.Endpoint, addrB)
 	}
 
-	regA2, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-a", "test", csrA)
+	// Re-registering requires a fresh key; the first CSR is rejected
+	// by the CSR policy.
+	if _, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-a", "test", csrA, previousIdentity(t, "cluster-z", regB, keyB, csrA)); err == nil {
+		t.Fatal("expected a reused CSR key to be rejected")
+	}
+
+	csrA2 := generateCSR(t, "agent-a")
+	regA2, err := link.RegisterCluster(t.Context(), "cluster-z", "agent-a", "test", csrA2, previousIdentity(t, "cluster-z", regB, keyB, csrA2))
 	if err != nil {
 		t.Fatalf("register agent-a #2: %v", err)
 	}
@@ -159,6 +169,125 @@ func TestLinkRegisterClusterReregisterAndReplaceAcrossAgents(t *testing.T) {
 	}
 }
 
+func TestLinkRegisterClusterRejectsCSRForAnotherAgent(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	initTunnelServer(t, tunnel)
+
+	_, _, err := tunnel.RegisterLink(t.Context(), "cluster-a", "agent-a", testAgentUID("cluster-a"), "test", generateCSR(t, "agent-b"))
+	if code, _ := core.DomainErrorCode(err); code != core.ErrorCodePermissionDenied {
+		t.Fatalf("expected PermissionDenied for a CSR issued to another agent, got %v", err)
+	}
+	if _, ok := tunnel.ListLinks()["cluster-a"]; ok {
+		t.Fatal("expected the rejected registration to leave no link behind")
+	}
+}
+
+func TestLinkRegisterClusterWithPreviousIdentity(t *testing.T) {
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	policy, err := pki.NewCSRPolicy("")
+	if err != nil {
+		t.Fatalf("create CSR policy: %v", err)
+	}
+	revocations := pki.NewRevocationList()
+	tunnel := chisel.NewService(ca, revocations, policy)
+	initTunnelServer(t, tunnel)
+	link, tokens := newTestLink(t, tunnel)
+
+	key, keyPEM, err := pki.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	csr, err := pki.GenerateCSR(key, "agent-1")
+	if err != nil {
+		t.Fatalf("generate CSR: %v", err)
+	}
+	token := enrollmentToken(t, tokens, "cluster-id")
+	reg, err := link.RegisterCluster(t.Context(), "cluster-id", "agent-1", "test", csr, token)
+	if err != nil {
+		t.Fatalf("register with token: %v", err)
+	}
+
+	// The token is single-use.
+	if _, err := link.RegisterCluster(t.Context(), "cluster-id", "agent-1", "test", generateCSR(t, "agent-1"), token); err == nil {
+		t.Fatal("expected a spent enrollment token to be rejected")
+	}
+
+	// A restarted agent has a new ID but proves the identity of its
+	// predecessor.
+	withIdentity := func(cluster, agentID string) error {
+		key, _, err := pki.GenerateKey()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		csr, err := pki.GenerateCSR(key, agentID)
+		if err != nil {
+			t.Fatalf("generate CSR: %v", err)
+		}
+		proof, err := pki.SignRenewalProof(keyPEM, cluster, csr)
+		if err != nil {
+			t.Fatalf("sign proof: %v", err)
+		}
+		_, err = link.RegisterCluster(t.Context(), cluster, agentID, "test", csr, core.EnrollmentProof{AgentUID: testAgentUID(cluster), Certificate: reg.Certificate, Proof: proof})
+		return err
+	}
+	if err := withIdentity("cluster-id", "agent-2"); err != nil {
+		t.Fatalf("register with previous identity: %v", err)
+	}
+	if link := tunnel.ListLinks()["cluster-id"]; link.User != "agent-2" {
+		t.Fatalf("expected cluster-id to be served by agent-2, got %q", link.User)
+	}
+
+	// The identity is bound to its cluster.
+	if err := withIdentity("cluster-other", "agent-3"); err == nil {
+		t.Fatal("expected an identity for another cluster to be rejected")
+	}
+
+	// A revoked certificate is no identity.
+	serial, err := pki.CertificateSerial(reg.Certificate)
+	if err != nil {
+		t.Fatalf("read serial: %v", err)
+	}
+	if err := revocations.RevokeSerial(t.Context(), serial, "test"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if err := withIdentity("cluster-id", "agent-4"); err == nil {
+		t.Fatal("expected a revoked identity to be rejected")
+	}
+}
+
+func TestLinkRegisterClusterRefusesAnotherAgentUID(t *testing.T) {
+	tunnel := newTestTunnel(t)
+	initTunnelServer(t, tunnel)
+	link, tokens := newTestLink(t, tunnel)
+
+	if _, err := link.RegisterCluster(t.Context(), "cluster-a", "agent-a", "test", generateCSR(t, "agent-a"), enrollmentToken(t, tokens, "cluster-a")); err != nil {
+		t.Fatalf("register cluster-a: %v", err)
+	}
+
+	intruder := enrollmentToken(t, tokens, "cluster-a")
+	intruder.AgentUID = "uid-intruder"
+	_, err := link.RegisterCluster(t.Context(), "cluster-a", "agent-b", "test", generateCSR(t, "agent-b"), intruder)
+	if code, _ := core.DomainErrorCode(err); code != core.ErrorCodeFailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for another agent UID, got %v", err)
+	}
+	if l := tunnel.ListLinks()["cluster-a"]; l.User != "agent-a" {
+		t.Fatalf("expected cluster-a to stay with agent-a, got %q", l.User)
+	}
+
+	if err := link.RehomeCluster(t.Context(), "cluster-a", "uid-intruder"); err != nil {
+		t.Fatalf("re-home cluster-a: %v", err)
+	}
+	if _, err := link.RegisterCluster(t.Context(), "cluster-a", "agent-b", "test", generateCSR(t, "agent-b"), intruder); err != nil {
+		t.Fatalf("register re-homed cluster-a: %v", err)
+	}
+	if l := tunnel.ListLinks()["cluster-a"]; l.User != "agent-b" || len(l.Replicas) != 1 {
+		t.Fatalf("expected cluster-a to be served by agent-b alone, got %+v", l.Replicas)
+	}
+}
+
 // newTestTunnel creates a chisel.Service with a fresh test CA
 // injected at construction time.
 func newTestTunnel(t *testing.T) *chisel.Service {
@@ -167,7 +296,11 @@ func newTestTunnel(t *testing.T) *chisel.Service {
 	if err != nil {
 		t.Fatalf("create CA: %v", err)
 	}
-	return chisel.NewService(ca)
+	policy, err := pki.NewCSRPolicy("")
+	if err != nil {
+		t.Fatalf("create CSR policy: %v", err)
+	}
+	return chisel.NewService(ca, pki.NewRevocationList(), policy)
 }
 
 func initTunnelServer(t *testing.T, tunnel *chisel.Service) {
@@ -184,6 +317,35 @@ func initTunnelServer(t *testing.T, tunnel *chisel.Service) {
 	})
 }
 
+// newTestLink creates a LinkUseCase on top of tunnel together with the
+// enrollment token registry it redeems tokens from.
+func newTestLink(t *testing.T, tunnel *chisel.Service) (*core.LinkUseCase, *core.EnrollmentTokens) {
+	t.Helper()
+	tokens := core.NewEnrollmentTokens()
+	link, err := core.NewLinkUseCase(tunnel, tunnel, testManifestConfig(), manifest.NewRenderer(), nil, tokens, core.NewClusterPins(), core.NewClusterApprovals(false), core.NewAgentUpgrades("test"), nil)
+	if err != nil {
+		t.Fatalf("create link use case: %v", err)
+	}
+	return link, tokens
+}
+
+// enrollmentToken issues a fresh enrollment token for cluster, to be
+// presented by the agent with the cluster's testAgentUID.
+func enrollmentToken(t *testing.T, tokens *core.EnrollmentTokens, cluster string) core.EnrollmentProof {
+	t.Helper()
+	token, _, err := tokens.Issue(t.Context(), cluster)
+	if err != nil {
+		t.Fatalf("issue enrollment token: %v", err)
+	}
+	return core.EnrollmentProof{AgentUID: testAgentUID(cluster), Token: token}
+}
+
+// testAgentUID returns the agent UID of the Kubernetes cluster that
+// serves cluster in tests.
+func testAgentUID(cluster string) string {
+	return "uid-" + cluster
+}
+
 // testManifestConfig returns an AgentManifestConfig with dummy values
 // suitable for integration tests.
 func testManifestConfig() core.AgentManifestConfig {
@@ -198,13 +360,32 @@ func testManifestConfig() core.AgentManifestConfig {
 // the given common name.
 func generateCSR(t *testing.T, cn string) []byte {
 	t.Helper()
-	key, _, err := pki.GenerateKey()
+	csr, _ := generateCSRAndKey(t, cn)
+	return csr
+}
+
+// generateCSRAndKey is like generateCSR but also returns the
+// PEM-encoded private key.
+func generateCSRAndKey(t *testing.T, cn string) (csr, keyPEM []byte) {
+	t.Helper()
+	key, keyPEM, err := pki.GenerateKey()
 	if err != nil {
 		t.Fatalf("generate key: %v", err)
 	}
-	csr, err := pki.GenerateCSR(key, cn)
+	csr, err = pki.GenerateCSR(key, cn)
 	if err != nil {
 		t.Fatalf("generate CSR: %v", err)
 	}
-	return csr
+	return csr, keyPEM
+}
+
+// previousIdentity returns the proof with which an agent presenting
+// csr registers cluster with the identity of reg, whose key is keyPEM.
+func previousIdentity(t *testing.T, cluster string, reg core.Registration, keyPEM, csr []byte) core.EnrollmentProof {
+	t.Helper()
+	proof, err := pki.SignRenewalProof(keyPEM, cluster, csr)
+	if err != nil {
+		t.Fatalf("sign proof: %v", err)
+	}
+	return core.EnrollmentProof{AgentUID: testAgentUID(cluster), Certificate: reg.Certificate, Proof: proof}
 }